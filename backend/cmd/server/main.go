@@ -90,6 +90,7 @@ func setupRoutes(r *gin.Engine, cfg *config.Config) {
 	{
 		packages.POST("", packageAPI.Upload)                // 上传离线包
 		packages.GET("", packageAPI.List)                   // 获取离线包列表
+		packages.GET("/variants", packageAPI.ResolveVariants) // 按服务器操作系统预览离线包变体
 		packages.GET("/:id", packageAPI.Get)                // 获取离线包详情
 		packages.GET("/:id/metadata", packageAPI.GetMetadata) // 获取离线包元数据
 		packages.DELETE("/:id", packageAPI.Delete)          // 删除离线包
@@ -112,13 +113,16 @@ func setupRoutes(r *gin.Engine, cfg *config.Config) {
 	servers := v1.Group("/servers")
 	servers.Use(api.AuthMiddleware(cfg))
 	{
-		servers.POST("", serverAPI.Create)                       // 创建服务器
-		servers.GET("", serverAPI.List)                          // 获取服务器列表
-		servers.GET("/:id", serverAPI.Get)                       // 获取服务器详情
-		servers.PUT("/:id", serverAPI.Update)                    // 更新服务器
-		servers.DELETE("/:id", serverAPI.Delete)                 // 删除服务器
-		servers.POST("/:id/test", serverAPI.TestConnection)      // 测试已保存服务器连接
-		servers.POST("/test", serverAPI.TestConnectionDirect)    // 直接测试连接（不保存）
+		servers.POST("", serverAPI.Create)                            // 创建服务器
+		servers.GET("", serverAPI.List)                               // 获取服务器列表
+		servers.GET("/:id", serverAPI.Get)                            // 获取服务器详情
+		servers.PUT("/:id", serverAPI.Update)                         // 更新服务器
+		servers.DELETE("/:id", serverAPI.Delete)                      // 删除服务器
+		servers.POST("/:id/test", serverAPI.TestConnection)           // 测试已保存服务器连接
+		servers.POST("/test", serverAPI.TestConnectionDirect)         // 直接测试连接（不保存）
+		servers.GET("/:id/host-key", serverAPI.GetHostKey)            // 获取主机密钥信息
+		servers.POST("/:id/host-key/accept", serverAPI.AcceptHostKey) // 接受变更后的主机密钥
+		servers.POST("/known-hosts", serverAPI.ImportKnownHosts)      // 导入 known_hosts
 	}
 
 	// Nginx 配置 API
@@ -133,8 +137,18 @@ func setupRoutes(r *gin.Engine, cfg *config.Config) {
 		nginx.DELETE("/:id", nginxAPI.Delete)                 // 删除配置
 		nginx.GET("/:id/generate", nginxAPI.Generate)         // 生成配置文件
 		nginx.POST("/preview", nginxAPI.Preview)              // 预览配置（不保存）
+		nginx.POST("/import", nginxAPI.Import)                // 从服务器导入现有配置
+		nginx.GET("/advice", nginxAPI.AdviceReport)           // 所有配置的最佳实践与安全建议报告
 		nginx.POST("/:id/apply", nginxAPI.ApplyConfig)        // 应用配置到服务器
 		nginx.GET("/:id/apply-history", nginxAPI.GetApplyHistory) // 获取配置应用历史
+		nginx.GET("/:id/revisions", nginxAPI.ListRevisions)   // 获取修订历史
+		nginx.GET("/:id/revisions/:revision", nginxAPI.GetRevision) // 获取指定修订版本
+		nginx.POST("/:id/revisions/:revision/apply", nginxAPI.ApplyRevision) // 将指定修订版本应用到服务器
+		nginx.GET("/:id/diff", nginxAPI.DiffRevisions)        // 对比修订版本
+		nginx.GET("/:id/upstreams", nginxAPI.ListUpstreams)   // 获取 upstream 列表
+		nginx.POST("/:id/upstreams", nginxAPI.CreateUpstream) // 添加 upstream
+		nginx.PUT("/:id/upstreams/:upstream_id", nginxAPI.UpdateUpstream)    // 更新 upstream
+		nginx.DELETE("/:id/upstreams/:upstream_id", nginxAPI.DeleteUpstream) // 删除 upstream
 		nginx.GET("/applies/:id", nginxAPI.GetApplyDetail)    // 获取应用详情
 		nginx.GET("/deploy-info/:server_id", nginxAPI.GetNginxDeployInfo) // 获取服务器上的 Nginx 部署信息
 	}
@@ -144,16 +158,25 @@ func setupRoutes(r *gin.Engine, cfg *config.Config) {
 	deployments := v1.Group("/deployments")
 	deployments.Use(api.AuthMiddleware(cfg))
 	{
-		deployments.POST("", deploymentAPI.Create)                    // 创建部署任务
-		deployments.POST("/batch", deploymentAPI.BatchCreate)         // 批量创建部署任务
-		deployments.GET("", deploymentAPI.List)                       // 获取部署任务列表
-		deployments.GET("/:id", deploymentAPI.Get)                    // 获取部署任务详情
-		deployments.DELETE("/:id", deploymentAPI.Delete)              // 删除部署任务
-		deployments.POST("/:id/execute", deploymentAPI.Execute)       // 执行部署任务
-		deployments.POST("/:id/cancel", deploymentAPI.Cancel)         // 取消部署任务
-		deployments.POST("/:id/rollback", deploymentAPI.Rollback)     // 回滚部署
-		deployments.GET("/:id/logs", deploymentAPI.GetLogs)           // 获取部署日志
-		deployments.GET("/:id/logs/stream", deploymentAPI.StreamLogs) // SSE 实时日志流
+		deployments.POST("", deploymentAPI.Create)                                     // 创建部署任务
+		deployments.POST("/batch", deploymentAPI.BatchCreate)                          // 批量创建部署任务
+		deployments.GET("", deploymentAPI.List)                                        // 获取部署任务列表
+		deployments.GET("/:id", deploymentAPI.Get)                                     // 获取部署任务详情
+		deployments.DELETE("/:id", deploymentAPI.Delete)                               // 删除部署任务
+		deployments.POST("/:id/execute", deploymentAPI.Execute)                        // 执行部署任务
+		deployments.POST("/:id/cancel", deploymentAPI.Cancel)                          // 取消部署任务
+		deployments.POST("/:id/rollback", deploymentAPI.Rollback)                      // 回滚部署
+		deployments.POST("/:id/preflight", deploymentAPI.Preflight)                    // 部署前预检
+		deployments.POST("/:id/clone", deploymentAPI.Clone)                            // 复制部署任务（含钩子）
+		deployments.GET("/:id/hooks", deploymentAPI.ListHooks)                         // 获取钩子列表
+		deployments.POST("/:id/hooks", deploymentAPI.CreateHook)                       // 添加钩子
+		deployments.POST("/:id/hooks/attach", deploymentAPI.AttachScript)              // 从脚本模板添加钩子
+		deployments.PUT("/:id/hooks/order", deploymentAPI.ReorderHooks)                // 调整钩子顺序
+		deployments.PUT("/:id/hooks/:hook_id", deploymentAPI.UpdateHook)               // 更新钩子
+		deployments.PUT("/:id/hooks/:hook_id/revision", deploymentAPI.PinHookRevision) // 切换钩子脚本版本
+		deployments.DELETE("/:id/hooks/:hook_id", deploymentAPI.DeleteHook)            // 删除钩子
+		deployments.GET("/:id/logs", deploymentAPI.GetLogs)                            // 获取部署日志
+		deployments.GET("/:id/logs/stream", deploymentAPI.StreamLogs)                  // SSE 实时日志流
 	}
 
 	// 部署脚本管理 API
@@ -161,11 +184,27 @@ func setupRoutes(r *gin.Engine, cfg *config.Config) {
 	scripts := v1.Group("/scripts")
 	scripts.Use(api.AuthMiddleware(cfg))
 	{
-		scripts.POST("", scriptAPI.Create)         // 创建脚本模板
-		scripts.GET("", scriptAPI.List)            // 获取脚本模板列表
-		scripts.GET("/:id", scriptAPI.Get)         // 获取脚本模板详情
-		scripts.PUT("/:id", scriptAPI.Update)      // 更新脚本模板
-		scripts.DELETE("/:id", scriptAPI.Delete)   // 删除脚本模板
+		scripts.POST("", scriptAPI.Create)                             // 创建脚本模板
+		scripts.GET("", scriptAPI.List)                                // 获取脚本模板列表
+		scripts.GET("/:id", scriptAPI.Get)                             // 获取脚本模板详情
+		scripts.PUT("/:id", scriptAPI.Update)                          // 更新脚本模板
+		scripts.DELETE("/:id", scriptAPI.Delete)                       // 删除脚本模板
+		scripts.GET("/:id/revisions", scriptAPI.ListRevisions)         // 获取修订历史
+		scripts.GET("/:id/revisions/:revision", scriptAPI.GetRevision) // 获取指定修订版本
+		scripts.GET("/:id/diff", scriptAPI.DiffRevisions)              // 对比修订版本
+	}
+
+	// 临时作业 API
+	jobAPI := api.NewJobAPI(cfg)
+	jobs := v1.Group("/jobs")
+	jobs.Use(api.AuthMiddleware(cfg))
+	{
+		jobs.POST("", jobAPI.Create)            // 创建并执行作业
+		jobs.GET("", jobAPI.List)               // 获取作业历史
+		jobs.GET("/:id", jobAPI.Get)            // 获取作业详情及聚合结果
+		jobs.GET("/:id/logs", jobAPI.GetLogs)   // 获取作业日志
+		jobs.GET("/:id/stream", jobAPI.Stream)  // SSE 实时输出
+		jobs.POST("/:id/cancel", jobAPI.Cancel) // 取消作业
 	}
 
 	// 健康检查
@@ -4,9 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -28,16 +26,19 @@ type DeploymentAPI struct {
 }
 
 func NewDeploymentAPI(cfg *config.Config) *DeploymentAPI {
+	transferMgr.configure(cfg.Transfer)
+	sshPool.configure(cfg.SSH)
 	return &DeploymentAPI{cfg: cfg}
 }
 
 // deploymentExecution 部署执行实例
 type deploymentExecution struct {
-	deployment *models.Deployment
-	ctx        context.Context
-	cancel     context.CancelFunc
-	logChan    chan *models.DeploymentLog
-	done       chan struct{}
+	deployment   *models.Deployment
+	ctx          context.Context
+	cancel       context.CancelFunc
+	logChan      chan *models.DeploymentLog
+	progressChan chan *transferProgress
+	done         chan struct{}
 }
 
 // deploymentManager 部署管理器，管理所有活跃的部署任务
@@ -87,6 +88,9 @@ type CreateDeploymentRequest struct {
 	RestartService bool   `json:"restart_service"`
 	ServiceName    string `json:"service_name"`
 	DeployParams   string `json:"deploy_params"` // JSON 格式的部署参数
+	BandwidthLimit int64  `json:"bandwidth_limit"` // 上传带宽上限（KB/s，0 表示不限速）
+	PreflightPolicy string `json:"preflight_policy" binding:"omitempty,oneof=block warn skip"` // 预检策略，默认 block
+	IgnoreOSCheck   bool   `json:"ignore_os_check"` // 忽略操作系统兼容性检查
 }
 
 // Create 创建部署任务
@@ -116,6 +120,9 @@ func (a *DeploymentAPI) Create(c *gin.Context) {
 		RestartService: req.RestartService,
 		ServiceName:    req.ServiceName,
 		DeployParams:   req.DeployParams,
+		BandwidthLimit: req.BandwidthLimit,
+		PreflightPolicy: defaultString(req.PreflightPolicy, "block"),
+		IgnoreOSCheck:   req.IgnoreOSCheck,
 	}
 
 	switch req.Type {
@@ -131,7 +138,7 @@ func (a *DeploymentAPI) Create(c *gin.Context) {
 		}
 		deployment.NginxConfigID = req.NginxConfigID
 		if deployment.TargetPath == "" {
-			deployment.TargetPath = "/etc/nginx/nginx.conf"
+			deployment.TargetPath = nginxConfigTargetPath(&cfg)
 		}
 		if deployment.ServiceName == "" {
 			deployment.ServiceName = "nginx"
@@ -147,6 +154,9 @@ func (a *DeploymentAPI) Create(c *gin.Context) {
 			response.Error(c, http.StatusBadRequest, "离线包不存在")
 			return
 		}
+		if !checkPackageTarget(c, &pkg, &server, req.IgnoreOSCheck) {
+			return
+		}
 		deployment.PackageID = req.PackageID
 		if deployment.TargetPath == "" {
 			deployment.TargetPath = "/tmp"
@@ -176,6 +186,21 @@ func (a *DeploymentAPI) Create(c *gin.Context) {
 	response.Success(c, deployment)
 }
 
+// checkPackageTarget 校验离线包与目标服务器的架构及操作系统兼容性，不兼容时返回 400 并返回 false
+func checkPackageTarget(c *gin.Context, pkg *models.MiddlewarePackage, server *models.Server, ignoreOSCheck bool) bool {
+	if !archMatches(pkg.Arch, server.Arch) {
+		response.Error(c, http.StatusBadRequest, archMismatchReason(pkg.Arch, server.Arch))
+		return false
+	}
+	if !ignoreOSCheck {
+		if compat := checkOSCompatibility(packageSupportedOS(pkg), server.OSType, server.OSVersion); !compat.Compatible {
+			response.ErrorWithData(c, http.StatusBadRequest, compat.Reason+"，如确需部署请设置 ignore_os_check", compat)
+			return false
+		}
+	}
+	return true
+}
+
 // List 获取部署任务列表
 func (a *DeploymentAPI) List(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -219,7 +244,7 @@ func (a *DeploymentAPI) Get(c *gin.Context) {
 
 	var deployment models.Deployment
 	if err := db.DB.Preload("Server").Preload("NginxConfig").Preload("Package").
-		Preload("Certificate").Preload("Logs").First(&deployment, id).Error; err != nil {
+		Preload("Certificate").Preload("RelayServer").Preload("Logs").First(&deployment, id).Error; err != nil {
 		response.Error(c, http.StatusNotFound, "部署任务不存在")
 		return
 	}
@@ -249,6 +274,7 @@ func (a *DeploymentAPI) Delete(c *gin.Context) {
 
 	// 删除关联日志
 	db.DB.Where("deployment_id = ?", id).Delete(&models.DeploymentLog{})
+	db.DB.Where("deployment_id = ?", id).Delete(&models.DeploymentHook{})
 	db.DB.Delete(&deployment)
 
 	response.Success(c, nil)
@@ -281,11 +307,12 @@ func (a *DeploymentAPI) Execute(c *gin.Context) {
 	done := make(chan struct{})
 
 	exec := &deploymentExecution{
-		deployment: &deployment,
-		ctx:        ctx,
-		cancel:     cancel,
-		logChan:    logChan,
-		done:       done,
+		deployment:   &deployment,
+		ctx:          ctx,
+		cancel:       cancel,
+		logChan:      logChan,
+		progressChan: make(chan *transferProgress, 16),
+		done:         done,
 	}
 
 	deployMgr.Add(uint(id), exec)
@@ -310,6 +337,13 @@ type BatchCreateRequest struct {
 	RestartService bool     `json:"restart_service"`
 	ServiceName    string   `json:"service_name"`
 	DeployParams   string   `json:"deploy_params"` // JSON 格式的部署参数
+	BandwidthLimit int64    `json:"bandwidth_limit"` // 每台服务器的上传带宽上限（KB/s，0 表示不限速）
+	RelayServerID  *uint    `json:"relay_server_id"` // 中转服务器 ID（仅离线包部署）
+	RelayMethod    string   `json:"relay_method" binding:"omitempty,oneof=scp rsync"` // 中转复制方式，默认 rsync
+	PreflightPolicy string  `json:"preflight_policy" binding:"omitempty,oneof=block warn skip"` // 预检策略，默认 block
+	IgnoreOSCheck   bool    `json:"ignore_os_check"` // 忽略操作系统兼容性检查
+	PackageName     string  `json:"package_name"`    // 离线包名称（与 package_version 一起使用，按服务器操作系统自动选择变体）
+	PackageVersion  string  `json:"package_version"` // 离线包版本
 	AutoExecute    bool     `json:"auto_execute"`  // 是否自动执行
 }
 
@@ -328,6 +362,88 @@ func (a *DeploymentAPI) BatchCreate(c *gin.Context) {
 		return
 	}
 
+	// 验证中转服务器
+	if req.RelayServerID != nil {
+		if req.Type != "package" {
+			response.Error(c, http.StatusBadRequest, "仅离线包部署支持中转分发")
+			return
+		}
+		var relay models.Server
+		if err := db.DB.First(&relay, *req.RelayServerID).Error; err != nil {
+			response.Error(c, http.StatusBadRequest, "中转服务器不存在")
+			return
+		}
+		if req.RelayMethod == "" {
+			req.RelayMethod = "rsync"
+		}
+	}
+
+	// 确定每台服务器使用的离线包（在创建任何任务之前完成）
+	// 指定 package_id 时所有服务器使用同一离线包；指定名称和版本时按服务器操作系统选择变体
+	packageByServer := make(map[uint]uint)
+	var variants []PackageVariant
+	if req.Type == "package" {
+		switch {
+		case req.PackageID != nil:
+			var pkg models.MiddlewarePackage
+			if err := db.DB.First(&pkg, *req.PackageID).Error; err != nil {
+				response.Error(c, http.StatusBadRequest, "离线包不存在")
+				return
+			}
+			var archMismatch []string
+			for _, server := range servers {
+				if !archMatches(pkg.Arch, server.Arch) {
+					archMismatch = append(archMismatch, server.Name+" ("+server.Arch+")")
+				}
+			}
+			if len(archMismatch) > 0 {
+				response.ErrorWithData(c, http.StatusBadRequest,
+					fmt.Sprintf("%d 台服务器的架构与离线包（%s）不一致", len(archMismatch), pkg.Arch),
+					gin.H{"arch_mismatch": archMismatch})
+				return
+			}
+			if !req.IgnoreOSCheck {
+				supported := packageSupportedOS(&pkg)
+				incompatible := gin.H{}
+				for _, server := range servers {
+					if compat := checkOSCompatibility(supported, server.OSType, server.OSVersion); !compat.Compatible {
+						incompatible[server.Name] = compat
+					}
+				}
+				if len(incompatible) > 0 {
+					response.ErrorWithData(c, http.StatusBadRequest,
+						fmt.Sprintf("%d 台服务器的操作系统与离线包不兼容，如确需部署请设置 ignore_os_check", len(incompatible)),
+						gin.H{"incompatible": incompatible})
+					return
+				}
+			}
+			for _, server := range servers {
+				packageByServer[server.ID] = pkg.ID
+			}
+
+		case req.PackageName != "" && req.PackageVersion != "":
+			matched, unmatched, err := resolvePackageVariants(req.PackageName, req.PackageVersion, servers)
+			if err != nil {
+				response.Error(c, http.StatusInternalServerError, "查询离线包失败")
+				return
+			}
+			if len(unmatched) > 0 {
+				response.ErrorWithData(c, http.StatusBadRequest,
+					fmt.Sprintf("%d 台服务器没有匹配的 %s %s 离线包变体", len(unmatched), req.PackageName, req.PackageVersion),
+					gin.H{"unmatched": unmatched, "matched": matched})
+				return
+			}
+			for _, v := range matched {
+				packageByServer[v.ServerID] = v.PackageID
+			}
+			variants = matched
+
+		default:
+			response.Error(c, http.StatusBadRequest, "请选择离线包")
+			return
+		}
+	}
+
 	// 根据类型验证资源
 	var createdDeployments []models.Deployment
 
@@ -343,6 +459,9 @@ func (a *DeploymentAPI) BatchCreate(c *gin.Context) {
 			RestartService: req.RestartService,
 			ServiceName:    req.ServiceName,
 			DeployParams:   req.DeployParams,
+			BandwidthLimit: req.BandwidthLimit,
+			PreflightPolicy: defaultString(req.PreflightPolicy, "block"),
+			IgnoreOSCheck:   req.IgnoreOSCheck,
 		}
 
 		switch req.Type {
@@ -353,21 +472,20 @@ func (a *DeploymentAPI) BatchCreate(c *gin.Context) {
 			}
 			deployment.NginxConfigID = req.NginxConfigID
 			if deployment.TargetPath == "" {
-				deployment.TargetPath = "/etc/nginx/nginx.conf"
+				deployment.TargetPath = defaultNginxDeployTargetPath(*req.NginxConfigID)
 			}
 			if deployment.ServiceName == "" {
 				deployment.ServiceName = "nginx"
 			}
 
 		case "package":
-			if req.PackageID == nil {
-				response.Error(c, http.StatusBadRequest, "请选择离线包")
-				return
-			}
-			deployment.PackageID = req.PackageID
+			packageID := packageByServer[serverID]
+			deployment.PackageID = &packageID
 			if deployment.TargetPath == "" {
 				deployment.TargetPath = "/tmp"
 			}
+			deployment.RelayServerID = req.RelayServerID
+			deployment.RelayMethod = req.RelayMethod
 
 		case "certificate":
 			if req.CertificateID == nil {
@@ -387,15 +505,20 @@ func (a *DeploymentAPI) BatchCreate(c *gin.Context) {
 
 		createdDeployments = append(createdDeployments, deployment)
 
-		// 如果设置了自动执行，则立即执行
+		// 如果设置了自动执行，则立即执行（需加载关联的服务器和资源）
 		if req.AutoExecute {
-			go a.executeDeployment(&deployment)
+			var toExecute models.Deployment
+			if err := db.DB.Preload("Server").Preload("NginxConfig").Preload("Package").
+				Preload("Certificate").First(&toExecute, deployment.ID).Error; err == nil {
+				go a.executeDeployment(&toExecute)
+			}
 		}
 	}
 
 	response.Success(c, gin.H{
 		"message":     fmt.Sprintf("成功创建 %d 个部署任务", len(createdDeployments)),
 		"deployments": createdDeployments,
+		"variants":    variants,
 	})
 }
 
@@ -439,6 +562,7 @@ func (a *DeploymentAPI) executeDeployment(deployment *models.Deployment) {
 
 	step := 1
 	var finalErr error
+	ctx := context.Background() // 批量部署不支持取消
 
 	defer func() {
 		completedAt := time.Now()
@@ -466,13 +590,14 @@ func (a *DeploymentAPI) executeDeployment(deployment *models.Deployment) {
 
 	// 1. 建立 SSH 连接
 	a.addLog(deployment.ID, step, "建立 SSH 连接", "")
-	client, err := a.connectSSH(deployment.Server)
+	lease, err := sshPool.Acquire(deployment.Server)
 	if err != nil {
 		finalErr = fmt.Errorf("SSH 连接失败: %v", err)
 		a.updateLog(deployment.ID, step, "failed", "", finalErr.Error())
 		return
 	}
-	defer client.Close()
+	defer lease.Release()
+	client := lease.Client
 	a.updateLog(deployment.ID, step, "success", "连接成功", "")
 	step++
 
@@ -489,7 +614,7 @@ func (a *DeploymentAPI) executeDeployment(deployment *models.Deployment) {
 	step++
 
 	// 3. 执行 pre_deploy 钩子
-	if err := executeHooksByType(deployment, "pre_deploy", client, sftpClient); err != nil {
+	if err := executeHooksByType(ctx, deployment, "pre_deploy", client, sftpClient, &step); err != nil {
 		finalErr = fmt.Errorf("pre_deploy 钩子执行失败: %v", err)
 		return
 	}
@@ -504,27 +629,36 @@ func (a *DeploymentAPI) executeDeployment(deployment *models.Deployment) {
 		finalErr = a.deployCertificate(client, sftpClient, deployment, &step)
 	}
 
-	// 5. 执行 post_deploy 钩子（无论成功或失败都执行）
-	executeHooksByType(deployment, "post_deploy", client, sftpClient)
+	// 5. 执行 post_deploy 钩子（无论成功或失败都执行），fail_deployment 策略的钩子失败时部署失败
+	if err := executeHooksByType(ctx, deployment, "post_deploy", client, sftpClient, &step); err != nil && finalErr == nil {
+		finalErr = err
+	}
 
 	// 6. 根据结果执行 on_success 或 on_failure 钩子
-	if finalErr != nil {
-		executeHooksByType(deployment, "on_failure", client, sftpClient)
-	} else {
-		executeHooksByType(deployment, "on_success", client, sftpClient)
-	}
+	finalErr = executeResultHooks(ctx, deployment, client, sftpClient, &step, finalErr)
 }
 
 // deployNginxConfig 部署 Nginx 配置
 func (a *DeploymentAPI) deployNginxConfig(client *ssh.Client, sftpClient *sftp.Client, deployment *models.Deployment, step *int) error {
 	// 生成配置内容
 	a.addLog(deployment.ID, *step, "生成 Nginx 配置", "")
-	content, err := generateNginxConfig(deployment.NginxConfig)
+	if deployment.NginxConfigID == nil {
+		a.updateLog(deployment.ID, *step, "failed", "", "未关联 Nginx 配置")
+		return fmt.Errorf("未关联 Nginx 配置")
+	}
+	nginxConfig, err := loadNginxConfigForRender(*deployment.NginxConfigID)
+	if err != nil {
+		a.updateLog(deployment.ID, *step, "failed", "", err.Error())
+		return fmt.Errorf("加载 Nginx 配置失败: %v", err)
+	}
+	// 记录本次部署的配置内容（与最新版本一致时沿用最新版本）
+	rev, err := recordNginxRevisionLocked(nginxConfig, nginxRevisionDeploy, "system", fmt.Sprintf("部署任务 #%d", deployment.ID))
 	if err != nil {
 		a.updateLog(deployment.ID, *step, "failed", "", err.Error())
 		return err
 	}
-	a.updateLog(deployment.ID, *step, "success", fmt.Sprintf("配置文件大小: %d 字节", len(content)), "")
+	content := rev.Content
+	a.updateLog(deployment.ID, *step, "success", fmt.Sprintf("配置修订版本 r%d，文件大小: %d 字节", rev.Revision, len(content)), "")
 	(*step)++
 
 	// 备份原配置
@@ -544,6 +678,8 @@ func (a *DeploymentAPI) deployNginxConfig(client *ssh.Client, sftpClient *sftp.C
 		// 更新备份路径到数据库
 		if strings.TrimSpace(output) != "" {
 			db.DB.Model(deployment).Update("backup_path", backupPath)
+		} else {
+			backupPath = ""
 		}
 
 		a.updateLog(deployment.ID, *step, "success", fmt.Sprintf("备份至: %s", backupPath), "")
@@ -571,10 +707,47 @@ func (a *DeploymentAPI) deployNginxConfig(client *ssh.Client, sftpClient *sftp.C
 	a.updateLog(deployment.ID, *step, "success", fmt.Sprintf("已上传至 %s", deployment.TargetPath), "")
 	(*step)++
 
-	// 测试配置
+	// 上传 basic 认证用户文件
+	htpasswd, err := revisionHtpasswd(rev, nginxConfig)
+	if err != nil {
+		a.addLog(deployment.ID, *step, "上传认证用户文件", "")
+		a.updateLog(deployment.ID, *step, "failed", "", err.Error())
+		return err
+	}
+	if htpasswd != "" {
+		a.addLog(deployment.ID, *step, "上传认证用户文件", "")
+		htpasswdPath := nginxHtpasswdPath(nginxConfig)
+		if err := a.uploadContent(sftpClient, htpasswdPath, []byte(htpasswd)); err != nil {
+			a.updateLog(deployment.ID, *step, "failed", "", err.Error())
+			return fmt.Errorf("上传认证用户文件失败: %v", err)
+		}
+		if output, err := a.runCommand(client, nginxHtpasswdPermCommand(nginxConfig, htpasswdPath)); err != nil {
+			a.updateLog(deployment.ID, *step, "failed", output, err.Error())
+			return fmt.Errorf("设置认证用户文件权限失败: %v", err)
+		}
+		a.updateLog(deployment.ID, *step, "success", fmt.Sprintf("已上传至 %s", htpasswdPath), "")
+		(*step)++
+	}
+
+	// 创建代理缓存目录
+	if mkdirCmd := nginxCacheDirsCommand(nginxConfig); mkdirCmd != "" {
+		a.addLog(deployment.ID, *step, "创建缓存目录", "")
+		if output, err := a.runCommand(client, mkdirCmd); err != nil {
+			a.updateLog(deployment.ID, *step, "failed", output, err.Error())
+			return fmt.Errorf("创建缓存目录失败: %v", err)
+		}
+		a.updateLog(deployment.ID, *step, "success", mkdirCmd, "")
+		(*step)++
+	}
+
+	// 测试配置（nginx -t 测试包含 include 文件在内的完整配置树）
 	a.addLog(deployment.ID, *step, "测试 Nginx 配置", "")
 	output, err = a.runCommand(client, "nginx -t 2>&1")
 	if err != nil {
+		if isNginxVhost(nginxConfig) {
+			restoreOutput, _ := a.runCommand(client, restoreIncludeFileCommand(deployment.TargetPath, backupPath))
+			output += "\n已恢复虚拟主机文件 " + deployment.TargetPath + restoreOutput
+		}
 		a.updateLog(deployment.ID, *step, "failed", output, err.Error())
 		return fmt.Errorf("配置测试失败: %v", err)
 	}
@@ -602,6 +775,16 @@ func (a *DeploymentAPI) deployNginxConfig(client *ssh.Client, sftpClient *sftp.C
 func (a *DeploymentAPI) deployPackage(client *ssh.Client, sftpClient *sftp.Client, deployment *models.Deployment, step *int) error {
 	pkg := deployment.Package
 
+	// 检查目标服务器操作系统是否在离线包支持列表中
+	if err := a.checkTargetOS(client, deployment, step); err != nil {
+		return err
+	}
+
+	// 按离线包的系统要求预检目标服务器
+	if err := a.preflight(client, deployment, step); err != nil {
+		return err
+	}
+
 	// 创建目标目录
 	a.addLog(deployment.ID, *step, "创建目标目录", "")
 	mkdirCmd := fmt.Sprintf("mkdir -p %s", deployment.TargetPath)
@@ -618,13 +801,34 @@ func (a *DeploymentAPI) deployPackage(client *ssh.Client, sftpClient *sftp.Clien
 	localPath := pkg.FilePath
 	remotePath := filepath.Join(deployment.TargetPath, pkg.FileName)
 
-	if err := a.uploadFile(sftpClient, localPath, remotePath); err != nil {
-		a.updateLog(deployment.ID, *step, "failed", "", err.Error())
+	// 配置了中转服务器时优先经中转分发，失败则回退为直接上传
+	var relayNote string
+	if deployment.RelayServerID != nil && *deployment.RelayServerID != deployment.ServerID {
+		summary, err := a.distributeViaRelay(client, deployment, pkg, remotePath, *step)
+		if err == nil {
+			a.updateLog(deployment.ID, *step, "success", summary, "")
+			(*step)++
+			return a.installPackage(client, deployment, step)
+		}
+		logger.Warnf("部署 %d 中转分发失败，回退为直接上传: %v", deployment.ID, err)
+		relayNote = fmt.Sprintf("中转分发失败，已回退为直接上传: %v\n", err)
+	}
+
+	transfer, err := a.uploadFile(client, sftpClient, localPath, remotePath, pkg.FileHash, a.transferOptions(deployment, *step))
+	if err != nil {
+		a.updateLog(deployment.ID, *step, "failed", relayNote, err.Error())
 		return fmt.Errorf("上传失败: %v", err)
 	}
-	a.updateLog(deployment.ID, *step, "success", fmt.Sprintf("已上传 %s (%.2f MB)", pkg.FileName, float64(pkg.FileSize)/1024/1024), "")
+	a.updateLog(deployment.ID, *step, "success", relayNote+transfer.Summary(), "")
 	(*step)++
 
+	return a.installPackage(client, deployment, step)
+}
+
+// installPackage 解压已上传的离线包并执行安装脚本
+func (a *DeploymentAPI) installPackage(client *ssh.Client, deployment *models.Deployment, step *int) error {
+	pkg := deployment.Package
+
 	// 解压离线包（如果是 tar.gz 或 zip）
 	var extractDir string
 	if strings.HasSuffix(pkg.FileName, ".tar.gz") || strings.HasSuffix(pkg.FileName, ".tgz") {
@@ -677,7 +881,7 @@ func (a *DeploymentAPI) deployPackage(client *ssh.Client, sftpClient *sftp.Clien
 	// 设置脚本执行权限
 	a.addLog(deployment.ID, *step, "设置执行权限", "")
 	chmodCmd := fmt.Sprintf("chmod +x %s", scriptPath)
-	output, err = a.runCommand(client, chmodCmd)
+	output, err := a.runCommand(client, chmodCmd)
 	if err != nil {
 		a.updateLog(deployment.ID, *step, "failed", output, err.Error())
 		return fmt.Errorf("设置权限失败: %v", err)
@@ -737,7 +941,7 @@ func (a *DeploymentAPI) deployCertificate(client *ssh.Client, sftpClient *sftp.C
 	// 上传证书文件
 	a.addLog(deployment.ID, *step, "上传证书文件", "")
 	certRemotePath := filepath.Join(deployment.TargetPath, filepath.Base(cert.CertFilePath))
-	if err := a.uploadFile(sftpClient, cert.CertFilePath, certRemotePath); err != nil {
+	if _, err := a.uploadFile(client, sftpClient, cert.CertFilePath, certRemotePath, "", a.transferOptions(deployment, *step)); err != nil {
 		a.updateLog(deployment.ID, *step, "failed", "", err.Error())
 		return fmt.Errorf("上传证书失败: %v", err)
 	}
@@ -747,7 +951,7 @@ func (a *DeploymentAPI) deployCertificate(client *ssh.Client, sftpClient *sftp.C
 	// 上传私钥文件
 	a.addLog(deployment.ID, *step, "上传私钥文件", "")
 	keyRemotePath := filepath.Join(deployment.TargetPath, filepath.Base(cert.KeyFilePath))
-	if err := a.uploadFile(sftpClient, cert.KeyFilePath, keyRemotePath); err != nil {
+	if _, err := a.uploadFile(client, sftpClient, cert.KeyFilePath, keyRemotePath, "", a.transferOptions(deployment, *step)); err != nil {
 		a.updateLog(deployment.ID, *step, "failed", "", err.Error())
 		return fmt.Errorf("上传私钥失败: %v", err)
 	}
@@ -782,48 +986,9 @@ func (a *DeploymentAPI) deployCertificate(client *ssh.Client, sftpClient *sftp.C
 	return nil
 }
 
-// connectSSH 建立 SSH 连接
-func (a *DeploymentAPI) connectSSH(server *models.Server) (*ssh.Client, error) {
-	var authMethods []ssh.AuthMethod
-
-	if server.AuthType == "password" {
-		authMethods = append(authMethods, ssh.Password(server.Password))
-	} else if server.AuthType == "key" {
-		var signer ssh.Signer
-		var err error
-
-		if server.Passphrase != "" {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(server.PrivateKey), []byte(server.Passphrase))
-		} else {
-			signer, err = ssh.ParsePrivateKey([]byte(server.PrivateKey))
-		}
-		if err != nil {
-			return nil, fmt.Errorf("解析私钥失败: %v", err)
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	}
-
-	config := &ssh.ClientConfig{
-		User:            server.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}
-
-	addr := fmt.Sprintf("%s:%d", server.Host, server.Port)
-	return ssh.Dial("tcp", addr, config)
-}
-
 // runCommand 执行远程命令
 func (a *DeploymentAPI) runCommand(client *ssh.Client, cmd string) (string, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return "", err
-	}
-	defer session.Close()
-
-	output, err := session.CombinedOutput(cmd)
-	return string(output), err
+	return runRemote(client, cmd)
 }
 
 // uploadContent 上传内容到远程文件
@@ -850,42 +1015,16 @@ func (a *DeploymentAPI) uploadContent(sftpClient *sftp.Client, remotePath string
 	return nil
 }
 
-// uploadFile 上传本地文件到远程
-func (a *DeploymentAPI) uploadFile(sftpClient *sftp.Client, localPath, remotePath string) error {
-	// 确保目录存在
-	dir := filepath.Dir(remotePath)
-	if err := sftpClient.MkdirAll(dir); err != nil {
-		logger.Warnf("创建目录 %s 失败（可能已存在）: %v", dir, err)
-	}
-
-	localFile, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("打开本地文件失败: %v", err)
-	}
-	defer localFile.Close()
-
-	remoteFile, err := sftpClient.Create(remotePath)
-	if err != nil {
-		return fmt.Errorf("创建远程文件失败: %v", err)
-	}
-	defer remoteFile.Close()
-
-	_, err = io.Copy(remoteFile, localFile)
-	if err != nil {
-		return fmt.Errorf("复制文件失败: %v", err)
-	}
-
-	return nil
-}
-
 // addLog 添加日志
 func (a *DeploymentAPI) addLog(deploymentID uint, step int, action, output string) {
 	log := &models.DeploymentLog{
 		DeploymentID: deploymentID,
-		Step:         step,
-		Action:       action,
-		Status:       "running",
-		Output:       output,
+		StepLog: models.StepLog{
+			Step:   step,
+			Action: action,
+			Status: "running",
+			Output: output,
+		},
 	}
 	db.DB.Create(log)
 }
@@ -947,6 +1086,9 @@ func (a *DeploymentAPI) Rollback(c *gin.Context) {
 		response.Error(c, http.StatusInternalServerError, "创建回滚任务失败")
 		return
 	}
+	if err := copyDeploymentHooks(db.DB, deployment.ID, rollbackDeployment.ID); err != nil {
+		logger.Warnf("复制钩子到回滚任务失败: %v", err)
+	}
 
 	// 异步执行回滚
 	go a.executeRollback(rollbackDeployment, &deployment)
@@ -995,13 +1137,14 @@ func (a *DeploymentAPI) executeRollback(rollbackDeployment, originalDeployment *
 
 	// 1. 建立 SSH 连接
 	a.addLog(rollbackDeployment.ID, step, "建立 SSH 连接", "")
-	client, err := a.connectSSH(originalDeployment.Server)
+	lease, err := sshPool.Acquire(originalDeployment.Server)
 	if err != nil {
 		finalErr = fmt.Errorf("SSH 连接失败: %v", err)
 		a.updateLog(rollbackDeployment.ID, step, "failed", "", finalErr.Error())
 		return
 	}
-	defer client.Close()
+	defer lease.Release()
+	client := lease.Client
 	a.updateLog(rollbackDeployment.ID, step, "success", "连接成功", "")
 	step++
 
@@ -1139,6 +1282,12 @@ func (a *DeploymentAPI) StreamLogs(c *gin.Context) {
 			fmt.Fprintf(c.Writer, "event: log\ndata: %s\n\n", data)
 			c.Writer.Flush()
 
+		case progress := <-exec.progressChan:
+			// 发送文件传输进度
+			data, _ := json.Marshal(progress)
+			fmt.Fprintf(c.Writer, "event: progress\ndata: %s\n\n", data)
+			c.Writer.Flush()
+
 		case <-exec.done:
 			// 部署完成
 			fmt.Fprintf(c.Writer, "event: done\ndata: {}\n\n")
@@ -1157,10 +1306,12 @@ func (a *DeploymentAPI) StreamLogs(c *gin.Context) {
 func (a *DeploymentAPI) handleCancellation(deployment *models.Deployment, logChan chan<- *models.DeploymentLog) {
 	log := &models.DeploymentLog{
 		DeploymentID: deployment.ID,
-		Step:         999,
-		Action:       "部署已取消",
-		Status:       "cancelled",
-		Output:       "用户主动取消了部署任务",
+		StepLog: models.StepLog{
+			Step:   999,
+			Action: "部署已取消",
+			Status: "cancelled",
+			Output: "用户主动取消了部署任务",
+		},
 	}
 	db.DB.Create(log)
 	if logChan != nil {
@@ -1222,7 +1373,7 @@ func (a *DeploymentAPI) executeDeploymentWithContext(
 	db.DB.Create(log)
 	logChan <- log
 
-	client, err := a.connectSSH(deployment.Server)
+	lease, err := sshPool.Acquire(deployment.Server)
 	if err != nil {
 		finalErr = fmt.Errorf("SSH 连接失败: %v", err)
 		log.Status = "failed"
@@ -1232,7 +1383,8 @@ func (a *DeploymentAPI) executeDeploymentWithContext(
 		a.finishDeployment(deployment, finalErr)
 		return
 	}
-	defer client.Close()
+	defer lease.Release()
+	client := lease.Client
 
 	log.Status = "success"
 	log.Output = "连接成功"
@@ -1280,7 +1432,11 @@ func (a *DeploymentAPI) executeDeploymentWithContext(
 	}
 
 	// 3. 执行 pre_deploy 钩子
-	if err := executeHooksByType(deployment, "pre_deploy", client, sftpClient); err != nil {
+	if err := executeHooksByType(ctx, deployment, "pre_deploy", client, sftpClient, &step); err != nil {
+		if ctx.Err() != nil {
+			a.handleCancellation(deployment, logChan)
+			return
+		}
 		finalErr = fmt.Errorf("pre_deploy 钩子执行失败: %v", err)
 		a.finishDeployment(deployment, finalErr)
 		return
@@ -1304,15 +1460,13 @@ func (a *DeploymentAPI) executeDeploymentWithContext(
 		finalErr = a.deployCertificate(client, sftpClient, deployment, &step)
 	}
 
-	// 5. 执行 post_deploy 钩子（无论成功或失败都执行）
-	executeHooksByType(deployment, "post_deploy", client, sftpClient)
+	// 5. 执行 post_deploy 钩子（无论成功或失败都执行），fail_deployment 策略的钩子失败时部署失败
+	if err := executeHooksByType(ctx, deployment, "post_deploy", client, sftpClient, &step); err != nil && finalErr == nil {
+		finalErr = err
+	}
 
 	// 6. 根据结果执行 on_success 或 on_failure 钩子
-	if finalErr != nil {
-		executeHooksByType(deployment, "on_failure", client, sftpClient)
-	} else {
-		executeHooksByType(deployment, "on_success", client, sftpClient)
-	}
+	finalErr = executeResultHooks(ctx, deployment, client, sftpClient, &step, finalErr)
 
 	// 最终状态更新
 	a.finishDeployment(deployment, finalErr)
@@ -1322,9 +1476,11 @@ func (a *DeploymentAPI) executeDeploymentWithContext(
 func (a *DeploymentAPI) createLog(deploymentID uint, step int, action, status string) *models.DeploymentLog {
 	return &models.DeploymentLog{
 		DeploymentID: deploymentID,
-		Step:         step,
-		Action:       action,
-		Status:       status,
+		StepLog: models.StepLog{
+			Step:   step,
+			Action: action,
+			Status: status,
+		},
 	}
 }
 
@@ -0,0 +1,603 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+	"gorm.io/gorm"
+)
+
+// hookTypes 支持的钩子类型
+var hookTypes = map[string]bool{
+	"pre_deploy":  true,
+	"post_deploy": true,
+	"on_success":  true,
+	"on_failure":  true,
+}
+
+// HookRequest 创建/更新钩子请求
+type HookRequest struct {
+	HookType   string `json:"hook_type" binding:"required"`
+	ScriptType string `json:"script_type" binding:"omitempty,oneof=shell bash python"`
+	Content    string `json:"content" binding:"required"`
+	Timeout    int    `json:"timeout"`
+	WorkDir    string `json:"work_dir"`
+	Variables  string `json:"variables"`
+	Templated  bool   `json:"templated"` // 按模板渲染（提供了变量取值时总是渲染）
+	SortOrder  *int   `json:"sort_order"`
+
+	FailurePolicy string `json:"failure_policy" binding:"omitempty,oneof=fail_deployment continue_on_error"`
+	Condition     string `json:"condition"` // HookCondition 的 JSON
+	RetryCount    int    `json:"retry_count" binding:"min=0,max=10"`
+}
+
+// AttachScriptRequest 从脚本模板创建钩子请求
+type AttachScriptRequest struct {
+	ScriptID  uint              `json:"script_id" binding:"required"`
+	Revision  int               `json:"revision"` // 固定使用的脚本修订版本，默认当前版本
+	HookType  string            `json:"hook_type" binding:"required"`
+	Variables map[string]string `json:"variables"` // 变量取值
+	SortOrder *int              `json:"sort_order"`
+
+	FailurePolicy string `json:"failure_policy" binding:"omitempty,oneof=fail_deployment continue_on_error"`
+	Condition     string `json:"condition"` // HookCondition 的 JSON
+	RetryCount    int    `json:"retry_count" binding:"min=0,max=10"`
+}
+
+// ReorderHooksRequest 调整钩子顺序请求
+type ReorderHooksRequest struct {
+	HookIDs []uint `json:"hook_ids" binding:"required"` // 按执行顺序排列的钩子 ID
+}
+
+// loadEditableDeployment 加载可编辑钩子的部署任务，执行中的任务不允许修改
+func loadEditableDeployment(c *gin.Context) (*models.Deployment, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的 ID")
+		return nil, false
+	}
+
+	var deployment models.Deployment
+	if err := db.DB.Preload("Server").Preload("Package").First(&deployment, id).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "部署任务不存在")
+		return nil, false
+	}
+	if deployment.Status == models.DeployStatusRunning {
+		response.Error(c, http.StatusBadRequest, "部署任务正在执行，不能修改钩子")
+		return nil, false
+	}
+	return &deployment, true
+}
+
+// loadDeploymentHook 加载属于指定部署任务的钩子
+func loadDeploymentHook(c *gin.Context, deploymentID uint) (*models.DeploymentHook, bool) {
+	hookID, err := strconv.ParseUint(c.Param("hook_id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的钩子 ID")
+		return nil, false
+	}
+
+	var hook models.DeploymentHook
+	if err := db.DB.Where("id = ? AND deployment_id = ?", hookID, deploymentID).First(&hook).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "钩子不存在")
+		return nil, false
+	}
+	return &hook, true
+}
+
+// nextHookSortOrder 获取部署任务下一个钩子的执行顺序
+func nextHookSortOrder(deploymentID uint) int {
+	var maxOrder *int
+	db.DB.Model(&models.DeploymentHook{}).Where("deployment_id = ?", deploymentID).
+		Select("MAX(sort_order)").Scan(&maxOrder)
+	if maxOrder == nil {
+		return 1
+	}
+	return *maxOrder + 1
+}
+
+// ListHooks 获取部署任务的钩子列表
+func (a *DeploymentAPI) ListHooks(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的 ID")
+		return
+	}
+
+	query := db.DB.Where("deployment_id = ?", id)
+	if hookType := c.Query("hook_type"); hookType != "" {
+		query = query.Where("hook_type = ?", hookType)
+	}
+
+	var hooks []models.DeploymentHook
+	if err := query.Preload("Script").Order("sort_order ASC, id ASC").Find(&hooks).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询钩子失败")
+		return
+	}
+
+	response.Success(c, hooks)
+}
+
+// CreateHook 为部署任务添加钩子
+func (a *DeploymentAPI) CreateHook(c *gin.Context) {
+	deployment, ok := loadEditableDeployment(c)
+	if !ok {
+		return
+	}
+
+	var req HookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误: "+err.Error())
+		return
+	}
+	if !hookTypes[req.HookType] {
+		response.Error(c, http.StatusBadRequest, "不支持的钩子类型: "+req.HookType)
+		return
+	}
+	if _, err := parseHookCondition(req.Condition); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hook := &models.DeploymentHook{
+		DeploymentID: deployment.ID,
+		HookType:     req.HookType,
+		ScriptType:   defaultString(req.ScriptType, "shell"),
+		Content:      req.Content,
+		Timeout:      defaultInt(req.Timeout, 300),
+		WorkDir:      req.WorkDir,
+		Variables:    req.Variables,
+		Templated:    req.Templated,
+
+		FailurePolicy: req.FailurePolicy,
+		Condition:     req.Condition,
+		RetryCount:    req.RetryCount,
+	}
+	rendered, err := renderHook(hook, deployment)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	hook.RenderedContent = rendered
+	if req.SortOrder != nil {
+		hook.SortOrder = *req.SortOrder
+	} else {
+		hook.SortOrder = nextHookSortOrder(deployment.ID)
+	}
+
+	if err := db.DB.Create(hook).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建钩子失败")
+		return
+	}
+
+	response.Success(c, hook)
+}
+
+// AttachScript 从脚本模板实例化钩子
+func (a *DeploymentAPI) AttachScript(c *gin.Context) {
+	deployment, ok := loadEditableDeployment(c)
+	if !ok {
+		return
+	}
+
+	var req AttachScriptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误: "+err.Error())
+		return
+	}
+	if !hookTypes[req.HookType] {
+		response.Error(c, http.StatusBadRequest, "不支持的钩子类型: "+req.HookType)
+		return
+	}
+	if _, err := parseHookCondition(req.Condition); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var script models.DeploymentScript
+	if err := db.DB.First(&script, req.ScriptID).Error; err != nil {
+		response.Error(c, http.StatusBadRequest, "脚本模板不存在")
+		return
+	}
+	if script.Status != "active" {
+		response.Error(c, http.StatusBadRequest, "脚本模板已停用")
+		return
+	}
+
+	rev, err := loadScriptRevision(&script, req.Revision)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	variables, rendered, err := instantiateScriptRevision(rev, deployment, req.Variables)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hook := &models.DeploymentHook{
+		DeploymentID:    deployment.ID,
+		ScriptID:        &script.ID,
+		ScriptRevision:  rev.Revision,
+		HookType:        req.HookType,
+		ScriptType:      rev.ScriptType,
+		Content:         rev.Content,
+		Timeout:         defaultInt(rev.Timeout, 300),
+		WorkDir:         rev.WorkDir,
+		Variables:       variables,
+		Templated:       rev.Templated,
+		RenderedContent: rendered,
+
+		FailurePolicy: req.FailurePolicy,
+		Condition:     req.Condition,
+		RetryCount:    req.RetryCount,
+	}
+	if req.SortOrder != nil {
+		hook.SortOrder = *req.SortOrder
+	} else {
+		hook.SortOrder = nextHookSortOrder(deployment.ID)
+	}
+
+	if err := db.DB.Create(hook).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建钩子失败")
+		return
+	}
+
+	hook.Script = &script
+	response.Success(c, hook)
+}
+
+// UpdateHook 更新钩子
+func (a *DeploymentAPI) UpdateHook(c *gin.Context) {
+	deployment, ok := loadEditableDeployment(c)
+	if !ok {
+		return
+	}
+	hook, ok := loadDeploymentHook(c, deployment.ID)
+	if !ok {
+		return
+	}
+
+	var req HookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误: "+err.Error())
+		return
+	}
+	if !hookTypes[req.HookType] {
+		response.Error(c, http.StatusBadRequest, "不支持的钩子类型: "+req.HookType)
+		return
+	}
+	if _, err := parseHookCondition(req.Condition); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	preview := *hook
+	preview.Content, preview.Variables, preview.Templated = req.Content, req.Variables, req.Templated
+	rendered, err := renderHook(&preview, deployment)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updates := map[string]interface{}{
+		"hook_type":        req.HookType,
+		"script_type":      defaultString(req.ScriptType, "shell"),
+		"content":          req.Content,
+		"timeout":          defaultInt(req.Timeout, 300),
+		"work_dir":         req.WorkDir,
+		"variables":        req.Variables,
+		"templated":        req.Templated,
+		"rendered_content": rendered,
+		"failure_policy":   req.FailurePolicy,
+		"condition":        req.Condition,
+		"retry_count":      req.RetryCount,
+	}
+	if req.SortOrder != nil {
+		updates["sort_order"] = *req.SortOrder
+	}
+	// 手动修改内容后不再对应任何脚本修订版本
+	if req.Content != hook.Content {
+		updates["script_revision"] = 0
+	}
+
+	if err := db.DB.Model(hook).Updates(updates).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "更新钩子失败")
+		return
+	}
+
+	db.DB.First(hook, hook.ID)
+	response.Success(c, hook)
+}
+
+// DeleteHook 删除钩子
+func (a *DeploymentAPI) DeleteHook(c *gin.Context) {
+	deployment, ok := loadEditableDeployment(c)
+	if !ok {
+		return
+	}
+	hook, ok := loadDeploymentHook(c, deployment.ID)
+	if !ok {
+		return
+	}
+
+	if err := db.DB.Delete(hook).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "删除钩子失败")
+		return
+	}
+
+	response.Success(c, nil)
+}
+
+// instantiateScriptRevision 按脚本修订版本的变量定义解析变量取值并渲染，返回变量取值 JSON 与渲染结果。
+// 未启用模板的修订版本原样返回内容
+func instantiateScriptRevision(rev *models.DeploymentScriptRevision, deployment *models.Deployment, values map[string]string) (string, string, error) {
+	defs, err := parseScriptVariables(rev.Variables)
+	if err != nil {
+		return "", "", err
+	}
+	resolved, err := resolveScriptVariables(defs, values)
+	if err != nil {
+		return "", "", err
+	}
+	rendered := rev.Content
+	if scriptTemplated(rev.Templated, rev.Variables) {
+		if rendered, err = renderScript(rev.Content, scriptTemplateData(deployment, resolved)); err != nil {
+			return "", "", err
+		}
+	}
+
+	variables := ""
+	if len(resolved) > 0 {
+		data, _ := json.Marshal(resolved)
+		variables = string(data)
+	}
+	return variables, rendered, nil
+}
+
+// PinHookRevision 将从脚本模板创建的钩子切换到指定修订版本（沿用原有变量取值）
+func (a *DeploymentAPI) PinHookRevision(c *gin.Context) {
+	deployment, ok := loadEditableDeployment(c)
+	if !ok {
+		return
+	}
+	hook, ok := loadDeploymentHook(c, deployment.ID)
+	if !ok {
+		return
+	}
+	if hook.ScriptID == nil {
+		response.Error(c, http.StatusBadRequest, "该钩子不是从脚本模板创建的")
+		return
+	}
+
+	var req struct {
+		Revision int `json:"revision"` // 0 表示当前版本
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误: "+err.Error())
+		return
+	}
+
+	var script models.DeploymentScript
+	if err := db.DB.First(&script, *hook.ScriptID).Error; err != nil {
+		response.Error(c, http.StatusBadRequest, "脚本模板不存在")
+		return
+	}
+	rev, err := loadScriptRevision(&script, req.Revision)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	values, err := parseHookVariables(hook.Variables)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	variables, rendered, err := instantiateScriptRevision(rev, deployment, values)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.DB.Model(hook).Updates(map[string]interface{}{
+		"script_revision":  rev.Revision,
+		"script_type":      rev.ScriptType,
+		"content":          rev.Content,
+		"timeout":          defaultInt(rev.Timeout, 300),
+		"work_dir":         rev.WorkDir,
+		"variables":        variables,
+		"templated":        rev.Templated,
+		"rendered_content": rendered,
+	}).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "更新钩子失败")
+		return
+	}
+
+	db.DB.First(hook, hook.ID)
+	response.Success(c, hook)
+}
+
+// ReorderHooks 按给定顺序重排钩子，未列出的钩子排在其后并保持原有相对顺序
+func (a *DeploymentAPI) ReorderHooks(c *gin.Context) {
+	deployment, ok := loadEditableDeployment(c)
+	if !ok {
+		return
+	}
+
+	var req ReorderHooksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误: "+err.Error())
+		return
+	}
+
+	var hooks []models.DeploymentHook
+	db.DB.Where("deployment_id = ?", deployment.ID).Order("sort_order ASC, id ASC").Find(&hooks)
+
+	existing := make(map[uint]bool, len(hooks))
+	for _, h := range hooks {
+		existing[h.ID] = true
+	}
+	seen := make(map[uint]bool, len(req.HookIDs))
+	for _, id := range req.HookIDs {
+		if !existing[id] {
+			response.Error(c, http.StatusBadRequest, fmt.Sprintf("钩子 %d 不属于该部署任务", id))
+			return
+		}
+		if seen[id] {
+			response.Error(c, http.StatusBadRequest, fmt.Sprintf("钩子 %d 重复", id))
+			return
+		}
+		seen[id] = true
+	}
+
+	order := append([]uint{}, req.HookIDs...)
+	for _, h := range hooks {
+		if !seen[h.ID] {
+			order = append(order, h.ID)
+		}
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		for i, id := range order {
+			if err := tx.Model(&models.DeploymentHook{}).Where("id = ?", id).Update("sort_order", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "调整钩子顺序失败")
+		return
+	}
+
+	db.DB.Where("deployment_id = ?", deployment.ID).Order("sort_order ASC, id ASC").Find(&hooks)
+	response.Success(c, hooks)
+}
+
+// copyDeploymentHooks 将钩子配置复制到另一个部署任务（不复制执行结果）
+func copyDeploymentHooks(tx *gorm.DB, fromID, toID uint) error {
+	var hooks []models.DeploymentHook
+	if err := tx.Where("deployment_id = ?", fromID).Order("sort_order ASC, id ASC").Find(&hooks).Error; err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	copies := make([]models.DeploymentHook, 0, len(hooks))
+	for _, h := range hooks {
+		copies = append(copies, models.DeploymentHook{
+			DeploymentID:   toID,
+			ScriptID:       h.ScriptID,
+			ScriptRevision: h.ScriptRevision,
+			HookType:       h.HookType,
+			ScriptType:     h.ScriptType,
+			Content:        h.Content,
+			SortOrder:      h.SortOrder,
+			Timeout:        h.Timeout,
+			WorkDir:        h.WorkDir,
+			Variables:      h.Variables,
+			Templated:      h.Templated,
+
+			FailurePolicy: h.FailurePolicy,
+			Condition:     h.Condition,
+			RetryCount:    h.RetryCount,
+		})
+	}
+	return tx.Create(&copies).Error
+}
+
+// Clone 复制部署任务（包括钩子配置），生成新的待执行任务
+func (a *DeploymentAPI) Clone(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的 ID")
+		return
+	}
+
+	var source models.Deployment
+	if err := db.DB.First(&source, id).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "部署任务不存在")
+		return
+	}
+
+	var req struct {
+		Name          string `json:"name"`
+		ServerID      uint   `json:"server_id"`       // 可选，复制到另一台服务器
+		IgnoreOSCheck *bool  `json:"ignore_os_check"` // 可选，默认沿用原任务的设置
+	}
+	// 请求体可以为空，表示按原任务复制
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.Error(c, http.StatusBadRequest, "请求参数错误: "+err.Error())
+		return
+	}
+
+	clone := &models.Deployment{
+		Name:            defaultString(req.Name, source.Name+" (副本)"),
+		Description:     source.Description,
+		Type:            source.Type,
+		ServerID:        source.ServerID,
+		Status:          models.DeployStatusPending,
+		NginxConfigID:   source.NginxConfigID,
+		PackageID:       source.PackageID,
+		CertificateID:   source.CertificateID,
+		TargetPath:      source.TargetPath,
+		BackupEnabled:   source.BackupEnabled,
+		RestartService:  source.RestartService,
+		ServiceName:     source.ServiceName,
+		DeployParams:    source.DeployParams,
+		BandwidthLimit:  source.BandwidthLimit,
+		PreflightPolicy: source.PreflightPolicy,
+		IgnoreOSCheck:   source.IgnoreOSCheck,
+		RelayServerID:   source.RelayServerID,
+		RelayMethod:     source.RelayMethod,
+	}
+	if req.IgnoreOSCheck != nil {
+		clone.IgnoreOSCheck = *req.IgnoreOSCheck
+	}
+	if req.ServerID != 0 {
+		var server models.Server
+		if err := db.DB.First(&server, req.ServerID).Error; err != nil {
+			response.Error(c, http.StatusBadRequest, "服务器不存在")
+			return
+		}
+		clone.ServerID = server.ID
+
+		// 复制到其他服务器时与创建任务一样校验离线包兼容性
+		if clone.PackageID != nil {
+			var pkg models.MiddlewarePackage
+			if err := db.DB.First(&pkg, *clone.PackageID).Error; err != nil {
+				response.Error(c, http.StatusBadRequest, "离线包不存在")
+				return
+			}
+			if !checkPackageTarget(c, &pkg, &server, clone.IgnoreOSCheck) {
+				return
+			}
+		}
+	}
+
+	// 任务与钩子一并创建，避免钩子复制失败时留下缺少钩子的副本
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(clone).Error; err != nil {
+			return err
+		}
+		return copyDeploymentHooks(tx, source.ID, clone.ID)
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "复制部署任务失败")
+		return
+	}
+
+	db.DB.Preload("Hooks", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("sort_order ASC, id ASC")
+	}).First(clone, clone.ID)
+	response.Success(c, clone)
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -13,7 +16,7 @@ import (
 )
 
 // executeHook 执行单个钩子
-func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient *sftp.Client) error {
+func executeHook(hook *models.DeploymentHook, deployment *models.Deployment, sshClient *ssh.Client, sftpClient *sftp.Client) error {
 	startTime := time.Now()
 	hook.Executed = true
 	now := time.Now()
@@ -36,8 +39,16 @@ func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient
 	scriptFileName := fmt.Sprintf("hook_%d_%s.sh", hook.ID, hook.HookType)
 	scriptPath := fmt.Sprintf("%s/%s", workDir, scriptFileName)
 
-	// 上传脚本内容到服务器
-	scriptContent := hook.Content
+	// 使用部署上下文渲染脚本，渲染结果保存到钩子记录中用于审计
+	scriptContent, err := renderHook(hook, deployment)
+	if err != nil {
+		hook.Status = "failed"
+		hook.ErrorMsg = err.Error()
+		logger.Error(hook.ErrorMsg)
+		return err
+	}
+	hook.RenderedContent = scriptContent
+	hook.ExecutedRevision = hook.ScriptRevision
 
 	// 添加 shebang
 	if hook.ScriptType == "shell" || hook.ScriptType == "bash" {
@@ -55,8 +66,8 @@ func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient
 	if err != nil {
 		hook.Status = "failed"
 		hook.ErrorMsg = fmt.Sprintf("创建脚本文件失败: %v", err)
-		logger.Errorf(hook.ErrorMsg)
-		return fmt.Errorf(hook.ErrorMsg)
+		logger.Error(hook.ErrorMsg)
+		return errors.New(hook.ErrorMsg)
 	}
 
 	_, err = remoteFile.Write([]byte(scriptContent))
@@ -64,16 +75,16 @@ func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient
 	if err != nil {
 		hook.Status = "failed"
 		hook.ErrorMsg = fmt.Sprintf("写入脚本内容失败: %v", err)
-		logger.Errorf(hook.ErrorMsg)
-		return fmt.Errorf(hook.ErrorMsg)
+		logger.Error(hook.ErrorMsg)
+		return errors.New(hook.ErrorMsg)
 	}
 
 	// 设置脚本可执行权限
 	if err := sftpClient.Chmod(scriptPath, 0755); err != nil {
 		hook.Status = "failed"
 		hook.ErrorMsg = fmt.Sprintf("设置脚本权限失败: %v", err)
-		logger.Errorf(hook.ErrorMsg)
-		return fmt.Errorf(hook.ErrorMsg)
+		logger.Error(hook.ErrorMsg)
+		return errors.New(hook.ErrorMsg)
 	}
 
 	// 执行脚本
@@ -81,8 +92,8 @@ func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient
 	if err != nil {
 		hook.Status = "failed"
 		hook.ErrorMsg = fmt.Sprintf("创建 SSH 会话失败: %v", err)
-		logger.Errorf(hook.ErrorMsg)
-		return fmt.Errorf(hook.ErrorMsg)
+		logger.Error(hook.ErrorMsg)
+		return errors.New(hook.ErrorMsg)
 	}
 	defer session.Close()
 
@@ -113,7 +124,7 @@ func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient
 			hook.Status = "failed"
 			hook.ErrorMsg = fmt.Sprintf("脚本执行失败: %v", err)
 			logger.Errorf("钩子执行失败: %s - %v", hook.HookType, err)
-			return fmt.Errorf(hook.ErrorMsg)
+			return errors.New(hook.ErrorMsg)
 		}
 
 		hook.Status = "success"
@@ -123,7 +134,7 @@ func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient
 	case <-time.After(timeout):
 		hook.Status = "failed"
 		hook.ErrorMsg = fmt.Sprintf("脚本执行超时（超过 %d 秒）", hook.Timeout)
-		logger.Errorf(hook.ErrorMsg)
+		logger.Error(hook.ErrorMsg)
 
 		// 尝试清理
 		cleanupSession, _ := sshClient.NewSession()
@@ -132,16 +143,104 @@ func executeHook(hook *models.DeploymentHook, sshClient *ssh.Client, sftpClient
 			cleanupSession.Close()
 		}
 
-		return fmt.Errorf(hook.ErrorMsg)
+		return errors.New(hook.ErrorMsg)
+	}
+}
+
+// 钩子失败策略
+const (
+	hookFailDeployment  = "fail_deployment"   // 失败则部署失败（pre_deploy 失败时中止部署）
+	hookContinueOnError = "continue_on_error" // 失败仅记录警告
+)
+
+// hookRetryDelay 钩子重试间隔
+const hookRetryDelay = 5 * time.Second
+
+// hookFailurePolicy 钩子生效的失败策略：未设置时 pre_deploy 中止部署，其余仅警告
+func hookFailurePolicy(hook *models.DeploymentHook) string {
+	if hook.FailurePolicy != "" {
+		return hook.FailurePolicy
+	}
+	if hook.HookType == "pre_deploy" {
+		return hookFailDeployment
 	}
+	return hookContinueOnError
 }
 
-// executeHooksByType 执行指定类型的所有钩子
-func executeHooksByType(deployment *models.Deployment, hookType string, sshClient *ssh.Client, sftpClient *sftp.Client) error {
+// parseHookCondition 解析钩子执行条件
+func parseHookCondition(raw string) (*models.HookCondition, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var cond models.HookCondition
+	if err := json.Unmarshal([]byte(raw), &cond); err != nil {
+		return nil, fmt.Errorf("执行条件格式错误: %v", err)
+	}
+	return &cond, nil
+}
+
+// hookConditionMet 判断钩子执行条件是否满足，不满足时返回原因
+func hookConditionMet(hook *models.DeploymentHook, deployment *models.Deployment) (bool, string) {
+	cond, err := parseHookCondition(hook.Condition)
+	if err != nil {
+		return false, err.Error()
+	}
+	if cond == nil {
+		return true, ""
+	}
+
+	if len(cond.OSTypes) > 0 {
+		osType := ""
+		if deployment.Server != nil {
+			osType = deployment.Server.OSType
+		}
+		if !containsFold(cond.OSTypes, osType) {
+			return false, fmt.Sprintf("服务器操作系统 %s 不在 %s 中", defaultString(osType, "未知"), strings.Join(cond.OSTypes, ", "))
+		}
+	}
+
+	if len(cond.DeploymentTypes) > 0 && !containsFold(cond.DeploymentTypes, string(deployment.Type)) {
+		return false, fmt.Sprintf("部署类型 %s 不在 %s 中", deployment.Type, strings.Join(cond.DeploymentTypes, ", "))
+	}
+
+	if cond.Param != "" {
+		params := map[string]interface{}{}
+		if deployment.DeployParams != "" {
+			json.Unmarshal([]byte(deployment.DeployParams), &params)
+		}
+		value := ""
+		if v, ok := params[cond.Param]; ok && v != nil {
+			value = fmt.Sprint(v)
+		}
+		if len(cond.ParamValues) == 0 {
+			if value == "" {
+				return false, fmt.Sprintf("部署参数 %s 未设置", cond.Param)
+			}
+		} else if !containsFold(cond.ParamValues, value) {
+			return false, fmt.Sprintf("部署参数 %s=%s 不在 %s 中", cond.Param, value, strings.Join(cond.ParamValues, ", "))
+		}
+	}
+
+	return true, ""
+}
+
+// containsFold 判断列表中是否包含指定值（忽略大小写）
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// executeHooksByType 按顺序执行指定类型的所有钩子，每个钩子占用一个日志步骤。
+// 只有失败策略为 fail_deployment 的钩子在重试耗尽后仍失败，或等待重试时部署被取消才返回错误。
+func executeHooksByType(ctx context.Context, deployment *models.Deployment, hookType string, sshClient *ssh.Client, sftpClient *sftp.Client, step *int) error {
 	// 获取该部署的所有钩子
 	var hooks []models.DeploymentHook
 	if err := db.DB.Where("deployment_id = ? AND hook_type = ?", deployment.ID, hookType).
-		Order("id ASC").
+		Order("sort_order ASC, id ASC").
 		Find(&hooks).Error; err != nil {
 		logger.Errorf("获取钩子失败: %v", err)
 		return err
@@ -156,43 +255,98 @@ func executeHooksByType(deployment *models.Deployment, hookType string, sshClien
 
 	for i := range hooks {
 		hook := &hooks[i]
+		policy := hookFailurePolicy(hook)
 
-		// 记录日志
+		// 记录日志，从脚本模板创建的钩子标明所执行的修订版本
+		action := fmt.Sprintf("执行钩子: %s #%d", hookType, hook.ID)
+		if hook.ScriptID != nil && hook.ScriptRevision > 0 {
+			action += fmt.Sprintf("（脚本 #%d r%d）", *hook.ScriptID, hook.ScriptRevision)
+		}
 		logEntry := &models.DeploymentLog{
 			DeploymentID: deployment.ID,
-			Step:         0, // 钩子日志不计入常规步骤
-			Action:       fmt.Sprintf("执行钩子: %s", hookType),
-			Status:       "running",
+			StepLog: models.StepLog{
+				Step:   *step,
+				Action: action,
+				Status: "running",
+			},
 		}
 		db.DB.Create(logEntry)
+		(*step)++
 
-		// 执行钩子
-		err := executeHook(hook, sshClient, sftpClient)
+		// 检查执行条件
+		if ok, reason := hookConditionMet(hook, deployment); !ok {
+			hook.Status = "skipped"
+			hook.Output = "未满足执行条件: " + reason
+			hook.ErrorMsg = ""
+			db.DB.Save(hook)
 
-		// 更新日志
-		if err != nil {
-			logEntry.Status = "failed"
-			logEntry.ErrorMsg = hook.ErrorMsg
+			logEntry.Status = "skipped"
 			logEntry.Output = hook.Output
 			db.DB.Save(logEntry)
+			continue
+		}
 
-			// pre_deploy 钩子失败则终止部署
-			if hookType == "pre_deploy" {
-				return fmt.Errorf("pre_deploy 钩子执行失败，终止部署: %v", err)
+		// 执行钩子，失败时按重试次数重试
+		var err error
+		var attemptLog []string
+		for attempt := 0; attempt <= hook.RetryCount; attempt++ {
+			if attempt > 0 {
+				attemptLog = append(attemptLog, fmt.Sprintf("第 %d 次执行失败: %s", attempt, hook.ErrorMsg))
+				logger.Warnf("钩子 #%d 执行失败，%v 后进行第 %d 次重试", hook.ID, hookRetryDelay, attempt)
+				timer := time.NewTimer(hookRetryDelay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					logEntry.Status = "failed"
+					logEntry.Output = strings.Join(attemptLog, "\n")
+					logEntry.ErrorMsg = "部署已取消，停止重试"
+					db.DB.Save(logEntry)
+					return fmt.Errorf("%s 钩子 #%d 等待重试时部署已取消", hookType, hook.ID)
+				case <-timer.C:
+				}
+				hook.ErrorMsg = ""
 			}
+			hook.Attempts = attempt + 1
+			if err = executeHook(hook, deployment, sshClient, sftpClient); err == nil {
+				break
+			}
+		}
 
-			// post_deploy 和其他钩子失败仅记录警告
-			logger.Warnf("%s 钩子执行失败: %v", hookType, err)
-		} else {
+		output := hook.Output
+		if len(attemptLog) > 0 {
+			output = strings.Join(attemptLog, "\n") + "\n\n" + output
+		}
+		logEntry.Output = output
+		logEntry.Duration = int(hook.Duration)
+
+		if err == nil {
 			logEntry.Status = "success"
-			logEntry.Output = hook.Output
 			db.DB.Save(logEntry)
+			continue
 		}
 
-		logEntry.Duration = int(hook.Duration)
+		logEntry.Status = "failed"
+		logEntry.ErrorMsg = hook.ErrorMsg
 		db.DB.Save(logEntry)
+
+		if policy == hookFailDeployment {
+			return fmt.Errorf("%s 钩子 #%d 执行失败: %v", hookType, hook.ID, err)
+		}
+		logger.Warnf("%s 钩子 #%d 执行失败（继续执行）: %v", hookType, hook.ID, err)
 	}
 
 	logger.Infof("完成执行 %s 钩子", hookType)
 	return nil
 }
+
+// executeResultHooks 根据部署结果执行 on_success 或 on_failure 钩子，返回部署的最终错误。
+// on_success 钩子使部署失败时，同样执行 on_failure 钩子
+func executeResultHooks(ctx context.Context, deployment *models.Deployment, sshClient *ssh.Client, sftpClient *sftp.Client, step *int, deployErr error) error {
+	if deployErr == nil {
+		if deployErr = executeHooksByType(ctx, deployment, "on_success", sshClient, sftpClient, step); deployErr == nil {
+			return nil
+		}
+	}
+	executeHooksByType(ctx, deployment, "on_failure", sshClient, sftpClient, step)
+	return deployErr
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestHookConditionMet(t *testing.T) {
+	deployment := &models.Deployment{
+		Type:         models.DeployTypePackage,
+		DeployParams: `{"mode":"cluster","replicas":3}`,
+		Server:       &models.Server{OSType: "rocky"},
+	}
+
+	tests := []struct {
+		name      string
+		condition string
+		want      bool
+	}{
+		{"无条件", "", true},
+		{"操作系统匹配", `{"os_types":["centos","Rocky"]}`, true},
+		{"操作系统不匹配", `{"os_types":["ubuntu"]}`, false},
+		{"部署类型不匹配", `{"deployment_types":["nginx_config"]}`, false},
+		{"参数取值匹配", `{"param":"mode","param_values":["cluster"]}`, true},
+		{"数值参数匹配", `{"param":"replicas","param_values":["3"]}`, true},
+		{"参数未设置", `{"param":"tls"}`, false},
+		{"多个条件同时满足", `{"os_types":["rocky"],"deployment_types":["package"],"param":"mode"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, reason := hookConditionMet(&models.DeploymentHook{Condition: tt.condition}, deployment)
+			assert.Equal(t, tt.want, ok, reason)
+		})
+	}
+
+	assert.Equal(t, hookFailDeployment, hookFailurePolicy(&models.DeploymentHook{HookType: "pre_deploy"}))
+	assert.Equal(t, hookContinueOnError, hookFailurePolicy(&models.DeploymentHook{HookType: "post_deploy"}))
+}
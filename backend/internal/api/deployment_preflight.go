@@ -0,0 +1,389 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+	"golang.org/x/crypto/ssh"
+)
+
+// 预检项状态
+const (
+	preflightPass = "pass"
+	preflightFail = "fail"
+	preflightSkip = "skip"
+)
+
+// PreflightCheck 单项预检结果
+type PreflightCheck struct {
+	Name     string `json:"name"`     // 检查项
+	Status   string `json:"status"`   // pass, fail, skip
+	Expected string `json:"expected"` // 要求
+	Actual   string `json:"actual"`   // 实际情况
+	Message  string `json:"message,omitempty"`
+}
+
+// PreflightReport 预检报告
+type PreflightReport struct {
+	Passed bool             `json:"passed"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// add 添加检查项
+func (r *PreflightReport) add(check PreflightCheck) {
+	if check.Status == preflightFail {
+		r.Passed = false
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// Failed 返回未通过的检查项名称
+func (r *PreflightReport) Failed() []string {
+	var names []string
+	for _, check := range r.Checks {
+		if check.Status == preflightFail {
+			names = append(names, check.Name)
+		}
+	}
+	return names
+}
+
+// String 生成检查清单文本，用于部署日志
+func (r *PreflightReport) String() string {
+	var b strings.Builder
+	for _, check := range r.Checks {
+		mark := "[✓]"
+		switch check.Status {
+		case preflightFail:
+			mark = "[✗]"
+		case preflightSkip:
+			mark = "[-]"
+		}
+		fmt.Fprintf(&b, "%s %s: 要求 %s，实际 %s", mark, check.Name, check.Expected, check.Actual)
+		if check.Message != "" {
+			fmt.Fprintf(&b, "（%s）", check.Message)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// packageRequirements 读取离线包 metadata.json 中声明的系统要求
+func packageRequirements(pkg *models.MiddlewarePackage) (*models.Requirements, error) {
+	data, err := readPackageMetadata(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只解析 requirements，避免其他字段格式差异影响预检
+	var metadata struct {
+		Requirements *models.Requirements `json:"requirements"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("解析 metadata.json 失败: %v", err)
+	}
+	return metadata.Requirements, nil
+}
+
+// runPreflight 在目标服务器上按离线包的系统要求执行预检
+func (a *DeploymentAPI) runPreflight(client *ssh.Client, deployment *models.Deployment) *PreflightReport {
+	report := &PreflightReport{Passed: true}
+	pkg := deployment.Package
+
+	req, err := packageRequirements(pkg)
+	if err != nil && !errors.Is(err, errMetadataNotFound) && !os.IsNotExist(err) {
+		report.add(PreflightCheck{Name: "系统要求", Status: preflightSkip, Expected: "-", Actual: "-", Message: err.Error()})
+	}
+	if req == nil {
+		req = &models.Requirements{}
+	}
+
+	report.add(a.checkDiskSpace(client, deployment.TargetPath, req.DiskSpace, pkg.FileSize))
+	if req.Memory != "" {
+		report.add(a.checkMemory(client, req.Memory))
+	}
+	if req.RootRequired != nil && *req.RootRequired {
+		report.add(a.checkRoot(client))
+	}
+	for _, port := range req.Ports {
+		report.add(a.checkPort(client, port))
+	}
+	if len(req.Dependencies) > 0 {
+		for _, check := range a.checkDependencies(client, req.Dependencies) {
+			report.add(check)
+		}
+	}
+
+	return report
+}
+
+// checkDiskSpace 检查目标路径所在分区的可用空间（要求空间加上离线包本身的大小）
+func (a *DeploymentAPI) checkDiskSpace(client *ssh.Client, targetPath, required string, packageSize int64) PreflightCheck {
+	check := PreflightCheck{Name: "磁盘空间"}
+
+	need := packageSize
+	if required != "" {
+		size, err := parseSize(required)
+		if err != nil {
+			check.Status = preflightSkip
+			check.Expected, check.Actual = required, "-"
+			check.Message = err.Error()
+			return check
+		}
+		need += size
+	}
+	check.Expected = fmt.Sprintf("%s 可用 >= %s", targetPath, formatBytes(need))
+
+	// 目标路径可能尚未创建，向上查找已存在的目录
+	cmd := fmt.Sprintf(`p=%s; while [ ! -e "$p" ]; do p=$(dirname "$p"); done; df -Pk "$p" | tail -1`, shellQuote(targetPath))
+	output, err := a.runCommand(client, cmd)
+	fields := strings.Fields(output)
+	if err != nil || len(fields) < 4 {
+		check.Status = preflightFail
+		check.Actual = "未知"
+		check.Message = "无法获取磁盘信息: " + strings.TrimSpace(output)
+		return check
+	}
+
+	availKB, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		check.Status = preflightFail
+		check.Actual = "未知"
+		check.Message = "无法解析 df 输出"
+		return check
+	}
+
+	avail := availKB * 1024
+	check.Actual = fmt.Sprintf("%s 可用（挂载点 %s）", formatBytes(avail), fields[len(fields)-1])
+	check.Status = passIf(avail >= need)
+	return check
+}
+
+// checkMemory 检查可用内存
+func (a *DeploymentAPI) checkMemory(client *ssh.Client, required string) PreflightCheck {
+	check := PreflightCheck{Name: "可用内存", Expected: ">= " + required}
+
+	need, err := parseSize(required)
+	if err != nil {
+		check.Status = preflightSkip
+		check.Actual = "-"
+		check.Message = err.Error()
+		return check
+	}
+
+	output, err := a.runCommand(client, "grep MemAvailable /proc/meminfo")
+	fields := strings.Fields(output)
+	if err != nil || len(fields) < 2 {
+		check.Status = preflightFail
+		check.Actual = "未知"
+		check.Message = "无法读取 /proc/meminfo"
+		return check
+	}
+
+	availKB, _ := strconv.ParseInt(fields[1], 10, 64)
+	avail := availKB * 1024
+	check.Actual = formatBytes(avail)
+	check.Status = passIf(avail >= need)
+	return check
+}
+
+// checkRoot 检查是否为 root 或具有免密 sudo 权限
+func (a *DeploymentAPI) checkRoot(client *ssh.Client) PreflightCheck {
+	check := PreflightCheck{Name: "root 权限", Expected: "root 或免密 sudo"}
+
+	output, _ := a.runCommand(client, "id -u")
+	if strings.TrimSpace(output) == "0" {
+		check.Status = preflightPass
+		check.Actual = "root"
+		return check
+	}
+
+	if _, err := a.runCommand(client, "sudo -n true"); err == nil {
+		check.Status = preflightPass
+		check.Actual = "免密 sudo"
+		return check
+	}
+
+	check.Status = preflightFail
+	check.Actual = "非 root 且无免密 sudo（uid " + strings.TrimSpace(output) + "）"
+	return check
+}
+
+// checkPort 检查端口是否已被监听
+func (a *DeploymentAPI) checkPort(client *ssh.Client, port int) PreflightCheck {
+	check := PreflightCheck{Name: fmt.Sprintf("端口 %d", port), Expected: "未被占用"}
+
+	output, err := a.runCommand(client, "ss -ltn 2>/dev/null || netstat -ltn 2>/dev/null")
+	if err != nil && strings.TrimSpace(output) == "" {
+		check.Status = preflightSkip
+		check.Actual = "-"
+		check.Message = "ss/netstat 不可用"
+		return check
+	}
+
+	if listeningPorts(output)[port] {
+		check.Status = preflightFail
+		check.Actual = "已被监听"
+		return check
+	}
+	check.Status = preflightPass
+	check.Actual = "空闲"
+	return check
+}
+
+// checkDependencies 检查依赖的系统包是否已安装
+func (a *DeploymentAPI) checkDependencies(client *ssh.Client, deps []string) []PreflightCheck {
+	if _, err := a.runCommand(client, "command -v rpm"); err != nil {
+		return []PreflightCheck{{
+			Name: "依赖包", Status: preflightSkip, Expected: strings.Join(deps, ", "), Actual: "-", Message: "目标服务器不是 RPM 系统",
+		}}
+	}
+
+	quoted := make([]string, len(deps))
+	for i, dep := range deps {
+		quoted[i] = shellQuote(dep)
+	}
+	cmd := fmt.Sprintf(`for p in %s; do if rpm -q "$p" >/dev/null 2>&1; then echo "$p ok"; else echo "$p missing"; fi; done`, strings.Join(quoted, " "))
+	output, _ := a.runCommand(client, cmd)
+
+	installed := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "ok" {
+			installed[fields[0]] = true
+		}
+	}
+
+	var checks []PreflightCheck
+	for _, dep := range deps {
+		check := PreflightCheck{Name: "依赖包 " + dep, Expected: "已安装"}
+		if installed[dep] {
+			check.Status = preflightPass
+			check.Actual = "已安装"
+		} else {
+			check.Status = preflightFail
+			check.Actual = "未安装"
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// preflight 部署前执行预检并记录检查清单，按部署的预检策略决定是否中止
+func (a *DeploymentAPI) preflight(client *ssh.Client, deployment *models.Deployment, step *int) error {
+	if defaultString(deployment.PreflightPolicy, "block") == "skip" {
+		return nil
+	}
+
+	a.addLog(deployment.ID, *step, "部署前预检", "")
+	return a.applyPreflightPolicy(deployment, a.runPreflight(client, deployment), step)
+}
+
+// applyPreflightPolicy 记录预检结果，策略为 block 且未通过时返回错误，warn 时记录警告后继续
+func (a *DeploymentAPI) applyPreflightPolicy(deployment *models.Deployment, report *PreflightReport, step *int) error {
+	policy := defaultString(deployment.PreflightPolicy, "block")
+	checklist := report.String()
+
+	if !report.Passed {
+		failed := strings.Join(report.Failed(), "、")
+		if policy == "block" {
+			a.updateLog(deployment.ID, *step, "failed", checklist, "预检未通过: "+failed)
+			return fmt.Errorf("预检未通过: %s", failed)
+		}
+		logger.Warnf("部署 %d 预检未通过（策略 warn，继续部署）: %s", deployment.ID, failed)
+		checklist = fmt.Sprintf("警告: 预检未通过（%s），按策略继续部署\n%s", failed, checklist)
+	}
+
+	a.updateLog(deployment.ID, *step, "success", checklist, "")
+	(*step)++
+	return nil
+}
+
+// Preflight 对部署任务执行预检（不执行部署）
+func (a *DeploymentAPI) Preflight(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的部署ID")
+		return
+	}
+
+	var deployment models.Deployment
+	if err := db.DB.Preload("Server").Preload("Package").First(&deployment, id).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "部署任务不存在")
+		return
+	}
+
+	if deployment.Type != models.DeployTypePackage || deployment.Package == nil {
+		response.Error(c, http.StatusBadRequest, "仅离线包部署支持预检")
+		return
+	}
+
+	lease, err := sshPool.Acquire(deployment.Server)
+	if err != nil {
+		response.Error(c, http.StatusBadGateway, "SSH 连接失败: "+err.Error())
+		return
+	}
+	defer lease.Release()
+
+	report := a.runPreflight(lease.Client, &deployment)
+	response.Success(c, report)
+}
+
+// listeningPorts 解析 ss -ltn / netstat -ltn 输出中的监听端口
+func listeningPorts(output string) map[int]bool {
+	ports := map[int]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		local := fields[3]
+		idx := strings.LastIndex(local, ":")
+		if idx < 0 {
+			continue
+		}
+		if port, err := strconv.Atoi(local[idx+1:]); err == nil {
+			ports[port] = true
+		}
+	}
+	return ports
+}
+
+// sizePattern 容量字符串格式，如 500MB、1GB、2gb、512M
+var sizePattern = regexp.MustCompile(`(?i)^\s*([0-9]+(?:\.[0-9]+)?)\s*([KMGT]?)(I?B)?\s*$`)
+
+// parseSize 解析容量字符串为字节数
+func parseSize(s string) (int64, error) {
+	m := sizePattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("无法解析容量 %q", s)
+	}
+
+	value, _ := strconv.ParseFloat(m[1], 64)
+	units := map[string]float64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	return int64(value * units[strings.ToUpper(m[2])]), nil
+}
+
+// passIf 根据条件返回检查状态
+func passIf(ok bool) string {
+	if ok {
+		return preflightPass
+	}
+	return preflightFail
+}
+
+// shellQuote 对 shell 参数加单引号转义
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
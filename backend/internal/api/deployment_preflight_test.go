@@ -0,0 +1,120 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/config"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"1024", 1024},
+		{"512K", 512 << 10},
+		{"500MB", 500 << 20},
+		{"1GB", 1 << 30},
+		{"2gb", 2 << 30},
+		{"1.5G", 3 << 29},
+		{"2GiB", 2 << 30},
+		{" 1 T ", 1 << 40},
+	}
+	for _, tt := range tests {
+		size, err := parseSize(tt.input)
+		assert.NoError(t, err, tt.input)
+		assert.Equal(t, tt.want, size, tt.input)
+	}
+
+	for _, input := range []string{"", "abc", "1PB", "-1G", "1G2"} {
+		_, err := parseSize(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestListeningPorts(t *testing.T) {
+	ss := `State      Recv-Q Send-Q Local Address:Port  Peer Address:Port
+LISTEN     0      128    0.0.0.0:22              0.0.0.0:*
+LISTEN     0      511    127.0.0.1:6379          0.0.0.0:*
+LISTEN     0      128    [::]:8080               [::]:*
+LISTEN     0      128    *:3306                  *:*
+`
+	ports := listeningPorts(ss)
+	assert.Equal(t, map[int]bool{22: true, 6379: true, 8080: true, 3306: true}, ports)
+
+	netstat := `Active Internet connections (only servers)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State
+tcp        0      0 0.0.0.0:80              0.0.0.0:*               LISTEN
+tcp6       0      0 :::443                  :::*                    LISTEN
+`
+	ports = listeningPorts(netstat)
+	assert.Equal(t, map[int]bool{80: true, 443: true}, ports)
+
+	assert.Empty(t, listeningPorts(""))
+}
+
+func TestPreflightPolicy(t *testing.T) {
+	failed := func() *PreflightReport {
+		report := &PreflightReport{Passed: true}
+		report.add(PreflightCheck{Name: "磁盘空间", Status: preflightPass, Expected: ">= 1.0 GB", Actual: "10.0 GB"})
+		report.add(PreflightCheck{Name: "端口 80", Status: preflightFail, Expected: "未被占用", Actual: "已被监听"})
+		return report
+	}
+
+	// run 按策略处理预检报告，返回处理后的步骤号与该步骤的日志
+	run := func(t *testing.T, policy string, report *PreflightReport) (int, models.DeploymentLog, error) {
+		testDB := setupAPITestDB(t, &models.DeploymentLog{})
+		a := NewDeploymentAPI(&config.Config{})
+		deployment := &models.Deployment{ID: 1, PreflightPolicy: policy}
+
+		step := 3
+		a.addLog(deployment.ID, step, "部署前预检", "")
+		err := a.applyPreflightPolicy(deployment, report, &step)
+
+		var log models.DeploymentLog
+		testDB.Where("deployment_id = ? AND step = ?", deployment.ID, 3).First(&log)
+		return step, log, err
+	}
+
+	t.Run("默认策略为 block，未通过时中止", func(t *testing.T) {
+		step, log, err := run(t, "", failed())
+		assert.ErrorContains(t, err, "端口 80")
+		assert.Equal(t, 3, step)
+		assert.Equal(t, "failed", log.Status)
+		assert.Contains(t, log.Output, "[✗] 端口 80")
+		assert.Contains(t, log.ErrorMsg, "预检未通过")
+	})
+
+	t.Run("warn 策略记录警告后继续", func(t *testing.T) {
+		step, log, err := run(t, "warn", failed())
+		assert.NoError(t, err)
+		assert.Equal(t, 4, step)
+		assert.Equal(t, "success", log.Status)
+		assert.Contains(t, log.Output, "警告: 预检未通过（端口 80）")
+	})
+
+	t.Run("全部通过时继续", func(t *testing.T) {
+		report := &PreflightReport{Passed: true}
+		report.add(PreflightCheck{Name: "root 权限", Status: preflightPass, Expected: "root 或免密 sudo", Actual: "root"})
+		report.add(PreflightCheck{Name: "依赖包", Status: preflightSkip, Expected: "gcc", Actual: "-"})
+		step, log, err := run(t, "block", report)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, step)
+		assert.Equal(t, "success", log.Status)
+		assert.Contains(t, log.Output, "[-] 依赖包")
+	})
+
+	t.Run("skip 策略不连接服务器也不记录日志", func(t *testing.T) {
+		testDB := setupAPITestDB(t, &models.DeploymentLog{})
+		a := NewDeploymentAPI(&config.Config{})
+		step := 3
+		assert.NoError(t, a.preflight(nil, &models.Deployment{ID: 1, PreflightPolicy: "skip"}, &step))
+		assert.Equal(t, 3, step)
+
+		var count int64
+		testDB.Model(&models.DeploymentLog{}).Count(&count)
+		assert.Zero(t, count)
+	})
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// relayStagingRoot 中转服务器上的离线包暂存目录
+const relayStagingRoot = "/var/tmp/middleware-deploy-kit/relay"
+
+// relayStagedTTL 暂存结果的缓存时间，过期后重新校验中转服务器上的文件
+const relayStagedTTL = 30 * time.Minute
+
+// relayStage 单个离线包在某台中转服务器上的暂存状态
+type relayStage struct {
+	mu       sync.Mutex
+	stagedAt time.Time
+}
+
+// relayManager 中转分发管理器，保证同一离线包并发部署时只向中转服务器上传一次
+type relayManager struct {
+	mu     sync.Mutex
+	stages map[string]*relayStage
+}
+
+// 全局中转分发管理器实例
+var relayMgr = &relayManager{
+	stages: make(map[string]*relayStage),
+}
+
+// stage 获取离线包在中转服务器上的暂存状态
+func (rm *relayManager) stage(relayID, packageID uint) *relayStage {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%d", relayID, packageID)
+	st, ok := rm.stages[key]
+	if !ok {
+		st = &relayStage{}
+		rm.stages[key] = st
+	}
+	return st
+}
+
+// distributeViaRelay 经中转服务器将离线包分发到目标服务器
+// 中转服务器需能以密钥方式免密登录目标服务器（BatchMode），复制完成后由平台在目标服务器上校验 SHA256。
+func (a *DeploymentAPI) distributeViaRelay(client *ssh.Client, deployment *models.Deployment, pkg *models.MiddlewarePackage, remotePath string, step int) (string, error) {
+	if pkg.FileHash == "" {
+		return "", fmt.Errorf("离线包缺少 SHA256，无法校验中转结果")
+	}
+
+	var relay models.Server
+	if err := db.DB.First(&relay, *deployment.RelayServerID).Error; err != nil {
+		return "", fmt.Errorf("中转服务器不存在")
+	}
+
+	// 目标服务器已有相同文件时无需复制
+	if hash, err := remoteSHA256(client, remotePath); err == nil && hash == pkg.FileHash {
+		return fmt.Sprintf("目标服务器已存在相同文件 %s，SHA256 校验一致，跳过分发\nSHA256: %s", pkg.FileName, pkg.FileHash), nil
+	}
+
+	relayLease, err := sshPool.Acquire(&relay)
+	if err != nil {
+		return "", fmt.Errorf("连接中转服务器 %s 失败: %v", relay.Name, err)
+	}
+	defer relayLease.Release()
+	relayClient := relayLease.Client
+
+	// 1. 暂存离线包到中转服务器
+	stagedPath := fmt.Sprintf("%s/pkg-%d/%s", relayStagingRoot, pkg.ID, pkg.FileName)
+	stageSummary, err := a.stageOnRelay(relayClient, &relay, deployment, pkg, stagedPath, step)
+	if err != nil {
+		return "", err
+	}
+
+	// 2. 由中转服务器复制到目标服务器
+	method := defaultString(deployment.RelayMethod, "rsync")
+	startTime := time.Now()
+	output, err := a.runCommand(relayClient, relayCopyCommand(method, stagedPath, deployment.Server, remotePath))
+	if err != nil {
+		return "", fmt.Errorf("中转服务器 %s 复制失败: %v %s", method, err, strings.TrimSpace(output))
+	}
+	duration := time.Since(startTime)
+
+	// 3. 在目标服务器上校验哈希
+	hash, err := remoteSHA256(client, remotePath)
+	if err != nil {
+		return "", fmt.Errorf("校验目标文件哈希失败: %v", err)
+	}
+	if hash != pkg.FileHash {
+		return "", fmt.Errorf("目标文件校验失败: 期望 SHA256 %s，实际 %s", pkg.FileHash, hash)
+	}
+
+	speed := float64(pkg.FileSize) / duration.Seconds()
+	logger.Infof("部署 %d 经中转服务器 %s 分发完成 (%s, %.1fs)", deployment.ID, relay.Name, formatBytes(pkg.FileSize), duration.Seconds())
+
+	return fmt.Sprintf("经中转服务器 %s (%s) 分发\n%s\n中转复制 (%s): %s，耗时 %.1fs，平均速率 %s/s\nSHA256 校验通过: %s",
+		relay.Name, relay.Host, stageSummary, method, formatBytes(pkg.FileSize), duration.Seconds(), formatBytes(int64(speed)), hash), nil
+}
+
+// stageOnRelay 将离线包上传到中转服务器，同一离线包在缓存有效期内只上传一次
+func (a *DeploymentAPI) stageOnRelay(relayClient *ssh.Client, relay *models.Server, deployment *models.Deployment, pkg *models.MiddlewarePackage, stagedPath string, step int) (string, error) {
+	st := relayMgr.stage(relay.ID, pkg.ID)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.stagedAt.IsZero() && time.Since(st.stagedAt) < relayStagedTTL {
+		return fmt.Sprintf("中转服务器已暂存 %s（%s 暂存）", pkg.FileName, st.stagedAt.Format("15:04:05")), nil
+	}
+
+	sftpClient, err := sftp.NewClient(relayClient)
+	if err != nil {
+		return "", fmt.Errorf("中转服务器 SFTP 会话创建失败: %v", err)
+	}
+	defer sftpClient.Close()
+
+	transfer, err := a.uploadFile(relayClient, sftpClient, pkg.FilePath, stagedPath, pkg.FileHash, a.transferOptions(deployment, step))
+	if err != nil {
+		return "", fmt.Errorf("上传到中转服务器失败: %v", err)
+	}
+
+	st.stagedAt = time.Now()
+	return "中转暂存: " + transfer.Summary(), nil
+}
+
+// relayCopyCommand 生成在中转服务器上执行的复制命令
+func relayCopyCommand(method, src string, target *models.Server, dst string) string {
+	sshOpts := "-o BatchMode=yes -o StrictHostKeyChecking=accept-new -o ConnectTimeout=10"
+	dest := fmt.Sprintf("%s@%s:%s", target.Username, target.Host, dst)
+
+	if method == "scp" {
+		return fmt.Sprintf("scp -P %d %s %s %s 2>&1", target.Port, sshOpts, src, dest)
+	}
+	return fmt.Sprintf("rsync -a --partial -e 'ssh -p %d %s' %s %s 2>&1", target.Port, sshOpts, src, dest)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
 	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
 	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+	"gorm.io/gorm"
 )
 
 // DeploymentScriptAPI 部署脚本 API
@@ -38,6 +39,10 @@ func (a *DeploymentScriptAPI) Create(c *gin.Context) {
 		response.Error(c, http.StatusBadRequest, "脚本内容不能为空")
 		return
 	}
+	if err := validateScriptTemplate(req.Content, req.Variables, req.Templated); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// 设置默认值
 	if req.ScriptType == "" {
@@ -53,8 +58,16 @@ func (a *DeploymentScriptAPI) Create(c *gin.Context) {
 		req.Timeout = 300
 	}
 
-	// 创建脚本
-	if err := db.DB.Create(&req).Error; err != nil {
+	// 创建脚本及初始修订版本
+	req.CurrentRevision = 0
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&req).Error; err != nil {
+			return err
+		}
+		_, err := createScriptRevision(tx, &req, currentUsername(c), "初始版本")
+		return err
+	})
+	if err != nil {
 		response.Error(c, http.StatusInternalServerError, "创建脚本失败: "+err.Error())
 		return
 	}
@@ -135,12 +148,20 @@ func (a *DeploymentScriptAPI) Update(c *gin.Context) {
 		return
 	}
 
-	var req models.DeploymentScript
+	var req struct {
+		models.DeploymentScript
+		Message string `json:"message"` // 修改说明
+	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.Error(c, http.StatusBadRequest, "参数错误: "+err.Error())
 		return
 	}
 
+	if err := validateScriptTemplate(req.Content, req.Variables, req.Templated); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// 更新字段
 	updates := map[string]interface{}{
 		"name":        req.Name,
@@ -153,10 +174,36 @@ func (a *DeploymentScriptAPI) Update(c *gin.Context) {
 		"timeout":     req.Timeout,
 		"work_dir":    req.WorkDir,
 		"variables":   req.Variables,
+		"templated":   req.Templated,
 		"status":      req.Status,
 	}
 
-	if err := db.DB.Model(&script).Updates(updates).Error; err != nil {
+	// 历史脚本先补建初始版本，保证修改前的内容可追溯
+	if err := ensureScriptRevision(&script); err != nil {
+		response.Error(c, http.StatusInternalServerError, "初始化脚本版本失败")
+		return
+	}
+
+	// 可执行内容有变化时生成新的修订版本，已有版本不会被修改
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&script).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&script, script.ID).Error; err != nil {
+			return err
+		}
+
+		var current models.DeploymentScriptRevision
+		if err := tx.Where("script_id = ? AND revision = ?", script.ID, script.CurrentRevision).First(&current).Error; err != nil {
+			return err
+		}
+		if !scriptRevisionChanged(&current, &script) {
+			return nil
+		}
+		_, err := createScriptRevision(tx, &script, currentUsername(c), defaultString(req.Message, "更新脚本"))
+		return err
+	})
+	if err != nil {
 		response.Error(c, http.StatusInternalServerError, "更新脚本失败")
 		return
 	}
@@ -186,6 +233,7 @@ func (a *DeploymentScriptAPI) Delete(c *gin.Context) {
 		return
 	}
 
+	// 脚本软删除，修订版本保留，已固定版本的任务仍可追溯和重跑
 	if err := db.DB.Delete(&script).Error; err != nil {
 		response.Error(c, http.StatusInternalServerError, "删除脚本失败")
 		return
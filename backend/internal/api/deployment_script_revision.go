@@ -0,0 +1,187 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+	"gorm.io/gorm"
+)
+
+// currentUsername 获取当前登录用户名
+func currentUsername(c *gin.Context) string {
+	return defaultString(c.GetString("username"), "unknown")
+}
+
+// scriptRevisionChanged 判断脚本的可执行内容是否与修订版本不同
+func scriptRevisionChanged(rev *models.DeploymentScriptRevision, script *models.DeploymentScript) bool {
+	return rev.Content != script.Content ||
+		rev.ScriptType != script.ScriptType ||
+		rev.Variables != script.Variables ||
+		rev.Templated != script.Templated ||
+		rev.Timeout != script.Timeout ||
+		rev.WorkDir != script.WorkDir
+}
+
+// createScriptRevision 以脚本当前内容创建新的修订版本并更新脚本的当前版本号
+func createScriptRevision(tx *gorm.DB, script *models.DeploymentScript, author, message string) (*models.DeploymentScriptRevision, error) {
+	rev := &models.DeploymentScriptRevision{
+		ScriptID:   script.ID,
+		Revision:   script.CurrentRevision + 1,
+		ScriptType: script.ScriptType,
+		Content:    script.Content,
+		Variables:  script.Variables,
+		Templated:  script.Templated,
+		Timeout:    script.Timeout,
+		WorkDir:    script.WorkDir,
+		Author:     author,
+		Message:    message,
+	}
+	if err := tx.Create(rev).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(script).Update("current_revision", rev.Revision).Error; err != nil {
+		return nil, err
+	}
+	script.CurrentRevision = rev.Revision
+	return rev, nil
+}
+
+// ensureScriptRevision 为尚无修订记录的历史脚本补建初始版本
+func ensureScriptRevision(script *models.DeploymentScript) error {
+	if script.CurrentRevision > 0 {
+		return nil
+	}
+	_, err := createScriptRevision(db.DB, script, "system", "初始版本")
+	return err
+}
+
+// loadScriptRevision 加载脚本的指定修订版本，revision 为 0 时加载当前版本
+func loadScriptRevision(script *models.DeploymentScript, revision int) (*models.DeploymentScriptRevision, error) {
+	if err := ensureScriptRevision(script); err != nil {
+		return nil, err
+	}
+	if revision == 0 {
+		revision = script.CurrentRevision
+	}
+
+	var rev models.DeploymentScriptRevision
+	if err := db.DB.Where("script_id = ? AND revision = ?", script.ID, revision).First(&rev).Error; err != nil {
+		return nil, fmt.Errorf("脚本修订版本 r%d 不存在", revision)
+	}
+	return &rev, nil
+}
+
+// loadScript 按路径参数加载脚本
+func loadScript(c *gin.Context) (*models.DeploymentScript, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的脚本ID")
+		return nil, false
+	}
+
+	var script models.DeploymentScript
+	if err := db.DB.First(&script, id).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "脚本不存在")
+		return nil, false
+	}
+	return &script, true
+}
+
+// ListRevisions 获取脚本修订历史
+func (a *DeploymentScriptAPI) ListRevisions(c *gin.Context) {
+	script, ok := loadScript(c)
+	if !ok {
+		return
+	}
+	if err := ensureScriptRevision(script); err != nil {
+		response.Error(c, http.StatusInternalServerError, "初始化脚本版本失败")
+		return
+	}
+
+	var revisions []models.DeploymentScriptRevision
+	if err := db.DB.Where("script_id = ?", script.ID).Order("revision DESC").Find(&revisions).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询修订历史失败")
+		return
+	}
+
+	response.Success(c, gin.H{
+		"current_revision": script.CurrentRevision,
+		"revisions":        revisions,
+	})
+}
+
+// GetRevision 获取脚本指定修订版本
+func (a *DeploymentScriptAPI) GetRevision(c *gin.Context) {
+	script, ok := loadScript(c)
+	if !ok {
+		return
+	}
+	revision, err := strconv.Atoi(c.Param("revision"))
+	if err != nil || revision <= 0 {
+		response.Error(c, http.StatusBadRequest, "无效的修订版本号")
+		return
+	}
+
+	rev, err := loadScriptRevision(script, revision)
+	if err != nil {
+		response.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	response.Success(c, rev)
+}
+
+// DiffRevisions 对比脚本两个修订版本（统一 diff 格式），to 默认为当前版本，from 默认为 to 的上一版本
+func (a *DeploymentScriptAPI) DiffRevisions(c *gin.Context) {
+	script, ok := loadScript(c)
+	if !ok {
+		return
+	}
+	if err := ensureScriptRevision(script); err != nil {
+		response.Error(c, http.StatusInternalServerError, "初始化脚本版本失败")
+		return
+	}
+
+	to, _ := strconv.Atoi(c.DefaultQuery("to", strconv.Itoa(script.CurrentRevision)))
+	from, _ := strconv.Atoi(c.DefaultQuery("from", strconv.Itoa(to-1)))
+	if from <= 0 || to <= 0 {
+		response.Error(c, http.StatusBadRequest, "无效的修订版本号")
+		return
+	}
+
+	fromRev, err := loadScriptRevision(script, from)
+	if err != nil {
+		response.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+	toRev, err := loadScriptRevision(script, to)
+	if err != nil {
+		response.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromRev.Content),
+		B:        difflib.SplitLines(toRev.Content),
+		FromFile: fmt.Sprintf("%s r%d", script.Name, from),
+		ToFile:   fmt.Sprintf("%s r%d", script.Name, to),
+		Context:  3,
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "生成差异失败")
+		return
+	}
+
+	response.Success(c, gin.H{
+		"from":              fromRev,
+		"to":                toRev,
+		"diff":              diff,
+		"variables_changed": fromRev.Variables != toRev.Variables,
+	})
+}
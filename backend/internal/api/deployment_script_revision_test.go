@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/config"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestDeploymentScriptAPI_Revisions(t *testing.T) {
+	testDB := setupAPITestDB(t, &models.DeploymentScript{}, &models.DeploymentScriptRevision{}, &models.DeploymentHook{})
+
+	scriptAPI := NewDeploymentScriptAPI(&config.Config{})
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("username", "alice") })
+	router.POST("/scripts", scriptAPI.Create)
+	router.PUT("/scripts/:id", scriptAPI.Update)
+	router.GET("/scripts/:id/diff", scriptAPI.DiffRevisions)
+	router.DELETE("/scripts/:id", scriptAPI.Delete)
+
+	w := sendJSON(router, http.MethodPost, "/scripts", map[string]interface{}{"name": "check", "content": "echo one\n"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var script models.DeploymentScript
+	testDB.First(&script)
+	assert.Equal(t, 1, script.CurrentRevision)
+
+	update := map[string]interface{}{"name": "check", "content": "echo two\n", "script_type": "shell", "status": "active", "message": "改为 two"}
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPut, "/scripts/1", update).Code)
+	// 内容未变化时不生成新版本
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPut, "/scripts/1", update).Code)
+
+	var revisions []models.DeploymentScriptRevision
+	testDB.Order("revision ASC").Find(&revisions)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, "echo one\n", revisions[0].Content)
+	assert.Equal(t, "alice", revisions[1].Author)
+	assert.Equal(t, "改为 two", revisions[1].Message)
+
+	w = sendJSON(router, http.MethodGet, "/scripts/1/diff", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `-echo one`)
+	assert.Contains(t, w.Body.String(), `+echo two`)
+
+	// 删除脚本后修订版本仍保留
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodDelete, "/scripts/1", nil).Code)
+	assert.Error(t, testDB.First(&models.DeploymentScript{}, 1).Error)
+	var kept int64
+	testDB.Model(&models.DeploymentScriptRevision{}).Where("script_id = ?", 1).Count(&kept)
+	assert.Equal(t, int64(2), kept)
+}
@@ -42,6 +42,26 @@ func setupDeploymentTestDB(t *testing.T) *gorm.DB {
 	return testDB
 }
 
+// setupAPITestDB 创建内存测试数据库、迁移给定模型并替换全局 db.DB
+func setupAPITestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	gin.SetMode(gin.TestMode)
+	logger.Init()
+
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	// 内存数据库每个连接相互独立，事务需与其他查询共用同一连接
+	sqlDB, _ := testDB.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := testDB.AutoMigrate(tables...); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	db.DB = testDB
+
+	return testDB
+}
+
 func TestDeploymentAPI_Create(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	testDB := setupDeploymentTestDB(t)
@@ -264,8 +284,8 @@ func TestDeploymentAPI_Get(t *testing.T) {
 
 	// 添加日志
 	logs := []models.DeploymentLog{
-		{DeploymentID: deployment.ID, Step: 1, Action: "步骤1", Status: "success"},
-		{DeploymentID: deployment.ID, Step: 2, Action: "步骤2", Status: "success"},
+		{DeploymentID: deployment.ID, StepLog: models.StepLog{Step: 1, Action: "步骤1", Status: "success"}},
+		{DeploymentID: deployment.ID, StepLog: models.StepLog{Step: 2, Action: "步骤2", Status: "success"}},
 	}
 	for i := range logs {
 		testDB.Create(&logs[i])
@@ -389,3 +409,83 @@ func TestDeploymentAPI_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestDeploymentAPI_Clone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testDB := setupDeploymentTestDB(t)
+	testDB.AutoMigrate(&models.DeploymentHook{})
+	db.DB = testDB
+
+	x86 := &models.Server{Name: "x86", Host: "192.168.1.100", Port: 22, Username: "root", AuthType: "password", Password: "password", Arch: "x86_64"}
+	arm := &models.Server{Name: "arm", Host: "192.168.1.101", Port: 22, Username: "root", AuthType: "password", Password: "password", Arch: "aarch64"}
+	relay := &models.Server{Name: "relay", Host: "192.168.1.102", Port: 22, Username: "root", AuthType: "password", Password: "password"}
+	testDB.Create(x86)
+	testDB.Create(arm)
+	testDB.Create(relay)
+	pkg := &models.MiddlewarePackage{Name: "redis", Version: "7.2", OSType: "rocky", OSVersion: "9", Arch: "x86_64", Status: "active"}
+	testDB.Create(pkg)
+	source := &models.Deployment{
+		Name: "redis", Type: models.DeployTypePackage, ServerID: x86.ID, Status: models.DeployStatusSuccess,
+		PackageID: &pkg.ID, RelayServerID: &relay.ID, RelayMethod: "scp", IgnoreOSCheck: true,
+	}
+	testDB.Create(source)
+
+	deployAPI := NewDeploymentAPI(&config.Config{})
+	router := gin.New()
+	router.POST("/deployments/:id/clone", deployAPI.Clone)
+
+	clone := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/deployments/1/clone", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("空请求体按原任务复制", func(t *testing.T) {
+		w := clone("")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var copied models.Deployment
+		testDB.Last(&copied)
+		assert.Equal(t, x86.ID, copied.ServerID)
+		if assert.NotNil(t, copied.RelayServerID) {
+			assert.Equal(t, relay.ID, *copied.RelayServerID)
+		}
+		assert.Equal(t, "scp", copied.RelayMethod)
+		assert.True(t, copied.IgnoreOSCheck)
+	})
+
+	t.Run("请求体格式错误", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, clone(`{"server_id":`).Code)
+	})
+
+	t.Run("复制到架构不一致的服务器", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{"server_id": arm.ID})
+		assert.Equal(t, http.StatusBadRequest, clone(string(body)).Code)
+	})
+
+	t.Run("钩子随任务一并复制", func(t *testing.T) {
+		testDB.Create(&models.DeploymentHook{DeploymentID: source.ID, HookType: "pre_deploy", ScriptType: "shell", Content: "echo pre"})
+		w := clone("")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var copied models.Deployment
+		testDB.Last(&copied)
+		var hooks []models.DeploymentHook
+		testDB.Where("deployment_id = ?", copied.ID).Find(&hooks)
+		if assert.Len(t, hooks, 1) {
+			assert.Equal(t, "echo pre", hooks[0].Content)
+		}
+	})
+
+	t.Run("复制钩子失败时不留下副本", func(t *testing.T) {
+		var before, after int64
+		testDB.Model(&models.Deployment{}).Count(&before)
+
+		testDB.Migrator().DropTable(&models.DeploymentHook{})
+		assert.Equal(t, http.StatusInternalServerError, clone("").Code)
+
+		testDB.Model(&models.Deployment{}).Count(&after)
+		assert.Equal(t, before, after)
+	})
+}
@@ -0,0 +1,457 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/config"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// transferManager 传输管理器，控制全局带宽与并发上传数
+type transferManager struct {
+	once    sync.Once
+	slots   chan struct{}
+	limiter *rateLimiter
+}
+
+// 全局传输管理器实例
+var transferMgr = &transferManager{}
+
+// configure 按配置初始化传输管理器（仅首次调用生效）
+func (tm *transferManager) configure(cfg config.TransferConfig) {
+	tm.once.Do(func() {
+		if cfg.MaxConcurrentStreams > 0 {
+			tm.slots = make(chan struct{}, cfg.MaxConcurrentStreams)
+		}
+		tm.limiter = newRateLimiter(cfg.BandwidthLimit * 1024)
+		logger.Infof("传输管理器初始化: 最大并发上传数 %d，全局带宽上限 %d KB/s", cfg.MaxConcurrentStreams, cfg.BandwidthLimit)
+	})
+}
+
+// acquire 获取一个上传通道，超过并发上限时阻塞等待，ctx 取消时放弃等待
+func (tm *transferManager) acquire(ctx context.Context) error {
+	if tm.slots == nil {
+		return nil
+	}
+	select {
+	case tm.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release 释放上传通道
+func (tm *transferManager) release() {
+	if tm.slots != nil {
+		<-tm.slots
+	}
+}
+
+// rateLimiter 令牌桶限速器，可在多个传输间共享
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter 创建限速器，bytesPerSec <= 0 时返回 nil（不限速）
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait 消耗 n 字节的配额，配额不足时休眠至可用，ctx 取消时提前返回 ctx.Err()
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		// 最多积累 1 秒的突发配额
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttleChunkSize 限速读取时单次读取的最大字节数
+const throttleChunkSize = 32 * 1024
+
+// throttledReader 带限速与进度回调的 Reader，ctx 取消后读取返回 ctx.Err()
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+	onRead   func(n int)
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		if waitErr := l.wait(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	if n > 0 && t.onRead != nil {
+		t.onRead(n)
+	}
+	return n, err
+}
+
+// transferProgress 文件传输进度，通过 SSE 推送给前端
+type transferProgress struct {
+	DeploymentID uint    `json:"deployment_id"`
+	Step         int     `json:"step"`
+	FileName     string  `json:"file_name"`
+	Transferred  int64   `json:"transferred"` // 已传输字节数（含续传偏移）
+	Total        int64   `json:"total"`       // 文件总大小
+	Percent      float64 `json:"percent"`     // 完成百分比
+	Speed        int64   `json:"speed"`       // 当前平均速率（字节/秒）
+}
+
+// progressInterval 进度上报的最小间隔
+const progressInterval = 500 * time.Millisecond
+
+// transferOptions 单次传输的选项
+type transferOptions struct {
+	Ctx            context.Context         // 取消信号（为空表示不可取消）
+	BandwidthLimit int64                   // 本次传输的带宽上限（字节/秒，0 表示不限速）
+	OnProgress     func(*transferProgress) // 进度回调
+}
+
+// transferOptions 根据部署任务构建传输选项，进度推送到该部署的 SSE 日志流，部署取消时中止等待
+func (a *DeploymentAPI) transferOptions(deployment *models.Deployment, step int) *transferOptions {
+	ctx := context.Background()
+	if exec, ok := deployMgr.Get(deployment.ID); ok && exec.ctx != nil {
+		ctx = exec.ctx
+	}
+	return &transferOptions{
+		Ctx:            ctx,
+		BandwidthLimit: deployment.BandwidthLimit * 1024,
+		OnProgress: func(p *transferProgress) {
+			exec, ok := deployMgr.Get(deployment.ID)
+			if !ok || exec.progressChan == nil {
+				return
+			}
+			p.DeploymentID = deployment.ID
+			p.Step = step
+			// 非阻塞发送，SSE 客户端消费不及时时丢弃中间进度
+			select {
+			case exec.progressChan <- p:
+			default:
+			}
+		},
+	}
+}
+
+// transferResult 文件传输结果
+type transferResult struct {
+	FileName    string        // 文件名
+	Total       int64         // 文件总大小（字节）
+	Transferred int64         // 本次实际传输的字节数
+	Offset      int64         // 断点续传的起始偏移
+	Skipped     bool          // 远端已存在相同文件，跳过传输
+	Resumed     bool          // 是否为断点续传
+	Hash        string        // 文件 SHA256
+	Duration    time.Duration // 传输耗时
+}
+
+// Throughput 平均传输速率（字节/秒）
+func (r *transferResult) Throughput() float64 {
+	seconds := r.Duration.Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(r.Transferred) / seconds
+}
+
+// Summary 生成用于步骤日志的传输摘要
+func (r *transferResult) Summary() string {
+	if r.Skipped {
+		return fmt.Sprintf("远端已存在相同文件 %s (%s)，SHA256 校验一致，跳过上传\nSHA256: %s",
+			r.FileName, formatBytes(r.Total), r.Hash)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "已上传 %s (%s)，本次传输 %s，耗时 %.1fs，平均速率 %s/s",
+		r.FileName, formatBytes(r.Total), formatBytes(r.Transferred), r.Duration.Seconds(), formatBytes(int64(r.Throughput())))
+	if r.Resumed {
+		fmt.Fprintf(&sb, "\n断点续传：从偏移 %s 处继续", formatBytes(r.Offset))
+	}
+	fmt.Fprintf(&sb, "\nSHA256 校验通过: %s", r.Hash)
+	return sb.String()
+}
+
+// uploadFile 上传本地文件到远程
+// 远端已存在哈希一致的文件时跳过上传；存在不完整的同名文件时从远端偏移处续传；传输完成后校验 SHA256。
+// expectedHash 为空时在本地计算文件哈希。传输受全局并发数、全局带宽及 opts 中的带宽上限约束。
+func (a *DeploymentAPI) uploadFile(client *ssh.Client, sftpClient *sftp.Client, localPath, remotePath, expectedHash string, opts *transferOptions) (*transferResult, error) {
+	if opts == nil {
+		opts = &transferOptions{}
+	}
+	if opts.Ctx == nil {
+		opts.Ctx = context.Background()
+	}
+
+	// 确保目录存在
+	dir := filepath.Dir(remotePath)
+	if err := sftpClient.MkdirAll(dir); err != nil {
+		logger.Warnf("创建目录 %s 失败（可能已存在）: %v", dir, err)
+	}
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开本地文件失败: %v", err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("读取本地文件信息失败: %v", err)
+	}
+
+	result := &transferResult{
+		FileName: filepath.Base(remotePath),
+		Total:    info.Size(),
+		Hash:     strings.ToLower(expectedHash),
+	}
+
+	if result.Hash == "" {
+		if result.Hash, err = hashFile(localFile, -1); err != nil {
+			return nil, fmt.Errorf("计算本地文件哈希失败: %v", err)
+		}
+	}
+
+	// 检查远端是否已有文件
+	remoteSize := int64(-1)
+	if remoteInfo, err := sftpClient.Stat(remotePath); err == nil && remoteInfo.Mode().IsRegular() {
+		remoteSize = remoteInfo.Size()
+	}
+	if err := checkRemoteFile(localFile, result, remoteSize, func() (string, error) {
+		return remoteSHA256(client, remotePath)
+	}); err != nil {
+		return nil, err
+	}
+	if result.Skipped {
+		return result, nil
+	}
+
+	// 等待上传通道
+	if err := transferMgr.acquire(opts.Ctx); err != nil {
+		return nil, fmt.Errorf("等待上传通道时任务已取消: %v", err)
+	}
+	defer transferMgr.release()
+
+	startTime := time.Now()
+
+	var remoteFile *sftp.File
+	if result.Resumed {
+		remoteFile, err = sftpClient.OpenFile(remotePath, os.O_WRONLY)
+		if err == nil {
+			_, err = remoteFile.Seek(result.Offset, io.SeekStart)
+		}
+	} else {
+		remoteFile, err = sftpClient.Create(remotePath)
+	}
+	if err != nil {
+		if remoteFile != nil {
+			remoteFile.Close()
+		}
+		return nil, fmt.Errorf("创建远程文件失败: %v", err)
+	}
+
+	if _, err := localFile.Seek(result.Offset, io.SeekStart); err != nil {
+		remoteFile.Close()
+		return nil, fmt.Errorf("定位本地文件失败: %v", err)
+	}
+
+	reader := &throttledReader{
+		ctx:      opts.Ctx,
+		r:        localFile,
+		limiters: []*rateLimiter{transferMgr.limiter, newRateLimiter(opts.BandwidthLimit)},
+	}
+	if opts.OnProgress != nil {
+		var transferred int64
+		lastReport := time.Time{}
+		reader.onRead = func(n int) {
+			transferred += int64(n)
+			done := result.Offset + transferred
+			if time.Since(lastReport) < progressInterval && done < result.Total {
+				return
+			}
+			lastReport = time.Now()
+
+			progress := &transferProgress{
+				FileName:    result.FileName,
+				Transferred: done,
+				Total:       result.Total,
+			}
+			if result.Total > 0 {
+				progress.Percent = float64(done) * 100 / float64(result.Total)
+			}
+			if elapsed := time.Since(startTime).Seconds(); elapsed > 0 {
+				progress.Speed = int64(float64(transferred) / elapsed)
+			}
+			opts.OnProgress(progress)
+		}
+	}
+
+	result.Transferred, err = io.Copy(remoteFile, reader)
+	if closeErr := remoteFile.Close(); err == nil {
+		err = closeErr
+	}
+	result.Duration = time.Since(startTime)
+	if err != nil {
+		// 保留已传输的部分，下次执行时可续传
+		return result, fmt.Errorf("复制文件失败（已传输 %s，可续传）: %v", formatBytes(result.Offset+result.Transferred), err)
+	}
+
+	// 校验远端文件完整性
+	remoteHash, err := remoteSHA256(client, remotePath)
+	if err != nil {
+		return result, fmt.Errorf("校验远端文件哈希失败: %v", err)
+	}
+	if remoteHash != result.Hash {
+		// 删除损坏的文件，避免下次续传到错误的内容上
+		if rmErr := sftpClient.Remove(remotePath); rmErr != nil {
+			logger.Warnf("删除校验失败的远端文件 %s 失败: %v", remotePath, rmErr)
+		}
+		return result, fmt.Errorf("文件校验失败: 期望 SHA256 %s，实际 %s", result.Hash, remoteHash)
+	}
+
+	logger.Infof("文件上传完成: %s -> %s (%s, %.1fs)", localPath, remotePath, formatBytes(result.Transferred), result.Duration.Seconds())
+	return result, nil
+}
+
+// checkRemoteFile 根据远端已有文件决定跳过上传、断点续传或从头上传，结果记录在 result 中。
+// remoteSize < 0 表示远端文件不存在，remoteHash 计算远端文件的 SHA256
+func checkRemoteFile(localFile *os.File, result *transferResult, remoteSize int64, remoteHash func() (string, error)) error {
+	switch {
+	case remoteSize == result.Total:
+		hash, err := remoteHash()
+		if err == nil && hash == result.Hash {
+			result.Skipped = true
+			return nil
+		}
+		if err != nil {
+			logger.Warnf("计算远端文件哈希失败，重新上传: %v", err)
+		}
+
+	case remoteSize > 0 && remoteSize < result.Total:
+		// 远端文件是本地文件的前缀时才续传，否则重新上传
+		localPrefixHash, err := hashFile(localFile, remoteSize)
+		if err != nil {
+			return fmt.Errorf("计算本地文件哈希失败: %v", err)
+		}
+		hash, err := remoteHash()
+		if err == nil && hash == localPrefixHash {
+			result.Resumed = true
+			result.Offset = remoteSize
+		} else {
+			logger.Infof("远端文件 %s 与本地内容不一致，重新上传", result.FileName)
+		}
+	}
+	return nil
+}
+
+// hashFile 计算文件前 limit 字节的 SHA256，limit < 0 表示整个文件
+func hashFile(f *os.File, limit int64) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	var err error
+	if limit < 0 {
+		_, err = io.Copy(hash, f)
+	} else {
+		_, err = io.CopyN(hash, f, limit)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// remoteSHA256 计算远端文件的 SHA256
+func remoteSHA256(client *ssh.Client, remotePath string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput("sha256sum " + shellQuote(remotePath))
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("无法解析 sha256sum 输出: %s", strings.TrimSpace(string(output)))
+	}
+
+	return strings.ToLower(fields[0]), nil
+}
+
+// formatBytes 格式化字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCheckRemoteFile(t *testing.T) {
+	logger.Init()
+
+	const content = "0123456789abcdef"
+	localPath := filepath.Join(t.TempDir(), "pkg.tar.gz")
+	if err := os.WriteFile(localPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write local file: %v", err)
+	}
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		t.Fatalf("Failed to open local file: %v", err)
+	}
+	defer localFile.Close()
+
+	check := func(remoteSize int64, remoteHash string, hashErr error) *transferResult {
+		result := &transferResult{FileName: "pkg.tar.gz", Total: int64(len(content)), Hash: sha256Hex(content)}
+		err := checkRemoteFile(localFile, result, remoteSize, func() (string, error) { return remoteHash, hashErr })
+		assert.NoError(t, err)
+		return result
+	}
+
+	t.Run("远端文件哈希一致时跳过上传", func(t *testing.T) {
+		result := check(int64(len(content)), sha256Hex(content), nil)
+		assert.True(t, result.Skipped)
+		assert.False(t, result.Resumed)
+	})
+
+	t.Run("同样大小但内容不同时重新上传", func(t *testing.T) {
+		result := check(int64(len(content)), sha256Hex("fedcba9876543210"), nil)
+		assert.False(t, result.Skipped)
+		assert.Zero(t, result.Offset)
+
+		result = check(int64(len(content)), "", errors.New("sha256sum: not found"))
+		assert.False(t, result.Skipped)
+	})
+
+	t.Run("远端为本地文件前缀时续传", func(t *testing.T) {
+		result := check(6, sha256Hex(content[:6]), nil)
+		assert.True(t, result.Resumed)
+		assert.Equal(t, int64(6), result.Offset)
+	})
+
+	t.Run("前缀不一致时从头上传", func(t *testing.T) {
+		result := check(6, sha256Hex("xxxxxx"), nil)
+		assert.False(t, result.Resumed)
+		assert.Zero(t, result.Offset)
+	})
+
+	t.Run("远端不存在或大于本地文件时从头上传", func(t *testing.T) {
+		called := false
+		result := &transferResult{Total: int64(len(content)), Hash: sha256Hex(content)}
+		assert.NoError(t, checkRemoteFile(localFile, result, -1, func() (string, error) { called = true; return "", nil }))
+		assert.NoError(t, checkRemoteFile(localFile, result, 100, func() (string, error) { called = true; return "", nil }))
+		assert.False(t, called)
+		assert.False(t, result.Skipped || result.Resumed)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	var unlimited *rateLimiter
+	assert.NoError(t, unlimited.wait(context.Background(), 1<<20))
+	assert.Nil(t, newRateLimiter(0))
+
+	// 初始有 1 秒的突发配额，用尽后按速率等待
+	l := newRateLimiter(10000)
+	start := time.Now()
+	assert.NoError(t, l.wait(context.Background(), 10000))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	assert.NoError(t, l.wait(context.Background(), 2000))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// 取消时不再等待配额
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	assert.ErrorIs(t, l.wait(ctx, 100000), context.Canceled)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestThrottledReader_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := &throttledReader{ctx: ctx, r: strings.NewReader("data"), limiters: []*rateLimiter{newRateLimiter(1)}}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := reader.Read(make([]byte, 4))
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("throttled read did not stop after cancellation")
+	}
+
+	_, err := reader.Read(make([]byte, 4))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTransferManager_Acquire(t *testing.T) {
+	tm := &transferManager{slots: make(chan struct{}, 1)}
+	assert.NoError(t, tm.acquire(context.Background()))
+
+	// 通道已满时等待，取消后放弃
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, tm.acquire(ctx), context.DeadlineExceeded)
+
+	tm.release()
+	assert.NoError(t, tm.acquire(context.Background()))
+	tm.release()
+
+	// 未配置并发上限时不限制
+	assert.NoError(t, (&transferManager{}).acquire(context.Background()))
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/config"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+)
+
+// JobAPI 临时命令/脚本作业 API
+type JobAPI struct {
+	cfg *config.Config
+}
+
+// NewJobAPI 创建作业 API 实例
+func NewJobAPI(cfg *config.Config) *JobAPI {
+	sshPool.configure(cfg.SSH)
+	return &JobAPI{cfg: cfg}
+}
+
+// CreateJobRequest 创建作业请求
+type CreateJobRequest struct {
+	Name        string            `json:"name"`
+	ScriptID    *uint             `json:"script_id"`                                               // 脚本模板 ID（与 content 二选一）
+	Revision    int               `json:"revision"`                                                // 脚本修订版本，默认当前版本
+	Content     string            `json:"content"`                                                 // 内联脚本
+	ScriptType  string            `json:"script_type" binding:"omitempty,oneof=shell bash python"` // 内联脚本类型
+	Variables   map[string]string `json:"variables"`                                               // 变量取值
+	Templated   bool              `json:"templated"`                                               // 内联脚本按模板渲染（提供了变量取值时总是渲染）
+	ServerIDs   []uint            `json:"server_ids"`                                              // 目标服务器
+	GroupIDs    []uint            `json:"group_ids"`                                               // 目标服务器分组
+	Concurrency int               `json:"concurrency" binding:"min=0,max=100"`                     // 并发主机数，默认 10
+	Timeout     int               `json:"timeout" binding:"min=0,max=86400"`                       // 单台主机超时（秒），默认 300
+}
+
+// resolveJobServers 合并指定的服务器和分组成员，按 ID 去重
+func resolveJobServers(serverIDs, groupIDs []uint) ([]models.Server, error) {
+	ids := append([]uint{}, serverIDs...)
+	if len(groupIDs) > 0 {
+		var memberIDs []uint
+		if err := db.DB.Model(&models.ServerGroupMapping{}).Where("group_id IN ?", groupIDs).
+			Pluck("server_id", &memberIDs).Error; err != nil {
+			return nil, err
+		}
+		ids = append(ids, memberIDs...)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var servers []models.Server
+	if err := db.DB.Where("id IN ?", ids).Order("id ASC").Find(&servers).Error; err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// Create 创建并立即执行作业
+func (a *JobAPI) Create(c *gin.Context) {
+	var req CreateJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误: "+err.Error())
+		return
+	}
+	if (req.ScriptID == nil) == (strings.TrimSpace(req.Content) == "") {
+		response.Error(c, http.StatusBadRequest, "请指定脚本模板或填写脚本内容（二选一）")
+		return
+	}
+
+	job := &models.Job{
+		Name:        req.Name,
+		ScriptType:  defaultString(req.ScriptType, "shell"),
+		Content:     req.Content,
+		Templated:   req.Templated,
+		Concurrency: defaultInt(req.Concurrency, 10),
+		Timeout:     defaultInt(req.Timeout, 300),
+		Status:      models.JobStatusPending,
+		CreatedBy:   currentUsername(c),
+	}
+	vars := req.Variables
+
+	if req.ScriptID != nil {
+		var script models.DeploymentScript
+		if err := db.DB.First(&script, *req.ScriptID).Error; err != nil {
+			response.Error(c, http.StatusBadRequest, "脚本模板不存在")
+			return
+		}
+		rev, err := loadScriptRevision(&script, req.Revision)
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		defs, err := parseScriptVariables(rev.Variables)
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if vars, err = resolveScriptVariables(defs, req.Variables); err != nil {
+			response.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		job.ScriptID = &script.ID
+		job.ScriptRevision = rev.Revision
+		job.ScriptType = rev.ScriptType
+		job.Content = rev.Content
+		job.Templated = rev.Templated || scriptTemplated(false, rev.Variables)
+		if req.Timeout == 0 {
+			job.Timeout = defaultInt(rev.Timeout, 300)
+		}
+		job.Name = defaultString(job.Name, fmt.Sprintf("%s r%d", script.Name, rev.Revision))
+	}
+	if len(vars) > 0 {
+		data, _ := json.Marshal(vars)
+		job.Variables = string(data)
+	}
+	if scriptTemplated(job.Templated, job.Variables) {
+		if _, err := parseScriptTemplate(job.Content); err != nil {
+			response.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	servers, err := resolveJobServers(req.ServerIDs, req.GroupIDs)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询服务器失败")
+		return
+	}
+	if len(servers) == 0 {
+		response.Error(c, http.StatusBadRequest, "请选择至少一台服务器")
+		return
+	}
+	job.Name = defaultString(job.Name, "临时作业")
+	job.TotalHosts = len(servers)
+
+	if err := db.DB.Create(job).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建作业失败")
+		return
+	}
+
+	hosts := make([]models.JobHost, 0, len(servers))
+	serverMap := make(map[uint]*models.Server, len(servers))
+	for i := range servers {
+		serverMap[servers[i].ID] = &servers[i]
+		hosts = append(hosts, models.JobHost{
+			JobID:      job.ID,
+			ServerID:   servers[i].ID,
+			ServerName: servers[i].Name,
+			Host:       servers[i].Host,
+			Status:     "pending",
+		})
+	}
+	if err := db.DB.Create(&hosts).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建作业失败")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exec := &jobExecution{
+		job:         job,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		subscribers: make(map[chan jobEvent]struct{}),
+	}
+	jobMgr.Add(job.ID, exec)
+	go runJob(exec, append([]models.JobHost(nil), hosts...), serverMap, vars)
+
+	job.Hosts = hosts
+	response.Success(c, job)
+}
+
+// List 获取作业历史
+func (a *JobAPI) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+
+	query := db.DB.Model(&models.Job{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if scriptID := c.Query("script_id"); scriptID != "" {
+		query = query.Where("script_id = ?", scriptID)
+	}
+
+	var total int64
+	var jobs []models.Job
+	query.Count(&total)
+	query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&jobs)
+
+	response.Success(c, gin.H{
+		"jobs":      jobs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// Get 获取作业详情（含每台主机结果及聚合结果）
+func (a *JobAPI) Get(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的 ID")
+		return
+	}
+
+	var job models.Job
+	if err := db.DB.Preload("Hosts").First(&job, id).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "作业不存在")
+		return
+	}
+
+	response.Success(c, gin.H{
+		"job":     job,
+		"summary": aggregateJobHosts(job.Hosts),
+	})
+}
+
+// GetLogs 获取作业日志，可按服务器过滤
+func (a *JobAPI) GetLogs(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的 ID")
+		return
+	}
+
+	query := db.DB.Where("job_id = ?", id)
+	if serverID := c.Query("server_id"); serverID != "" {
+		query = query.Where("server_id = ?", serverID)
+	}
+
+	var logs []models.JobLog
+	query.Order("id ASC").Find(&logs)
+	response.Success(c, logs)
+}
+
+// Cancel 取消作业，已在执行的主机会被终止，未开始的主机不再执行
+func (a *JobAPI) Cancel(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的 ID")
+		return
+	}
+
+	exec, ok := jobMgr.Get(uint(id))
+	if !ok {
+		response.Error(c, http.StatusBadRequest, "作业未在执行中")
+		return
+	}
+	exec.cancel()
+
+	response.Success(c, gin.H{"message": "正在取消作业"})
+}
+
+// Stream 实时推送作业日志与每台主机的输出 (SSE)
+func (a *JobAPI) Stream(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无效的 ID")
+		return
+	}
+
+	var job models.Job
+	if err := db.DB.First(&job, id).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "作业不存在")
+		return
+	}
+
+	// 先订阅再发送历史日志，避免遗漏期间产生的事件
+	exec, running := jobMgr.Get(uint(id))
+	var events chan jobEvent
+	if running {
+		events = exec.subscribe()
+		defer exec.unsubscribe(events)
+	}
+
+	// 设置 SSE 响应头
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	var existingLogs []models.JobLog
+	db.DB.Where("job_id = ?", id).Order("id ASC").Find(&existingLogs)
+	for _, log := range existingLogs {
+		data, _ := json.Marshal(log)
+		fmt.Fprintf(c.Writer, "event: log\ndata: %s\n\n", data)
+	}
+	c.Writer.Flush()
+
+	if !running {
+		fmt.Fprintf(c.Writer, "event: done\ndata: {}\n\n")
+		c.Writer.Flush()
+		return
+	}
+
+	clientGone := c.Request.Context().Done()
+	for {
+		select {
+		case event := <-events:
+			data, _ := json.Marshal(event.Data)
+			fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Event, data)
+			c.Writer.Flush()
+
+		case <-exec.done:
+			// 推送剩余事件后结束
+			for {
+				select {
+				case event := <-events:
+					data, _ := json.Marshal(event.Data)
+					fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Event, data)
+				default:
+					fmt.Fprintf(c.Writer, "event: done\ndata: {}\n\n")
+					c.Writer.Flush()
+					return
+				}
+			}
+
+		case <-clientGone:
+			logger.Infof("SSE 客户端断开连接: job %d", id)
+			return
+		}
+	}
+}
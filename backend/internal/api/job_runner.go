@@ -0,0 +1,453 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// jobMaxOutput 单台主机保存的输出上限（超出部分截断，实时推送不受影响）
+const jobMaxOutput = 1 << 20
+
+// errJobTimeout 主机执行超时
+var errJobTimeout = errors.New("执行超时")
+
+// jobEvent 推送给 SSE 客户端的作业事件
+type jobEvent struct {
+	Event string // log, output, host
+	Data  interface{}
+}
+
+// jobOutputLine 主机实时输出行
+type jobOutputLine struct {
+	ServerID   uint   `json:"server_id"`
+	ServerName string `json:"server_name"`
+	Line       string `json:"line"`
+}
+
+// jobExecution 作业执行实例，支持多个 SSE 客户端同时订阅
+type jobExecution struct {
+	job    *models.Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	subscribers map[chan jobEvent]struct{}
+}
+
+// subscribe 订阅作业事件
+func (e *jobExecution) subscribe() chan jobEvent {
+	ch := make(chan jobEvent, 256)
+	e.mu.Lock()
+	e.subscribers[ch] = struct{}{}
+	e.mu.Unlock()
+	return ch
+}
+
+// unsubscribe 取消订阅
+func (e *jobExecution) unsubscribe(ch chan jobEvent) {
+	e.mu.Lock()
+	delete(e.subscribers, ch)
+	e.mu.Unlock()
+}
+
+// publish 向所有订阅者推送事件，订阅者处理不及时则丢弃，避免阻塞执行
+func (e *jobExecution) publish(event string, data interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers {
+		select {
+		case ch <- jobEvent{Event: event, Data: data}:
+		default:
+		}
+	}
+}
+
+// jobManager 作业管理器，管理所有执行中的作业
+type jobManager struct {
+	mu   sync.RWMutex
+	jobs map[uint]*jobExecution
+}
+
+// 全局作业管理器实例
+var jobMgr = &jobManager{
+	jobs: make(map[uint]*jobExecution),
+}
+
+// Add 添加作业到管理器
+func (m *jobManager) Add(id uint, exec *jobExecution) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[id] = exec
+}
+
+// Remove 从管理器移除作业
+func (m *jobManager) Remove(id uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+}
+
+// Get 获取执行中的作业
+func (m *jobManager) Get(id uint) (*jobExecution, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	exec, ok := m.jobs[id]
+	return exec, ok
+}
+
+// addJobLog 记录作业日志并推送给订阅者
+func addJobLog(exec *jobExecution, serverID uint, step int, action, status, output, errMsg string, duration time.Duration) {
+	log := &models.JobLog{
+		JobID:    exec.job.ID,
+		ServerID: serverID,
+		StepLog: models.StepLog{
+			Step:     step,
+			Action:   action,
+			Status:   status,
+			Output:   output,
+			ErrorMsg: errMsg,
+			Duration: int(duration.Milliseconds()),
+		},
+	}
+	db.DB.Create(log)
+	exec.publish("log", log)
+}
+
+// runJob 按并发上限在所有主机上执行作业（异步）
+func runJob(exec *jobExecution, hosts []models.JobHost, servers map[uint]*models.Server, vars map[string]string) {
+	job := exec.job
+	startTime := time.Now()
+
+	defer func() {
+		close(exec.done)
+		jobMgr.Remove(job.ID)
+		exec.cancel()
+	}()
+
+	db.DB.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":     models.JobStatusRunning,
+		"started_at": startTime,
+	})
+	addJobLog(exec, 0, 0, "开始执行作业", "running",
+		fmt.Sprintf("主机数: %d，并发数: %d，单机超时: %d 秒", len(hosts), job.Concurrency, job.Timeout), "", 0)
+
+	sem := make(chan struct{}, job.Concurrency)
+	var wg sync.WaitGroup
+
+	for i := range hosts {
+		host := &hosts[i]
+
+		select {
+		case sem <- struct{}{}:
+		case <-exec.ctx.Done():
+		}
+		if exec.ctx.Err() != nil {
+			host.Status = "cancelled"
+			db.DB.Model(host).Update("status", host.Status)
+			exec.publish("host", *host)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			runJobHost(exec, host, servers[host.ServerID], vars)
+		}()
+	}
+	wg.Wait()
+
+	// 汇总结果
+	success, failed := 0, 0
+	for _, h := range hosts {
+		switch h.Status {
+		case "success":
+			success++
+		case "failed", "timeout":
+			failed++
+		}
+	}
+
+	status := models.JobStatusPartial
+	switch {
+	case exec.ctx.Err() != nil && success+failed < len(hosts):
+		status = models.JobStatusCancelled
+	case success == len(hosts):
+		status = models.JobStatusSuccess
+	case success == 0:
+		status = models.JobStatusFailed
+	}
+
+	completedAt := time.Now()
+	db.DB.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":        status,
+		"success_hosts": success,
+		"failed_hosts":  failed,
+		"completed_at":  completedAt,
+		"duration":      int(completedAt.Sub(startTime).Seconds()),
+	})
+
+	logStatus := "success"
+	if status != models.JobStatusSuccess {
+		logStatus = "failed"
+	}
+	addJobLog(exec, 0, 0, "作业执行完成", logStatus,
+		fmt.Sprintf("成功: %d，失败: %d，未执行: %d", success, failed, len(hosts)-success-failed), "", completedAt.Sub(startTime))
+	logger.Infof("作业 %d 执行完成: %s（成功 %d，失败 %d）", job.ID, status, success, failed)
+}
+
+// runJobHost 在单台主机上执行作业脚本
+func runJobHost(exec *jobExecution, host *models.JobHost, server *models.Server, vars map[string]string) {
+	job := exec.job
+	startTime := time.Now()
+	host.Status = "running"
+	host.StartedAt = &startTime
+	db.DB.Model(host).Updates(map[string]interface{}{"status": host.Status, "started_at": startTime})
+	exec.publish("host", *host)
+
+	defer func() {
+		completedAt := time.Now()
+		host.CompletedAt = &completedAt
+		host.Duration = completedAt.Sub(startTime).Milliseconds()
+		db.DB.Save(host)
+		exec.publish("host", *host)
+	}()
+
+	fail := func(step int, action, output string, err error) {
+		host.Status = "failed"
+		host.ErrorMsg = err.Error()
+		addJobLog(exec, host.ServerID, step, action, "failed", output, host.ErrorMsg, time.Since(startTime))
+	}
+
+	if server == nil {
+		fail(1, "建立 SSH 连接", "", errors.New("服务器不存在"))
+		return
+	}
+
+	// 渲染脚本（未启用模板时原样执行）
+	content := job.Content
+	if scriptTemplated(job.Templated, job.Variables) {
+		rendered, err := renderScript(job.Content, scriptTemplateData(&models.Deployment{Server: server}, vars))
+		if err != nil {
+			fail(1, "渲染脚本", "", err)
+			return
+		}
+		content = rendered
+	}
+
+	// 1. 建立 SSH 连接
+	lease, err := sshPool.Acquire(server)
+	if err != nil {
+		fail(1, "建立 SSH 连接", "", fmt.Errorf("SSH 连接失败: %v", err))
+		return
+	}
+	defer lease.Release()
+	addJobLog(exec, host.ServerID, 1, "建立 SSH 连接", "success", "连接成功", "", time.Since(startTime))
+
+	// 2. 执行脚本
+	execStart := time.Now()
+	output, exitCode, err := runJobScript(exec.ctx, lease.Client, job.ScriptType, content,
+		time.Duration(job.Timeout)*time.Second, func(line string) {
+			exec.publish("output", jobOutputLine{ServerID: host.ServerID, ServerName: host.ServerName, Line: line})
+		})
+
+	host.Output = output
+	host.ExitCode = exitCode
+	host.OutputHash = jobOutputHash(output)
+
+	switch {
+	case err == nil:
+		host.Status = "success"
+		addJobLog(exec, host.ServerID, 2, "执行脚本", "success", output, "", time.Since(execStart))
+	case errors.Is(err, errJobTimeout):
+		host.Status = "timeout"
+		host.ErrorMsg = fmt.Sprintf("执行超时（超过 %d 秒）", job.Timeout)
+		addJobLog(exec, host.ServerID, 2, "执行脚本", "failed", output, host.ErrorMsg, time.Since(execStart))
+	case errors.Is(err, context.Canceled):
+		host.Status = "cancelled"
+		host.ErrorMsg = "用户取消"
+		addJobLog(exec, host.ServerID, 2, "执行脚本", "cancelled", output, host.ErrorMsg, time.Since(execStart))
+	default:
+		fail(2, "执行脚本", output, err)
+	}
+}
+
+// jobInterpreter 脚本解释器命令，脚本内容通过标准输入传入，无需上传临时文件
+func jobInterpreter(scriptType string) string {
+	if scriptType == "python" {
+		return "python3 -"
+	}
+	return "bash -s"
+}
+
+// runJobScript 执行脚本并逐行回调输出，返回合并后的输出和退出码（未正常退出时为空）
+func runJobScript(ctx context.Context, client *ssh.Client, scriptType, content string, timeout time.Duration, onLine func(string)) (string, *int, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", nil, fmt.Errorf("创建 SSH 会话失败: %v", err)
+	}
+	defer session.Close()
+
+	out := &jobOutputWriter{onLine: onLine}
+	session.Stdin = strings.NewReader(content)
+	session.Stdout = out
+	session.Stderr = out
+
+	if err := session.Start(jobInterpreter(scriptType)); err != nil {
+		return "", nil, fmt.Errorf("启动脚本失败: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+	case <-timer.C:
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		out.flush()
+		return out.String(), nil, errJobTimeout
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		out.flush()
+		return out.String(), nil, context.Canceled
+	}
+	out.flush()
+
+	if err == nil {
+		code := 0
+		return out.String(), &code, nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitStatus()
+		return out.String(), &code, fmt.Errorf("脚本退出码 %d", code)
+	}
+	return out.String(), nil, err
+}
+
+// jobOutputWriter 收集 stdout/stderr 输出并按行回调
+type jobOutputWriter struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	partial   []byte
+	truncated bool
+	onLine    func(string)
+}
+
+// Write 实现 io.Writer
+func (w *jobOutputWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if remain := jobMaxOutput - w.buf.Len(); remain > 0 {
+		if len(p) > remain {
+			w.buf.Write(p[:remain])
+			w.truncated = true
+		} else {
+			w.buf.Write(p)
+		}
+	} else {
+		w.truncated = true
+	}
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if w.onLine != nil {
+			w.onLine(strings.TrimRight(string(w.partial[:i]), "\r"))
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush 推送最后一行不完整的输出
+func (w *jobOutputWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 && w.onLine != nil {
+		w.onLine(string(w.partial))
+	}
+	w.partial = nil
+}
+
+// String 返回收集到的输出
+func (w *jobOutputWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.truncated {
+		return w.buf.String() + "\n... 输出过长，已截断"
+	}
+	return w.buf.String()
+}
+
+// jobOutputHash 输出内容摘要（忽略首尾空白），用于聚合相同输出
+func jobOutputHash(output string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(output)))
+	return hex.EncodeToString(sum[:])
+}
+
+// JobResultGroup 按状态、退出码和输出聚合的主机结果
+type JobResultGroup struct {
+	Status     string   `json:"status"`
+	ExitCode   *int     `json:"exit_code"`
+	OutputHash string   `json:"output_hash"`
+	Output     string   `json:"output"` // 该组的输出内容
+	Count      int      `json:"count"`
+	ServerIDs  []uint   `json:"server_ids"`
+	Servers    []string `json:"servers"`
+}
+
+// aggregateJobHosts 聚合退出码和输出完全相同的主机，按主机数降序排列
+func aggregateJobHosts(hosts []models.JobHost) []JobResultGroup {
+	index := map[string]int{}
+	var groups []JobResultGroup
+
+	for _, h := range hosts {
+		code := "-"
+		if h.ExitCode != nil {
+			code = fmt.Sprint(*h.ExitCode)
+		}
+		key := h.Status + "|" + code + "|" + h.OutputHash
+
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, JobResultGroup{
+				Status:     h.Status,
+				ExitCode:   h.ExitCode,
+				OutputHash: h.OutputHash,
+				Output:     defaultString(h.Output, h.ErrorMsg),
+			})
+		}
+		groups[i].Count++
+		groups[i].ServerIDs = append(groups[i].ServerIDs, h.ServerID)
+		groups[i].Servers = append(groups[i].Servers, defaultString(h.ServerName, h.Host))
+	}
+
+	sort.SliceStable(groups, func(a, b int) bool { return groups[a].Count > groups[b].Count })
+	return groups
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestAggregateJobHosts(t *testing.T) {
+	zero, one := 0, 1
+	hosts := []models.JobHost{
+		{ServerID: 1, ServerName: "web01", Status: "success", ExitCode: &zero, Output: "ok\n", OutputHash: jobOutputHash("ok\n")},
+		{ServerID: 2, ServerName: "web02", Status: "failed", ExitCode: &one, Output: "disk full", OutputHash: jobOutputHash("disk full")},
+		{ServerID: 3, ServerName: "web03", Status: "success", ExitCode: &zero, Output: "ok", OutputHash: jobOutputHash("ok")},
+		{ServerID: 4, ServerName: "web04", Status: "failed", ErrorMsg: "SSH 连接失败"},
+	}
+
+	groups := aggregateJobHosts(hosts)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, 2, groups[0].Count)
+	assert.Equal(t, []string{"web01", "web03"}, groups[0].Servers)
+	assert.Equal(t, "SSH 连接失败", groups[2].Output)
+}
+
+func TestJobOutputWriter(t *testing.T) {
+	var lines []string
+	w := &jobOutputWriter{onLine: func(line string) { lines = append(lines, line) }}
+
+	w.Write([]byte("first\r\nsec"))
+	w.Write([]byte("ond\nlast"))
+	w.flush()
+
+	assert.Equal(t, []string{"first", "second", "last"}, lines)
+	assert.Equal(t, "first\r\nsecond\nlast", w.String())
+}
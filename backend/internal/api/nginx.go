@@ -3,7 +3,8 @@ package api
 import (
 	"bytes"
 	"fmt"
-	"path/filepath"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
@@ -16,7 +17,6 @@ import (
 	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
 	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
 	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
-	"golang.org/x/crypto/ssh"
 )
 
 // NginxAPI Nginx 配置 API
@@ -26,6 +26,7 @@ type NginxAPI struct {
 
 // NewNginxAPI 创建 Nginx API 实例
 func NewNginxAPI(cfg *config.Config) *NginxAPI {
+	sshPool.configure(cfg.SSH)
 	return &NginxAPI{cfg: cfg}
 }
 
@@ -34,6 +35,10 @@ type CreateNginxConfigRequest struct {
 	Name              string `json:"name" binding:"required"`
 	Description       string `json:"description"`
 	ServerID          *uint  `json:"server_id"`
+	Mode              string `json:"mode" binding:"omitempty,oneof=main vhost"` // main 或 vhost
+	IncludeDir        *string `json:"include_dir"` // 为空表示保持不变
+	FileName          *string `json:"file_name"`   // 为空表示保持不变
+	WorkerUser        string `json:"worker_user"` // 创建时为空使用 nobody，更新时为空表示保持不变
 	WorkerProcesses   string `json:"worker_processes"`
 	WorkerConnections int    `json:"worker_connections"`
 	EnableHTTP        bool   `json:"enable_http"`
@@ -51,9 +56,17 @@ type CreateNginxConfigRequest struct {
 	EnableProxy       bool   `json:"enable_proxy"`
 	ProxyPass         string                 `json:"proxy_pass"`
 	Locations         []models.NginxLocation `json:"locations"`
+	Upstreams         []UpstreamRequest      `json:"upstreams"` // 负载均衡 upstream，更新时为空表示保持不变
+	StreamServers     []models.NginxStreamServer `json:"stream_servers"` // TCP/UDP 四层代理，更新时为空表示保持不变
+	LimitZones        []models.NginxLimitZone    `json:"limit_zones"`    // 限流 zone，更新时为空表示保持不变
+	AuthUsers         []AuthUserRequest          `json:"auth_users"`     // basic 认证用户，更新时为空表示保持不变
+	CacheZones        []models.NginxCacheZone    `json:"cache_zones"`    // 代理缓存 zone，更新时为空表示保持不变
 	ClientMaxBodySize string                 `json:"client_max_body_size"`
 	Gzip              bool                   `json:"gzip"`
 	CustomConfig      string                 `json:"custom_config"`
+	MainCustomConfig   *string `json:"main_custom_config"`   // 为空表示保持不变
+	ServerCustomConfig *string `json:"server_custom_config"` // 为空表示保持不变
+	Message            string  `json:"message"`              // 修改说明（记录到修订版本）
 }
 
 // Create 创建 Nginx 配置
@@ -76,11 +89,23 @@ func (n *NginxAPI) Create(c *gin.Context) {
 		response.BadRequest(c, "启用 HTTPS 需要选择证书")
 		return
 	}
+	if err := validateNginxMode(req.Mode, stringValue(req.IncludeDir), stringValue(req.FileName)); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateNginxWorkerUser(req.WorkerUser); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
 
 	cfg := &models.NginxConfig{
 		Name:              req.Name,
 		Description:       req.Description,
 		ServerID:          req.ServerID,
+		Mode:              defaultString(req.Mode, nginxModeMain),
+		IncludeDir:        stringValue(req.IncludeDir),
+		FileName:          stringValue(req.FileName),
+		WorkerUser:        defaultString(req.WorkerUser, defaultNginxWorkerUser),
 		WorkerProcesses:   defaultString(req.WorkerProcesses, "auto"),
 		WorkerConnections: defaultInt(req.WorkerConnections, 1024),
 		EnableHTTP:        req.EnableHTTP,
@@ -100,10 +125,59 @@ func (n *NginxAPI) Create(c *gin.Context) {
 		ClientMaxBodySize: defaultString(req.ClientMaxBodySize, "100m"),
 		Gzip:              req.Gzip,
 		CustomConfig:      req.CustomConfig,
+		MainCustomConfig:   stringValue(req.MainCustomConfig),
+		ServerCustomConfig: stringValue(req.ServerCustomConfig),
 		Status:            "draft",
 	}
 
-	// 使用事务创建配置和 locations
+	upstreams, err := buildUpstreams(req.Upstreams)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateLocations(req.Locations); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	sortLocations(req.Locations)
+	cfg.Locations = req.Locations
+	cfg.Upstreams = upstreams
+	cfg.StreamServers = req.StreamServers
+	cfg.LimitZones = req.LimitZones
+	authUsers, err := buildAuthUsers(0, req.AuthUsers)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	cfg.AuthUsers = authUsers
+	cfg.CacheZones = req.CacheZones
+	if err := validateProxyPassRefs(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateStreamConfig(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateAccessPolicies(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateProxySettings(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if !checkNginxConfig(c, cfg) {
+		return
+	}
+	cfg.Locations = nil
+	cfg.Upstreams = nil
+	cfg.StreamServers = nil
+	cfg.LimitZones = nil
+	cfg.AuthUsers = nil
+	cfg.CacheZones = nil
+
+	// 使用事务创建配置、locations 和 upstreams
 	tx := db.DB.Begin()
 	if err := tx.Create(cfg).Error; err != nil {
 		tx.Rollback()
@@ -126,6 +200,68 @@ func (n *NginxAPI) Create(c *gin.Context) {
 		}
 	}
 
+	// 保存 upstreams
+	for i := range upstreams {
+		upstreams[i].NginxConfigID = cfg.ID
+		if err := tx.Create(&upstreams[i]).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("创建 upstream 失败: %v", err)
+			response.InternalServerError(c, "创建 upstream 失败")
+			return
+		}
+	}
+
+	// 保存 stream servers
+	for i, s := range req.StreamServers {
+		s.NginxConfigID = cfg.ID
+		s.SortOrder = i
+		if err := tx.Create(&s).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("创建 stream server 失败: %v", err)
+			response.InternalServerError(c, "创建 stream server 失败")
+			return
+		}
+	}
+
+	// 保存限流 zone 与认证用户
+	for _, z := range req.LimitZones {
+		z.NginxConfigID = cfg.ID
+		if err := tx.Create(&z).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("创建限流 zone 失败: %v", err)
+			response.InternalServerError(c, "创建限流 zone 失败")
+			return
+		}
+	}
+	for _, u := range authUsers {
+		u.NginxConfigID = cfg.ID
+		if err := tx.Create(&u).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("创建认证用户失败: %v", err)
+			response.InternalServerError(c, "创建认证用户失败")
+			return
+		}
+	}
+
+	// 保存缓存 zone
+	for _, z := range req.CacheZones {
+		z.NginxConfigID = cfg.ID
+		if err := tx.Create(&z).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("创建缓存 zone 失败: %v", err)
+			response.InternalServerError(c, "创建缓存 zone 失败")
+			return
+		}
+	}
+
+	// 记录初始修订版本（新配置提交前对其他请求不可见，无需加修订版本锁）
+	if _, err := saveNginxRevision(tx, cfg.ID, nginxRevisionSave, currentUsername(c), defaultString(req.Message, "初始版本")); err != nil {
+		tx.Rollback()
+		logger.Errorf("记录 Nginx 配置版本失败: %v", err)
+		response.InternalServerError(c, "创建失败")
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		logger.Errorf("提交事务失败: %v", err)
 		response.InternalServerError(c, "创建失败")
@@ -133,7 +269,7 @@ func (n *NginxAPI) Create(c *gin.Context) {
 	}
 
 	// 重新加载带 locations 的配置
-	db.DB.Preload("Locations").First(cfg, cfg.ID)
+	db.DB.Preload("Locations", orderLocations).Preload("Upstreams").Preload("StreamServers", orderStreamServers).Preload("LimitZones").Preload("AuthUsers").Preload("CacheZones").First(cfg, cfg.ID)
 
 	logger.Infof("Nginx 配置创建成功: %s", cfg.Name)
 	response.SuccessWithMessage(c, "创建成功", cfg)
@@ -158,6 +294,9 @@ func (n *NginxAPI) List(c *gin.Context) {
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
+	if mode := c.Query("mode"); mode != "" {
+		query = query.Where("mode = ?", mode)
+	}
 
 	var total int64
 	query.Count(&total)
@@ -189,7 +328,7 @@ func (n *NginxAPI) Get(c *gin.Context) {
 	}
 
 	var cfg models.NginxConfig
-	if err := db.DB.Preload("Server").Preload("Certificate").Preload("Locations").First(&cfg, id).Error; err != nil {
+	if err := db.DB.Preload("Server").Preload("Certificate").Preload("Locations", orderLocations).Preload("Upstreams").Preload("StreamServers", orderStreamServers).Preload("LimitZones").Preload("AuthUsers").Preload("CacheZones").First(&cfg, id).Error; err != nil {
 		response.NotFound(c, "配置不存在")
 		return
 	}
@@ -217,10 +356,44 @@ func (n *NginxAPI) Update(c *gin.Context) {
 		return
 	}
 
+	// 历史配置先补建初始版本，保证修改前的内容可追溯
+	if err := ensureNginxRevision(&cfg); err != nil {
+		logger.Errorf("初始化 Nginx 配置版本失败: %v", err)
+		response.InternalServerError(c, "初始化配置版本失败")
+		return
+	}
+
+	if req.Mode != "" {
+		cfg.Mode = req.Mode
+	}
+	if req.IncludeDir != nil {
+		cfg.IncludeDir = *req.IncludeDir
+	}
+	if req.FileName != nil {
+		cfg.FileName = *req.FileName
+	}
+	if req.MainCustomConfig != nil {
+		cfg.MainCustomConfig = *req.MainCustomConfig
+	}
+	if req.ServerCustomConfig != nil {
+		cfg.ServerCustomConfig = *req.ServerCustomConfig
+	}
+	if err := validateNginxMode(cfg.Mode, cfg.IncludeDir, cfg.FileName); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateNginxWorkerUser(req.WorkerUser); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
 	// 更新字段
 	cfg.Name = req.Name
 	cfg.Description = req.Description
 	cfg.ServerID = req.ServerID
+	if req.WorkerUser != "" {
+		cfg.WorkerUser = req.WorkerUser
+	}
 	cfg.WorkerProcesses = req.WorkerProcesses
 	cfg.WorkerConnections = req.WorkerConnections
 	cfg.EnableHTTP = req.EnableHTTP
@@ -241,7 +414,77 @@ func (n *NginxAPI) Update(c *gin.Context) {
 	cfg.Gzip = req.Gzip
 	cfg.CustomConfig = req.CustomConfig
 
-	// 使用事务更新配置和 locations
+	// 未传 upstreams 时沿用已有的 upstreams 校验引用
+	var upstreams []models.NginxUpstream
+	if req.Upstreams != nil {
+		if upstreams, err = buildUpstreams(req.Upstreams); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	} else {
+		db.DB.Where("nginx_config_id = ?", cfg.ID).Find(&upstreams)
+	}
+	streamServers := req.StreamServers
+	if streamServers == nil {
+		db.DB.Where("nginx_config_id = ?", cfg.ID).Order("sort_order ASC, id ASC").Find(&streamServers)
+	}
+	limitZones := req.LimitZones
+	if limitZones == nil {
+		db.DB.Where("nginx_config_id = ?", cfg.ID).Find(&limitZones)
+	}
+	var authUsers []models.NginxAuthUser
+	if req.AuthUsers != nil {
+		if authUsers, err = buildAuthUsers(cfg.ID, req.AuthUsers); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	} else {
+		db.DB.Where("nginx_config_id = ?", cfg.ID).Find(&authUsers)
+	}
+	cacheZones := req.CacheZones
+	if cacheZones == nil {
+		db.DB.Where("nginx_config_id = ?", cfg.ID).Find(&cacheZones)
+	}
+	if err := validateLocations(req.Locations); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	sortLocations(req.Locations)
+	cfg.Locations = req.Locations
+	cfg.Upstreams = upstreams
+	cfg.StreamServers = streamServers
+	cfg.LimitZones = limitZones
+	cfg.AuthUsers = authUsers
+	cfg.CacheZones = cacheZones
+	if err := validateProxyPassRefs(&cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateStreamConfig(&cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateAccessPolicies(&cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateProxySettings(&cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if !checkNginxConfig(c, &cfg) {
+		return
+	}
+	cfg.Locations = nil
+	cfg.Upstreams = nil
+	cfg.StreamServers = nil
+	cfg.LimitZones = nil
+	cfg.AuthUsers = nil
+	cfg.CacheZones = nil
+
+	// 使用事务更新配置、locations 和 upstreams，修订版本锁持有到事务结束
+	unlock := lockNginxRevision(cfg.ID)
+	defer unlock()
 	tx := db.DB.Begin()
 	if err := tx.Save(&cfg).Error; err != nil {
 		tx.Rollback()
@@ -273,6 +516,112 @@ func (n *NginxAPI) Update(c *gin.Context) {
 		}
 	}
 
+	// 替换 upstreams
+	if req.Upstreams != nil {
+		if err := tx.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxUpstream{}).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("删除旧 upstream 失败: %v", err)
+			response.InternalServerError(c, "更新失败")
+			return
+		}
+		for i := range upstreams {
+			upstreams[i].NginxConfigID = cfg.ID
+			if err := tx.Create(&upstreams[i]).Error; err != nil {
+				tx.Rollback()
+				logger.Errorf("创建 upstream 失败: %v", err)
+				response.InternalServerError(c, "更新 upstream 失败")
+				return
+			}
+		}
+	}
+
+	// 替换 stream servers
+	if req.StreamServers != nil {
+		if err := tx.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxStreamServer{}).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("删除旧 stream server 失败: %v", err)
+			response.InternalServerError(c, "更新失败")
+			return
+		}
+		for i, s := range req.StreamServers {
+			s.ID = 0 // 清除 ID，作为新记录插入
+			s.NginxConfigID = cfg.ID
+			s.SortOrder = i
+			if err := tx.Create(&s).Error; err != nil {
+				tx.Rollback()
+				logger.Errorf("创建 stream server 失败: %v", err)
+				response.InternalServerError(c, "更新 stream server 失败")
+				return
+			}
+		}
+	}
+
+	// 替换限流 zone
+	if req.LimitZones != nil {
+		if err := tx.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxLimitZone{}).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("删除旧限流 zone 失败: %v", err)
+			response.InternalServerError(c, "更新失败")
+			return
+		}
+		for _, z := range req.LimitZones {
+			z.ID = 0 // 清除 ID，作为新记录插入
+			z.NginxConfigID = cfg.ID
+			if err := tx.Create(&z).Error; err != nil {
+				tx.Rollback()
+				logger.Errorf("创建限流 zone 失败: %v", err)
+				response.InternalServerError(c, "更新限流 zone 失败")
+				return
+			}
+		}
+	}
+
+	// 替换认证用户（未修改密码的用户沿用原有哈希）
+	if req.AuthUsers != nil {
+		if err := tx.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxAuthUser{}).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("删除旧认证用户失败: %v", err)
+			response.InternalServerError(c, "更新失败")
+			return
+		}
+		for _, u := range authUsers {
+			if err := tx.Create(&u).Error; err != nil {
+				tx.Rollback()
+				logger.Errorf("创建认证用户失败: %v", err)
+				response.InternalServerError(c, "更新认证用户失败")
+				return
+			}
+		}
+	}
+
+	// 替换缓存 zone
+	if req.CacheZones != nil {
+		if err := tx.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxCacheZone{}).Error; err != nil {
+			tx.Rollback()
+			logger.Errorf("删除旧缓存 zone 失败: %v", err)
+			response.InternalServerError(c, "更新失败")
+			return
+		}
+		for _, z := range req.CacheZones {
+			z.ID = 0 // 清除 ID，作为新记录插入
+			z.NginxConfigID = cfg.ID
+			if err := tx.Create(&z).Error; err != nil {
+				tx.Rollback()
+				logger.Errorf("创建缓存 zone 失败: %v", err)
+				response.InternalServerError(c, "更新缓存 zone 失败")
+				return
+			}
+		}
+	}
+
+	// 内容有变化时生成新的修订版本，已有版本不会被修改
+	if _, err := saveNginxRevision(tx, cfg.ID, nginxRevisionSave, currentUsername(c), defaultString(req.Message, "更新配置")); err != nil {
+		tx.Rollback()
+		logger.Errorf("记录 Nginx 配置版本失败: %v", err)
+		response.InternalServerError(c, "更新失败")
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		logger.Errorf("提交事务失败: %v", err)
 		response.InternalServerError(c, "更新失败")
@@ -280,7 +629,7 @@ func (n *NginxAPI) Update(c *gin.Context) {
 	}
 
 	// 重新加载带 locations 的配置
-	db.DB.Preload("Locations").First(&cfg, cfg.ID)
+	db.DB.Preload("Locations", orderLocations).Preload("Upstreams").Preload("StreamServers", orderStreamServers).Preload("LimitZones").Preload("AuthUsers").Preload("CacheZones").First(&cfg, cfg.ID)
 
 	logger.Infof("Nginx 配置更新成功: %s (ID: %d)", cfg.Name, cfg.ID)
 	response.SuccessWithMessage(c, "更新成功", cfg)
@@ -300,6 +649,11 @@ func (n *NginxAPI) Delete(c *gin.Context) {
 		return
 	}
 
+	db.DB.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxUpstream{})
+	db.DB.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxStreamServer{})
+	db.DB.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxLimitZone{})
+	db.DB.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxAuthUser{})
+	db.DB.Where("nginx_config_id = ?", cfg.ID).Delete(&models.NginxCacheZone{})
 	if err := db.DB.Delete(&cfg).Error; err != nil {
 		logger.Errorf("删除 Nginx 配置失败: %v", err)
 		response.InternalServerError(c, "删除失败")
@@ -318,13 +672,13 @@ func (n *NginxAPI) Generate(c *gin.Context) {
 		return
 	}
 
-	var cfg models.NginxConfig
-	if err := db.DB.Preload("Certificate").Preload("Locations").First(&cfg, id).Error; err != nil {
+	cfg, err := loadNginxConfigForRender(uint(id))
+	if err != nil {
 		response.NotFound(c, "配置不存在")
 		return
 	}
 
-	content, err := generateNginxConfig(&cfg)
+	content, err := generateNginxConfig(cfg)
 	if err != nil {
 		logger.Errorf("生成 Nginx 配置失败: %v", err)
 		response.InternalServerError(c, "生成配置失败: "+err.Error())
@@ -347,6 +701,10 @@ func (n *NginxAPI) Preview(c *gin.Context) {
 
 	cfg := &models.NginxConfig{
 		Name:              req.Name,
+		Mode:              defaultString(req.Mode, nginxModeMain),
+		IncludeDir:        stringValue(req.IncludeDir),
+		FileName:          stringValue(req.FileName),
+		WorkerUser:        defaultString(req.WorkerUser, defaultNginxWorkerUser),
 		WorkerProcesses:   defaultString(req.WorkerProcesses, "auto"),
 		WorkerConnections: defaultInt(req.WorkerConnections, 1024),
 		EnableHTTP:        req.EnableHTTP,
@@ -365,6 +723,8 @@ func (n *NginxAPI) Preview(c *gin.Context) {
 		ClientMaxBodySize: defaultString(req.ClientMaxBodySize, "100m"),
 		Gzip:              req.Gzip,
 		CustomConfig:      req.CustomConfig,
+		MainCustomConfig:   stringValue(req.MainCustomConfig),
+		ServerCustomConfig: stringValue(req.ServerCustomConfig),
 	}
 
 	// 如果有证书 ID，加载证书信息
@@ -375,33 +735,98 @@ func (n *NginxAPI) Preview(c *gin.Context) {
 		}
 	}
 
-	// 处理 locations
+	if err := validateNginxWorkerUser(req.WorkerUser); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	// 处理 locations 和 upstreams
+	if err := validateLocations(req.Locations); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
 	cfg.Locations = req.Locations
+	upstreams, err := buildUpstreams(req.Upstreams)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	cfg.Upstreams = upstreams
+	cfg.StreamServers = req.StreamServers
+	cfg.LimitZones = req.LimitZones
+	// 预览只需要用户名，不生成密码哈希
+	for _, u := range req.AuthUsers {
+		cfg.AuthUsers = append(cfg.AuthUsers, models.NginxAuthUser{Username: u.Username})
+	}
+	cfg.CacheZones = req.CacheZones
+	cfg.ServerID = req.ServerID
+	if err := validateProxyPassRefs(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateStreamConfig(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateAccessPolicies(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateProxySettings(cfg); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
 	logger.Infof("预览配置 - EnableProxy: %v, Locations 数量: %d, Locations: %+v", req.EnableProxy, len(req.Locations), req.Locations)
 
-	content, err := generateNginxConfig(cfg)
+	// 预览时同时返回离线校验结果与配置建议，行号对应生成的内容
+	content, errs, warnings, err := validateNginxConfig(cfg)
 	if err != nil {
 		logger.Errorf("生成 Nginx 配置预览失败: %v", err)
 		response.InternalServerError(c, "生成配置失败: "+err.Error())
 		return
 	}
+	if errs == nil {
+		errs = []nginxValidationError{}
+	}
+	if warnings == nil {
+		warnings = []nginxValidationError{}
+	}
 
 	response.Success(c, gin.H{
-		"content": content,
+		"content":  content,
+		"errors":   errs,
+		"warnings": warnings,
+		"advice":   adviseNginxContent(content, isNginxVhost(cfg), cachedNginxUser(req.ServerID)),
 	})
 }
 
+// defaultNginxWorkerUser 未指定时 worker 进程的运行用户
+const defaultNginxWorkerUser = "nobody"
+
+// nginxWorkerUserPattern worker 运行用户格式：用户名，可跟一个用户组
+var nginxWorkerUserPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*( [A-Za-z_][A-Za-z0-9_.-]*)?$`)
+
+// validateNginxWorkerUser 校验 worker 运行用户，为空表示使用默认用户
+func validateNginxWorkerUser(user string) error {
+	if user != "" && !nginxWorkerUserPattern.MatchString(user) {
+		return fmt.Errorf("worker 运行用户 %q 不合法，格式为「用户」或「用户 用户组」", user)
+	}
+	return nil
+}
+
 // generateNginxConfig 生成 Nginx 配置文件内容
 func generateNginxConfig(cfg *models.NginxConfig) (string, error) {
 	tmpl := `# Nginx 配置文件
 # 由中间件部署平台自动生成
 # 配置名称: {{.Name}}
 
-user nobody;
+user {{if .WorkerUser}}{{.WorkerUser}}{{else}}nobody{{end}};
 worker_processes {{.WorkerProcesses}};
 error_log {{.ErrorLogPath}} warn;
 pid /var/run/nginx.pid;
-
+{{if .MainCustomConfig}}
+{{.MainCustomConfig}}
+{{end}}
 events {
     worker_connections {{.WorkerConnections}};
     use epoll;
@@ -442,7 +867,7 @@ http {
 
     client_max_body_size {{.ClientMaxBodySize}};
 
-{{if .Gzip}}
+{{template "zones" .}}{{template "upstreams" .}}{{if .Gzip}}
     # Gzip 压缩
     gzip on;
     gzip_vary on;
@@ -451,51 +876,72 @@ http {
     gzip_types text/plain text/css text/xml application/json application/javascript application/rss+xml application/atom+xml image/svg+xml;
 {{end}}
 
-{{if .EnableHTTP}}
+{{template "servers" .}}
+{{if .IncludeDir}}
+    # 虚拟主机
+    include {{.IncludeDir}}/*.conf;
+{{end}}
+{{if .CustomConfig}}
+    # 自定义配置
+{{.CustomConfig}}
+{{end}}
+}
+{{.StreamBlock}}`
+
+	data, err := buildNginxTemplateData(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	t := template.New("nginx").Funcs(template.FuncMap{"upstreamBlock": renderUpstreamBlock, "limitZone": renderLimitZone, "cacheZone": renderCacheZone, "indent": indentLines})
+	for _, part := range []string{nginxServersTemplate, nginxUpstreamsTemplate, nginxZonesTemplate} {
+		if _, err := t.Parse(part); err != nil {
+			return "", err
+		}
+	}
+	if data.Mode == "vhost" {
+		tmpl = nginxVhostTemplate
+	}
+	if _, err := t.Parse(tmpl); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// nginxUpstreamsTemplate upstream 块模板
+const nginxUpstreamsTemplate = `{{define "upstreams"}}{{if .HTTPUpstreams}}
+    # 负载均衡
+{{range .HTTPUpstreams}}{{upstreamBlock .}}{{end}}{{end}}{{end}}`
+
+// nginxZonesTemplate http 上下文中的共享内存 zone 模板（限流与代理缓存）
+const nginxZonesTemplate = `{{define "zones"}}{{if .LimitZones}}
+    # 限流
+{{range .LimitZones}}{{limitZone .}}{{end}}{{end}}{{if .CacheZones}}
+    # 代理缓存
+{{range .CacheZones}}{{cacheZone .}}{{end}}{{end}}{{end}}`
+
+// nginxServersTemplate HTTP/HTTPS server 块模板（完整配置与虚拟主机共用）
+const nginxServersTemplate = `{{define "servers"}}{{if .EnableHTTP}}
     # HTTP Server
     server {
         listen {{.HTTPPort}};
         server_name {{.ServerName}};
-{{if .HTTPToHTTPS}}
+{{if eq .Mode "vhost"}}        access_log {{.AccessLogPath}};
+        client_max_body_size {{.ClientMaxBodySize}};
+{{end}}{{if .HTTPToHTTPS}}
         return 301 https://$host$request_uri;
 {{else}}
         root {{.RootPath}};
         index {{.IndexFiles}};
-
-{{if .EnableProxy}}
-{{if .Locations}}{{range .Locations}}
-        location {{.Path}} {
-{{if .ProxyPass}}
-            proxy_pass {{.ProxyPass}};
-            proxy_set_header Host $host;
-            proxy_set_header X-Real-IP $remote_addr;
-            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-            proxy_set_header X-Forwarded-Proto $scheme;
-{{end}}
-{{if .Root}}
-            root {{.Root}};
-{{end}}
-{{if .TryFiles}}
-            try_files {{.TryFiles}};
-{{else}}{{if and (not .ProxyPass) (not .Root)}}
-            try_files $uri $uri/ =404;
-{{end}}{{end}}
-        }
-{{end}}{{else}}
-        location / {
-            proxy_pass {{.ProxyPass}};
-            proxy_set_header Host $host;
-            proxy_set_header X-Real-IP $remote_addr;
-            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-            proxy_set_header X-Forwarded-Proto $scheme;
-        }
-{{end}}
-{{else}}
-        location / {
-            try_files $uri $uri/ =404;
-        }
-{{end}}
-{{end}}
+{{if .ServerCustomConfig}}
+{{indent "        " .ServerCustomConfig}}{{end}}
+{{.LocationBlocks}}{{end}}
     }
 {{end}}
 
@@ -504,7 +950,9 @@ http {
     server {
         listen {{.HTTPSPort}} ssl http2;
         server_name {{.ServerName}};
-
+{{if eq .Mode "vhost"}}        access_log {{.AccessLogPath}};
+        client_max_body_size {{.ClientMaxBodySize}};
+{{end}}
         ssl_certificate {{if .Certificate}}{{.Certificate.CertFilePath}}{{else}}/etc/nginx/ssl/cert.crt{{end}};
         ssl_certificate_key {{if .Certificate}}{{.Certificate.KeyFilePath}}{{else}}/etc/nginx/ssl/cert.key{{end}};
 
@@ -518,62 +966,22 @@ http {
 
         root {{.RootPath}};
         index {{.IndexFiles}};
-
-{{if .EnableProxy}}
-{{if .Locations}}{{range .Locations}}
-        location {{.Path}} {
-{{if .ProxyPass}}
-            proxy_pass {{.ProxyPass}};
-            proxy_set_header Host $host;
-            proxy_set_header X-Real-IP $remote_addr;
-            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-            proxy_set_header X-Forwarded-Proto $scheme;
-{{end}}
-{{if .Root}}
-            root {{.Root}};
-{{end}}
-{{if .TryFiles}}
-            try_files {{.TryFiles}};
-{{else}}{{if and (not .ProxyPass) (not .Root)}}
-            try_files $uri $uri/ =404;
-{{end}}{{end}}
-        }
-{{end}}{{else}}
-        location / {
-            proxy_pass {{.ProxyPass}};
-            proxy_set_header Host $host;
-            proxy_set_header X-Real-IP $remote_addr;
-            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-            proxy_set_header X-Forwarded-Proto $scheme;
-        }
-{{end}}
-{{else}}
-        location / {
-            try_files $uri $uri/ =404;
-        }
-{{end}}
-    }
+{{if .ServerCustomConfig}}
+{{indent "        " .ServerCustomConfig}}{{end}}
+{{.LocationBlocks}}    }
 {{end}}
+{{end}}`
 
+// nginxVhostTemplate 虚拟主机配置模板，作为 conf.d 下的 include 文件部署
+const nginxVhostTemplate = `# Nginx 虚拟主机配置
+# 由中间件部署平台自动生成
+# 配置名称: {{.Name}}
+{{template "zones" .}}{{template "upstreams" .}}
+{{template "servers" .}}
 {{if .CustomConfig}}
-    # 自定义配置
+# 自定义配置
 {{.CustomConfig}}
-{{end}}
-}
-`
-
-	t, err := template.New("nginx").Parse(tmpl)
-	if err != nil {
-		return "", err
-	}
-
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, cfg); err != nil {
-		return "", err
-	}
-
-	return buf.String(), nil
-}
+{{end}}`
 
 func defaultString(s, def string) string {
 	if s == "" {
@@ -596,6 +1004,7 @@ type ApplyConfigRequest struct {
 	BackupEnabled  bool   `json:"backup_enabled"`
 	RestartService bool   `json:"restart_service"`
 	ServiceName    string `json:"service_name"`
+	MainConfigPath string `json:"main_config_path"` // 虚拟主机模式下用于测试的主配置路径，为空时使用 nginx 默认配置
 }
 
 // ApplyConfig 应用 Nginx 配置到服务器
@@ -613,12 +1022,17 @@ func (n *NginxAPI) ApplyConfig(c *gin.Context) {
 	}
 
 	// 验证配置存在
-	var cfg models.NginxConfig
-	if err := db.DB.Preload("Certificate").Preload("Locations").First(&cfg, id).Error; err != nil {
+	cfg, err := loadNginxConfigForRender(uint(id))
+	if err != nil {
 		response.NotFound(c, "配置不存在")
 		return
 	}
 
+	// 上传前离线校验，避免错误配置到达服务器
+	if !checkNginxConfig(c, cfg) {
+		return
+	}
+
 	// 验证服务器存在
 	var server models.Server
 	if err := db.DB.First(&server, req.ServerID).Error; err != nil {
@@ -626,28 +1040,45 @@ func (n *NginxAPI) ApplyConfig(c *gin.Context) {
 		return
 	}
 
-	// 创建应用记录
+	// 记录本次应用的配置内容（与最新版本一致时沿用最新版本）
+	rev, err := recordNginxRevisionLocked(cfg, nginxRevisionApply, currentUsername(c), "应用到服务器 "+server.Name)
+	if err != nil {
+		logger.Errorf("记录 Nginx 配置版本失败: %v", err)
+		response.InternalServerError(c, "记录配置版本失败: "+err.Error())
+		return
+	}
+
+	apply, err := n.createApply(uint(id), cfg, rev, &server, &req)
+	if err != nil {
+		logger.Errorf("创建配置应用记录失败: %v", err)
+		response.InternalServerError(c, "创建失败")
+		return
+	}
+
+	logger.Infof("Nginx 配置应用任务已创建: %d", apply.ID)
+	response.SuccessWithMessage(c, "配置应用任务已创建", apply)
+}
+
+// createApply 创建应用记录并异步将修订版本的内容应用到服务器
+func (n *NginxAPI) createApply(configID uint, cfg *models.NginxConfig, rev *models.NginxConfigRevision, server *models.Server, req *ApplyConfigRequest) (*models.NginxConfigApply, error) {
 	apply := &models.NginxConfigApply{
-		NginxConfigID:  uint(id),
-		ServerID:       req.ServerID,
-		TargetPath:     defaultString(req.TargetPath, "/etc/nginx/nginx.conf"),
+		NginxConfigID:  configID,
+		ServerID:       server.ID,
+		TargetPath:     defaultString(req.TargetPath, nginxConfigTargetPath(cfg)),
+		MainConfigPath: req.MainConfigPath,
+		Revision:       rev.Revision,
 		BackupEnabled:  req.BackupEnabled,
 		RestartService: req.RestartService,
 		ServiceName:    defaultString(req.ServiceName, "nginx"),
 		Status:         "pending",
 	}
-
 	if err := db.DB.Create(apply).Error; err != nil {
-		logger.Errorf("创建配置应用记录失败: %v", err)
-		response.InternalServerError(c, "创建失败")
-		return
+		return nil, err
 	}
 
 	// 异步执行配置应用
-	go n.executeApplyConfig(apply.ID, &cfg, &server)
-
-	logger.Infof("Nginx 配置应用任务已创建: %d", apply.ID)
-	response.SuccessWithMessage(c, "配置应用任务已创建", apply)
+	go n.executeApplyConfig(apply.ID, cfg, server, rev)
+	return apply, nil
 }
 
 // GetApplyHistory 获取配置应用历史
@@ -712,7 +1143,9 @@ func (n *NginxAPI) GetApplyDetail(c *gin.Context) {
 }
 
 // executeApplyConfig 执行配置应用
-func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, server *models.Server) {
+// 配置内容与认证用户文件均取自修订版本，保证应用的内容与版本记录一致
+func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, server *models.Server, rev *models.NginxConfigRevision) {
+	content := rev.Content
 	// 更新状态为 running
 	startTime := now()
 	db.DB.Model(&models.NginxConfigApply{}).Where("id = ?", applyID).Updates(map[string]interface{}{
@@ -744,21 +1177,12 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 		return
 	}
 
-	// 步骤1: 生成配置文件
-	n.addApplyLog(applyID, 1, "生成 Nginx 配置文件", "running", "", "")
-	content, err := generateNginxConfig(cfg)
-	if err != nil {
-		logger.Errorf("生成配置文件失败: %v", err)
-		n.addApplyLog(applyID, 1, "生成 Nginx 配置文件", "failed", "", err.Error())
-		finalStatus = "failed"
-		errorMsg = "生成配置文件失败"
-		return
-	}
-	n.addApplyLog(applyID, 1, "生成 Nginx 配置文件", "success", "配置文件生成成功", "")
+	// 步骤1: 生成配置文件（内容取自修订版本）
+	n.addApplyLog(applyID, 1, "生成 Nginx 配置文件", "success", fmt.Sprintf("配置修订版本 r%d，%d 字节", apply.Revision, len(content)), "")
 
 	// 步骤2: 连接到目标服务器
 	n.addApplyLog(applyID, 2, "连接到目标服务器", "running", "", "")
-	sshClient, sftpClient, err := connectToServer(server)
+	lease, sftpClient, err := connectToServer(server)
 	if err != nil {
 		logger.Errorf("连接服务器失败: %v", err)
 		n.addApplyLog(applyID, 2, "连接到目标服务器", "failed", "", err.Error())
@@ -766,33 +1190,28 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 		errorMsg = "连接服务器失败"
 		return
 	}
-	defer sshClient.Close()
+	defer lease.Release()
 	defer sftpClient.Close()
+	sshClient := lease.Client
 	n.addApplyLog(applyID, 2, "连接到目标服务器", "success", "SSH 连接建立成功", "")
 
 	// 确定目标文件完整路径（提前计算，供后续步骤使用）
-	targetFile := apply.TargetPath
 	// 如果目标路径不以 .conf 结尾，说明是目录，需要添加文件名
-	if !strings.HasSuffix(targetFile, ".conf") {
-		targetFile = filepath.Join(targetFile, "nginx.conf")
-	}
+	targetFile := nginxConfigTargetFile(cfg, apply.TargetPath)
+	var backupPath string
 
 	// 步骤3: 备份原配置（如果启用）
 	if apply.BackupEnabled {
 		n.addApplyLog(applyID, 3, "备份原配置文件", "running", "", "")
-		backupPath := targetFile + ".backup." + startTime.Format("20060102150405")
+		backupPath = targetFile + ".backup." + startTime.Format("20060102150405")
 
 		// 检查原文件是否存在
 		checkCmd := "test -f " + targetFile + " && echo exists || echo notexists"
-		session, _ := sshClient.NewSession()
-		output, _ := session.CombinedOutput(checkCmd)
-		session.Close()
+		output, _ := runRemote(sshClient, checkCmd)
 
 		if string(output) == "exists\n" {
 			cpCmd := "sudo cp " + targetFile + " " + backupPath
-			session, _ := sshClient.NewSession()
-			cpOutput, err := session.CombinedOutput(cpCmd)
-			session.Close()
+			cpOutput, err := runRemote(sshClient, cpCmd)
 			if err != nil {
 				cpOutputStr := string(cpOutput)
 				logger.Errorf("备份配置文件失败: %v, 输出: %s", err, cpOutputStr)
@@ -804,9 +1223,7 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 
 			// 验证备份文件是否真的存在
 			verifyCmd := "ls -lh " + backupPath + " 2>&1"
-			session, _ = sshClient.NewSession()
-			verifyOutput, verifyErr := session.CombinedOutput(verifyCmd)
-			session.Close()
+			verifyOutput, verifyErr := runRemote(sshClient, verifyCmd)
 			verifyOutputStr := string(verifyOutput)
 			logger.Infof("备份文件验证: %s", verifyOutputStr)
 
@@ -822,6 +1239,7 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 			db.DB.Model(&models.NginxConfigApply{}).Where("id = ?", applyID).Update("backup_path", backupPath)
 			n.addApplyLog(applyID, 3, "备份原配置文件", "success", "备份至: "+backupPath+"\n验证: "+verifyOutputStr, "")
 		} else {
+			backupPath = ""
 			n.addApplyLog(applyID, 3, "备份原配置文件", "success", "原文件不存在，跳过备份", "")
 		}
 	}
@@ -853,9 +1271,7 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 
 	// 移动到目标位置（需要 sudo 权限）
 	mvCmd := "sudo mv " + tmpPath + " " + targetFile
-	session, _ := sshClient.NewSession()
-	output, err := session.CombinedOutput(mvCmd)
-	session.Close()
+	output, err := runRemote(sshClient, mvCmd)
 	if err != nil {
 		outputStr := string(output)
 		logger.Errorf("移动配置文件失败: %v, 输出: %s", err, outputStr)
@@ -867,9 +1283,7 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 
 	// 验证配置文件是否真的存在并获取详细信息
 	verifyCmd := "ls -lh " + targetFile + " && head -n 5 " + targetFile
-	session, _ = sshClient.NewSession()
-	verifyOutput, verifyErr := session.CombinedOutput(verifyCmd)
-	session.Close()
+	verifyOutput, verifyErr := runRemote(sshClient, verifyCmd)
 	verifyOutputStr := string(verifyOutput)
 	logger.Infof("配置文件验证: %s", verifyOutputStr)
 
@@ -883,30 +1297,84 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 
 	n.addApplyLog(applyID, stepNum, "上传新配置文件", "success", "配置文件已上传至: "+targetFile+"\n验证:\n"+verifyOutputStr, "")
 
+	// 上传 basic 认证用户文件（仅启用了 basic 认证时）
+	htpasswd, err := revisionHtpasswd(rev, cfg)
+	if err != nil {
+		stepNum++
+		n.addApplyLog(applyID, stepNum, "上传认证用户文件", "failed", "", err.Error())
+		finalStatus = "failed"
+		errorMsg = "生成认证用户文件失败"
+		return
+	}
+	if htpasswd != "" {
+		stepNum++
+		n.addApplyLog(applyID, stepNum, "上传认证用户文件", "running", "", "")
+		htpasswdPath := nginxHtpasswdPath(cfg)
+		tmpHtpasswd := fmt.Sprintf("/tmp/nginx_htpasswd_%d_%s", applyID, startTime.Format("20060102150405"))
+		// 临时文件包含密码哈希，写入内容前先收紧权限，避免其他用户读取
+		file, err := sftpClient.OpenFile(tmpHtpasswd, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if err == nil {
+			if err = file.Chmod(0600); err == nil {
+				_, err = file.Write([]byte(htpasswd))
+			}
+			file.Close()
+			if err != nil {
+				sftpClient.Remove(tmpHtpasswd)
+			}
+		}
+		if err != nil {
+			n.addApplyLog(applyID, stepNum, "上传认证用户文件", "failed", "", err.Error())
+			finalStatus = "failed"
+			errorMsg = "上传认证用户文件失败"
+			return
+		}
+		installCmd := fmt.Sprintf("sudo mkdir -p %s && sudo mv %s %s && sudo sh -c %s", nginxHtpasswdDir, tmpHtpasswd, htpasswdPath, shellQuote(nginxHtpasswdPermCommand(cfg, htpasswdPath)))
+		if output, err := runRemote(sshClient, installCmd); err != nil {
+			n.addApplyLog(applyID, stepNum, "上传认证用户文件", "failed", output, err.Error())
+			finalStatus = "failed"
+			errorMsg = "上传认证用户文件失败"
+			return
+		}
+		n.addApplyLog(applyID, stepNum, "上传认证用户文件", "success", "认证用户文件已上传至: "+htpasswdPath, "")
+	}
+
+	// 创建代理缓存目录（仅定义了缓存 zone 时）
+	if mkdirCmd := nginxCacheDirsCommand(cfg); mkdirCmd != "" {
+		stepNum++
+		n.addApplyLog(applyID, stepNum, "创建缓存目录", "running", "", "")
+		if output, err := runRemote(sshClient, "sudo "+mkdirCmd); err != nil {
+			n.addApplyLog(applyID, stepNum, "创建缓存目录", "failed", output, err.Error())
+			finalStatus = "failed"
+			errorMsg = "创建缓存目录失败"
+			return
+		}
+		n.addApplyLog(applyID, stepNum, "创建缓存目录", "success", mkdirCmd, "")
+	}
+
 	// 步骤5: 测试配置
 	stepNum++
 	n.addApplyLog(applyID, stepNum, "测试 Nginx 配置", "running", "", "")
 
 	// 查找 nginx 可执行文件路径
 	findNginxCmd := "if which nginx >/dev/null 2>&1; then which nginx; elif [ -f /usr/local/nginx/sbin/nginx ]; then echo /usr/local/nginx/sbin/nginx; elif [ -f /usr/sbin/nginx ]; then echo /usr/sbin/nginx; else echo nginx; fi"
-	session, _ = sshClient.NewSession()
-	nginxPathOutput, _ := session.CombinedOutput(findNginxCmd)
-	session.Close()
+	nginxPathOutput, _ := runRemote(sshClient, findNginxCmd)
 	nginxPath := strings.TrimSpace(string(nginxPathOutput))
 	if nginxPath == "" {
 		nginxPath = "nginx" // 回退到 PATH 中查找
 	}
 	logger.Infof("找到 nginx 路径: %s", nginxPath)
 
-	// 测试配置，指定配置文件路径
-	testCmd := "sudo " + nginxPath + " -t -c " + targetFile
-	session, _ = sshClient.NewSession()
-	output, err = session.CombinedOutput(testCmd)
-	session.Close()
+	// 测试配置，虚拟主机通过主配置测试完整配置树
+	testCmd := "sudo " + nginxTestCommand(nginxPath, cfg, targetFile, apply.MainConfigPath)
+	output, err = runRemote(sshClient, testCmd)
 
 	outputStr := string(output)
 	if err != nil {
 		logger.Errorf("Nginx 配置测试失败: %v, 输出: %s", err, outputStr)
+		if isNginxVhost(cfg) {
+			restoreOutput, _ := runRemote(sshClient, "sudo "+restoreIncludeFileCommand(targetFile, backupPath))
+			outputStr += "\n已恢复虚拟主机文件 " + targetFile + string(restoreOutput)
+		}
 		n.addApplyLog(applyID, stepNum, "测试 Nginx 配置", "failed", outputStr, err.Error())
 		finalStatus = "failed"
 		errorMsg = "配置测试失败"
@@ -921,9 +1389,7 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 
 		// 先尝试 systemctl
 		restartCmd := "sudo systemctl restart " + apply.ServiceName
-		session, _ = sshClient.NewSession()
-		output, err = session.CombinedOutput(restartCmd)
-		session.Close()
+		output, err = runRemote(sshClient, restartCmd)
 
 		outputStr = string(output)
 		// 如果 systemctl 失败，检查 nginx 是否运行，然后决定 reload 还是启动
@@ -932,9 +1398,7 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 
 			// 检查 nginx 是否正在运行
 			checkCmd := "pgrep -x nginx >/dev/null 2>&1 && echo running || echo stopped"
-			session, _ = sshClient.NewSession()
-			checkOutput, _ := session.CombinedOutput(checkCmd)
-			session.Close()
+			checkOutput, _ := runRemote(sshClient, checkCmd)
 			nginxStatus := strings.TrimSpace(string(checkOutput))
 
 			var nginxCmd string
@@ -943,14 +1407,18 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 				nginxCmd = "sudo " + nginxPath + " -s reload"
 				logger.Infof("Nginx 正在运行，执行 reload")
 			} else {
-				// nginx 未运行，直接启动
+				// nginx 未运行，直接启动（虚拟主机随主配置启动）
 				nginxCmd = "sudo " + nginxPath + " -c " + targetFile
+				if isNginxVhost(cfg) {
+					nginxCmd = "sudo " + nginxPath
+					if apply.MainConfigPath != "" {
+						nginxCmd += " -c " + apply.MainConfigPath
+					}
+				}
 				logger.Infof("Nginx 未运行，执行启动")
 			}
 
-			session, _ = sshClient.NewSession()
-			output, err = session.CombinedOutput(nginxCmd)
-			session.Close()
+			output, err = runRemote(sshClient, nginxCmd)
 			outputStr = string(output)
 
 			if err != nil {
@@ -965,9 +1433,7 @@ func (n *NginxAPI) executeApplyConfig(applyID uint, cfg *models.NginxConfig, ser
 		// 验证 nginx 是否真的在运行
 		time.Sleep(1 * time.Second) // 等待 nginx 启动
 		verifyCmd := "ps aux | grep nginx | grep -v grep || echo 'nginx not running'"
-		session, _ = sshClient.NewSession()
-		verifyOutput, _ := session.CombinedOutput(verifyCmd)
-		session.Close()
+		verifyOutput, _ := runRemote(sshClient, verifyCmd)
 		verifyOutputStr := string(verifyOutput)
 		logger.Infof("Nginx 进程验证: %s", verifyOutputStr)
 
@@ -1004,47 +1470,20 @@ func now() *time.Time {
 	return &t
 }
 
-// connectToServer 连接到服务器并返回 SSH 和 SFTP 客户端
-func connectToServer(server *models.Server) (*ssh.Client, *sftp.Client, error) {
-	var authMethods []ssh.AuthMethod
-
-	if server.AuthType == "password" {
-		authMethods = append(authMethods, ssh.Password(server.Password))
-	} else if server.AuthType == "key" {
-		var signer ssh.Signer
-		var err error
-
-		if server.Passphrase != "" {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(server.PrivateKey), []byte(server.Passphrase))
-		} else {
-			signer, err = ssh.ParsePrivateKey([]byte(server.PrivateKey))
-		}
-		if err != nil {
-			return nil, nil, fmt.Errorf("解析私钥失败: %v", err)
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	}
-
-	config := &ssh.ClientConfig{
-		User:            server.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}
-
-	addr := fmt.Sprintf("%s:%d", server.Host, server.Port)
-	sshClient, err := ssh.Dial("tcp", addr, config)
+// connectToServer 从连接池获取到服务器的连接并创建 SFTP 客户端
+func connectToServer(server *models.Server) (*sshLease, *sftp.Client, error) {
+	lease, err := sshPool.Acquire(server)
 	if err != nil {
 		return nil, nil, fmt.Errorf("SSH连接失败: %v", err)
 	}
 
-	sftpClient, err := sftp.NewClient(sshClient)
+	sftpClient, err := sftp.NewClient(lease.Client)
 	if err != nil {
-		sshClient.Close()
+		lease.Release()
 		return nil, nil, fmt.Errorf("SFTP会话创建失败: %v", err)
 	}
 
-	return sshClient, sftpClient, nil
+	return lease, sftpClient, nil
 }
 
 // GetNginxDeployInfo 获取服务器上的 Nginx 部署信息
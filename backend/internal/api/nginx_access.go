@@ -0,0 +1,301 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// nginxHtpasswdDir basic 认证用户文件的部署目录
+const nginxHtpasswdDir = "/etc/nginx/htpasswd"
+
+// 限流 zone 类型
+const (
+	limitZoneReq  = "req"  // limit_req_zone，请求速率
+	limitZoneConn = "conn" // limit_conn_zone，并发连接
+)
+
+// limitRatePattern 请求速率，如 10r/s、60r/m
+var limitRatePattern = regexp.MustCompile(`^[0-9]+r/[sm]$`)
+
+// nginxSizePattern nginx 大小参数，如 10m、512k
+var nginxSizePattern = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// authUsernamePattern basic 认证用户名规则（htpasswd 以冒号分隔用户名与密码）
+var authUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+
+// AuthUserRequest basic 认证用户，密码为空时沿用已有密码
+type AuthUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password"`
+}
+
+// splitAddressList 拆分逗号、空白或换行分隔的地址列表
+func splitAddressList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// validateAccessAddress 校验 allow/deny 地址：IP、CIDR、unix: 或 all
+func validateAccessAddress(addr string) error {
+	if addr == "all" || addr == "unix:" || net.ParseIP(addr) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(addr); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%q 不是合法的 IP、CIDR 或 all", addr)
+}
+
+// limitZoneType 限流 zone 类型，默认 req
+func limitZoneType(z *models.NginxLimitZone) string {
+	return defaultString(z.Type, limitZoneReq)
+}
+
+// validateLimitZones 校验限流 zone 的名称、类型、维度、大小与速率，zone 名称在 req 与 conn 之间也不能重复
+func validateLimitZones(zones []models.NginxLimitZone) error {
+	seen := map[string]bool{}
+	for i := range zones {
+		z := &zones[i]
+		if !upstreamNamePattern.MatchString(z.Name) {
+			return fmt.Errorf("限流 zone 名称 %q 不合法，只能包含字母、数字、下划线、点和横线", z.Name)
+		}
+		if seen[z.Name] {
+			return fmt.Errorf("限流 zone %s 重复定义", z.Name)
+		}
+		seen[z.Name] = true
+
+		if key := defaultString(z.Key, "$binary_remote_addr"); strings.ContainsAny(key, " \t;{}\"'") {
+			return fmt.Errorf("限流 zone %s 的 key %q 包含非法字符", z.Name, key)
+		}
+		if z.Size != "" && !nginxSizePattern.MatchString(z.Size) {
+			return fmt.Errorf("限流 zone %s 的大小 %q 格式错误，应如 10m", z.Name, z.Size)
+		}
+		switch limitZoneType(z) {
+		case limitZoneReq:
+			if !limitRatePattern.MatchString(z.Rate) {
+				return fmt.Errorf("限流 zone %s 的速率 %q 格式错误，应如 10r/s", z.Name, z.Rate)
+			}
+		case limitZoneConn:
+			if z.Rate != "" {
+				return fmt.Errorf("并发连接限制 zone %s 不支持设置速率", z.Name)
+			}
+		default:
+			return fmt.Errorf("限流 zone %s 的类型 %q 不支持，只能是 req 或 conn", z.Name, z.Type)
+		}
+	}
+	return nil
+}
+
+// validateAccessPolicies 校验 location 的访问控制、限流与 basic 认证设置，引用的 zone 须已定义且类型匹配，
+// 启用认证时须至少有一个用户
+func validateAccessPolicies(cfg *models.NginxConfig) error {
+	if err := validateLimitZones(cfg.LimitZones); err != nil {
+		return err
+	}
+	zones := map[string]string{}
+	for i := range cfg.LimitZones {
+		zones[cfg.LimitZones[i].Name] = limitZoneType(&cfg.LimitZones[i])
+	}
+
+	auth := false
+	for _, loc := range cfg.Locations {
+		for _, addr := range append(splitAddressList(loc.Allow), splitAddressList(loc.Deny)...) {
+			if err := validateAccessAddress(addr); err != nil {
+				return fmt.Errorf("location %s 的访问控制: %v", loc.Path, err)
+			}
+		}
+
+		if loc.LimitReqZone != "" {
+			if zones[loc.LimitReqZone] != limitZoneReq {
+				return fmt.Errorf("location %s 引用了未定义的请求限流 zone: %s", loc.Path, loc.LimitReqZone)
+			}
+			if loc.LimitReqBurst < 0 {
+				return fmt.Errorf("location %s 的 burst 不能为负数", loc.Path)
+			}
+		} else if loc.LimitReqBurst != 0 || loc.LimitReqNodelay {
+			return fmt.Errorf("location %s 设置了 burst/nodelay 但未选择请求限流 zone", loc.Path)
+		}
+		if loc.LimitConnZone != "" {
+			if zones[loc.LimitConnZone] != limitZoneConn {
+				return fmt.Errorf("location %s 引用了未定义的并发连接限制 zone: %s", loc.Path, loc.LimitConnZone)
+			}
+			if loc.LimitConn <= 0 {
+				return fmt.Errorf("location %s 的最大并发连接数必须大于 0", loc.Path)
+			}
+		} else if loc.LimitConn != 0 {
+			return fmt.Errorf("location %s 设置了最大并发连接数但未选择并发连接限制 zone", loc.Path)
+		}
+
+		auth = auth || loc.AuthBasic != ""
+	}
+	if auth && len(cfg.AuthUsers) == 0 {
+		return fmt.Errorf("启用 basic 认证需要至少一个用户")
+	}
+
+	// 虚拟主机与主配置加载到同一 http 上下文，zone 名称不能重复
+	if ids := sharedHTTPConfigIDs(cfg); len(ids) > 0 && len(cfg.LimitZones) > 0 {
+		var others []models.NginxLimitZone
+		db.DB.Where("nginx_config_id IN ?", ids).Find(&others)
+		for _, z := range others {
+			if _, ok := zones[z.Name]; ok {
+				return fmt.Errorf("限流 zone %s 与同一服务器上的其他 Nginx 配置重名", z.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// buildAuthUsers 将请求转换为 basic 认证用户并对密码进行 bcrypt 哈希，密码为空时沿用配置中同名用户的已有密码
+func buildAuthUsers(configID uint, reqs []AuthUserRequest) ([]models.NginxAuthUser, error) {
+	existing := map[string]string{}
+	if configID != 0 {
+		var users []models.NginxAuthUser
+		db.DB.Where("nginx_config_id = ?", configID).Find(&users)
+		for _, u := range users {
+			existing[u.Username] = u.PasswordHash
+		}
+	}
+
+	seen := map[string]bool{}
+	users := make([]models.NginxAuthUser, 0, len(reqs))
+	for _, r := range reqs {
+		if !authUsernamePattern.MatchString(r.Username) {
+			return nil, fmt.Errorf("认证用户名 %q 不合法，只能包含字母、数字和 _.@-", r.Username)
+		}
+		if seen[r.Username] {
+			return nil, fmt.Errorf("认证用户 %s 重复", r.Username)
+		}
+		seen[r.Username] = true
+
+		user := models.NginxAuthUser{NginxConfigID: configID, Username: r.Username}
+		if r.Password == "" {
+			hash, ok := existing[r.Username]
+			if !ok {
+				return nil, fmt.Errorf("认证用户 %s 需要设置密码", r.Username)
+			}
+			user.PasswordHash = hash
+		} else if err := user.SetPassword(r.Password); err != nil {
+			return nil, fmt.Errorf("生成用户 %s 的密码哈希失败: %v", r.Username, err)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// nginxHtpasswdPath 配置的 basic 认证用户文件路径，以配置 ID 区分名称相近的配置
+func nginxHtpasswdPath(cfg *models.NginxConfig) string {
+	stem := strings.TrimSuffix(vhostFileName(cfg.Name), ".conf")
+	return path.Join(nginxHtpasswdDir, fmt.Sprintf("%d_%s", cfg.ID, stem))
+}
+
+// nginxHtpasswdGroup 获取 nginx worker 进程所属组的 shell 表达式。
+// 完整配置取配置的运行用户组，未指定组时取运行用户的主组；虚拟主机取运行中 worker 的组，未运行时依次尝试 nginx、www-data 用户
+func nginxHtpasswdGroup(cfg *models.NginxConfig) string {
+	if !isNginxVhost(cfg) {
+		fields := strings.Fields(defaultString(cfg.WorkerUser, defaultNginxWorkerUser))
+		if len(fields) > 1 {
+			return shellQuote(fields[1])
+		}
+		return "$(id -gn " + fields[0] + ")"
+	}
+	return `$(ps -o user=,group= -C nginx 2>/dev/null | awk '$1 != "root" {print $2; exit}' | grep . || id -gn nginx 2>/dev/null || id -gn www-data 2>/dev/null || id -gn nobody)`
+}
+
+// nginxHtpasswdPermCommand 将 htpasswd 文件设为 640 并归属 worker 进程的组，其他用户不可读取密码哈希
+func nginxHtpasswdPermCommand(cfg *models.NginxConfig, file string) string {
+	return fmt.Sprintf("chgrp %s %s && chmod 640 %s", nginxHtpasswdGroup(cfg), shellQuote(file), shellQuote(file))
+}
+
+// nginxUsesBasicAuth 判断配置中是否有启用 basic 认证的 location
+func nginxUsesBasicAuth(cfg *models.NginxConfig) bool {
+	for _, loc := range cfg.Locations {
+		if loc.AuthBasic != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// nginxHtpasswdContent 生成配置的 htpasswd 文件内容（用户取自已加载的 AuthUsers），未启用 basic 认证时返回空
+func nginxHtpasswdContent(cfg *models.NginxConfig) (string, error) {
+	if !nginxUsesBasicAuth(cfg) {
+		return "", nil
+	}
+	if len(cfg.AuthUsers) == 0 {
+		return "", fmt.Errorf("配置启用了 basic 认证但没有认证用户")
+	}
+	var b strings.Builder
+	for _, u := range cfg.AuthUsers {
+		fmt.Fprintf(&b, "%s:%s\n", u.Username, u.PasswordHash)
+	}
+	return b.String(), nil
+}
+
+// revisionHtpasswd 返回修订版本的 htpasswd 文件内容。
+// 早期版本未记录文件内容时，按快照中的用户名从当前用户中取密码哈希，快照中的用户已删除时报错
+func revisionHtpasswd(rev *models.NginxConfigRevision, revCfg *models.NginxConfig) (string, error) {
+	if rev.Htpasswd != "" || !nginxUsesBasicAuth(revCfg) {
+		return rev.Htpasswd, nil
+	}
+	var current []models.NginxAuthUser
+	if err := db.DB.Where("nginx_config_id = ?", rev.NginxConfigID).Find(&current).Error; err != nil {
+		return "", err
+	}
+	hashes := make(map[string]string, len(current))
+	for _, u := range current {
+		hashes[u.Username] = u.PasswordHash
+	}
+	users := make([]models.NginxAuthUser, 0, len(revCfg.AuthUsers))
+	for _, u := range revCfg.AuthUsers {
+		hash, ok := hashes[u.Username]
+		if !ok {
+			return "", fmt.Errorf("修订版本 r%d 的认证用户 %s 已删除，无法还原认证用户文件", rev.Revision, u.Username)
+		}
+		users = append(users, models.NginxAuthUser{Username: u.Username, PasswordHash: hash})
+	}
+	return nginxHtpasswdContent(&models.NginxConfig{Locations: revCfg.Locations, AuthUsers: users})
+}
+
+// renderLimitZone 渲染 http 块中的限流 zone 定义
+func renderLimitZone(z models.NginxLimitZone) string {
+	key := defaultString(z.Key, "$binary_remote_addr")
+	size := defaultString(z.Size, "10m")
+	if limitZoneType(&z) == limitZoneConn {
+		return fmt.Sprintf("    limit_conn_zone %s zone=%s:%s;\n", key, z.Name, size)
+	}
+	return fmt.Sprintf("    limit_req_zone %s zone=%s:%s rate=%s;\n", key, z.Name, size, z.Rate)
+}
+
+// renderLocationAccess 渲染 location 的访问控制、限流与 basic 认证指令
+func renderLocationAccess(b *strings.Builder, loc *models.NginxLocation, htpasswd string) {
+	for _, addr := range splitAddressList(loc.Allow) {
+		fmt.Fprintf(b, "            allow %s;\n", addr)
+	}
+	for _, addr := range splitAddressList(loc.Deny) {
+		fmt.Fprintf(b, "            deny %s;\n", addr)
+	}
+	if loc.LimitReqZone != "" {
+		fmt.Fprintf(b, "            limit_req zone=%s", loc.LimitReqZone)
+		if loc.LimitReqBurst > 0 {
+			fmt.Fprintf(b, " burst=%d", loc.LimitReqBurst)
+		}
+		if loc.LimitReqNodelay {
+			b.WriteString(" nodelay")
+		}
+		b.WriteString(";\n")
+	}
+	if loc.LimitConnZone != "" {
+		fmt.Fprintf(b, "            limit_conn %s %d;\n", loc.LimitConnZone, loc.LimitConn)
+	}
+	if loc.AuthBasic != "" {
+		fmt.Fprintf(b, "            auth_basic %s;\n", quoteNginxString(loc.AuthBasic))
+		fmt.Fprintf(b, "            auth_basic_user_file %s;\n", htpasswd)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNginxAccessPolicies(t *testing.T) {
+	testDB := setupAPITestDB(t, nginxTestTables()...)
+
+	_, router := newNginxTestRouter()
+
+	cfg := nginxTestConfig("admin-site", "admin.example.com")
+	cfg["limit_zones"] = []map[string]interface{}{
+		{"name": "api_rate", "type": "req", "rate": "10r/s"},
+		{"name": "per_ip", "type": "conn", "size": "5m"},
+	}
+	cfg["auth_users"] = []map[string]interface{}{{"username": "ops", "password": "s3cret"}}
+	cfg["locations"] = []map[string]interface{}{{
+		"path": "/admin/", "handler_type": "proxy", "proxy_pass": "http://127.0.0.1:9000",
+		"allow": "10.0.0.0/8, 192.168.1.10", "deny": "all",
+		"limit_req_zone": "api_rate", "limit_req_burst": 20, "limit_req_nodelay": true,
+		"limit_conn_zone": "per_ip", "limit_conn": 10,
+		"auth_basic": "Admin Area",
+	}}
+	w := sendJSON(router, http.MethodPost, "/nginx", cfg)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "s3cret")
+
+	var stored models.NginxConfig
+	assert.NoError(t, testDB.Preload("Locations").Preload("LimitZones").Preload("AuthUsers").First(&stored).Error)
+	if assert.Len(t, stored.AuthUsers, 1) {
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(stored.AuthUsers[0].PasswordHash), []byte("s3cret")))
+	}
+
+	content, err := generateNginxConfig(&stored)
+	assert.NoError(t, err)
+	assert.Contains(t, content, "    limit_req_zone $binary_remote_addr zone=api_rate:10m rate=10r/s;\n")
+	assert.Contains(t, content, "    limit_conn_zone $binary_remote_addr zone=per_ip:5m;\n")
+	assert.Contains(t, content, strings.Join([]string{
+		"        location /admin/ {",
+		"            allow 10.0.0.0/8;",
+		"            allow 192.168.1.10;",
+		"            deny all;",
+		"            limit_req zone=api_rate burst=20 nodelay;",
+		"            limit_conn per_ip 10;",
+		`            auth_basic "Admin Area";`,
+		"            auth_basic_user_file /etc/nginx/htpasswd/1_admin-site;",
+	}, "\n"))
+
+	htpasswd, err := nginxHtpasswdContent(&stored)
+	assert.NoError(t, err)
+	assert.Equal(t, "ops:"+stored.AuthUsers[0].PasswordHash+"\n", htpasswd)
+
+	// 未填写密码的已有用户沿用原哈希，新用户必须设置密码
+	cfg["auth_users"] = []map[string]interface{}{{"username": "ops"}, {"username": "dev"}}
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, http.MethodPut, "/nginx/1", cfg).Code)
+	cfg["auth_users"] = []map[string]interface{}{{"username": "ops"}, {"username": "dev", "password": "pw"}}
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPut, "/nginx/1", cfg).Code)
+	var users []models.NginxAuthUser
+	testDB.Order("id").Find(&users)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, stored.AuthUsers[0].PasswordHash, users[0].PasswordHash)
+	}
+
+	// 应用历史版本时使用该版本记录的认证用户，而不是当前用户
+	var revisions []models.NginxConfigRevision
+	testDB.Order("revision ASC").Find(&revisions)
+	if assert.Len(t, revisions, 2) {
+		first, err := revisionConfig(&revisions[0])
+		assert.NoError(t, err)
+		htpasswd, err := revisionHtpasswd(&revisions[0], first)
+		assert.NoError(t, err)
+		assert.Equal(t, "ops:"+stored.AuthUsers[0].PasswordHash+"\n", htpasswd)
+		assert.Contains(t, revisions[1].Htpasswd, "dev:")
+
+		// 早期版本未记录文件内容时按快照中的用户名还原
+		revisions[0].Htpasswd = ""
+		htpasswd, err = revisionHtpasswd(&revisions[0], first)
+		assert.NoError(t, err)
+		assert.Equal(t, "ops:"+stored.AuthUsers[0].PasswordHash+"\n", htpasswd)
+	}
+	assert.Contains(t, nginxHtpasswdPermCommand(&stored, nginxHtpasswdPath(&stored)), "chgrp $(id -gn nobody) '/etc/nginx/htpasswd/1_admin-site' && chmod 640")
+
+	t.Run("校验", func(t *testing.T) {
+		base := func() *models.NginxConfig {
+			return &models.NginxConfig{
+				LimitZones: []models.NginxLimitZone{{Name: "rate", Rate: "5r/s"}, {Name: "conn", Type: "conn"}},
+				AuthUsers:  []models.NginxAuthUser{{Username: "ops"}},
+				Locations:  []models.NginxLocation{{Path: "/", Allow: "10.0.0.0/8", Deny: "all", LimitReqZone: "rate", AuthBasic: "x"}},
+			}
+		}
+		runNginxValidationCases(t, validateAccessPolicies, base, []nginxValidationCase{
+			{"非法 CIDR", func(c *models.NginxConfig) { c.Locations[0].Allow = "10.0.0.0/33" }, "10.0.0.0/33"},
+			{"引用连接数 zone 限流请求", func(c *models.NginxConfig) { c.Locations[0].LimitReqZone = "conn" }, "未定义的请求限流 zone"},
+			{"连接数限制未设置上限", func(c *models.NginxConfig) { c.Locations[0].LimitConnZone = "conn" }, "必须大于 0"},
+			{"非法速率", func(c *models.NginxConfig) { c.LimitZones[0].Rate = "fast" }, ""},
+			{"认证未配置用户", func(c *models.NginxConfig) { c.AuthUsers = nil }, "至少一个用户"},
+		})
+	})
+}
@@ -0,0 +1,489 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+)
+
+// 建议严重程度
+const (
+	adviceHigh   = "high"
+	adviceMedium = "medium"
+	adviceLow    = "low"
+)
+
+// adviceMaxBodySize client_max_body_size 超过该值时提示（字节）
+const adviceMaxBodySize = 100 << 20
+
+// adviceSecurityHeaders 建议设置的安全响应头及推荐值
+var adviceSecurityHeaders = []struct {
+	Name  string
+	Value string
+}{
+	{"X-Frame-Options", "SAMEORIGIN"},
+	{"X-Content-Type-Options", "nosniff"},
+	{"Referrer-Policy", "strict-origin-when-cross-origin"},
+}
+
+// adviceWeakProtocols 不安全的 TLS/SSL 协议版本
+var adviceWeakProtocols = map[string]bool{"SSLv2": true, "SSLv3": true, "TLSv1": true, "TLSv1.1": true}
+
+// NginxAdvice 配置最佳实践与安全建议
+type NginxAdvice struct {
+	Rule     string `json:"rule"`           // 规则标识
+	Severity string `json:"severity"`       // high, medium, low
+	Line     int    `json:"line,omitempty"` // 生成的配置中的行号
+	Message  string `json:"message"`        // 问题描述
+	Fix      string `json:"fix"`            // 修复建议
+}
+
+// nginxAdvisor 在生成的配置上运行建议规则
+type nginxAdvisor struct {
+	advice    []NginxAdvice
+	nginxUser string // 目标主机上已存在的 nginx 运行用户，为空表示未知
+}
+
+func (a *nginxAdvisor) add(rule, severity string, line int, message, fix string) {
+	a.advice = append(a.advice, NginxAdvice{Rule: rule, Severity: severity, Line: line, Message: message, Fix: fix})
+}
+
+// lookupDirective 从内到外查找作用域中的指令，返回最内层的最后一条
+func lookupDirective(name string, scopes ...[]*nginxDirective) *nginxDirective {
+	for _, scope := range scopes {
+		if found := findDirectives(scope, name); len(found) > 0 {
+			return found[len(found)-1]
+		}
+	}
+	return nil
+}
+
+// effectiveHeaders 生效的 add_header：nginx 中内层只要定义了 add_header 就不再继承外层
+func effectiveHeaders(scopes ...[]*nginxDirective) map[string]bool {
+	headers := map[string]bool{}
+	for _, scope := range scopes {
+		found := findDirectives(scope, "add_header")
+		if len(found) == 0 {
+			continue
+		}
+		for _, d := range found {
+			headers[strings.ToLower(d.arg(0))] = true
+		}
+		break
+	}
+	return headers
+}
+
+// parseNginxSize 解析 nginx 大小值（如 10m、1g），无法解析时返回 -1
+func parseNginxSize(value string) int64 {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return -1
+	}
+	unit := int64(1)
+	switch value[len(value)-1] {
+	case 'k':
+		unit = 1 << 10
+	case 'm':
+		unit = 1 << 20
+	case 'g':
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n * unit
+}
+
+// isHTTPSServer 判断 server 块是否监听 HTTPS
+func isHTTPSServer(server *nginxDirective) bool {
+	for _, l := range findDirectives(server.Block, "listen") {
+		for _, arg := range l.Args {
+			if arg == "ssl" {
+				return true
+			}
+		}
+	}
+	if d := lookupDirective("ssl", server.Block); d != nil && d.arg(0) == "on" {
+		return true
+	}
+	return false
+}
+
+// isRedirectOnlyServer 判断 server 块是否仅做跳转（server 级 return 且没有 location）
+func isRedirectOnlyServer(server *nginxDirective) bool {
+	return len(findDirectives(server.Block, "return")) > 0 && len(findDirectives(server.Block, "location")) == 0
+}
+
+// checkMain 检查全局配置
+func (a *nginxAdvisor) checkMain(main []*nginxDirective) {
+	user := lookupDirective("user", main)
+	if a.nginxUser == "" || (user != nil && user.arg(0) != "nobody") {
+		return
+	}
+	line := 0
+	if user != nil {
+		line = user.Line
+	}
+	a.add("user_nobody", adviceMedium, line,
+		fmt.Sprintf("worker 进程以 nobody 运行，而目标主机上存在专用用户 %s", a.nginxUser),
+		fmt.Sprintf("将配置的「Worker 运行用户」改为 %s（生成 user %s;），避免与其他以 nobody 运行的服务共享权限", a.nginxUser, a.nginxUser))
+}
+
+// checkHTTP 检查 http 级配置与其中的 server 块；vhost 模式下 http 为 nil
+func (a *nginxAdvisor) checkHTTP(http *nginxDirective, servers []*nginxDirective) {
+	var httpBlock []*nginxDirective
+	if http != nil {
+		httpBlock = http.Block
+	}
+
+	// server_tokens 在 http 级关闭即可覆盖所有 server
+	if d := lookupDirective("server_tokens", httpBlock); http != nil && (d == nil || d.arg(0) != "off") {
+		line := http.Line
+		if d != nil {
+			line = d.Line
+		}
+		a.add("server_tokens", adviceLow, line, "响应头和错误页会暴露 nginx 版本号", "在 http 块中添加 server_tokens off;")
+	}
+
+	for _, d := range findDirectives(httpBlock, "client_max_body_size") {
+		a.checkBodySize(d)
+	}
+	for _, d := range findDirectives(httpBlock, "ssl_protocols") {
+		a.checkProtocols(d)
+	}
+
+	for _, server := range servers {
+		a.checkServer(server, httpBlock, http == nil)
+	}
+}
+
+// checkServer 检查 server 块
+func (a *nginxAdvisor) checkServer(server *nginxDirective, httpBlock []*nginxDirective, vhost bool) {
+	name := "_"
+	if d := lookupDirective("server_name", server.Block); d != nil {
+		name = strings.Join(d.Args, " ")
+	}
+	https := isHTTPSServer(server)
+
+	if vhost {
+		if d := lookupDirective("server_tokens", server.Block); d == nil || d.arg(0) != "off" {
+			a.add("server_tokens", adviceLow, server.Line,
+				fmt.Sprintf("server %s 未关闭 server_tokens（如主配置 http 块已关闭可忽略）", name), "在 server 块中添加 server_tokens off;")
+		}
+	}
+	for _, d := range findDirectives(server.Block, "client_max_body_size") {
+		a.checkBodySize(d)
+	}
+	for _, d := range findDirectives(server.Block, "ssl_protocols") {
+		a.checkProtocols(d)
+	}
+	if https && lookupDirective("ssl_protocols", server.Block, httpBlock) == nil {
+		a.add("weak_tls", adviceMedium, server.Line,
+			fmt.Sprintf("HTTPS server %s 未设置 ssl_protocols，旧版本 nginx 默认启用 TLSv1/TLSv1.1", name),
+			"添加 ssl_protocols TLSv1.2 TLSv1.3;")
+	}
+
+	// 仅做跳转的 server 不返回内容，无需检查响应头与日志
+	if isRedirectOnlyServer(server) {
+		return
+	}
+
+	headers := effectiveHeaders(server.Block, httpBlock)
+	var missing, fixes []string
+	for _, h := range adviceSecurityHeaders {
+		if !headers[strings.ToLower(h.Name)] {
+			missing = append(missing, h.Name)
+			fixes = append(fixes, fmt.Sprintf("add_header %s %s always;", h.Name, h.Value))
+		}
+	}
+	if len(missing) > 0 {
+		a.add("security_headers", adviceMedium, server.Line,
+			fmt.Sprintf("server %s 缺少安全响应头: %s", name, strings.Join(missing, ", ")),
+			"在 server 块中添加 "+strings.Join(fixes, " "))
+	}
+	if https && !headers["strict-transport-security"] {
+		a.add("hsts", adviceMedium, server.Line,
+			fmt.Sprintf("HTTPS server %s 未设置 HSTS，浏览器仍可能通过 HTTP 访问", name),
+			`添加 add_header Strict-Transport-Security "max-age=31536000" always;`)
+	}
+
+	if d := lookupDirective("access_log", server.Block, httpBlock); d == nil || d.arg(0) == "off" {
+		line := server.Line
+		if d != nil {
+			line = d.Line
+		}
+		message := fmt.Sprintf("server %s 未记录访问日志", name)
+		if d == nil && vhost {
+			message += "（如主配置 http 块已配置可忽略）"
+		}
+		a.add("access_log", adviceLow, line, message, "添加 access_log /var/log/nginx/<站点>.access.log; 便于审计与排障")
+	}
+
+	a.checkLocations(server.Block, [][]*nginxDirective{server.Block, httpBlock})
+}
+
+// checkLocations 递归检查 location 中的反向代理超时设置
+func (a *nginxAdvisor) checkLocations(block []*nginxDirective, outer [][]*nginxDirective) {
+	for _, loc := range findDirectives(block, "location") {
+		scopes := append([][]*nginxDirective{loc.Block}, outer...)
+		for _, d := range findDirectives(loc.Block, "client_max_body_size") {
+			a.checkBodySize(d)
+		}
+		if pass := lookupDirective("proxy_pass", loc.Block); pass != nil &&
+			lookupDirective("proxy_connect_timeout", scopes...) == nil &&
+			lookupDirective("proxy_read_timeout", scopes...) == nil {
+			a.add("proxy_timeout", adviceLow, pass.Line,
+				fmt.Sprintf("location %s 代理到 %s 未设置超时，后端无响应时连接会占用 60 秒", strings.Join(loc.Args, " "), pass.arg(0)),
+				"按后端特性设置 proxy_connect_timeout 5s; proxy_read_timeout 60s; proxy_send_timeout 60s;")
+		}
+		a.checkLocations(loc.Block, scopes)
+	}
+}
+
+// checkBodySize 检查请求体大小限制
+func (a *nginxAdvisor) checkBodySize(d *nginxDirective) {
+	size := parseNginxSize(d.arg(0))
+	switch {
+	case size == 0:
+		a.add("client_max_body_size", adviceMedium, d.Line, "client_max_body_size 为 0，不限制请求体大小",
+			"按业务需要设置上限，如 client_max_body_size 20m;")
+	case size > adviceMaxBodySize:
+		a.add("client_max_body_size", adviceLow, d.Line, fmt.Sprintf("client_max_body_size %s 过大，容易被大请求耗尽磁盘与带宽", d.arg(0)),
+			"仅在上传接口的 location 中放宽限制，全局保持 100m 以内")
+	}
+}
+
+// checkProtocols 检查是否启用了不安全的协议版本
+func (a *nginxAdvisor) checkProtocols(d *nginxDirective) {
+	var weak []string
+	for _, p := range d.Args {
+		if adviceWeakProtocols[p] {
+			weak = append(weak, p)
+		}
+	}
+	if len(weak) > 0 {
+		a.add("weak_tls", adviceHigh, d.Line, "启用了不安全的协议版本: "+strings.Join(weak, " "),
+			"改为 ssl_protocols TLSv1.2 TLSv1.3;")
+	}
+}
+
+// adviseNginxContent 对生成的配置运行建议规则，按行号排序返回；配置无法解析时返回空
+func adviseNginxContent(content string, vhost bool, nginxUser string) []NginxAdvice {
+	directives, err := parseNginxConfig(content, "")
+	if err != nil {
+		return []NginxAdvice{}
+	}
+
+	a := &nginxAdvisor{advice: []NginxAdvice{}, nginxUser: nginxUser}
+	if vhost {
+		a.checkHTTP(nil, findDirectives(directives, "server"))
+	} else {
+		a.checkMain(directives)
+		for _, http := range findDirectives(directives, "http") {
+			a.checkHTTP(http, findDirectives(http.Block, "server"))
+		}
+	}
+
+	sort.SliceStable(a.advice, func(i, j int) bool { return a.advice[i].Line < a.advice[j].Line })
+	return a.advice
+}
+
+// nginxUserCacheTTL 主机 nginx 用户查询结果的缓存时间
+const nginxUserCacheTTL = 10 * time.Minute
+
+// nginxUserLookupTimeout 查询 nginx 用户的超时时间，超时视为未知（跳过依赖该信息的建议）
+const nginxUserLookupTimeout = 3 * time.Second
+
+// nginxUserCache 缓存各服务器上的 nginx 运行用户，避免每次预览都连接服务器
+var nginxUserCache = struct {
+	sync.Mutex
+	entries map[uint]nginxUserEntry
+	pending map[uint]chan struct{} // 正在查询的服务器，查询结束时关闭
+}{entries: map[uint]nginxUserEntry{}, pending: map[uint]chan struct{}{}}
+
+type nginxUserEntry struct {
+	user    string
+	expires time.Time
+}
+
+// cachedNginxUser 只读缓存获取服务器的 nginx 用户，未缓存时在后台查询并返回空，不阻塞预览
+func cachedNginxUser(serverID *uint) string {
+	if serverID == nil {
+		return ""
+	}
+	user, _ := startNginxUserLookup(*serverID)
+	return user
+}
+
+// lookupNginxUser 查询服务器上已存在的 nginx 专用用户（nginx 或 www-data），
+// 最多等待 nginxUserLookupTimeout，查询失败或超时返回空
+func lookupNginxUser(serverID *uint) string {
+	if serverID == nil {
+		return ""
+	}
+	user, done := startNginxUserLookup(*serverID)
+	if done == nil {
+		return user
+	}
+	select {
+	case <-done:
+	case <-time.After(nginxUserLookupTimeout):
+		return ""
+	}
+	user, _ = startNginxUserLookup(*serverID)
+	return user
+}
+
+// lookupNginxUsers 并发查询多台服务器的 nginx 用户
+func lookupNginxUsers(serverIDs []uint) map[uint]string {
+	users := make(map[uint]string, len(serverIDs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range serverIDs {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			user := lookupNginxUser(&id)
+			mu.Lock()
+			users[id] = user
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	return users
+}
+
+// startNginxUserLookup 缓存有效时返回缓存的用户且 done 为 nil；
+// 否则确保有一个后台查询在进行，返回其结束通知
+func startNginxUserLookup(serverID uint) (string, <-chan struct{}) {
+	nginxUserCache.Lock()
+	defer nginxUserCache.Unlock()
+	if entry, ok := nginxUserCache.entries[serverID]; ok && time.Now().Before(entry.expires) {
+		return entry.user, nil
+	}
+	if done, ok := nginxUserCache.pending[serverID]; ok {
+		return "", done
+	}
+
+	done := make(chan struct{})
+	nginxUserCache.pending[serverID] = done
+	go func() {
+		user := queryNginxUser(serverID)
+		nginxUserCache.Lock()
+		nginxUserCache.entries[serverID] = nginxUserEntry{user: user, expires: time.Now().Add(nginxUserCacheTTL)}
+		delete(nginxUserCache.pending, serverID)
+		nginxUserCache.Unlock()
+		close(done)
+	}()
+	return "", done
+}
+
+// queryNginxUser 连接服务器查询 nginx 用户，查询失败返回空
+func queryNginxUser(serverID uint) string {
+	var server models.Server
+	if err := db.DB.First(&server, serverID).Error; err != nil {
+		return ""
+	}
+	lease, err := sshPool.acquire(&server, false, nginxUserLookupTimeout)
+	if err != nil {
+		logger.Warnf("查询服务器 %s 的 nginx 用户失败: %v", server.Name, err)
+		return ""
+	}
+	defer lease.Release()
+	output, err := runRemote(lease.Client, "getent passwd nginx www-data 2>/dev/null | cut -d: -f1 | head -n 1")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// adviceSummary 按严重程度统计建议数量
+func adviceSummary(advice []NginxAdvice) map[string]int {
+	summary := map[string]int{adviceHigh: 0, adviceMedium: 0, adviceLow: 0}
+	for _, a := range advice {
+		summary[a.Severity]++
+	}
+	return summary
+}
+
+// nginxAdviceReport 单个配置的建议报告
+type nginxAdviceReport struct {
+	ConfigID uint           `json:"config_id"`
+	Name     string         `json:"name"`
+	Mode     string         `json:"mode"`
+	ServerID *uint          `json:"server_id"`
+	Summary  map[string]int `json:"summary"`
+	Advice   []NginxAdvice  `json:"advice"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// AdviceReport 对所有配置运行建议规则，生成汇总报告；可用 severity 过滤
+func (n *NginxAPI) AdviceReport(c *gin.Context) {
+	severity := c.Query("severity")
+
+	var ids []uint
+	if err := db.DB.Model(&models.NginxConfig{}).Order("id ASC").Pluck("id", &ids).Error; err != nil {
+		logger.Errorf("查询 Nginx 配置失败: %v", err)
+		response.InternalServerError(c, "查询失败")
+		return
+	}
+
+	configs := make([]*models.NginxConfig, 0, len(ids))
+	var serverIDs []uint
+	seen := map[uint]bool{}
+	for _, id := range ids {
+		cfg, err := loadNginxConfigForRender(id)
+		if err != nil {
+			continue
+		}
+		configs = append(configs, cfg)
+		if cfg.ServerID != nil && !seen[*cfg.ServerID] {
+			seen[*cfg.ServerID] = true
+			serverIDs = append(serverIDs, *cfg.ServerID)
+		}
+	}
+	// 各服务器的 nginx 用户并发查询，单台服务器不可达不会拖慢整个报告
+	users := lookupNginxUsers(serverIDs)
+
+	reports := make([]nginxAdviceReport, 0, len(configs))
+	var all []NginxAdvice
+	for _, cfg := range configs {
+		nginxUser := ""
+		if cfg.ServerID != nil {
+			nginxUser = users[*cfg.ServerID]
+		}
+		report := nginxAdviceReport{ConfigID: cfg.ID, Name: cfg.Name, Mode: cfg.Mode, ServerID: cfg.ServerID, Advice: []NginxAdvice{}}
+		content, err := generateNginxConfig(cfg)
+		if err != nil {
+			report.Error = "生成配置失败: " + err.Error()
+		} else {
+			for _, a := range adviseNginxContent(content, isNginxVhost(cfg), nginxUser) {
+				if severity == "" || a.Severity == severity {
+					report.Advice = append(report.Advice, a)
+				}
+			}
+		}
+		report.Summary = adviceSummary(report.Advice)
+		all = append(all, report.Advice...)
+		reports = append(reports, report)
+	}
+
+	response.Success(c, gin.H{
+		"summary": adviceSummary(all),
+		"reports": reports,
+	})
+}
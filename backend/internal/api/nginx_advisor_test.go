@@ -0,0 +1,106 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestAdviseNginxContent(t *testing.T) {
+	rules := func(advice []NginxAdvice) map[string]string {
+		found := map[string]string{}
+		for _, a := range advice {
+			assert.NotEmpty(t, a.Fix)
+			found[a.Rule] = a.Severity
+		}
+		return found
+	}
+
+	cfg := &models.NginxConfig{
+		Name:              "site",
+		WorkerProcesses:   "auto",
+		WorkerConnections: 1024,
+		EnableHTTP:        true,
+		HTTPPort:          80,
+		HTTPToHTTPS:       true,
+		EnableHTTPS:       true,
+		HTTPSPort:         443,
+		ServerName:        "example.com",
+		RootPath:          "/srv/www",
+		IndexFiles:        "index.html",
+		AccessLogPath:     "/var/log/nginx/access.log",
+		ErrorLogPath:      "/var/log/nginx/error.log",
+		ClientMaxBodySize: "1g",
+		Locations:         []models.NginxLocation{{Path: "/api/", HandlerType: "proxy", ProxyPass: "http://127.0.0.1:8080"}},
+	}
+
+	t.Run("生成的默认配置", func(t *testing.T) {
+		content, err := generateNginxConfig(cfg)
+		assert.NoError(t, err)
+		found := rules(adviseNginxContent(content, false, "nginx"))
+		assert.Equal(t, adviceMedium, found["security_headers"])
+		assert.Equal(t, adviceMedium, found["hsts"])
+		assert.Equal(t, adviceLow, found["server_tokens"])
+		assert.Equal(t, adviceLow, found["proxy_timeout"])
+		assert.Equal(t, adviceLow, found["client_max_body_size"])
+		assert.Equal(t, adviceMedium, found["user_nobody"])
+		for _, advice := range adviseNginxContent(content, false, "nginx") {
+			if advice.Rule == "user_nobody" {
+				assert.Contains(t, advice.Fix, "Worker 运行用户")
+			}
+		}
+		assert.NotContains(t, found, "weak_tls")
+		assert.NotContains(t, found, "access_log")
+
+		// 未知主机用户时不检查运行用户
+		assert.NotContains(t, rules(adviseNginxContent(content, false, "")), "user_nobody")
+	})
+
+	t.Run("修复后不再提示", func(t *testing.T) {
+		fixed := *cfg
+		fixed.WorkerUser = "nginx"
+		fixed.ClientMaxBodySize = "20m"
+		fixed.CustomConfig = "    server_tokens off;"
+		fixed.ServerCustomConfig = "add_header X-Frame-Options SAMEORIGIN always;\nadd_header X-Content-Type-Options nosniff always;\n" +
+			"add_header Referrer-Policy strict-origin-when-cross-origin always;\nadd_header Strict-Transport-Security \"max-age=31536000\" always;\n" +
+			"proxy_connect_timeout 5s;"
+		content, err := generateNginxConfig(&fixed)
+		assert.NoError(t, err)
+		assert.Empty(t, adviseNginxContent(content, false, "nginx"))
+	})
+
+	t.Run("虚拟主机与不安全协议", func(t *testing.T) {
+		advice := adviseNginxContent("server {\n    listen 443 ssl;\n    ssl_protocols TLSv1 TLSv1.2;\n    access_log off;\n    client_max_body_size 0;\n    location / { root /srv; }\n}\n", true, "")
+		found := rules(advice)
+		assert.Equal(t, adviceHigh, found["weak_tls"])
+		assert.Equal(t, adviceMedium, found["client_max_body_size"])
+		assert.Equal(t, adviceLow, found["access_log"])
+		assert.Equal(t, adviceLow, found["server_tokens"])
+		for i := 1; i < len(advice); i++ {
+			assert.LessOrEqual(t, advice[i-1].Line, advice[i].Line)
+		}
+	})
+}
+
+func TestLookupNginxUser(t *testing.T) {
+	setupAPITestDB(t, &models.Server{})
+
+	cached, missing := uint(9001), uint(9002)
+	nginxUserCache.Lock()
+	nginxUserCache.entries[cached] = nginxUserEntry{user: "www-data", expires: time.Now().Add(time.Minute)}
+	nginxUserCache.Unlock()
+
+	assert.Equal(t, "www-data", cachedNginxUser(&cached))
+	assert.Equal(t, "", cachedNginxUser(nil))
+
+	// 未缓存的服务器不阻塞预览，查询结束后写入缓存
+	assert.Equal(t, "", cachedNginxUser(&missing))
+	users := lookupNginxUsers([]uint{cached, missing})
+	assert.Equal(t, map[uint]string{cached: "www-data", missing: ""}, users)
+	nginxUserCache.Lock()
+	_, ok := nginxUserCache.entries[missing]
+	nginxUserCache.Unlock()
+	assert.True(t, ok)
+}
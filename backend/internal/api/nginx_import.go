@@ -0,0 +1,747 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+)
+
+// 导入限制
+const (
+	nginxImportMaxFileSize = 1 << 20 // 单个配置文件最大 1MB
+	nginxImportMaxFiles    = 100     // 最多展开的 include 文件数
+)
+
+// templateHTTPDirectives 由平台模板固定生成的 http 级指令，导入时不保留
+var templateHTTPDirectives = map[string]bool{
+	"default_type":        true,
+	"sendfile":            true,
+	"tcp_nopush":          true,
+	"tcp_nodelay":         true,
+	"keepalive_timeout":   true,
+	"types_hash_max_size": true,
+}
+
+// NginxImportRequest 导入 Nginx 配置请求
+type NginxImportRequest struct {
+	Name     string `json:"name" binding:"required"` // 导入后的配置名称，虚拟主机以此为前缀
+	ServerID *uint  `json:"server_id"`               // 从该服务器读取配置
+	Path     string `json:"path"`                    // 主配置路径，默认 /etc/nginx/nginx.conf
+	Content  string `json:"content"`                 // 直接提供配置内容（此时不展开 include）
+	DryRun   bool   `json:"dry_run"`                 // 只解析预览，不保存
+}
+
+// nginxImportResult 导入结果
+type nginxImportResult struct {
+	Config   *models.NginxConfig   `json:"config"`
+	Vhosts   []*models.NginxConfig `json:"vhosts"`
+	Files    []string              `json:"files"`
+	Warnings []string              `json:"warnings"`
+}
+
+// nginxImporter 将解析后的指令树转换为平台配置模型
+type nginxImporter struct {
+	name      string
+	readFile  func(path string) (string, error) // 为空时不展开 include
+	glob      func(pattern string) ([]string, error)
+	keepalive map[string]bool // 启用 keepalive 的 upstream
+	result    nginxImportResult
+}
+
+// warn 记录导入警告
+func (imp *nginxImporter) warn(d *nginxDirective, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if d != nil {
+		msg = fmt.Sprintf("%s:%d: %s", d.File, d.Line, msg)
+	}
+	imp.result.Warnings = append(imp.result.Warnings, msg)
+}
+
+// newImportedConfig 创建带默认值的配置
+func newImportedConfig(name, mode string) *models.NginxConfig {
+	return &models.NginxConfig{
+		Name:              name,
+		Mode:              mode,
+		WorkerUser:        defaultNginxWorkerUser,
+		WorkerProcesses:   "auto",
+		WorkerConnections: 1024,
+		HTTPPort:          80,
+		HTTPSPort:         443,
+		ServerName:        "_",
+		RootPath:          "/usr/share/nginx/html",
+		IndexFiles:        "index.html index.htm",
+		AccessLogPath:     "/var/log/nginx/access.log",
+		ErrorLogPath:      "/var/log/nginx/error.log",
+		LogFormat:         "main",
+		ClientMaxBodySize: "100m",
+		Status:            "draft",
+	}
+}
+
+// importMain 导入主配置文件
+func (imp *nginxImporter) importMain(mainPath, content string) error {
+	directives, err := parseNginxConfig(content, mainPath)
+	if err != nil {
+		return err
+	}
+	imp.result.Files = append(imp.result.Files, mainPath)
+
+	cfg := newImportedConfig(imp.name, nginxModeMain)
+	imp.result.Config = cfg
+
+	var http *nginxDirective
+	var raw []*nginxDirective
+	for _, d := range directives {
+		switch d.Name {
+		case "worker_processes":
+			cfg.WorkerProcesses = d.arg(0)
+		case "error_log":
+			cfg.ErrorLogPath = d.arg(0)
+		case "user":
+			cfg.WorkerUser = strings.Join(d.Args, " ")
+			if err := validateNginxWorkerUser(cfg.WorkerUser); err != nil {
+				imp.warn(d, "%v，使用默认用户 %s", err, defaultNginxWorkerUser)
+				cfg.WorkerUser = defaultNginxWorkerUser
+			}
+		case "pid":
+			imp.warn(d, "%s 指令由平台模板生成，导入时忽略", d.Name)
+		case "events":
+			for _, e := range d.Block {
+				switch e.Name {
+				case "worker_connections":
+					if n, err := strconv.Atoi(e.arg(0)); err == nil {
+						cfg.WorkerConnections = n
+					}
+				case "use", "multi_accept":
+				default:
+					imp.warn(e, "events 块中的 %s 指令暂不支持，导入时忽略", e.Name)
+				}
+			}
+		case "http":
+			http = d
+		default:
+			raw = append(raw, d)
+		}
+	}
+	cfg.MainCustomConfig = strings.TrimRight(renderNginxDirectives(raw, ""), "\n")
+	if http == nil {
+		imp.warn(nil, "%s 中没有 http 块", mainPath)
+		return nil
+	}
+
+	// 先展开 include 的虚拟主机文件并收集 upstream，便于识别 keepalive 引用
+	var vhostFiles []*nginxDirective
+	httpBlock := make([]*nginxDirective, 0, len(http.Block))
+	for _, d := range http.Block {
+		if isVhostInclude(d) && imp.readFile != nil {
+			files, err := imp.expandInclude(path.Dir(mainPath), d)
+			if err != nil {
+				imp.warn(d, "展开 include 失败: %v", err)
+				httpBlock = append(httpBlock, d)
+				continue
+			}
+			includeDir := resolveNginxPath(path.Dir(mainPath), path.Dir(d.arg(0)))
+			if cfg.IncludeDir == "" {
+				cfg.IncludeDir = includeDir
+			}
+			if path.Base(d.arg(0)) != "*.conf" || cfg.IncludeDir != includeDir {
+				imp.warn(d, "include %s 的文件按虚拟主机导入，主配置统一 include %s/*.conf", d.arg(0), cfg.IncludeDir)
+			}
+			vhostFiles = append(vhostFiles, files...)
+			continue
+		}
+		httpBlock = append(httpBlock, d)
+	}
+	imp.collectKeepalive(httpBlock)
+	for _, f := range vhostFiles {
+		imp.collectKeepalive(f.Block)
+	}
+
+	raw = nil
+	var servers []*nginxDirective
+	for _, d := range httpBlock {
+		switch {
+		case d.Name == "include" && path.Base(d.arg(0)) == "mime.types":
+		case templateHTTPDirectives[d.Name] || strings.HasPrefix(d.Name, "gzip_"):
+			imp.warn(d, "%s 指令使用平台模板默认值", d.Name)
+		case d.Name == "client_max_body_size":
+			cfg.ClientMaxBodySize = d.arg(0)
+		case d.Name == "gzip":
+			cfg.Gzip = d.arg(0) == "on"
+		case d.Name == "log_format" && (d.arg(0) == "main" || d.arg(0) == "json"):
+			if logFormatDefinition(d) != templateLogFormat(d.arg(0)) {
+				imp.warn(d, "log_format %s 与平台模板的定义不同，导入后使用模板定义", d.arg(0))
+			}
+		case d.Name == "access_log":
+			cfg.AccessLogPath = d.arg(0)
+			if d.arg(1) == "json" {
+				cfg.LogFormat = "json"
+			}
+		case d.Name == "upstream":
+			if u, ok := imp.importUpstream(d); ok {
+				cfg.Upstreams = append(cfg.Upstreams, *u)
+			} else {
+				raw = append(raw, d)
+			}
+		case d.Name == "server" && d.Block != nil:
+			servers = append(servers, d)
+		default:
+			raw = append(raw, d)
+		}
+	}
+
+	rest := imp.importServers(cfg, servers)
+	cfg.CustomConfig = strings.TrimRight(renderNginxDirectives(append(raw, rest...), "    "), "\n")
+
+	for _, f := range vhostFiles {
+		imp.importVhost(f)
+	}
+	return nil
+}
+
+// isVhostInclude 判断 http 块中的 include 是否按虚拟主机文件展开：
+// .conf 文件或通配符（如 sites-enabled/*），mime.types 等非通配的普通文件除外
+func isVhostInclude(d *nginxDirective) bool {
+	if d.Name != "include" {
+		return false
+	}
+	return strings.HasSuffix(d.arg(0), ".conf") || strings.ContainsAny(d.arg(0), "*?[")
+}
+
+// logFormatDefinition 返回 log_format 指令去掉名称后的定义（多段字符串拼接）
+func logFormatDefinition(d *nginxDirective) string {
+	if len(d.Args) < 2 {
+		return ""
+	}
+	return strings.Join(d.Args[1:], "")
+}
+
+// templateLogFormat 返回平台模板中指定日志格式（main 或 json）的定义
+func templateLogFormat(name string) string {
+	cfg := newImportedConfig("log-format", nginxModeMain)
+	cfg.LogFormat = name
+	content, err := generateNginxConfig(cfg)
+	if err != nil {
+		return ""
+	}
+	directives, err := parseNginxConfig(content, "")
+	if err != nil {
+		return ""
+	}
+	for _, d := range directives {
+		if d.Name != "http" {
+			continue
+		}
+		for _, item := range d.Block {
+			if item.Name == "log_format" && item.arg(0) == name {
+				return logFormatDefinition(item)
+			}
+		}
+	}
+	return ""
+}
+
+// expandInclude 读取 include 匹配的文件，返回以文件为单位的伪块指令
+func (imp *nginxImporter) expandInclude(baseDir string, d *nginxDirective) ([]*nginxDirective, error) {
+	pattern := resolveNginxPath(baseDir, d.arg(0))
+	matches, err := imp.glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*nginxDirective
+	for _, file := range matches {
+		if len(imp.result.Files) >= nginxImportMaxFiles {
+			return files, fmt.Errorf("include 文件超过 %d 个，其余文件未导入", nginxImportMaxFiles)
+		}
+		content, err := imp.readFile(file)
+		if err != nil {
+			return files, err
+		}
+		block, err := parseNginxConfig(content, file)
+		if err != nil {
+			return files, err
+		}
+		imp.result.Files = append(imp.result.Files, file)
+		files = append(files, &nginxDirective{Name: file, Block: block, File: file})
+	}
+	return files, nil
+}
+
+// resolveNginxPath 将相对路径解析为相对主配置目录的绝对路径
+func resolveNginxPath(baseDir, p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(baseDir, p)
+}
+
+// importVhost 将 include 文件导入为虚拟主机配置
+func (imp *nginxImporter) importVhost(file *nginxDirective) {
+	stem := strings.TrimSuffix(path.Base(file.File), ".conf")
+	cfg := newImportedConfig(imp.name+"-"+stem, nginxModeVhost)
+	cfg.IncludeDir = path.Dir(file.File)
+	cfg.FileName = stem + ".conf"
+	cfg.EnableHTTP = false
+	if cfg.FileName != path.Base(file.File) {
+		// 主配置统一 include *.conf，非 .conf 文件（如 sites-enabled/default）部署时改名
+		imp.warn(nil, "%s 将部署为 %s，请在部署后删除原文件", file.File, cfg.FileName)
+	}
+
+	var raw, servers []*nginxDirective
+	for _, d := range file.Block {
+		switch {
+		case d.Name == "upstream":
+			if u, ok := imp.importUpstream(d); ok {
+				cfg.Upstreams = append(cfg.Upstreams, *u)
+			} else {
+				raw = append(raw, d)
+			}
+		case d.Name == "server" && d.Block != nil:
+			servers = append(servers, d)
+		default:
+			raw = append(raw, d)
+		}
+	}
+
+	rest := imp.importServers(cfg, servers)
+	cfg.CustomConfig = strings.TrimRight(renderNginxDirectives(append(raw, rest...), ""), "\n")
+	imp.result.Vhosts = append(imp.result.Vhosts, cfg)
+}
+
+// collectKeepalive 收集启用 keepalive 的 upstream 名称
+func (imp *nginxImporter) collectKeepalive(block []*nginxDirective) {
+	for _, d := range findDirectives(block, "upstream") {
+		if len(findDirectives(d.Block, "keepalive")) > 0 {
+			imp.keepalive[d.arg(0)] = true
+		}
+	}
+}
+
+// importUpstream 解析 upstream 块，包含不支持的指令或参数时返回 false 以原样保留
+func (imp *nginxImporter) importUpstream(d *nginxDirective) (*models.NginxUpstream, bool) {
+	u := &models.NginxUpstream{Name: d.arg(0), LoadBalance: "round_robin"}
+	var servers []models.UpstreamServer
+
+	for _, item := range d.Block {
+		switch item.Name {
+		case "least_conn", "ip_hash":
+			u.LoadBalance = item.Name
+		case "keepalive":
+			u.Keepalive, _ = strconv.Atoi(item.arg(0))
+		case "server":
+			s := models.UpstreamServer{Address: item.arg(0)}
+			for _, param := range item.Args[1:] {
+				key, value, _ := strings.Cut(param, "=")
+				var err error
+				switch key {
+				case "weight":
+					s.Weight, err = strconv.Atoi(value)
+				case "max_fails":
+					s.MaxFails, err = strconv.Atoi(value)
+				case "fail_timeout":
+					s.FailTimeout = value
+				case "backup":
+					s.Backup = true
+				case "down":
+					s.Down = true
+				default:
+					err = fmt.Errorf("不支持的参数")
+				}
+				if err != nil {
+					imp.warn(item, "upstream %s 的 server 参数 %s 暂不支持，整个 upstream 按原样保留", u.Name, param)
+					return nil, false
+				}
+			}
+			servers = append(servers, s)
+		default:
+			imp.warn(item, "upstream %s 中的 %s 指令暂不支持，整个 upstream 按原样保留", u.Name, item.Name)
+			return nil, false
+		}
+	}
+
+	if err := validateUpstreamServers(u.LoadBalance, servers); err != nil {
+		imp.warn(d, "upstream %s: %v，按原样保留", u.Name, err)
+		return nil, false
+	}
+	data, _ := json.Marshal(servers)
+	u.Servers = string(data)
+	return u, true
+}
+
+// serverListen 解析 server 块的 listen 指令，返回端口与是否启用 ssl
+func serverListen(srv *nginxDirective) (port int, ssl bool, ok bool) {
+	listens := findDirectives(srv.Block, "listen")
+	if len(listens) == 0 {
+		return 80, false, true
+	}
+	addr := listens[0].arg(0)
+	if i := strings.LastIndex(addr, ":"); i >= 0 && !strings.HasSuffix(addr, "]") {
+		addr = addr[i+1:]
+	}
+	port, err := strconv.Atoi(addr)
+	if err != nil {
+		return 0, false, false
+	}
+	for _, a := range listens[0].Args[1:] {
+		if a == "ssl" {
+			ssl = true
+		}
+	}
+	return port, ssl, true
+}
+
+// serverNames 获取 server 块的 server_name
+func serverNames(srv *nginxDirective) string {
+	if names := findDirectives(srv.Block, "server_name"); len(names) > 0 {
+		return strings.Join(names[0].Args, " ")
+	}
+	return "_"
+}
+
+// isHTTPSRedirectServer 判断 server 块是否只做 HTTP 跳转 HTTPS
+func isHTTPSRedirectServer(srv *nginxDirective) bool {
+	for _, d := range srv.Block {
+		switch d.Name {
+		case "listen", "server_name":
+		case "return":
+			if !strings.HasPrefix(d.arg(1), "https://") {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return len(findDirectives(srv.Block, "return")) == 1
+}
+
+// importServers 将第一组 server（同名的 HTTP 与 HTTPS server）导入到配置，返回无法映射的 server 块
+func (imp *nginxImporter) importServers(cfg *models.NginxConfig, servers []*nginxDirective) []*nginxDirective {
+	var plain, secure *nginxDirective
+	var rest []*nginxDirective
+	name := ""
+	for _, srv := range servers {
+		port, ssl, ok := serverListen(srv)
+		if !ok {
+			imp.warn(srv, "无法识别的 listen 参数，server 块按原样保留")
+			rest = append(rest, srv)
+			continue
+		}
+		if plain == nil && secure == nil {
+			name = serverNames(srv)
+		}
+		switch {
+		case serverNames(srv) != name:
+			rest = append(rest, srv)
+		case ssl && secure == nil:
+			secure = srv
+			cfg.EnableHTTPS = true
+			cfg.HTTPSPort = port
+		case !ssl && plain == nil:
+			plain = srv
+			cfg.EnableHTTP = true
+			cfg.HTTPPort = port
+		default:
+			rest = append(rest, srv)
+		}
+	}
+	if len(rest) > 0 {
+		imp.warn(nil, "%s: 每个配置只映射一组 HTTP/HTTPS server，其余 %d 个 server 块按原样保留", cfg.Name, len(rest))
+	}
+	if plain == nil && secure == nil {
+		return rest
+	}
+	cfg.ServerName = name
+
+	// 内容以 HTTPS server 为准；HTTP server 仅做跳转时开启 HTTP 跳转 HTTPS
+	content := plain
+	if secure != nil {
+		content = secure
+		if plain != nil {
+			if isHTTPSRedirectServer(plain) {
+				cfg.HTTPToHTTPS = true
+			} else if len(plain.Block) != len(secure.Block) {
+				imp.warn(plain, "HTTP 与 HTTPS server 内容不同，以 HTTPS server 为准")
+			}
+		}
+	}
+	imp.importServerBody(cfg, content)
+	return rest
+}
+
+// importServerBody 导入 server 块内的指令
+func (imp *nginxImporter) importServerBody(cfg *models.NginxConfig, srv *nginxDirective) {
+	var raw []*nginxDirective
+	sslWarned := false
+	listens := 0
+	for _, d := range srv.Block {
+		switch {
+		case d.Name == "listen":
+			if listens++; listens == 2 {
+				imp.warn(d, "server 块有多个 listen，仅保留第一个端口")
+			}
+		case d.Name == "server_name":
+		case d.Name == "root":
+			cfg.RootPath = d.arg(0)
+		case d.Name == "index":
+			cfg.IndexFiles = strings.Join(d.Args, " ")
+		case d.Name == "ssl_certificate":
+			var cert models.Certificate
+			if db.DB.Where("cert_file_path = ?", d.arg(0)).Limit(1).Find(&cert).RowsAffected > 0 {
+				cfg.CertificateID = &cert.ID
+			} else {
+				imp.warn(d, "证书 %s 未在平台中管理，请导入后选择证书", d.arg(0))
+			}
+		case strings.HasPrefix(d.Name, "ssl_"):
+			if !sslWarned {
+				imp.warn(d, "SSL 参数使用平台模板默认值")
+				sslWarned = true
+			}
+		case d.Name == "access_log" && isNginxVhost(cfg):
+			cfg.AccessLogPath = d.arg(0)
+		case d.Name == "client_max_body_size" && isNginxVhost(cfg):
+			cfg.ClientMaxBodySize = d.arg(0)
+		case d.Name == "location" && d.Block != nil:
+			if loc, ok := imp.importLocation(d); ok {
+				loc.SortOrder = len(cfg.Locations)
+				cfg.Locations = append(cfg.Locations, *loc)
+			} else {
+				raw = append(raw, d)
+			}
+		default:
+			raw = append(raw, d)
+		}
+	}
+	cfg.ServerCustomConfig = strings.TrimRight(renderNginxDirectives(raw, ""), "\n")
+}
+
+// importLocation 导入 location 块，命名 location 返回 false 以原样保留
+func (imp *nginxImporter) importLocation(d *nginxDirective) (*models.NginxLocation, bool) {
+	loc := &models.NginxLocation{MatchType: "prefix", HandlerType: "static"}
+	switch len(d.Args) {
+	case 1:
+		loc.Path = d.arg(0)
+		for _, m := range []string{"^~", "~*", "=", "~"} {
+			if strings.HasPrefix(loc.Path, m) && len(loc.Path) > len(m) {
+				loc.MatchType = matchTypeByModifier(m)
+				loc.Path = loc.Path[len(m):]
+				break
+			}
+		}
+	case 2:
+		loc.MatchType = matchTypeByModifier(d.arg(0))
+		loc.Path = d.arg(1)
+	}
+	if loc.Path == "" || loc.MatchType == "" || strings.HasPrefix(loc.Path, "@") {
+		return nil, false
+	}
+
+	headers := map[string]string{}
+	var raw []*nginxDirective
+	var httpVersion, connection *nginxDirective
+	for _, item := range d.Block {
+		switch item.Name {
+		case "proxy_pass":
+			loc.ProxyPass = item.arg(0)
+			loc.HandlerType = "proxy"
+		case "proxy_set_header":
+			name, value := item.arg(0), item.arg(1)
+			switch {
+			case strings.EqualFold(name, "Connection") && value == "":
+				connection = item
+			case isDefaultProxyHeader(name, value):
+			default:
+				headers[name] = value
+			}
+		case "proxy_http_version":
+			httpVersion = item
+		case "root":
+			loc.Root = item.arg(0)
+		case "try_files":
+			loc.TryFiles = strings.Join(item.Args, " ")
+		case "return":
+			code, err := strconv.Atoi(item.arg(0))
+			if err != nil {
+				raw = append(raw, item)
+				continue
+			}
+			if redirectCodes[code] && item.arg(1) != "" {
+				loc.HandlerType = "redirect"
+				loc.RedirectCode = code
+				loc.RedirectURL = item.arg(1)
+			} else {
+				loc.HandlerType = "return"
+				loc.ReturnCode = code
+				loc.ReturnBody = item.arg(1)
+			}
+		default:
+			raw = append(raw, item)
+		}
+	}
+
+	// 引用 keepalive upstream 时模板会自动生成 HTTP/1.1 与 Connection 头
+	keepalive := imp.keepalive[proxyPassHost(loc.ProxyPass)]
+	if httpVersion != nil && !(keepalive && httpVersion.arg(0) == "1.1") {
+		raw = append(raw, httpVersion)
+	}
+	if connection != nil && !keepalive {
+		headers[connection.arg(0)] = connection.arg(1)
+	}
+	if len(headers) > 0 {
+		data, _ := json.Marshal(headers)
+		loc.ProxySetHeaders = string(data)
+	}
+	if loc.HandlerType == "static" && loc.TryFiles == "" && loc.Root == "" && len(raw) > 0 {
+		// 仅包含自定义指令的 location（如 alias、rewrite），不自动补充 try_files
+		loc.HandlerType = "custom"
+	}
+
+	loc.CustomConfig = strings.TrimRight(renderNginxDirectives(raw, ""), "\n")
+	if err := validateLocations([]models.NginxLocation{*loc}); err != nil {
+		imp.warn(d, "%v，location 按原样保留", err)
+		return nil, false
+	}
+	return loc, true
+}
+
+// matchTypeByModifier 根据 location 修饰符获取匹配类型
+func matchTypeByModifier(modifier string) string {
+	for matchType, m := range locationMatchModifiers {
+		if m == modifier && m != "" {
+			return matchType
+		}
+	}
+	return ""
+}
+
+// isDefaultProxyHeader 判断是否为模板默认生成的代理请求头
+func isDefaultProxyHeader(name, value string) bool {
+	for _, h := range defaultProxyHeaders {
+		if strings.EqualFold(h.Name, name) && h.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// saveImportedConfigs 在事务中保存导入的配置（locations、upstreams 随配置一并创建）
+func saveImportedConfigs(configs []*models.NginxConfig, author string) error {
+	// 导入的均为新配置，提交前对其他请求不可见，无需加修订版本锁
+	tx := db.DB.Begin()
+	for _, cfg := range configs {
+		if err := tx.Create(cfg).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := saveNginxRevision(tx, cfg.ID, nginxRevisionImport, author, cfg.Description); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// Import 从服务器读取现有 nginx.conf（含 include 的 .conf 文件）或直接解析提供的内容，
+// 转换为平台配置；include 的文件导入为虚拟主机，无法识别的指令原样保留在自定义配置中
+func (n *NginxAPI) Import(c *gin.Context) {
+	var req NginxImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误: "+err.Error())
+		return
+	}
+	if req.ServerID == nil && strings.TrimSpace(req.Content) == "" {
+		response.BadRequest(c, "请指定服务器或提供配置内容")
+		return
+	}
+
+	imp := &nginxImporter{name: req.Name, keepalive: map[string]bool{}}
+	mainPath := defaultString(req.Path, "/etc/nginx/nginx.conf")
+	content := req.Content
+	source := "提交的内容"
+
+	if req.ServerID != nil {
+		var server models.Server
+		if err := db.DB.First(&server, *req.ServerID).Error; err != nil {
+			response.NotFound(c, "服务器不存在")
+			return
+		}
+		lease, sftpClient, err := connectToServer(&server)
+		if err != nil {
+			response.InternalServerError(c, err.Error())
+			return
+		}
+		defer lease.Release()
+		defer sftpClient.Close()
+
+		imp.readFile = func(p string) (string, error) {
+			f, err := sftpClient.Open(p)
+			if err != nil {
+				return "", fmt.Errorf("读取 %s 失败: %v", p, err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(io.LimitReader(f, nginxImportMaxFileSize+1))
+			if err != nil {
+				return "", fmt.Errorf("读取 %s 失败: %v", p, err)
+			}
+			if len(data) > nginxImportMaxFileSize {
+				return "", fmt.Errorf("%s 超过 1MB", p)
+			}
+			return string(data), nil
+		}
+		imp.glob = sftpClient.Glob
+
+		if content == "" {
+			if content, err = imp.readFile(mainPath); err != nil {
+				response.BadRequest(c, err.Error())
+				return
+			}
+		}
+		source = server.Name + ":" + mainPath
+	}
+
+	if err := imp.importMain(mainPath, content); err != nil {
+		response.BadRequest(c, "解析配置失败: "+err.Error())
+		return
+	}
+
+	configs := append([]*models.NginxConfig{imp.result.Config}, imp.result.Vhosts...)
+	names := make([]string, 0, len(configs))
+	for _, cfg := range configs {
+		cfg.ServerID = req.ServerID
+		cfg.Description = "从 " + source + " 导入"
+		names = append(names, cfg.Name)
+	}
+	if imp.result.Warnings == nil {
+		imp.result.Warnings = []string{}
+	}
+
+	if req.DryRun {
+		response.Success(c, imp.result)
+		return
+	}
+
+	var existing []string
+	db.DB.Model(&models.NginxConfig{}).Where("name IN ?", names).Pluck("name", &existing)
+	if len(existing) > 0 {
+		response.Conflict(c, "配置名称已存在: "+strings.Join(existing, ", "))
+		return
+	}
+
+	if err := saveImportedConfigs(configs, currentUsername(c)); err != nil {
+		logger.Errorf("保存导入的 Nginx 配置失败: %v", err)
+		response.InternalServerError(c, "保存失败")
+		return
+	}
+
+	logger.Infof("Nginx 配置导入成功: %s，虚拟主机 %d 个", source, len(imp.result.Vhosts))
+	response.SuccessWithMessage(c, "导入成功", imp.result)
+}
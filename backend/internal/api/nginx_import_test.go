@@ -0,0 +1,214 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestParseNginxConfig(t *testing.T) {
+	directives, err := parseNginxConfig(`
+# 注释
+http {
+    log_format main '$remote_addr "$request"';
+    location ~ "^/a{2}$" { return 200 "ok;}"; }
+    set $x ${host}_suffix;
+}`, "nginx.conf")
+	assert.NoError(t, err)
+	http := directives[0]
+	assert.Equal(t, "http", http.Name)
+	assert.Equal(t, []string{"main", `$remote_addr "$request"`}, http.Block[0].Args)
+	assert.Equal(t, []string{"~", "^/a{2}$"}, http.Block[1].Args)
+	assert.Equal(t, []string{"200", "ok;}"}, http.Block[1].Block[0].Args)
+	assert.Equal(t, []string{"$x", "${host}_suffix"}, http.Block[2].Args)
+
+	// lua 块内容原样保留，其中的括号与分号不参与解析
+	directives, err = parseNginxConfig("location / {\n    content_by_lua_block {\n        local t = {a = \"}\"} -- }\n        ngx.say(t.a)\n    }\n    return 200;\n}\n", "lua.conf")
+	if assert.NoError(t, err) {
+		loc := directives[0]
+		assert.Equal(t, "content_by_lua_block", loc.Block[0].Name)
+		assert.Contains(t, loc.Block[0].Raw, `ngx.say(t.a)`)
+		assert.Equal(t, "return", loc.Block[1].Name)
+		assert.Equal(t, 6, loc.Block[1].Line)
+		assert.Contains(t, renderNginxDirectives(directives, ""), "    content_by_lua_block {\n        local t")
+	}
+
+	_, err = parseNginxConfig("http {\n    server {\n", "nginx.conf")
+	assert.ErrorContains(t, err, "nginx.conf:2")
+	_, err = parseNginxConfig("worker_processes 2\n}", "nginx.conf")
+	assert.Error(t, err)
+}
+
+func TestNginxImporter(t *testing.T) {
+	setupAPITestDB(t, &models.Certificate{})
+
+	files := map[string]string{
+		"/etc/nginx/nginx.conf": `
+user nginx;
+worker_processes 4;
+worker_rlimit_nofile 65535;
+events { worker_connections 4096; }
+http {
+    include mime.types;
+    sendfile on;
+    log_format main '$remote_addr [$time_local] "$request" $status';
+    client_max_body_size 20m;
+    upstream api { least_conn; server 10.0.0.1:8080 weight=2; server 10.0.0.2:8080 backup; keepalive 16; }
+    map $http_upgrade $conn { default upgrade; '' close; }
+    server {
+        listen 80;
+        server_name example.com;
+        return 301 https://$host$request_uri;
+    }
+    server {
+        listen 443 ssl;
+        server_name example.com;
+        ssl_certificate /etc/nginx/ssl/example.crt;
+        ssl_protocols TLSv1.2;
+        add_header X-Frame-Options DENY;
+        location /api/ {
+            proxy_pass http://api;
+            proxy_http_version 1.1;
+            proxy_set_header Connection "";
+            proxy_set_header Host $host;
+            proxy_set_header X-Tenant demo;
+            proxy_read_timeout 60s;
+        }
+        location = /old { return 302 /new; }
+        location /files/ { alias /data/files/; }
+        location @fallback { proxy_pass http://api; }
+    }
+    include conf.d/*.conf;
+    include sites-enabled/*;
+}`,
+		"/etc/nginx/sites-enabled/default": `
+server {
+    listen 8081;
+    server_name default.local;
+    location / { return 200; }
+}`,
+		"/etc/nginx/conf.d/shop.conf": `
+server {
+    listen 8080;
+    server_name shop.local;
+    access_log /var/log/nginx/shop.log;
+    location / { root /srv/shop; try_files $uri /index.html; }
+}`,
+	}
+
+	imp := &nginxImporter{
+		name:      "web01",
+		keepalive: map[string]bool{},
+		readFile: func(p string) (string, error) {
+			if content, ok := files[p]; ok {
+				return content, nil
+			}
+			return "", fmt.Errorf("not found: %s", p)
+		},
+		glob: func(pattern string) ([]string, error) {
+			var matches []string
+			for p := range files {
+				if ok, _ := path.Match(pattern, p); ok {
+					matches = append(matches, p)
+				}
+			}
+			return matches, nil
+		},
+	}
+	assert.NoError(t, imp.importMain("/etc/nginx/nginx.conf", files["/etc/nginx/nginx.conf"]))
+
+	cfg := imp.result.Config
+	assert.Equal(t, "nginx", cfg.WorkerUser)
+	assert.Equal(t, "4", cfg.WorkerProcesses)
+	assert.Equal(t, 4096, cfg.WorkerConnections)
+	assert.Equal(t, "20m", cfg.ClientMaxBodySize)
+	assert.Equal(t, "worker_rlimit_nofile 65535;", cfg.MainCustomConfig)
+	assert.Contains(t, cfg.CustomConfig, "    map $http_upgrade $conn {\n        default upgrade;\n        \"\" close;\n    }")
+	assert.Equal(t, "/etc/nginx/conf.d", cfg.IncludeDir)
+	assert.True(t, cfg.EnableHTTPS)
+	assert.True(t, cfg.HTTPToHTTPS)
+	assert.Equal(t, 443, cfg.HTTPSPort)
+	assert.Equal(t, "example.com", cfg.ServerName)
+	assert.Contains(t, cfg.ServerCustomConfig, "add_header X-Frame-Options DENY;")
+	assert.Contains(t, cfg.ServerCustomConfig, "location @fallback {")
+
+	if assert.Len(t, cfg.Upstreams, 1) {
+		assert.Equal(t, "least_conn", cfg.Upstreams[0].LoadBalance)
+		assert.Equal(t, 16, cfg.Upstreams[0].Keepalive)
+	}
+	if assert.Len(t, cfg.Locations, 3) {
+		api := cfg.Locations[0]
+		assert.Equal(t, "proxy", api.HandlerType)
+		assert.Equal(t, `{"X-Tenant":"demo"}`, api.ProxySetHeaders)
+		assert.Equal(t, "proxy_read_timeout 60s;", api.CustomConfig)
+		assert.Equal(t, "exact", cfg.Locations[1].MatchType)
+		assert.Equal(t, "redirect", cfg.Locations[1].HandlerType)
+		assert.Equal(t, "custom", cfg.Locations[2].HandlerType)
+	}
+
+	if assert.Len(t, imp.result.Vhosts, 2) {
+		vhost := imp.result.Vhosts[0]
+		assert.Equal(t, "web01-shop", vhost.Name)
+		assert.Equal(t, "/etc/nginx/conf.d/shop.conf", nginxConfigTargetPath(vhost))
+		assert.Equal(t, 8080, vhost.HTTPPort)
+		assert.Equal(t, "/var/log/nginx/shop.log", vhost.AccessLogPath)
+
+		// include 通配符匹配的非 .conf 文件同样按虚拟主机导入
+		site := imp.result.Vhosts[1]
+		assert.Equal(t, "web01-default", site.Name)
+		assert.Equal(t, "/etc/nginx/sites-enabled/default.conf", nginxConfigTargetPath(site))
+		assert.Equal(t, 8081, site.HTTPPort)
+	}
+	assert.Contains(t, strings.Join(imp.result.Warnings, "\n"), "log_format main 与平台模板的定义不同")
+	same, err := parseNginxConfig(`log_format main '$remote_addr - $remote_user [$time_local] "$request" '
+        '$status $body_bytes_sent "$http_referer" ' '"$http_user_agent" "$http_x_forwarded_for"';`, "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, templateLogFormat("main"))
+	assert.Equal(t, templateLogFormat("main"), logFormatDefinition(same[0]))
+
+	// 导入结果可重新生成配置
+	content, err := generateNginxConfig(cfg)
+	assert.NoError(t, err)
+	assert.Contains(t, content, "include /etc/nginx/conf.d/*.conf;")
+	assert.Contains(t, content, "        location /files/ {\n            alias /data/files/;\n        }")
+}
+
+func TestNginxWorkerUser(t *testing.T) {
+	t.Run("渲染运行用户，未指定时使用 nobody", func(t *testing.T) {
+		content, err := generateNginxConfig(&models.NginxConfig{EnableHTTP: true})
+		assert.NoError(t, err)
+		assert.Contains(t, content, "\nuser nobody;\n")
+
+		content, err = generateNginxConfig(&models.NginxConfig{EnableHTTP: true, WorkerUser: "nginx nginx"})
+		assert.NoError(t, err)
+		assert.Contains(t, content, "\nuser nginx nginx;\n")
+	})
+
+	t.Run("校验", func(t *testing.T) {
+		assert.NoError(t, validateNginxWorkerUser(""))
+		assert.NoError(t, validateNginxWorkerUser("www-data"))
+		assert.NoError(t, validateNginxWorkerUser("nginx nginx"))
+		assert.Error(t, validateNginxWorkerUser("nginx; daemon off"))
+		assert.Error(t, validateNginxWorkerUser("a b c"))
+		assert.Error(t, validateNginxWorkerUser("$(id)"))
+	})
+
+	t.Run("htpasswd 归属运行用户的组", func(t *testing.T) {
+		assert.Equal(t, "$(id -gn nobody)", nginxHtpasswdGroup(&models.NginxConfig{}))
+		assert.Equal(t, "$(id -gn nginx)", nginxHtpasswdGroup(&models.NginxConfig{WorkerUser: "nginx"}))
+		assert.Equal(t, "'www'", nginxHtpasswdGroup(&models.NginxConfig{WorkerUser: "nginx www"}))
+	})
+
+	t.Run("导入时保留 user 指令", func(t *testing.T) {
+		imp := &nginxImporter{name: "imported"}
+		assert.NoError(t, imp.importMain("/etc/nginx/nginx.conf", "user www-data www-data;\npid /run/nginx.pid;\nevents {}\n"))
+		assert.Equal(t, "www-data www-data", imp.result.Config.WorkerUser)
+		warnings := strings.Join(imp.result.Warnings, "\n")
+		assert.NotContains(t, warnings, "user")
+		assert.Contains(t, warnings, "pid 指令由平台模板生成")
+	})
+}
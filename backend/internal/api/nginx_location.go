@@ -0,0 +1,361 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// locationMatchModifiers location 匹配类型对应的修饰符
+var locationMatchModifiers = map[string]string{
+	"prefix":          "",
+	"exact":           "=",
+	"prefix_priority": "^~",
+	"regex":           "~",
+	"regex_i":         "~*",
+}
+
+// redirectCodes 允许的重定向状态码
+var redirectCodes = map[int]bool{301: true, 302: true, 303: true, 307: true, 308: true}
+
+// headerNamePattern HTTP 头名称规则
+var headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+
+// defaultProxyHeaders 代理时默认设置的请求头
+var defaultProxyHeaders = []proxyHeader{
+	{Name: "Host", Value: "$host"},
+	{Name: "X-Real-IP", Value: "$remote_addr"},
+	{Name: "X-Forwarded-For", Value: "$proxy_add_x_forwarded_for"},
+	{Name: "X-Forwarded-Proto", Value: "$scheme"},
+}
+
+// proxyHeader proxy_set_header 设置项
+type proxyHeader struct {
+	Name  string
+	Value string
+}
+
+// locationMatchType 获取 location 的匹配类型，为空时按前缀匹配
+func locationMatchType(loc *models.NginxLocation) string {
+	return defaultString(loc.MatchType, "prefix")
+}
+
+// locationHandlerType 获取 location 的处理方式。
+// 历史数据未设置处理方式但填写了 proxy_pass 时按代理处理。
+func locationHandlerType(loc *models.NginxLocation) string {
+	handler := defaultString(loc.HandlerType, "static")
+	if handler == "static" && loc.ProxyPass != "" {
+		return "proxy"
+	}
+	return handler
+}
+
+// parseProxySetHeaders 解析自定义请求头（JSON 对象，如 {"X-App": "demo"}）
+func parseProxySetHeaders(raw string) ([]proxyHeader, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var m map[string]string
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return nil, fmt.Errorf("proxy_set_headers 格式错误，应为 JSON 对象: %v", err)
+	}
+
+	headers := make([]proxyHeader, 0, len(m))
+	for name, value := range m {
+		if !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("请求头名称 %q 不合法", name)
+		}
+		headers = append(headers, proxyHeader{Name: name, Value: value})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers, nil
+}
+
+// mergeProxyHeaders 合并默认请求头与自定义请求头，同名时以自定义为准
+func mergeProxyHeaders(custom []proxyHeader) []proxyHeader {
+	merged := make([]proxyHeader, 0, len(defaultProxyHeaders)+len(custom))
+	for _, h := range defaultProxyHeaders {
+		overridden := false
+		for _, c := range custom {
+			if strings.EqualFold(c.Name, h.Name) {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, h)
+		}
+	}
+	return append(merged, custom...)
+}
+
+// validateLocationRegex 校验正则路径。nginx 使用 PCRE，Go 不支持的 Perl 扩展语法（如环视）不视为错误。
+func validateLocationRegex(path string) error {
+	_, err := regexp.Compile(path)
+	if err == nil {
+		return nil
+	}
+	var syntaxErr *syntax.Error
+	if errors.As(err, &syntaxErr) && syntaxErr.Code == syntax.ErrInvalidPerlOp {
+		return nil
+	}
+	return fmt.Errorf("正则路径 %q 不合法: %v", path, err)
+}
+
+// validateLocations 校验 location 的匹配方式、处理方式及相关参数
+func validateLocations(locations []models.NginxLocation) error {
+	seen := map[string]bool{}
+	for i := range locations {
+		loc := &locations[i]
+		matchType := locationMatchType(loc)
+		modifier, ok := locationMatchModifiers[matchType]
+		if !ok {
+			return fmt.Errorf("location %s 的匹配类型 %q 不支持", loc.Path, loc.MatchType)
+		}
+		if strings.TrimSpace(loc.Path) == "" {
+			return fmt.Errorf("location 路径不能为空")
+		}
+
+		if strings.HasPrefix(matchType, "regex") {
+			if err := validateLocationRegex(loc.Path); err != nil {
+				return err
+			}
+		} else {
+			if !strings.HasPrefix(loc.Path, "/") {
+				return fmt.Errorf("location 路径 %q 必须以 / 开头", loc.Path)
+			}
+			if strings.ContainsAny(loc.Path, " \t;{}\"'") {
+				return fmt.Errorf("location 路径 %q 包含非法字符", loc.Path)
+			}
+		}
+
+		// 以下取值原样写入指令，分号、花括号或换行会截断指令并注入额外配置
+		for _, field := range []struct{ name, value string }{
+			{"proxy_pass", loc.ProxyPass},
+			{"redirect_url", loc.RedirectURL},
+			{"root", loc.Root},
+			{"try_files", loc.TryFiles},
+		} {
+			if strings.ContainsAny(field.value, ";{}\r\n") {
+				return fmt.Errorf("location %s 的 %s %q 包含非法字符", loc.Path, field.name, field.value)
+			}
+		}
+
+		// 前缀匹配与 ^~ 前缀匹配在 nginx 中视为同一 location，只按路径判断重复
+		if modifier == "^~" {
+			modifier = ""
+		}
+		key := modifier + " " + loc.Path
+		if seen[key] {
+			return fmt.Errorf("location %s 重复定义", loc.Path)
+		}
+		seen[key] = true
+
+		switch handler := locationHandlerType(loc); handler {
+		case "static", "custom":
+		case "proxy":
+			if strings.TrimSpace(loc.ProxyPass) == "" {
+				return fmt.Errorf("location %s 为代理方式，必须填写 proxy_pass", loc.Path)
+			}
+			if _, err := parseProxySetHeaders(loc.ProxySetHeaders); err != nil {
+				return fmt.Errorf("location %s: %v", loc.Path, err)
+			}
+		case "redirect":
+			if strings.TrimSpace(loc.RedirectURL) == "" {
+				return fmt.Errorf("location %s 为重定向方式，必须填写 redirect_url", loc.Path)
+			}
+			if loc.RedirectCode != 0 && !redirectCodes[loc.RedirectCode] {
+				return fmt.Errorf("location %s 的重定向状态码 %d 不合法", loc.Path, loc.RedirectCode)
+			}
+		case "return":
+			if loc.ReturnCode < 100 || loc.ReturnCode > 599 {
+				return fmt.Errorf("location %s 的返回状态码 %d 不合法", loc.Path, loc.ReturnCode)
+			}
+		default:
+			return fmt.Errorf("location %s 的处理方式 %q 不支持", loc.Path, handler)
+		}
+	}
+	return nil
+}
+
+// sortLocations 按 SortOrder 稳定排序，相同顺序保持原有先后
+func sortLocations(locations []models.NginxLocation) {
+	sort.SliceStable(locations, func(i, j int) bool {
+		return locations[i].SortOrder < locations[j].SortOrder
+	})
+}
+
+// orderLocations 预加载 location 时按顺序排列
+func orderLocations(tx *gorm.DB) *gorm.DB {
+	return tx.Order("sort_order ASC, id ASC")
+}
+
+// quoteNginxString 将字符串转为 nginx 双引号字符串
+func quoteNginxString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// nginxValue 渲染指令参数，空值或包含空白、分号等字符时加引号
+func nginxValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t;{}\"'") {
+		return quoteNginxString(s)
+	}
+	return s
+}
+
+// indentLines 为多行配置片段的每一行添加缩进，末尾保留换行
+func indentLines(prefix, s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// stringValue 获取可选字符串的值
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// locationMatch 渲染 location 的匹配部分，正则中含空白或大括号时加引号
+func locationMatch(loc *models.NginxLocation) string {
+	path := loc.Path
+	if strings.ContainsAny(path, " \t{};") {
+		path = quoteNginxString(path)
+	}
+	if modifier := locationMatchModifiers[locationMatchType(loc)]; modifier != "" {
+		return modifier + " " + path
+	}
+	return path
+}
+
+// locationRenderContext 渲染 location 所需的配置级信息
+type locationRenderContext struct {
+	keepalive  map[string]bool                   // 启用了 keepalive 的 upstream
+	htpasswd   string                            // basic 认证用户文件路径
+	cacheZones map[string]*models.NginxCacheZone // 缓存 zone
+}
+
+// renderLocationBlock 渲染 location 块（位于 server 块内）
+func renderLocationBlock(loc *models.NginxLocation, rc *locationRenderContext) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "        location %s {\n", locationMatch(loc))
+	renderLocationAccess(&b, loc, rc.htpasswd)
+
+	switch locationHandlerType(loc) {
+	case "proxy":
+		custom, err := parseProxySetHeaders(loc.ProxySetHeaders)
+		if err != nil {
+			return "", fmt.Errorf("location %s: %v", loc.Path, err)
+		}
+		fmt.Fprintf(&b, "            proxy_pass %s;\n", loc.ProxyPass)
+		keepalive := rc.keepalive[proxyPassHost(loc.ProxyPass)]
+		if keepalive || loc.WebSocket {
+			b.WriteString("            proxy_http_version 1.1;\n")
+		}
+		if loc.WebSocket {
+			// WebSocket 升级请求需要透传 Upgrade 与 Connection 头
+			custom = append(custom, proxyHeader{Name: "Upgrade", Value: "$http_upgrade"}, proxyHeader{Name: "Connection", Value: "upgrade"})
+		} else if keepalive {
+			custom = append(custom, proxyHeader{Name: "Connection", Value: ""})
+		}
+		for _, h := range mergeProxyHeaders(custom) {
+			fmt.Fprintf(&b, "            proxy_set_header %s %s;\n", h.Name, nginxValue(h.Value))
+		}
+		renderLocationProxy(&b, loc, rc)
+	case "redirect":
+		fmt.Fprintf(&b, "            return %d %s;\n", defaultInt(loc.RedirectCode, 301), loc.RedirectURL)
+	case "return":
+		if loc.ReturnBody != "" {
+			fmt.Fprintf(&b, "            return %d %s;\n", loc.ReturnCode, quoteNginxString(loc.ReturnBody))
+		} else {
+			fmt.Fprintf(&b, "            return %d;\n", loc.ReturnCode)
+		}
+	case "custom":
+		// 只输出自定义配置片段
+	default:
+		if loc.Root != "" {
+			fmt.Fprintf(&b, "            root %s;\n", loc.Root)
+		}
+		fmt.Fprintf(&b, "            try_files %s;\n", defaultString(loc.TryFiles, "$uri $uri/ =404"))
+	}
+
+	if loc.CustomConfig != "" {
+		b.WriteString(indentLines("            ", loc.CustomConfig))
+	}
+
+	b.WriteString("        }\n")
+	return b.String(), nil
+}
+
+// nginxTemplateData 渲染 nginx.conf 模板使用的视图模型
+type nginxTemplateData struct {
+	*models.NginxConfig
+	LocationBlocks string                 // 已渲染的 location 块（HTTP 与 HTTPS server 共用）
+	HTTPUpstreams  []models.NginxUpstream // http 上下文的 upstream
+	StreamBlock    string                 // 已渲染的 stream 块，无四层代理时为空
+}
+
+// buildNginxTemplateData 构建模板视图模型：location 按顺序渲染，
+// 未配置 location 时按代理开关生成默认的 location /
+func buildNginxTemplateData(cfg *models.NginxConfig) (*nginxTemplateData, error) {
+	locations := append([]models.NginxLocation(nil), cfg.Locations...)
+	sortLocations(locations)
+	if len(locations) == 0 {
+		def := models.NginxLocation{Path: "/", HandlerType: "static"}
+		if cfg.EnableProxy && cfg.ProxyPass != "" {
+			def.HandlerType = "proxy"
+			def.ProxyPass = cfg.ProxyPass
+		}
+		locations = append(locations, def)
+	}
+
+	rc := &locationRenderContext{
+		keepalive:  keepaliveUpstreams(cfg),
+		htpasswd:   nginxHtpasswdPath(cfg),
+		cacheZones: map[string]*models.NginxCacheZone{},
+	}
+	for i := range cfg.CacheZones {
+		rc.cacheZones[cfg.CacheZones[i].Name] = &cfg.CacheZones[i]
+	}
+	var blocks []string
+	for i := range locations {
+		block, err := renderLocationBlock(&locations[i], rc)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	var httpUpstreams []models.NginxUpstream
+	for _, u := range cfg.Upstreams {
+		if !isStreamUpstream(&u) {
+			httpUpstreams = append(httpUpstreams, u)
+		}
+	}
+	stream, err := renderStreamBlock(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nginxTemplateData{
+		NginxConfig:    cfg,
+		LocationBlocks: strings.Join(blocks, "\n"),
+		HTTPUpstreams:  httpUpstreams,
+		StreamBlock:    stream,
+	}, nil
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestNginxLocations(t *testing.T) {
+	t.Run("渲染各类匹配与处理方式", func(t *testing.T) {
+		cfg := &models.NginxConfig{
+			Name:       "site",
+			EnableHTTP: true,
+			HTTPPort:   80,
+			Locations: []models.NginxLocation{
+				{Path: "/old", MatchType: "exact", HandlerType: "redirect", RedirectURL: "/new", RedirectCode: 302, SortOrder: 2},
+				{Path: "/api/", ProxyPass: "http://127.0.0.1:8080", ProxySetHeaders: `{"Host":"api.local","X-App":"demo app"}`, SortOrder: 1},
+				{Path: `\.(png|jpg)$`, MatchType: "regex_i", Root: "/data/img", SortOrder: 3},
+				{Path: "/health", MatchType: "exact", HandlerType: "return", ReturnCode: 200, ReturnBody: `ok "1"`, SortOrder: 0},
+			},
+		}
+		assert.NoError(t, validateLocations(cfg.Locations))
+
+		content, err := generateNginxConfig(cfg)
+		assert.NoError(t, err)
+		assert.Contains(t, content, "location = /health {\n            return 200 \"ok \\\"1\\\"\";")
+		assert.Contains(t, content, "location = /old {\n            return 302 /new;")
+		assert.Contains(t, content, "location ~* \\.(png|jpg)$ {\n            root /data/img;\n            try_files $uri $uri/ =404;")
+		assert.Contains(t, content, "proxy_set_header Host api.local;")
+		assert.Contains(t, content, `proxy_set_header X-App "demo app";`)
+		assert.NotContains(t, content, "proxy_set_header Host $host;")
+
+		// 按 SortOrder 输出
+		health := strings.Index(content, "location = /health")
+		api := strings.Index(content, "location /api/")
+		old := strings.Index(content, "location = /old")
+		assert.True(t, health < api && api < old)
+	})
+
+	t.Run("未启用代理也渲染 location", func(t *testing.T) {
+		cfg := &models.NginxConfig{
+			EnableHTTP: true,
+			Locations:  []models.NginxLocation{{Path: "/static/", Root: "/data"}},
+		}
+		content, err := generateNginxConfig(cfg)
+		assert.NoError(t, err)
+		assert.Contains(t, content, "location /static/ {")
+	})
+
+	t.Run("校验", func(t *testing.T) {
+		assert.ErrorContains(t, validateLocations([]models.NginxLocation{{Path: "^/(a", MatchType: "regex"}}), "正则")
+		assert.NoError(t, validateLocations([]models.NginxLocation{{Path: "^/(?!admin)", MatchType: "regex"}}))
+		assert.Error(t, validateLocations([]models.NginxLocation{{Path: "/", HandlerType: "return"}}))
+		assert.ErrorContains(t, validateLocations([]models.NginxLocation{{Path: "/a"}, {Path: "/a"}}), "重复")
+		assert.ErrorContains(t, validateLocations([]models.NginxLocation{{Path: "/a"}, {Path: "/a", MatchType: "prefix_priority"}}), "重复")
+		assert.NoError(t, validateLocations([]models.NginxLocation{{Path: "/a"}, {Path: "/a", MatchType: "exact"}, {Path: "/a", MatchType: "regex"}}))
+		assert.ErrorContains(t, validateLocations([]models.NginxLocation{{Path: "/", ProxyPass: "http://b; deny all", HandlerType: "proxy"}}), "proxy_pass")
+		assert.ErrorContains(t, validateLocations([]models.NginxLocation{{Path: "/", HandlerType: "redirect", RedirectURL: "https://a.com}"}}), "redirect_url")
+		assert.ErrorContains(t, validateLocations([]models.NginxLocation{{Path: "/", Root: "/data\nautoindex on"}}), "root")
+		assert.ErrorContains(t, validateLocations([]models.NginxLocation{{Path: "/", TryFiles: "$uri {"}}), "try_files")
+		assert.NoError(t, validateLocations([]models.NginxLocation{{Path: "/", TryFiles: "$uri $uri/ /index.html"}}))
+	})
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nginxDirective nginx 配置中的一条指令，Block 不为 nil 时表示块指令
+type nginxDirective struct {
+	Name  string            `json:"name"`
+	Args  []string          `json:"args"`
+	Block []*nginxDirective `json:"block,omitempty"`
+	File  string            `json:"file,omitempty"`
+	Line  int               `json:"line"`
+	Raw   string            `json:"raw,omitempty"` // *_by_lua_block 等块的原始内容（非 nginx 语法，不解析）
+}
+
+// nginxParseError 带文件与行号的解析错误
+type nginxParseError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *nginxParseError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("第 %d 行: %s", e.Line, e.Msg)
+}
+
+// nginxToken 词法单元
+type nginxToken struct {
+	Value  string
+	Line   int
+	Quoted bool // 是否为引号字符串（引号字符串中的 ;{} 不作为分隔符）
+	Raw    bool // 是否为原始块内容
+}
+
+// isRawBlockDirective 块内容不是 nginx 语法、需原样保留的指令（lua-nginx-module 的 *_by_lua_block）
+func isRawBlockDirective(name string) bool {
+	return strings.HasSuffix(name, "_by_lua_block")
+}
+
+// scanRawBlock 从 "{" 之后读取原始块内容直到匹配的 "}"，跳过字符串与 -- 注释中的括号。
+// 返回内容、"}" 之后的位置及内容中的换行数
+func scanRawBlock(runes []rune, i int) (string, int, int, bool) {
+	start, depth, lines := i, 1, 0
+	for i < len(runes) {
+		switch c := runes[i]; {
+		case c == '\n':
+			lines++
+		case c == '"' || c == '\'':
+			for i++; i < len(runes) && runes[i] != c; i++ {
+				if runes[i] == '\\' {
+					i++
+				} else if runes[i] == '\n' {
+					lines++
+				}
+			}
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return string(runes[start:i]), i + 1, lines, true
+			}
+		}
+		i++
+	}
+	return "", i, lines, false
+}
+
+// tokenizeNginx 将配置内容切分为词法单元，跳过注释
+func tokenizeNginx(content, file string) ([]nginxToken, error) {
+	var tokens []nginxToken
+	line := 1
+	runes := []rune(content)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, nginxToken{Value: string(c), Line: line})
+			i++
+		case c == '"' || c == '\'':
+			start := line
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, &nginxParseError{File: file, Line: start, Msg: "引号未闭合"}
+				}
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == c || runes[i+1] == '\\') {
+					b.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == c {
+					i++
+					break
+				}
+				if runes[i] == '\n' {
+					line++
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, nginxToken{Value: b.String(), Line: start, Quoted: true})
+		default:
+			var b strings.Builder
+			for i < len(runes) {
+				r := runes[i]
+				if r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == ';' || r == '}' {
+					break
+				}
+				// ${var} 形式的变量中的大括号属于参数本身
+				if r == '{' {
+					if i > 0 && runes[i-1] == '$' {
+						for i < len(runes) && runes[i] != '}' {
+							b.WriteRune(runes[i])
+							i++
+						}
+						if i < len(runes) {
+							b.WriteRune(runes[i])
+							i++
+						}
+						continue
+					}
+					break
+				}
+				if r == '\\' && i+1 < len(runes) {
+					b.WriteRune(r)
+					b.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				b.WriteRune(r)
+				i++
+			}
+			tokens = append(tokens, nginxToken{Value: b.String(), Line: line})
+
+			if isRawBlockDirective(b.String()) {
+				j := i
+				for j < len(runes) && (runes[j] == ' ' || runes[j] == '\t' || runes[j] == '\r' || runes[j] == '\n') {
+					j++
+				}
+				if j >= len(runes) || runes[j] != '{' {
+					continue
+				}
+				for ; i < j; i++ {
+					if runes[i] == '\n' {
+						line++
+					}
+				}
+				open := line
+				body, next, lines, ok := scanRawBlock(runes, j+1)
+				if !ok {
+					return nil, &nginxParseError{File: file, Line: open, Msg: "块未闭合，缺少 \"}\""}
+				}
+				line += lines
+				tokens = append(tokens,
+					nginxToken{Value: "{", Line: open},
+					nginxToken{Value: body, Line: open, Raw: true},
+					nginxToken{Value: "}", Line: line})
+				i = next
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// parseNginxConfig 解析 nginx 配置内容为指令树
+func parseNginxConfig(content, file string) ([]*nginxDirective, error) {
+	tokens, err := tokenizeNginx(content, file)
+	if err != nil {
+		return nil, err
+	}
+
+	pos := 0
+	var parseBlock func(depth int, openLine int) ([]*nginxDirective, error)
+	parseBlock = func(depth int, openLine int) ([]*nginxDirective, error) {
+		directives := []*nginxDirective{}
+		for pos < len(tokens) {
+			tok := tokens[pos]
+			if !tok.Quoted && tok.Value == "}" {
+				if depth == 0 {
+					return nil, &nginxParseError{File: file, Line: tok.Line, Msg: "多余的 \"}\""}
+				}
+				pos++
+				return directives, nil
+			}
+			if !tok.Quoted && (tok.Value == "{" || tok.Value == ";") {
+				return nil, &nginxParseError{File: file, Line: tok.Line, Msg: fmt.Sprintf("意外的 %q", tok.Value)}
+			}
+
+			d := &nginxDirective{Name: tok.Value, Args: []string{}, File: file, Line: tok.Line}
+			pos++
+			for {
+				if pos >= len(tokens) {
+					return nil, &nginxParseError{File: file, Line: d.Line, Msg: fmt.Sprintf("指令 %q 缺少 \";\" 或 \"{\"", d.Name)}
+				}
+				t := tokens[pos]
+				pos++
+				if !t.Quoted && t.Value == ";" {
+					break
+				}
+				if !t.Quoted && t.Value == "{" && pos+1 < len(tokens) && tokens[pos].Raw {
+					d.Raw = tokens[pos].Value
+					d.Block = []*nginxDirective{}
+					pos += 2
+					break
+				}
+				if !t.Quoted && t.Value == "{" {
+					block, err := parseBlock(depth+1, t.Line)
+					if err != nil {
+						return nil, err
+					}
+					d.Block = block
+					break
+				}
+				if !t.Quoted && t.Value == "}" {
+					return nil, &nginxParseError{File: file, Line: d.Line, Msg: fmt.Sprintf("指令 %q 缺少 \";\"", d.Name)}
+				}
+				d.Args = append(d.Args, t.Value)
+			}
+			directives = append(directives, d)
+		}
+		if depth > 0 {
+			return nil, &nginxParseError{File: file, Line: openLine, Msg: "块未闭合，缺少 \"}\""}
+		}
+		return directives, nil
+	}
+
+	return parseBlock(0, 0)
+}
+
+// findDirectives 查找块中指定名称的指令
+func findDirectives(block []*nginxDirective, name string) []*nginxDirective {
+	var found []*nginxDirective
+	for _, d := range block {
+		if d.Name == name {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// arg 获取指令的第 i 个参数，不存在时返回空
+func (d *nginxDirective) arg(i int) string {
+	if i < len(d.Args) {
+		return d.Args[i]
+	}
+	return ""
+}
+
+// renderNginxDirectives 将指令树重新输出为配置文本
+func renderNginxDirectives(directives []*nginxDirective, indent string) string {
+	var b strings.Builder
+	for _, d := range directives {
+		b.WriteString(indent + nginxValue(d.Name))
+		for _, a := range d.Args {
+			b.WriteString(" " + nginxValue(a))
+		}
+		if d.Block == nil {
+			b.WriteString(";\n")
+			continue
+		}
+		if d.Raw != "" {
+			b.WriteString(" {" + d.Raw + "}\n")
+			continue
+		}
+		b.WriteString(" {\n")
+		b.WriteString(renderNginxDirectives(d.Block, indent+"    "))
+		b.WriteString(indent + "}\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// proxyNextUpstreamConditions proxy_next_upstream 支持的条件
+var proxyNextUpstreamConditions = map[string]bool{
+	"error": true, "timeout": true, "invalid_header": true, "non_idempotent": true, "off": true,
+	"http_500": true, "http_502": true, "http_503": true, "http_504": true,
+	"http_403": true, "http_404": true, "http_429": true,
+}
+
+// cacheLevelsPattern proxy_cache_path 的目录层级，如 1:2
+var cacheLevelsPattern = regexp.MustCompile(`^[12](:[12]){0,2}$`)
+
+// sharedHTTPConfigIDs 同一服务器上与配置加载到同一 http 上下文的其他配置：
+// 虚拟主机与服务器上所有配置共享，主配置只与虚拟主机共享
+func sharedHTTPConfigIDs(cfg *models.NginxConfig) []uint {
+	if cfg.ServerID == nil {
+		return nil
+	}
+	query := db.DB.Model(&models.NginxConfig{}).Where("server_id = ? AND id <> ?", *cfg.ServerID, cfg.ID)
+	if !isNginxVhost(cfg) {
+		query = query.Where("mode = ?", nginxModeVhost)
+	}
+	var ids []uint
+	query.Pluck("id", &ids)
+	return ids
+}
+
+// splitDirectiveList 拆分换行或分号分隔的多条指令参数
+func splitDirectiveList(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '\n' || r == '\r' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validateCacheValid 校验 proxy_cache_valid 条目：可选的状态码（或 any）后跟有效期
+func validateCacheValid(entry string) error {
+	fields := strings.Fields(entry)
+	if !nginxTimePattern.MatchString(fields[len(fields)-1]) {
+		return fmt.Errorf("缓存有效期 %q 格式错误，应如 200 302 10m", entry)
+	}
+	for _, code := range fields[:len(fields)-1] {
+		if n, err := strconv.Atoi(code); code != "any" && (err != nil || n < 100 || n > 599) {
+			return fmt.Errorf("缓存有效期 %q 中的状态码 %s 不合法", entry, code)
+		}
+	}
+	return nil
+}
+
+// validateCacheZones 校验缓存 zone 的名称、目录、层级、大小与过期时间，名称与目录均不能重复
+func validateCacheZones(zones []models.NginxCacheZone) error {
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	for i := range zones {
+		z := &zones[i]
+		if !upstreamNamePattern.MatchString(z.Name) {
+			return fmt.Errorf("缓存 zone 名称 %q 不合法，只能包含字母、数字、下划线、点和横线", z.Name)
+		}
+		if names[z.Name] {
+			return fmt.Errorf("缓存 zone %s 重复定义", z.Name)
+		}
+		names[z.Name] = true
+
+		if !path.IsAbs(z.Path) || strings.ContainsAny(z.Path, " \t;{}\"'") {
+			return fmt.Errorf("缓存 zone %s 的目录 %q 必须是不含空白和特殊字符的绝对路径", z.Name, z.Path)
+		}
+		if paths[path.Clean(z.Path)] {
+			return fmt.Errorf("缓存目录 %s 被多个缓存 zone 使用", z.Path)
+		}
+		paths[path.Clean(z.Path)] = true
+		if z.Levels != "" && !cacheLevelsPattern.MatchString(z.Levels) {
+			return fmt.Errorf("缓存 zone %s 的目录层级 %q 格式错误，应如 1:2", z.Name, z.Levels)
+		}
+		for _, size := range []string{z.Size, z.MaxSize} {
+			if size != "" && !nginxSizePattern.MatchString(size) {
+				return fmt.Errorf("缓存 zone %s 的大小 %q 格式错误，应如 10m、1g", z.Name, size)
+			}
+		}
+		if z.Inactive != "" && !nginxTimePattern.MatchString(z.Inactive) {
+			return fmt.Errorf("缓存 zone %s 的过期时间 %q 格式错误，应如 60m", z.Name, z.Inactive)
+		}
+		if strings.ContainsAny(z.CacheKey, ";{}\"'") || strings.ContainsAny(z.Bypass, ";{}\"'") {
+			return fmt.Errorf("缓存 zone %s 的 key 或跳过条件包含非法字符", z.Name)
+		}
+	}
+	return nil
+}
+
+// locationHasProxySettings 判断 location 是否设置了代理调优或缓存参数
+func locationHasProxySettings(loc *models.NginxLocation) bool {
+	return loc.ProxyConnectTimeout != "" || loc.ProxyReadTimeout != "" || loc.ProxySendTimeout != "" ||
+		loc.ProxyBuffering != "" || loc.WebSocket || loc.ProxyNextUpstream != "" ||
+		loc.ProxyNextUpstreamTries != 0 || loc.ProxyNextUpstreamTimeout != "" ||
+		loc.ProxyCache != "" || loc.ProxyCacheValid != ""
+}
+
+// validateProxySettings 校验代理 location 的超时、缓冲、后端切换与缓存设置，引用的缓存 zone 须已定义
+func validateProxySettings(cfg *models.NginxConfig) error {
+	if err := validateCacheZones(cfg.CacheZones); err != nil {
+		return err
+	}
+	zones := map[string]bool{}
+	for _, z := range cfg.CacheZones {
+		zones[z.Name] = true
+	}
+
+	for i := range cfg.Locations {
+		loc := &cfg.Locations[i]
+		if !locationHasProxySettings(loc) {
+			continue
+		}
+		if locationHandlerType(loc) != "proxy" {
+			return fmt.Errorf("location %s 不是代理方式，不能设置代理参数", loc.Path)
+		}
+		for _, t := range []string{loc.ProxyConnectTimeout, loc.ProxyReadTimeout, loc.ProxySendTimeout, loc.ProxyNextUpstreamTimeout} {
+			if t != "" && !nginxTimePattern.MatchString(t) {
+				return fmt.Errorf("location %s 的超时 %q 格式错误，应如 60s", loc.Path, t)
+			}
+		}
+		if loc.ProxyBuffering != "" && loc.ProxyBuffering != "on" && loc.ProxyBuffering != "off" {
+			return fmt.Errorf("location %s 的 proxy_buffering 只能是 on 或 off", loc.Path)
+		}
+		conditions := strings.Fields(loc.ProxyNextUpstream)
+		for _, cond := range conditions {
+			if !proxyNextUpstreamConditions[cond] {
+				return fmt.Errorf("location %s 的 proxy_next_upstream 条件 %q 不支持", loc.Path, cond)
+			}
+			if cond == "off" && len(conditions) > 1 {
+				return fmt.Errorf("location %s 的 proxy_next_upstream 为 off 时不能设置其他条件", loc.Path)
+			}
+		}
+		if loc.ProxyNextUpstreamTries < 0 {
+			return fmt.Errorf("location %s 的 proxy_next_upstream_tries 不能为负数", loc.Path)
+		}
+
+		if loc.ProxyCache == "" {
+			if loc.ProxyCacheValid != "" {
+				return fmt.Errorf("location %s 设置了缓存有效期但未选择缓存 zone", loc.Path)
+			}
+			continue
+		}
+		if !zones[loc.ProxyCache] {
+			return fmt.Errorf("location %s 引用了未定义的缓存 zone: %s", loc.Path, loc.ProxyCache)
+		}
+		for _, entry := range splitDirectiveList(loc.ProxyCacheValid) {
+			if err := validateCacheValid(entry); err != nil {
+				return fmt.Errorf("location %s: %v", loc.Path, err)
+			}
+		}
+	}
+
+	// 同一 http 上下文中 keys_zone 名称与缓存目录不能重复
+	if ids := sharedHTTPConfigIDs(cfg); len(ids) > 0 && len(cfg.CacheZones) > 0 {
+		var others []models.NginxCacheZone
+		db.DB.Where("nginx_config_id IN ?", ids).Find(&others)
+		for _, other := range others {
+			for _, z := range cfg.CacheZones {
+				if z.Name == other.Name || path.Clean(z.Path) == path.Clean(other.Path) {
+					return fmt.Errorf("缓存 zone %s 与同一服务器上其他 Nginx 配置的缓存 zone %s 名称或目录冲突", z.Name, other.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renderCacheZone 渲染 http 块中的 proxy_cache_path
+func renderCacheZone(z models.NginxCacheZone) string {
+	line := fmt.Sprintf("    proxy_cache_path %s levels=%s keys_zone=%s:%s", z.Path,
+		defaultString(z.Levels, "1:2"), z.Name, defaultString(z.Size, "10m"))
+	if z.MaxSize != "" {
+		line += " max_size=" + z.MaxSize
+	}
+	return line + " inactive=" + defaultString(z.Inactive, "60m") + " use_temp_path=off;\n"
+}
+
+// nginxCacheDirsCommand 创建缓存 zone 目录的命令，未定义缓存 zone 时返回空
+func nginxCacheDirsCommand(cfg *models.NginxConfig) string {
+	if len(cfg.CacheZones) == 0 {
+		return ""
+	}
+	dirs := make([]string, 0, len(cfg.CacheZones))
+	for _, z := range cfg.CacheZones {
+		dirs = append(dirs, path.Clean(z.Path))
+	}
+	return "mkdir -p " + strings.Join(dirs, " ")
+}
+
+// renderLocationProxy 渲染代理 location 的超时、缓冲、后端切换与缓存指令
+func renderLocationProxy(b *strings.Builder, loc *models.NginxLocation, rc *locationRenderContext) {
+	var tries string
+	if loc.ProxyNextUpstreamTries > 0 {
+		tries = strconv.Itoa(loc.ProxyNextUpstreamTries)
+	}
+	directives := []struct{ name, value string }{
+		{"proxy_connect_timeout", loc.ProxyConnectTimeout},
+		{"proxy_read_timeout", loc.ProxyReadTimeout},
+		{"proxy_send_timeout", loc.ProxySendTimeout},
+		{"proxy_buffering", loc.ProxyBuffering},
+		{"proxy_next_upstream", loc.ProxyNextUpstream},
+		{"proxy_next_upstream_tries", tries},
+		{"proxy_next_upstream_timeout", loc.ProxyNextUpstreamTimeout},
+	}
+	for _, d := range directives {
+		if d.value != "" {
+			fmt.Fprintf(b, "            %s %s;\n", d.name, d.value)
+		}
+	}
+
+	zone, ok := rc.cacheZones[loc.ProxyCache]
+	if !ok {
+		return
+	}
+	fmt.Fprintf(b, "            proxy_cache %s;\n", zone.Name)
+	if zone.CacheKey != "" {
+		fmt.Fprintf(b, "            proxy_cache_key %s;\n", nginxValue(zone.CacheKey))
+	}
+	for _, entry := range splitDirectiveList(loc.ProxyCacheValid) {
+		fmt.Fprintf(b, "            proxy_cache_valid %s;\n", strings.Join(strings.Fields(entry), " "))
+	}
+	if bypass := strings.Join(strings.Fields(zone.Bypass), " "); bypass != "" {
+		fmt.Fprintf(b, "            proxy_cache_bypass %s;\n", bypass)
+		fmt.Fprintf(b, "            proxy_no_cache %s;\n", bypass)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestNginxProxySettings(t *testing.T) {
+	testDB := setupAPITestDB(t, nginxTestTables()...)
+
+	_, router := newNginxTestRouter()
+
+	cfg := nginxTestConfig("api-site", "api.example.com")
+	cfg["cache_zones"] = []map[string]interface{}{{
+		"name": "api_cache", "path": "/var/cache/nginx/api", "size": "20m", "max_size": "1g", "inactive": "30m",
+		"cache_key": "$scheme$host$request_uri", "bypass": "$cookie_nocache $arg_nocache",
+	}}
+	cfg["locations"] = []map[string]interface{}{
+		{
+			"path": "/api/", "handler_type": "proxy", "proxy_pass": "http://127.0.0.1:9000",
+			"proxy_connect_timeout": "5s", "proxy_read_timeout": "120s", "proxy_buffering": "off",
+			"proxy_next_upstream": "error timeout http_502", "proxy_next_upstream_tries": 3,
+			"proxy_cache": "api_cache", "proxy_cache_valid": "200 302 10m; 404 1m",
+		},
+		{"path": "/ws/", "handler_type": "proxy", "proxy_pass": "http://127.0.0.1:9001", "websocket": true},
+	}
+	w := sendJSON(router, http.MethodPost, "/nginx", cfg)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	stored, err := loadNginxConfigForRender(1)
+	assert.NoError(t, err)
+	content, err := generateNginxConfig(stored)
+	assert.NoError(t, err)
+	assert.Contains(t, content, "    proxy_cache_path /var/cache/nginx/api levels=1:2 keys_zone=api_cache:20m max_size=1g inactive=30m use_temp_path=off;\n")
+	assert.Contains(t, content, strings.Join([]string{
+		"            proxy_connect_timeout 5s;",
+		"            proxy_read_timeout 120s;",
+		"            proxy_buffering off;",
+		"            proxy_next_upstream error timeout http_502;",
+		"            proxy_next_upstream_tries 3;",
+		"            proxy_cache api_cache;",
+		"            proxy_cache_key $scheme$host$request_uri;",
+		"            proxy_cache_valid 200 302 10m;",
+		"            proxy_cache_valid 404 1m;",
+		"            proxy_cache_bypass $cookie_nocache $arg_nocache;",
+		"            proxy_no_cache $cookie_nocache $arg_nocache;",
+	}, "\n"))
+	assert.Contains(t, content, "            proxy_http_version 1.1;\n")
+	assert.Contains(t, content, "            proxy_set_header Upgrade $http_upgrade;\n")
+	assert.Contains(t, content, "            proxy_set_header Connection upgrade;\n")
+	assert.Equal(t, "mkdir -p /var/cache/nginx/api", nginxCacheDirsCommand(stored))
+
+	// 更新时未提交 cache_zones 则保持不变，仍被引用的 zone 不能删除
+	delete(cfg, "cache_zones")
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPut, "/nginx/1", cfg).Code)
+	var count int64
+	testDB.Model(&models.NginxCacheZone{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+	cfg["cache_zones"] = []map[string]interface{}{}
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, http.MethodPut, "/nginx/1", cfg).Code)
+
+	t.Run("校验", func(t *testing.T) {
+		base := func() *models.NginxConfig {
+			return &models.NginxConfig{
+				CacheZones: []models.NginxCacheZone{{Name: "c", Path: "/var/cache/nginx/c"}},
+				Locations: []models.NginxLocation{{
+					Path: "/", HandlerType: "proxy", ProxyPass: "http://127.0.0.1:8080",
+					ProxyReadTimeout: "60s", ProxyCache: "c", ProxyCacheValid: "any 1m",
+				}},
+			}
+		}
+		runNginxValidationCases(t, validateProxySettings, base, []nginxValidationCase{
+			{"非代理 location", func(c *models.NginxConfig) { c.Locations[0].HandlerType = "return" }, "不是代理方式"},
+			{"非法超时", func(c *models.NginxConfig) { c.Locations[0].ProxyReadTimeout = "forever" }, "forever"},
+			{"off 与其他条件混用", func(c *models.NginxConfig) { c.Locations[0].ProxyNextUpstream = "off error" }, ""},
+			{"非法状态码", func(c *models.NginxConfig) { c.Locations[0].ProxyCacheValid = "700 1m" }, "700"},
+			{"缓存路径非绝对路径", func(c *models.NginxConfig) { c.CacheZones[0].Path = "cache" }, "绝对路径"},
+		})
+	})
+}
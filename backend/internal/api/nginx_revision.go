@@ -0,0 +1,360 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+	"gorm.io/gorm"
+)
+
+// 修订版本来源
+const (
+	nginxRevisionSave   = "save"   // 保存配置
+	nginxRevisionImport = "import" // 从服务器导入
+	nginxRevisionApply  = "apply"  // 应用到服务器
+	nginxRevisionDeploy = "deploy" // 部署任务
+)
+
+// nginxSnapshotVolatileKeys 快照中不记录的字段：标识、时间戳和状态不影响生成的配置
+var nginxSnapshotVolatileKeys = []string{"id", "nginx_config_id", "created_at", "updated_at", "status", "current_revision", "server", "certificate"}
+
+// nginxConfigSnapshot 将配置（含 locations、upstreams、stream servers、限流与缓存 zone、认证用户名）序列化为稳定的 JSON 快照。
+// 更新配置时 location 会重新插入，ID 变化不应产生新版本，因此去掉标识与时间戳字段
+func nginxConfigSnapshot(cfg *models.NginxConfig) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	var snapshot map[string]interface{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return "", err
+	}
+
+	strip := func(m map[string]interface{}) {
+		for _, key := range nginxSnapshotVolatileKeys {
+			delete(m, key)
+		}
+	}
+	strip(snapshot)
+	for _, key := range []string{"locations", "upstreams", "stream_servers", "limit_zones", "auth_users", "cache_zones"} {
+		items, _ := snapshot[key].([]interface{})
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok {
+				strip(m)
+			}
+		}
+		if items == nil {
+			snapshot[key] = []interface{}{}
+		}
+	}
+
+	out, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out) + "\n", nil
+}
+
+// loadNginxConfigForRenderTx 在指定事务中加载生成配置文件所需的全部关联数据
+func loadNginxConfigForRenderTx(tx *gorm.DB, id uint) (*models.NginxConfig, error) {
+	var cfg models.NginxConfig
+	if err := tx.Preload("Certificate").Preload("Locations", orderLocations).Preload("Upstreams").Preload("StreamServers", orderStreamServers).Preload("LimitZones").Preload("AuthUsers").Preload("CacheZones").First(&cfg, id).Error; err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// nginxRevisionLocks 按配置 ID 串行化修订版本号的分配（值为 *sync.Mutex）
+var nginxRevisionLocks sync.Map
+
+// lockNginxRevision 锁定指定配置的修订版本分配，返回解锁函数。
+// 需在开启事务前加锁并持有到事务提交，否则其他请求可能在提交前读到旧的最新版本
+func lockNginxRevision(configID uint) func() {
+	v, _ := nginxRevisionLocks.LoadOrStore(configID, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// recordNginxRevision 以配置当前内容及渲染结果记录修订版本。
+// 快照、渲染结果与认证用户文件均与最新版本一致时不生成新版本，直接返回最新版本。
+// 调用方需通过 lockNginxRevision 加锁直到 tx 提交，否则并发记录同一配置的版本会撞上唯一索引
+func recordNginxRevision(tx *gorm.DB, cfg *models.NginxConfig, source, author, message string) (*models.NginxConfigRevision, error) {
+	content, err := generateNginxConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("生成配置失败: %v", err)
+	}
+	snapshot, err := nginxConfigSnapshot(cfg)
+	if err != nil {
+		return nil, err
+	}
+	htpasswd, err := nginxHtpasswdContent(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest models.NginxConfigRevision
+	result := tx.Where("nginx_config_id = ?", cfg.ID).Order("revision DESC").Limit(1).Find(&latest)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 && latest.Content == content && latest.Snapshot == snapshot && latest.Htpasswd == htpasswd {
+		return &latest, nil
+	}
+
+	rev := &models.NginxConfigRevision{
+		NginxConfigID: cfg.ID,
+		Revision:      latest.Revision + 1,
+		Source:        source,
+		Snapshot:      snapshot,
+		Content:       content,
+		Htpasswd:      htpasswd,
+		Author:        author,
+		Message:       message,
+	}
+	if err := tx.Create(rev).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.NginxConfig{}).Where("id = ?", cfg.ID).UpdateColumn("current_revision", rev.Revision).Error; err != nil {
+		return nil, err
+	}
+	cfg.CurrentRevision = rev.Revision
+	return rev, nil
+}
+
+// recordNginxRevisionLocked 在独立事务中记录修订版本并持有锁直到提交，供部署、应用配置等不在保存事务中的场景使用
+func recordNginxRevisionLocked(cfg *models.NginxConfig, source, author, message string) (*models.NginxConfigRevision, error) {
+	unlock := lockNginxRevision(cfg.ID)
+	defer unlock()
+
+	var rev *models.NginxConfigRevision
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		rev, err = recordNginxRevision(tx, cfg, source, author, message)
+		return err
+	})
+	return rev, err
+}
+
+// saveNginxRevision 配置保存后在同一事务中重新加载并记录修订版本，调用方需持有修订版本锁
+func saveNginxRevision(tx *gorm.DB, configID uint, source, author, message string) (*models.NginxConfigRevision, error) {
+	cfg, err := loadNginxConfigForRenderTx(tx, configID)
+	if err != nil {
+		return nil, err
+	}
+	return recordNginxRevision(tx, cfg, source, author, message)
+}
+
+// ensureNginxRevision 为尚无修订记录的历史配置补建初始版本
+func ensureNginxRevision(cfg *models.NginxConfig) error {
+	if cfg.CurrentRevision > 0 {
+		return nil
+	}
+	unlock := lockNginxRevision(cfg.ID)
+	defer unlock()
+	rev, err := saveNginxRevision(db.DB, cfg.ID, nginxRevisionSave, "system", "初始版本")
+	if err != nil {
+		return err
+	}
+	cfg.CurrentRevision = rev.Revision
+	return nil
+}
+
+// loadNginxRevision 加载配置的指定修订版本
+func loadNginxRevision(configID uint, revision int) (*models.NginxConfigRevision, error) {
+	var rev models.NginxConfigRevision
+	if err := db.DB.Where("nginx_config_id = ? AND revision = ?", configID, revision).First(&rev).Error; err != nil {
+		return nil, fmt.Errorf("配置修订版本 r%d 不存在", revision)
+	}
+	return &rev, nil
+}
+
+// revisionConfig 从修订版本快照还原配置，用于确定部署路径与测试方式
+func revisionConfig(rev *models.NginxConfigRevision) (*models.NginxConfig, error) {
+	var cfg models.NginxConfig
+	if err := json.Unmarshal([]byte(rev.Snapshot), &cfg); err != nil {
+		return nil, fmt.Errorf("解析修订版本快照失败: %v", err)
+	}
+	cfg.ID = rev.NginxConfigID
+	return &cfg, nil
+}
+
+// loadRevisionConfig 按路径参数加载配置并补建初始版本
+func loadRevisionConfig(c *gin.Context) (*models.NginxConfig, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 ID")
+		return nil, false
+	}
+
+	var cfg models.NginxConfig
+	if err := db.DB.First(&cfg, id).Error; err != nil {
+		response.NotFound(c, "配置不存在")
+		return nil, false
+	}
+	if err := ensureNginxRevision(&cfg); err != nil {
+		logger.Errorf("初始化 Nginx 配置版本失败: %v", err)
+		response.InternalServerError(c, "初始化配置版本失败")
+		return nil, false
+	}
+	return &cfg, true
+}
+
+// revisionParam 解析路径中的修订版本号
+func revisionParam(c *gin.Context) (int, bool) {
+	revision, err := strconv.Atoi(c.Param("revision"))
+	if err != nil || revision <= 0 {
+		response.BadRequest(c, "无效的修订版本号")
+		return 0, false
+	}
+	return revision, true
+}
+
+// ListRevisions 获取配置修订历史（不含快照与内容）
+func (n *NginxAPI) ListRevisions(c *gin.Context) {
+	cfg, ok := loadRevisionConfig(c)
+	if !ok {
+		return
+	}
+
+	var revisions []models.NginxConfigRevision
+	if err := db.DB.Omit("snapshot", "content").Where("nginx_config_id = ?", cfg.ID).Order("revision DESC").Find(&revisions).Error; err != nil {
+		logger.Errorf("查询 Nginx 配置修订历史失败: %v", err)
+		response.InternalServerError(c, "查询失败")
+		return
+	}
+
+	response.Success(c, gin.H{
+		"current_revision": cfg.CurrentRevision,
+		"revisions":        revisions,
+	})
+}
+
+// GetRevision 获取配置指定修订版本
+func (n *NginxAPI) GetRevision(c *gin.Context) {
+	cfg, ok := loadRevisionConfig(c)
+	if !ok {
+		return
+	}
+	revision, ok := revisionParam(c)
+	if !ok {
+		return
+	}
+
+	rev, err := loadNginxRevision(cfg.ID, revision)
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
+	response.Success(c, rev)
+}
+
+// DiffRevisions 对比配置两个修订版本的渲染结果与快照（统一 diff 格式），
+// to 默认为当前版本，from 默认为 to 的上一版本
+func (n *NginxAPI) DiffRevisions(c *gin.Context) {
+	cfg, ok := loadRevisionConfig(c)
+	if !ok {
+		return
+	}
+
+	to, _ := strconv.Atoi(c.DefaultQuery("to", strconv.Itoa(cfg.CurrentRevision)))
+	from, _ := strconv.Atoi(c.DefaultQuery("from", strconv.Itoa(to-1)))
+	if from <= 0 || to <= 0 {
+		response.BadRequest(c, "无效的修订版本号")
+		return
+	}
+
+	fromRev, err := loadNginxRevision(cfg.ID, from)
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+	toRev, err := loadNginxRevision(cfg.ID, to)
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
+	diff := func(a, b string) (string, error) {
+		return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(a),
+			B:        difflib.SplitLines(b),
+			FromFile: fmt.Sprintf("%s r%d", cfg.Name, from),
+			ToFile:   fmt.Sprintf("%s r%d", cfg.Name, to),
+			Context:  3,
+		})
+	}
+	contentDiff, err := diff(fromRev.Content, toRev.Content)
+	if err != nil {
+		response.InternalServerError(c, "生成差异失败")
+		return
+	}
+	snapshotDiff, err := diff(fromRev.Snapshot, toRev.Snapshot)
+	if err != nil {
+		response.InternalServerError(c, "生成差异失败")
+		return
+	}
+
+	fromRev.Snapshot, fromRev.Content = "", ""
+	toRev.Snapshot, toRev.Content = "", ""
+	response.Success(c, gin.H{
+		"from":          fromRev,
+		"to":            toRev,
+		"diff":          contentDiff,
+		"snapshot_diff": snapshotDiff,
+	})
+}
+
+// ApplyRevision 将指定修订版本的渲染结果原样应用到服务器，用于精确还原历史运行的配置
+func (n *NginxAPI) ApplyRevision(c *gin.Context) {
+	cfg, ok := loadRevisionConfig(c)
+	if !ok {
+		return
+	}
+	revision, ok := revisionParam(c)
+	if !ok {
+		return
+	}
+
+	var req ApplyConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误: "+err.Error())
+		return
+	}
+
+	rev, err := loadNginxRevision(cfg.ID, revision)
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+	revCfg, err := revisionConfig(rev)
+	if err != nil {
+		response.InternalServerError(c, err.Error())
+		return
+	}
+
+	var server models.Server
+	if err := db.DB.First(&server, req.ServerID).Error; err != nil {
+		response.NotFound(c, "服务器不存在")
+		return
+	}
+
+	apply, err := n.createApply(cfg.ID, revCfg, rev, &server, &req)
+	if err != nil {
+		logger.Errorf("创建配置应用记录失败: %v", err)
+		response.InternalServerError(c, "创建失败")
+		return
+	}
+
+	logger.Infof("Nginx 配置 %s 修订版本 r%d 应用任务已创建: %d", cfg.Name, rev.Revision, apply.ID)
+	response.SuccessWithMessage(c, "配置应用任务已创建", apply)
+}
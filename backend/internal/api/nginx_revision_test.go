@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestNginxAPI_Revisions(t *testing.T) {
+	testDB := setupAPITestDB(t, nginxTestTables()...)
+
+	nginxAPI, router := newNginxTestRouter()
+	router.GET("/nginx/:id/revisions", nginxAPI.ListRevisions)
+	router.GET("/nginx/:id/revisions/:revision", nginxAPI.GetRevision)
+	router.GET("/nginx/:id/diff", nginxAPI.DiffRevisions)
+
+	cfg := nginxTestConfig("web", "a.example.com")
+	cfg["mode"] = "vhost"
+	cfg["locations"] = []map[string]interface{}{{"path": "/", "handler_type": "proxy", "proxy_pass": "http://127.0.0.1:8080"}}
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPost, "/nginx", cfg).Code)
+
+	cfg["server_name"] = "b.example.com"
+	cfg["message"] = "更换域名"
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPut, "/nginx/1", cfg).Code)
+	// 内容未变化时不生成新版本（location 重建导致的 ID 变化不计入）
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPut, "/nginx/1", cfg).Code)
+
+	var revisions []models.NginxConfigRevision
+	testDB.Order("revision ASC").Find(&revisions)
+	if assert.Len(t, revisions, 2) {
+		assert.Contains(t, revisions[0].Content, "server_name a.example.com;")
+		assert.Equal(t, "alice", revisions[1].Author)
+		assert.Equal(t, "更换域名", revisions[1].Message)
+		assert.Equal(t, nginxRevisionSave, revisions[1].Source)
+
+		// 快照可还原部署所需的配置模式
+		revCfg, err := revisionConfig(&revisions[1])
+		assert.NoError(t, err)
+		assert.Equal(t, "/etc/nginx/conf.d/web.conf", nginxConfigTargetPath(revCfg))
+	}
+
+	var stored models.NginxConfig
+	testDB.First(&stored, 1)
+	assert.Equal(t, 2, stored.CurrentRevision)
+
+	w := sendJSON(router, http.MethodGet, "/nginx/1/diff", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `-        server_name a.example.com;`)
+	assert.Contains(t, w.Body.String(), `+        server_name b.example.com;`)
+
+	w = sendJSON(router, http.MethodGet, "/nginx/1/revisions", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "server_name")
+
+	assert.Equal(t, http.StatusNotFound, sendJSON(router, http.MethodGet, "/nginx/1/revisions/9", nil).Code)
+}
+
+func TestRecordNginxRevision_Concurrent(t *testing.T) {
+	testDB := setupAPITestDB(t, nginxTestTables()...)
+
+	cfg := &models.NginxConfig{
+		Name: "web", Mode: "vhost", EnableHTTP: true, HTTPPort: 80, ServerName: "a.example.com",
+		WorkerProcesses: "auto", WorkerConnections: 1024, RootPath: "/srv/web", IndexFiles: "index.html",
+		AccessLogPath: "/var/log/nginx/web.log", ErrorLogPath: "/var/log/nginx/error.log", ClientMaxBodySize: "10m",
+	}
+	assert.NoError(t, testDB.Create(cfg).Error)
+	_, err := saveNginxRevision(testDB, cfg.ID, nginxRevisionSave, "alice", "初始版本")
+	assert.NoError(t, err)
+	testDB.Model(cfg).UpdateColumn("server_name", "b.example.com")
+
+	// 批量部署同一配置时并发记录，只应生成一个新版本
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		loaded, err := loadNginxConfigForRenderTx(testDB, cfg.ID)
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := recordNginxRevisionLocked(loaded, nginxRevisionDeploy, "system", "部署任务")
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var count int64
+	testDB.Model(&models.NginxConfigRevision{}).Where("nginx_config_id = ?", cfg.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestNginxRevision_UpdateDuringDeploy(t *testing.T) {
+	testDB := setupAPITestDB(t)
+	// 使用文件数据库允许多个连接，保存与部署的事务才会真正并发
+	fileDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := fileDB.AutoMigrate(nginxTestTables()...); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	db.DB = fileDB
+	t.Cleanup(func() { db.DB = testDB })
+
+	_, router := newNginxTestRouter()
+
+	cfg := nginxTestConfig("web", "a.example.com")
+	cfg["mode"] = "vhost"
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPost, "/nginx", cfg).Code)
+
+	// 保存配置的同时部署之前加载的配置，两边都会生成新版本
+	for i := 0; i < 10; i++ {
+		loaded, err := loadNginxConfigForRenderTx(fileDB, 1)
+		assert.NoError(t, err)
+		loaded.ClientMaxBodySize = fmt.Sprintf("%dm", 100+i)
+		cfg["server_name"] = fmt.Sprintf("s%d.example.com", i)
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			<-start
+			w := sendJSON(router, http.MethodPut, "/nginx/1", cfg)
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := recordNginxRevisionLocked(loaded, nginxRevisionDeploy, "system", "部署任务")
+			assert.NoError(t, err)
+		}()
+		close(start)
+		wg.Wait()
+	}
+
+	var revisions []models.NginxConfigRevision
+	fileDB.Where("nginx_config_id = ?", 1).Order("revision ASC").Find(&revisions)
+	assert.Len(t, revisions, 21)
+	for i, rev := range revisions {
+		assert.Equal(t, i+1, rev.Revision)
+	}
+
+	var stored models.NginxConfig
+	fileDB.First(&stored, 1)
+	assert.Equal(t, len(revisions), stored.CurrentRevision)
+}
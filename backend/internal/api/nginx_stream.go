@@ -0,0 +1,211 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// upstream 所在上下文
+const (
+	upstreamContextHTTP   = "http"
+	upstreamContextStream = "stream"
+)
+
+// isStreamUpstream 判断 upstream 是否位于 stream 上下文
+func isStreamUpstream(u *models.NginxUpstream) bool {
+	return u.Context == upstreamContextStream
+}
+
+// orderStreamServers 按 sort_order 预加载 stream server
+func orderStreamServers(tx *gorm.DB) *gorm.DB {
+	return tx.Order("sort_order ASC, id ASC")
+}
+
+// streamProtocol stream server 的协议，默认 tcp
+func streamProtocol(s *models.NginxStreamServer) string {
+	return defaultString(s.Protocol, "tcp")
+}
+
+// validateStreamServers 校验 stream server 的端口、协议、代理目标与超时设置，同一端口与协议不能重复
+func validateStreamServers(servers []models.NginxStreamServer) error {
+	seen := map[string]bool{}
+	for i := range servers {
+		s := &servers[i]
+		label := defaultString(s.Name, strconv.Itoa(s.ListenPort))
+		if s.ListenPort <= 0 || s.ListenPort > 65535 {
+			return fmt.Errorf("stream server %s 的监听端口 %d 不合法", label, s.ListenPort)
+		}
+		protocol := streamProtocol(s)
+		if protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf("stream server %s 的协议 %q 不支持，只能是 tcp 或 udp", label, s.Protocol)
+		}
+		target := strings.TrimSpace(s.ProxyPass)
+		if target == "" || strings.ContainsAny(target, " \t;{}$") {
+			return fmt.Errorf("stream server %s 的代理目标 %q 不合法", label, s.ProxyPass)
+		}
+		for _, t := range []string{s.ProxyConnectTimeout, s.ProxyTimeout} {
+			if t != "" && !nginxTimePattern.MatchString(t) {
+				return fmt.Errorf("stream server %s 的超时 %q 格式错误，应如 10s", label, t)
+			}
+		}
+
+		key := fmt.Sprintf("%d/%s", s.ListenPort, protocol)
+		if seen[key] {
+			return fmt.Errorf("stream server 端口 %d/%s 重复", s.ListenPort, protocol)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// httpListenPorts 配置中 HTTP/HTTPS server 监听的 TCP 端口
+func httpListenPorts(cfg *models.NginxConfig) map[int]string {
+	ports := map[int]string{}
+	if cfg.EnableHTTP {
+		ports[cfg.HTTPPort] = "HTTP"
+	}
+	if cfg.EnableHTTPS {
+		ports[cfg.HTTPSPort] = "HTTPS"
+	}
+	return ports
+}
+
+// validateStreamConfig 校验 stream 配置：虚拟主机不支持 stream，代理目标需为 stream upstream 或 host:port，
+// TCP 端口不能与本配置及同一服务器上虚拟主机的 HTTP/HTTPS 端口冲突（虚拟主机反向检查）
+func validateStreamConfig(cfg *models.NginxConfig) error {
+	streamUpstreams := map[string]bool{}
+	for i := range cfg.Upstreams {
+		if isStreamUpstream(&cfg.Upstreams[i]) {
+			streamUpstreams[cfg.Upstreams[i].Name] = true
+		}
+	}
+	if isNginxVhost(cfg) {
+		if len(cfg.StreamServers) > 0 || len(streamUpstreams) > 0 {
+			return fmt.Errorf("虚拟主机配置位于 http 上下文，不支持 stream server 与 stream upstream")
+		}
+		return checkVhostStreamPorts(cfg)
+	}
+	if len(cfg.StreamServers) == 0 {
+		return nil
+	}
+	if err := validateStreamServers(cfg.StreamServers); err != nil {
+		return err
+	}
+
+	for _, s := range cfg.StreamServers {
+		target := strings.TrimSpace(s.ProxyPass)
+		if streamUpstreams[target] || strings.HasPrefix(target, "unix:") {
+			continue
+		}
+		// stream 的 proxy_pass 直接地址必须带端口
+		if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+			return fmt.Errorf("stream server %s 的代理目标 %s 既不是 stream upstream，也不是 host:port 地址",
+				defaultString(s.Name, strconv.Itoa(s.ListenPort)), target)
+		}
+	}
+
+	ports := httpListenPorts(cfg)
+	owners := map[int]string{}
+	for port, kind := range ports {
+		owners[port] = "本配置的 " + kind + " server"
+	}
+	if cfg.ServerID != nil {
+		var vhosts []models.NginxConfig
+		db.DB.Where("server_id = ? AND mode = ? AND id <> ?", *cfg.ServerID, nginxModeVhost, cfg.ID).Find(&vhosts)
+		for i := range vhosts {
+			for port, kind := range httpListenPorts(&vhosts[i]) {
+				if _, ok := owners[port]; !ok {
+					owners[port] = fmt.Sprintf("虚拟主机 %s 的 %s server", vhosts[i].Name, kind)
+				}
+			}
+		}
+	}
+	for _, s := range cfg.StreamServers {
+		if streamProtocol(&s) != "tcp" {
+			continue
+		}
+		if owner, ok := owners[s.ListenPort]; ok {
+			return fmt.Errorf("stream server 端口 %d 与%s冲突", s.ListenPort, owner)
+		}
+	}
+	return nil
+}
+
+// checkVhostStreamPorts 虚拟主机的 HTTP/HTTPS 端口不能与同一服务器主配置中的 TCP stream server 冲突
+func checkVhostStreamPorts(cfg *models.NginxConfig) error {
+	if cfg.ServerID == nil {
+		return nil
+	}
+	ports := httpListenPorts(cfg)
+	if len(ports) == 0 {
+		return nil
+	}
+
+	var servers []models.NginxStreamServer
+	db.DB.Joins("JOIN nginx_configs ON nginx_configs.id = nginx_stream_servers.nginx_config_id").
+		Where("nginx_configs.deleted_at IS NULL AND nginx_configs.server_id = ? AND nginx_configs.mode <> ? AND nginx_configs.id <> ?", *cfg.ServerID, nginxModeVhost, cfg.ID).
+		Find(&servers)
+	for _, s := range servers {
+		if kind, ok := ports[s.ListenPort]; ok && streamProtocol(&s) == "tcp" {
+			return fmt.Errorf("%s 端口 %d 与主配置中的 stream server %s 冲突",
+				kind, s.ListenPort, defaultString(s.Name, strconv.Itoa(s.ListenPort)))
+		}
+	}
+	return nil
+}
+
+// renderStreamBlock 渲染 stream 块（与 http 块同级），没有 stream 配置时返回空
+func renderStreamBlock(cfg *models.NginxConfig) (string, error) {
+	var upstreams []models.NginxUpstream
+	for _, u := range cfg.Upstreams {
+		if isStreamUpstream(&u) {
+			upstreams = append(upstreams, u)
+		}
+	}
+	if len(cfg.StreamServers) == 0 && len(upstreams) == 0 {
+		return "", nil
+	}
+
+	servers := append([]models.NginxStreamServer(nil), cfg.StreamServers...)
+	sort.SliceStable(servers, func(i, j int) bool { return servers[i].SortOrder < servers[j].SortOrder })
+
+	var b strings.Builder
+	b.WriteString("\n# TCP/UDP 四层代理\nstream {\n")
+	for _, u := range upstreams {
+		block, err := renderUpstreamBlock(u)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(block + "\n")
+	}
+	for _, s := range servers {
+		if s.Name != "" {
+			fmt.Fprintf(&b, "    # %s\n", s.Name)
+		}
+		b.WriteString("    server {\n")
+		listen := strconv.Itoa(s.ListenPort)
+		if streamProtocol(&s) == "udp" {
+			listen += " udp"
+		}
+		fmt.Fprintf(&b, "        listen %s;\n", listen)
+		fmt.Fprintf(&b, "        proxy_pass %s;\n", strings.TrimSpace(s.ProxyPass))
+		if s.ProxyConnectTimeout != "" {
+			fmt.Fprintf(&b, "        proxy_connect_timeout %s;\n", s.ProxyConnectTimeout)
+		}
+		if s.ProxyTimeout != "" {
+			fmt.Fprintf(&b, "        proxy_timeout %s;\n", s.ProxyTimeout)
+		}
+		if s.ProxyProtocol {
+			b.WriteString("        proxy_protocol on;\n")
+		}
+		b.WriteString("    }\n\n")
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n}\n", nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestNginxStreamServers(t *testing.T) {
+	base := func() *models.NginxConfig {
+		return &models.NginxConfig{
+			Name:              "gateway",
+			Mode:              nginxModeMain,
+			WorkerProcesses:   "auto",
+			WorkerConnections: 1024,
+			EnableHTTP:        true,
+			HTTPPort:          80,
+			ServerName:        "example.com",
+			RootPath:          "/srv/www",
+			IndexFiles:        "index.html",
+			AccessLogPath:     "/var/log/nginx/access.log",
+			ErrorLogPath:      "/var/log/nginx/error.log",
+			ClientMaxBodySize: "10m",
+			Upstreams: []models.NginxUpstream{
+				{Name: "api", Context: upstreamContextHTTP, Servers: `[{"address":"10.0.0.1:8080"}]`},
+				{Name: "mysql", Context: upstreamContextStream, LoadBalance: "least_conn", Servers: `[{"address":"10.0.0.5:3306"},{"address":"10.0.0.6:3306","backup":true}]`},
+			},
+			StreamServers: []models.NginxStreamServer{
+				{Name: "MySQL", ListenPort: 3306, ProxyPass: "mysql", ProxyConnectTimeout: "5s", ProxyTimeout: "10m", ProxyProtocol: true},
+				{Name: "DNS", ListenPort: 53, Protocol: "udp", ProxyPass: "10.0.0.53:53", SortOrder: 1},
+			},
+		}
+	}
+
+	t.Run("渲染 stream 块并通过校验", func(t *testing.T) {
+		cfg := base()
+		assert.NoError(t, validateStreamConfig(cfg))
+		content, errs, _, err := validateNginxConfig(cfg)
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+		assert.Contains(t, content, "\nstream {\n    upstream mysql {\n        least_conn;")
+		assert.Contains(t, content, "        listen 3306;\n        proxy_pass mysql;\n        proxy_connect_timeout 5s;\n        proxy_timeout 10m;\n        proxy_protocol on;\n")
+		assert.Contains(t, content, "        listen 53 udp;\n        proxy_pass 10.0.0.53:53;\n")
+		// stream upstream 不出现在 http 块中
+		assert.Equal(t, 1, strings.Count(content, "upstream mysql"))
+	})
+
+	t.Run("TCP 端口与 HTTP server 冲突", func(t *testing.T) {
+		cfg := base()
+		cfg.StreamServers[0].ListenPort = 80
+		assert.ErrorContains(t, validateStreamConfig(cfg), "端口 80")
+
+		// UDP 与 HTTP 的 TCP 端口互不影响
+		cfg = base()
+		cfg.StreamServers[1].ListenPort = 80
+		assert.NoError(t, validateStreamConfig(cfg))
+	})
+
+	t.Run("参数校验", func(t *testing.T) {
+		cfg := base()
+		cfg.StreamServers[1].ListenPort = 3306
+		cfg.StreamServers[1].Protocol = "tcp"
+		assert.ErrorContains(t, validateStreamConfig(cfg), "重复")
+
+		cfg = base()
+		cfg.StreamServers[0].ProxyPass = "redis"
+		assert.ErrorContains(t, validateStreamConfig(cfg), "redis")
+
+		cfg = base()
+		cfg.StreamServers[0].Protocol = "sctp"
+		assert.Error(t, validateStreamConfig(cfg))
+
+		cfg = base()
+		cfg.Mode = nginxModeVhost
+		assert.ErrorContains(t, validateStreamConfig(cfg), "虚拟主机")
+	})
+
+	t.Run("离线校验 stream 指令", func(t *testing.T) {
+		errs, _ := validateNginxContent(`
+events {}
+http {
+    upstream api { server 10.0.0.1:8080; }
+    server { listen 8080; }
+}
+stream {
+    server { listen 8080; proxy_pass api; }
+    server { listen 8080 udp; proxy_pass 10.0.0.1:53; }
+}`, false)
+		if assert.Len(t, errs, 2) {
+			assert.Contains(t, errs[0].Message, "未定义的 stream upstream: api")
+			assert.Contains(t, errs[1].Message, "冲突")
+		}
+	})
+}
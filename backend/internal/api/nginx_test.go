@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/config"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// nginxTestTables Nginx 配置相关测试需要迁移的模型
+func nginxTestTables() []interface{} {
+	return []interface{}{&models.Certificate{}, &models.NginxConfig{}, &models.NginxLocation{}, &models.NginxUpstream{},
+		&models.NginxStreamServer{}, &models.NginxLimitZone{}, &models.NginxAuthUser{}, &models.NginxCacheZone{}, &models.NginxConfigRevision{}}
+}
+
+// sendJSON 以 JSON 请求体调用路由并返回响应
+func sendJSON(router *gin.Engine, method, url string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// newNginxTestRouter 注册 Nginx 配置创建与更新接口的测试路由，请求以 alice 身份发起
+func newNginxTestRouter() (*NginxAPI, *gin.Engine) {
+	nginxAPI := &NginxAPI{cfg: &config.Config{}}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("username", "alice") })
+	router.POST("/nginx", nginxAPI.Create)
+	router.PUT("/nginx/:id", nginxAPI.Update)
+	return nginxAPI, router
+}
+
+// nginxTestConfig 返回创建 Nginx 配置所需的基础请求字段
+func nginxTestConfig(name, serverName string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name, "enable_http": true, "http_port": 80, "server_name": serverName,
+		"worker_processes": "auto", "worker_connections": 1024, "root_path": "/srv/" + name, "index_files": "index.html",
+		"access_log_path": "/var/log/nginx/access.log", "error_log_path": "/var/log/nginx/error.log", "client_max_body_size": "10m",
+	}
+}
+
+// nginxValidationCase 在基础配置上修改一处后期望校验失败的用例，wantErr 为空时只要求返回错误
+type nginxValidationCase struct {
+	name    string
+	mutate  func(c *models.NginxConfig)
+	wantErr string
+}
+
+// runNginxValidationCases 校验基础配置通过，并逐个执行修改后应失败的用例
+func runNginxValidationCases(t *testing.T, validate func(*models.NginxConfig) error, base func() *models.NginxConfig, cases []nginxValidationCase) {
+	assert.NoError(t, validate(base()))
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := base()
+			tc.mutate(c)
+			err := validate(c)
+			if tc.wantErr == "" {
+				assert.Error(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.wantErr)
+			}
+		})
+	}
+}
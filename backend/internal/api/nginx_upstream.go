@@ -0,0 +1,454 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+	"gorm.io/gorm"
+)
+
+// upstreamNamePattern upstream 名称规则
+var upstreamNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// nginxTimePattern nginx 时间参数，如 10、10s、1m
+var nginxTimePattern = regexp.MustCompile(`^[0-9]+(ms|s|m|h|d)?$`)
+
+// customUpstreamPattern 自定义配置片段中定义的 upstream
+var customUpstreamPattern = regexp.MustCompile(`(?m)^\s*upstream\s+([^\s{]+)\s*\{`)
+
+// UpstreamRequest 创建/更新 upstream 请求
+type UpstreamRequest struct {
+	Name        string                  `json:"name" binding:"required"`
+	Context     string                  `json:"context" binding:"omitempty,oneof=http stream"` // 默认 http
+	LoadBalance string                  `json:"load_balance" binding:"omitempty,oneof=round_robin least_conn ip_hash"`
+	Servers     []models.UpstreamServer `json:"servers"`
+	Keepalive   int                     `json:"keepalive" binding:"min=0"`
+}
+
+// toModel 校验请求并转换为 upstream 模型
+func (r *UpstreamRequest) toModel(configID uint) (*models.NginxUpstream, error) {
+	if !upstreamNamePattern.MatchString(r.Name) {
+		return nil, fmt.Errorf("upstream 名称 %q 不合法，只能包含字母、数字、下划线、点和横线", r.Name)
+	}
+	lb := defaultString(r.LoadBalance, "round_robin")
+	context := defaultString(r.Context, upstreamContextHTTP)
+	if context == upstreamContextStream {
+		if lb == "ip_hash" {
+			return nil, fmt.Errorf("stream upstream %s 不支持 ip_hash 负载均衡", r.Name)
+		}
+		if r.Keepalive > 0 {
+			return nil, fmt.Errorf("stream upstream %s 不支持 keepalive", r.Name)
+		}
+	}
+	if err := validateUpstreamServers(lb, r.Servers); err != nil {
+		return nil, fmt.Errorf("upstream %s: %v", r.Name, err)
+	}
+
+	servers, _ := json.Marshal(r.Servers)
+	return &models.NginxUpstream{
+		NginxConfigID: configID,
+		Name:          r.Name,
+		Context:       context,
+		LoadBalance:   lb,
+		Servers:       string(servers),
+		Keepalive:     r.Keepalive,
+	}, nil
+}
+
+// buildUpstreams 校验并转换配置请求中的 upstream 列表
+func buildUpstreams(reqs []UpstreamRequest) ([]models.NginxUpstream, error) {
+	upstreams := make([]models.NginxUpstream, 0, len(reqs))
+	for i := range reqs {
+		u, err := reqs[i].toModel(0)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, *u)
+	}
+	return upstreams, nil
+}
+
+// parseUpstreamServers 解析 upstream 的后端服务器列表
+func parseUpstreamServers(raw string) ([]models.UpstreamServer, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var servers []models.UpstreamServer
+	if err := json.Unmarshal([]byte(raw), &servers); err != nil {
+		return nil, fmt.Errorf("后端服务器列表格式错误: %v", err)
+	}
+	return servers, nil
+}
+
+// validateUpstreamServers 校验后端服务器参数
+func validateUpstreamServers(loadBalance string, servers []models.UpstreamServer) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("至少需要一个后端服务器")
+	}
+
+	active := 0
+	for _, s := range servers {
+		addr := strings.TrimSpace(s.Address)
+		if addr == "" || strings.ContainsAny(addr, " \t;{}") {
+			return fmt.Errorf("后端地址 %q 不合法", s.Address)
+		}
+		if s.Weight < 0 || s.MaxFails < 0 {
+			return fmt.Errorf("后端 %s 的 weight/max_fails 不能为负数", addr)
+		}
+		if s.FailTimeout != "" && !nginxTimePattern.MatchString(s.FailTimeout) {
+			return fmt.Errorf("后端 %s 的 fail_timeout %q 格式错误，应如 10s", addr, s.FailTimeout)
+		}
+		// nginx 不允许 ip_hash 与 backup 同时使用
+		if s.Backup && loadBalance == "ip_hash" {
+			return fmt.Errorf("ip_hash 负载均衡不支持 backup 服务器（%s）", addr)
+		}
+		if !s.Backup && !s.Down {
+			active++
+		}
+	}
+	if active == 0 {
+		return fmt.Errorf("至少需要一个非 backup 且未下线的后端服务器")
+	}
+	return nil
+}
+
+// renderUpstreamBlock 渲染 upstream 块（位于 http 块内）
+func renderUpstreamBlock(u models.NginxUpstream) (string, error) {
+	servers, err := parseUpstreamServers(u.Servers)
+	if err != nil {
+		return "", fmt.Errorf("upstream %s: %v", u.Name, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "    upstream %s {\n", u.Name)
+	switch u.LoadBalance {
+	case "least_conn", "ip_hash":
+		fmt.Fprintf(&b, "        %s;\n", u.LoadBalance)
+	}
+	for _, s := range servers {
+		line := "        server " + strings.TrimSpace(s.Address)
+		if s.Weight > 0 && s.Weight != 1 {
+			line += " weight=" + strconv.Itoa(s.Weight)
+		}
+		if s.MaxFails > 0 {
+			line += " max_fails=" + strconv.Itoa(s.MaxFails)
+		}
+		if s.FailTimeout != "" {
+			line += " fail_timeout=" + s.FailTimeout
+		}
+		if s.Backup {
+			line += " backup"
+		}
+		if s.Down {
+			line += " down"
+		}
+		b.WriteString(line + ";\n")
+	}
+	if u.Keepalive > 0 {
+		fmt.Fprintf(&b, "        keepalive %d;\n", u.Keepalive)
+	}
+	b.WriteString("    }\n")
+	return b.String(), nil
+}
+
+// keepaliveUpstreams 启用了 keepalive 的 upstream 名称
+func keepaliveUpstreams(cfg *models.NginxConfig) map[string]bool {
+	keepalive := map[string]bool{}
+	for _, u := range cfg.Upstreams {
+		if u.Keepalive > 0 && !isStreamUpstream(&u) {
+			keepalive[u.Name] = true
+		}
+	}
+	return keepalive
+}
+
+// proxyPassHost 提取 proxy_pass 中的主机部分，包含变量时返回空
+func proxyPassHost(proxyPass string) string {
+	target := strings.TrimSpace(proxyPass)
+	if target == "" || strings.Contains(target, "$") {
+		return ""
+	}
+	if i := strings.Index(target, "://"); i >= 0 {
+		target = target[i+3:]
+	}
+	if i := strings.IndexByte(target, '/'); i >= 0 {
+		target = target[:i]
+	}
+	return target
+}
+
+// configUpstreamNames 配置中可引用的 upstream 名称（含自定义配置片段中定义的）
+func configUpstreamNames(cfg *models.NginxConfig) map[string]bool {
+	names := map[string]bool{}
+	for _, u := range cfg.Upstreams {
+		if !isStreamUpstream(&u) {
+			names[u.Name] = true
+		}
+	}
+	for _, m := range customUpstreamPattern.FindAllStringSubmatch(cfg.CustomConfig, -1) {
+		names[m[1]] = true
+	}
+	return names
+}
+
+// validateProxyPassRefs 校验 upstream 名称不重复，且 proxy_pass 中形如 upstream 名称的主机都已定义。
+// 带端口、IP、域名（含点）和 localhost 的地址视为直接地址，不要求对应 upstream。
+func validateProxyPassRefs(cfg *models.NginxConfig) error {
+	seen := map[string]bool{}
+	for _, u := range cfg.Upstreams {
+		key := defaultString(u.Context, upstreamContextHTTP) + "/" + u.Name
+		if seen[key] {
+			return fmt.Errorf("upstream %s 重复定义", u.Name)
+		}
+		seen[key] = true
+	}
+
+	names := configUpstreamNames(cfg)
+	check := func(where, proxyPass string) error {
+		host := proxyPassHost(proxyPass)
+		if host == "" || names[host] {
+			return nil
+		}
+		if isDirectUpstreamHost(host) {
+			return nil
+		}
+		return fmt.Errorf("%s 的 proxy_pass 引用了未定义的 upstream: %s", where, host)
+	}
+
+	if cfg.EnableProxy && len(cfg.Locations) == 0 {
+		if err := check("默认 location", cfg.ProxyPass); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Locations {
+		loc := &cfg.Locations[i]
+		if locationHandlerType(loc) != "proxy" {
+			continue
+		}
+		if err := check("location "+loc.Path, loc.ProxyPass); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upstreamReferenced 判断 upstream 是否被配置的 location（stream upstream 为 stream server）引用
+func upstreamReferenced(cfg *models.NginxConfig, u *models.NginxUpstream) bool {
+	if isStreamUpstream(u) {
+		for _, s := range cfg.StreamServers {
+			if s.ProxyPass == u.Name {
+				return true
+			}
+		}
+		return false
+	}
+	if cfg.EnableProxy && proxyPassHost(cfg.ProxyPass) == u.Name {
+		return true
+	}
+	for _, loc := range cfg.Locations {
+		if proxyPassHost(loc.ProxyPass) == u.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadNginxConfigForRender 加载生成配置文件所需的全部关联数据
+func loadNginxConfigForRender(id uint) (*models.NginxConfig, error) {
+	return loadNginxConfigForRenderTx(db.DB, id)
+}
+
+// loadUpstreamConfig 按路径参数加载 Nginx 配置及其 locations、upstreams
+func loadUpstreamConfig(c *gin.Context) (*models.NginxConfig, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 ID")
+		return nil, false
+	}
+	cfg, err := loadNginxConfigForRender(uint(id))
+	if err != nil {
+		response.NotFound(c, "配置不存在")
+		return nil, false
+	}
+	return cfg, true
+}
+
+// findUpstream 查找配置中的 upstream
+func findUpstream(c *gin.Context, cfg *models.NginxConfig) (*models.NginxUpstream, bool) {
+	upstreamID, err := strconv.ParseUint(c.Param("upstream_id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 upstream ID")
+		return nil, false
+	}
+	for i := range cfg.Upstreams {
+		if cfg.Upstreams[i].ID == uint(upstreamID) {
+			return &cfg.Upstreams[i], true
+		}
+	}
+	response.NotFound(c, "upstream 不存在")
+	return nil, false
+}
+
+// ListUpstreams 获取配置的 upstream 列表
+func (n *NginxAPI) ListUpstreams(c *gin.Context) {
+	cfg, ok := loadUpstreamConfig(c)
+	if !ok {
+		return
+	}
+	response.Success(c, cfg.Upstreams)
+}
+
+// CreateUpstream 为配置添加 upstream
+func (n *NginxAPI) CreateUpstream(c *gin.Context) {
+	cfg, ok := loadUpstreamConfig(c)
+	if !ok {
+		return
+	}
+
+	var req UpstreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误: "+err.Error())
+		return
+	}
+	upstream, err := req.toModel(cfg.ID)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if isStreamUpstream(upstream) && isNginxVhost(cfg) {
+		response.BadRequest(c, "虚拟主机配置位于 http 上下文，不支持 stream upstream")
+		return
+	}
+	for _, u := range cfg.Upstreams {
+		if u.Name == upstream.Name && isStreamUpstream(&u) == isStreamUpstream(upstream) {
+			response.Conflict(c, "upstream 名称已存在")
+			return
+		}
+	}
+
+	unlock := lockNginxRevision(cfg.ID)
+	defer unlock()
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(upstream).Error; err != nil {
+			return err
+		}
+		_, err := saveNginxRevision(tx, cfg.ID, nginxRevisionSave, currentUsername(c), "添加 upstream "+upstream.Name)
+		return err
+	})
+	if err != nil {
+		logger.Errorf("创建 upstream 失败: %v", err)
+		response.InternalServerError(c, "创建失败")
+		return
+	}
+
+	response.SuccessWithMessage(c, "创建成功", upstream)
+}
+
+// UpdateUpstream 更新 upstream
+func (n *NginxAPI) UpdateUpstream(c *gin.Context) {
+	cfg, ok := loadUpstreamConfig(c)
+	if !ok {
+		return
+	}
+	upstream, ok := findUpstream(c, cfg)
+	if !ok {
+		return
+	}
+
+	var req UpstreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误: "+err.Error())
+		return
+	}
+	// 未指定上下文时保持不变
+	if req.Context == "" {
+		req.Context = defaultString(upstream.Context, upstreamContextHTTP)
+	}
+	updated, err := req.toModel(cfg.ID)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	if isStreamUpstream(updated) && isNginxVhost(cfg) {
+		response.BadRequest(c, "虚拟主机配置位于 http 上下文，不支持 stream upstream")
+		return
+	}
+
+	if updated.Name != upstream.Name || isStreamUpstream(updated) != isStreamUpstream(upstream) {
+		for _, u := range cfg.Upstreams {
+			if u.ID != upstream.ID && u.Name == updated.Name && isStreamUpstream(&u) == isStreamUpstream(updated) {
+				response.Conflict(c, "upstream 名称已存在")
+				return
+			}
+		}
+		if upstreamReferenced(cfg, upstream) {
+			response.BadRequest(c, fmt.Sprintf("upstream %s 正在被引用，不能重命名或修改上下文", upstream.Name))
+			return
+		}
+	}
+
+	unlock := lockNginxRevision(cfg.ID)
+	defer unlock()
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(upstream).Updates(map[string]interface{}{
+			"name":         updated.Name,
+			"context":      updated.Context,
+			"load_balance": updated.LoadBalance,
+			"servers":      updated.Servers,
+			"keepalive":    updated.Keepalive,
+		}).Error; err != nil {
+			return err
+		}
+		_, err := saveNginxRevision(tx, cfg.ID, nginxRevisionSave, currentUsername(c), "更新 upstream "+upstream.Name)
+		return err
+	})
+	if err != nil {
+		logger.Errorf("更新 upstream 失败: %v", err)
+		response.InternalServerError(c, "更新失败")
+		return
+	}
+
+	response.SuccessWithMessage(c, "更新成功", upstream)
+}
+
+// DeleteUpstream 删除 upstream，被 location 或 stream server 引用时不允许删除
+func (n *NginxAPI) DeleteUpstream(c *gin.Context) {
+	cfg, ok := loadUpstreamConfig(c)
+	if !ok {
+		return
+	}
+	upstream, ok := findUpstream(c, cfg)
+	if !ok {
+		return
+	}
+
+	if upstreamReferenced(cfg, upstream) {
+		response.BadRequest(c, fmt.Sprintf("upstream %s 正在被引用，无法删除", upstream.Name))
+		return
+	}
+
+	unlock := lockNginxRevision(cfg.ID)
+	defer unlock()
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(upstream).Error; err != nil {
+			return err
+		}
+		_, err := saveNginxRevision(tx, cfg.ID, nginxRevisionSave, currentUsername(c), "删除 upstream "+upstream.Name)
+		return err
+	})
+	if err != nil {
+		logger.Errorf("删除 upstream 失败: %v", err)
+		response.InternalServerError(c, "删除失败")
+		return
+	}
+
+	response.SuccessWithMessage(c, "删除成功", nil)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestNginxUpstream(t *testing.T) {
+	req := UpstreamRequest{
+		Name:        "backend",
+		LoadBalance: "least_conn",
+		Servers: []models.UpstreamServer{
+			{Address: "10.0.0.1:8080", Weight: 3, MaxFails: 2, FailTimeout: "10s"},
+			{Address: "10.0.0.2:8080", Backup: true},
+		},
+		Keepalive: 32,
+	}
+
+	t.Run("渲染 upstream 块", func(t *testing.T) {
+		upstreams, err := buildUpstreams([]UpstreamRequest{req})
+		assert.NoError(t, err)
+
+		cfg := &models.NginxConfig{
+			Name:        "lb",
+			EnableHTTP:  true,
+			HTTPPort:    80,
+			EnableProxy: true,
+			Locations:   []models.NginxLocation{{Path: "/api/", ProxyPass: "http://backend"}},
+			Upstreams:   upstreams,
+		}
+		assert.NoError(t, validateProxyPassRefs(cfg))
+
+		content, err := generateNginxConfig(cfg)
+		assert.NoError(t, err)
+		assert.Contains(t, content, "upstream backend {\n        least_conn;\n")
+		assert.Contains(t, content, "server 10.0.0.1:8080 weight=3 max_fails=2 fail_timeout=10s;")
+		assert.Contains(t, content, "server 10.0.0.2:8080 backup;")
+		assert.Contains(t, content, "keepalive 32;")
+		assert.Contains(t, content, "proxy_http_version 1.1;")
+	})
+
+	t.Run("ip_hash 不支持 backup", func(t *testing.T) {
+		bad := req
+		bad.LoadBalance = "ip_hash"
+		_, err := bad.toModel(1)
+		assert.ErrorContains(t, err, "backup")
+	})
+
+	t.Run("引用未定义的 upstream", func(t *testing.T) {
+		cfg := &models.NginxConfig{Locations: []models.NginxLocation{
+			{Path: "/a/", ProxyPass: "http://127.0.0.1:8080"},
+			{Path: "/b/", ProxyPass: "http://api.example.com"},
+			{Path: "/c/", ProxyPass: "http://missing/v1"},
+		}}
+		assert.ErrorContains(t, validateProxyPassRefs(cfg), "missing")
+
+		cfg.CustomConfig = "upstream missing {\n    server 10.0.0.3;\n}"
+		assert.NoError(t, validateProxyPassRefs(cfg))
+	})
+}
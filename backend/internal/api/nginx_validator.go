@@ -0,0 +1,712 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+)
+
+// nginx 指令上下文
+const (
+	ctxMain = 1 << iota
+	ctxEvents
+	ctxHTTP
+	ctxServer
+	ctxLocation
+	ctxUpstream
+	ctxIf          // server/location 中的 if 块
+	ctxLimitExcept // location 中的 limit_except 块
+	ctxStream
+	ctxStreamServer
+	ctxStreamUpstream
+	ctxAny          = 1<<iota - 1
+	ctxHSL          = ctxHTTP | ctxServer | ctxLocation
+	ctxHSLI         = ctxHSL | ctxIf
+	ctxServerLocIf  = ctxServer | ctxLocation | ctxIf
+	ctxLocIf        = ctxLocation | ctxIf
+	ctxHTTPServer   = ctxHTTP | ctxServer
+	ctxAccessPhases = ctxHSL | ctxLimitExcept
+	ctxStreams      = ctxStream | ctxStreamServer
+)
+
+// nginxContextNames 上下文名称，用于错误提示
+var nginxContextNames = map[int]string{
+	ctxMain:           "main",
+	ctxEvents:         "events",
+	ctxHTTP:           "http",
+	ctxServer:         "server",
+	ctxLocation:       "location",
+	ctxUpstream:       "upstream",
+	ctxIf:             "if",
+	ctxLimitExcept:    "limit_except",
+	ctxStream:         "stream",
+	ctxStreamServer:   "stream server",
+	ctxStreamUpstream: "stream upstream",
+}
+
+// nginxDirectiveSpec 指令规格：允许的上下文、参数个数范围（Max 为 -1 表示不限）和是否为块指令
+type nginxDirectiveSpec struct {
+	Context int
+	Min     int
+	Max     int
+	Block   bool
+	Child   int  // 块内指令的上下文
+	Raw     bool // 块内容不是指令（如 map、types），不校验
+}
+
+// nginxDirectiveSpecs 已知指令规格，同名指令在不同上下文中含义不同时有多条规格
+var nginxDirectiveSpecs = map[string][]nginxDirectiveSpec{
+	// 核心模块
+	"user":                    {{Context: ctxMain, Min: 1, Max: 2}},
+	"worker_processes":        {{Context: ctxMain, Min: 1, Max: 1}},
+	"worker_rlimit_nofile":    {{Context: ctxMain, Min: 1, Max: 1}},
+	"worker_rlimit_core":      {{Context: ctxMain, Min: 1, Max: 1}},
+	"worker_cpu_affinity":     {{Context: ctxMain, Min: 1, Max: -1}},
+	"worker_priority":         {{Context: ctxMain, Min: 1, Max: 1}},
+	"worker_shutdown_timeout": {{Context: ctxMain, Min: 1, Max: 1}},
+	"working_directory":       {{Context: ctxMain, Min: 1, Max: 1}},
+	"pid":                     {{Context: ctxMain, Min: 1, Max: 1}},
+	"lock_file":               {{Context: ctxMain, Min: 1, Max: 1}},
+	"daemon":                  {{Context: ctxMain, Min: 1, Max: 1}},
+	"master_process":          {{Context: ctxMain, Min: 1, Max: 1}},
+	"timer_resolution":        {{Context: ctxMain, Min: 1, Max: 1}},
+	"pcre_jit":                {{Context: ctxMain, Min: 1, Max: 1}},
+	"thread_pool":             {{Context: ctxMain, Min: 2, Max: 3}},
+	"env":                     {{Context: ctxMain, Min: 1, Max: 1}},
+	"load_module":             {{Context: ctxMain, Min: 1, Max: 1}},
+	"error_log":               {{Context: ctxMain | ctxHSL | ctxStreams, Min: 1, Max: 2}},
+	"include":                 {{Context: ctxAny, Min: 1, Max: 1}},
+
+	// events
+	"events":              {{Context: ctxMain, Block: true, Child: ctxEvents}},
+	"worker_connections":  {{Context: ctxEvents, Min: 1, Max: 1}},
+	"use":                 {{Context: ctxEvents, Min: 1, Max: 1}},
+	"multi_accept":        {{Context: ctxEvents, Min: 1, Max: 1}},
+	"accept_mutex":        {{Context: ctxEvents, Min: 1, Max: 1}},
+	"accept_mutex_delay":  {{Context: ctxEvents, Min: 1, Max: 1}},
+	"worker_aio_requests": {{Context: ctxEvents, Min: 1, Max: 1}},
+
+	// 块指令
+	"http":   {{Context: ctxMain, Block: true, Child: ctxHTTP}},
+	"stream": {{Context: ctxMain, Block: true, Child: ctxStream}},
+	"server": {
+		{Context: ctxHTTP, Block: true, Child: ctxServer},
+		{Context: ctxStream, Block: true, Child: ctxStreamServer},
+		{Context: ctxUpstream | ctxStreamUpstream, Min: 1, Max: -1},
+	},
+	"location":     {{Context: ctxServer | ctxLocation, Min: 1, Max: 2, Block: true, Child: ctxLocation}},
+	"if":           {{Context: ctxServer | ctxLocation, Min: 1, Max: -1, Block: true, Child: ctxIf}},
+	"limit_except": {{Context: ctxLocation, Min: 1, Max: -1, Block: true, Child: ctxLimitExcept}},
+	"upstream": {
+		{Context: ctxHTTP, Min: 1, Max: 1, Block: true, Child: ctxUpstream},
+		{Context: ctxStream, Min: 1, Max: 1, Block: true, Child: ctxStreamUpstream},
+	},
+	"map":           {{Context: ctxHTTP, Min: 2, Max: 2, Block: true, Raw: true}},
+	"geo":           {{Context: ctxHTTP, Min: 1, Max: 2, Block: true, Raw: true}},
+	"split_clients": {{Context: ctxHTTP, Min: 2, Max: 2, Block: true, Raw: true}},
+	"types":         {{Context: ctxHSL, Block: true, Raw: true}},
+
+	// http 核心
+	"listen":                        {{Context: ctxServer | ctxStreamServer, Min: 1, Max: -1}},
+	"server_name":                   {{Context: ctxServer, Min: 1, Max: -1}},
+	"root":                          {{Context: ctxHSLI, Min: 1, Max: 1}},
+	"alias":                         {{Context: ctxLocation, Min: 1, Max: 1}},
+	"index":                         {{Context: ctxHSL, Min: 1, Max: -1}},
+	"try_files":                     {{Context: ctxServer | ctxLocation, Min: 2, Max: -1}},
+	"error_page":                    {{Context: ctxHSLI, Min: 2, Max: -1}},
+	"internal":                      {{Context: ctxLocation, Min: 0, Max: 0}},
+	"default_type":                  {{Context: ctxHSL, Min: 1, Max: 1}},
+	"sendfile":                      {{Context: ctxHSLI, Min: 1, Max: 1}},
+	"tcp_nopush":                    {{Context: ctxHSL, Min: 1, Max: 1}},
+	"tcp_nodelay":                   {{Context: ctxHSL | ctxStreams, Min: 1, Max: 1}},
+	"keepalive_timeout":             {{Context: ctxHSL | ctxUpstream, Min: 1, Max: 2}},
+	"keepalive_requests":            {{Context: ctxHSL | ctxUpstream, Min: 1, Max: 1}},
+	"keepalive_disable":             {{Context: ctxHSL, Min: 1, Max: 2}},
+	"types_hash_max_size":           {{Context: ctxHSL, Min: 1, Max: 1}},
+	"types_hash_bucket_size":        {{Context: ctxHSL, Min: 1, Max: 1}},
+	"server_names_hash_max_size":    {{Context: ctxHTTP, Min: 1, Max: 1}},
+	"server_names_hash_bucket_size": {{Context: ctxHTTP, Min: 1, Max: 1}},
+	"variables_hash_max_size":       {{Context: ctxHTTP, Min: 1, Max: 1}},
+	"variables_hash_bucket_size":    {{Context: ctxHTTP, Min: 1, Max: 1}},
+	"map_hash_max_size":             {{Context: ctxHTTP, Min: 1, Max: 1}},
+	"map_hash_bucket_size":          {{Context: ctxHTTP, Min: 1, Max: 1}},
+	"client_max_body_size":          {{Context: ctxHSL, Min: 1, Max: 1}},
+	"client_body_buffer_size":       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"client_body_timeout":           {{Context: ctxHSL, Min: 1, Max: 1}},
+	"client_body_temp_path":         {{Context: ctxHSL, Min: 1, Max: 4}},
+	"client_header_timeout":         {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"client_header_buffer_size":     {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"large_client_header_buffers":   {{Context: ctxHTTPServer, Min: 2, Max: 2}},
+	"send_timeout":                  {{Context: ctxHSL, Min: 1, Max: 1}},
+	"reset_timedout_connection":     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"server_tokens":                 {{Context: ctxHSL, Min: 1, Max: 1}},
+	"underscores_in_headers":        {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ignore_invalid_headers":        {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"merge_slashes":                 {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"absolute_redirect":             {{Context: ctxHSL, Min: 1, Max: 1}},
+	"port_in_redirect":              {{Context: ctxHSL, Min: 1, Max: 1}},
+	"server_name_in_redirect":       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"chunked_transfer_encoding":     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"log_not_found":                 {{Context: ctxHSL, Min: 1, Max: 1}},
+	"log_subrequest":                {{Context: ctxHSL, Min: 1, Max: 1}},
+	"open_file_cache":               {{Context: ctxHSL, Min: 1, Max: 2}},
+	"open_file_cache_valid":         {{Context: ctxHSL, Min: 1, Max: 1}},
+	"open_file_cache_min_uses":      {{Context: ctxHSL, Min: 1, Max: 1}},
+	"open_file_cache_errors":        {{Context: ctxHSL, Min: 1, Max: 1}},
+	"aio":                           {{Context: ctxHSL, Min: 1, Max: 1}},
+	"directio":                      {{Context: ctxHSL, Min: 1, Max: 1}},
+	"output_buffers":                {{Context: ctxHSL, Min: 2, Max: 2}},
+	"postpone_output":               {{Context: ctxHSL, Min: 1, Max: 1}},
+	"resolver":                      {{Context: ctxHSL, Min: 1, Max: -1}},
+	"resolver_timeout":              {{Context: ctxHSL, Min: 1, Max: 1}},
+	"satisfy":                       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"etag":                          {{Context: ctxHSL, Min: 1, Max: 1}},
+	"if_modified_since":             {{Context: ctxHSL, Min: 1, Max: 1}},
+	"charset":                       {{Context: ctxHSLI, Min: 1, Max: 1}},
+	"source_charset":                {{Context: ctxHSLI, Min: 1, Max: 1}},
+	"autoindex":                     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"autoindex_exact_size":          {{Context: ctxHSL, Min: 1, Max: 1}},
+	"autoindex_localtime":           {{Context: ctxHSL, Min: 1, Max: 1}},
+	"stub_status":                   {{Context: ctxServer | ctxLocation, Min: 0, Max: 1}},
+
+	// 重写
+	"return":      {{Context: ctxServerLocIf, Min: 1, Max: 2}},
+	"rewrite":     {{Context: ctxServerLocIf, Min: 2, Max: 3}},
+	"set":         {{Context: ctxServerLocIf, Min: 2, Max: 2}},
+	"break":       {{Context: ctxServerLocIf, Min: 0, Max: 0}},
+	"rewrite_log": {{Context: ctxHTTP | ctxServerLocIf, Min: 1, Max: 1}},
+
+	// 日志
+	"access_log":          {{Context: ctxHSLI | ctxLimitExcept | ctxStreams, Min: 1, Max: -1}},
+	"log_format":          {{Context: ctxHTTP, Min: 2, Max: -1}},
+	"open_log_file_cache": {{Context: ctxHSL, Min: 1, Max: 4}},
+
+	// 响应头
+	"add_header":  {{Context: ctxHSLI, Min: 2, Max: 3}},
+	"add_trailer": {{Context: ctxHSLI, Min: 2, Max: 3}},
+	"expires":     {{Context: ctxHSLI, Min: 1, Max: 2}},
+
+	// 访问控制与限流
+	"allow":                {{Context: ctxAccessPhases | ctxStreams, Min: 1, Max: 1}},
+	"deny":                 {{Context: ctxAccessPhases | ctxStreams, Min: 1, Max: 1}},
+	"auth_basic":           {{Context: ctxAccessPhases, Min: 1, Max: 1}},
+	"auth_basic_user_file": {{Context: ctxAccessPhases, Min: 1, Max: 1}},
+	"limit_req_zone":       {{Context: ctxHTTP, Min: 3, Max: 4}},
+	"limit_req":            {{Context: ctxHSL, Min: 1, Max: 3}},
+	"limit_req_status":     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"limit_req_log_level":  {{Context: ctxHSL, Min: 1, Max: 1}},
+	"limit_conn_zone":      {{Context: ctxHTTP, Min: 2, Max: 2}},
+	"limit_conn":           {{Context: ctxHSL, Min: 2, Max: 2}},
+	"limit_conn_status":    {{Context: ctxHSL, Min: 1, Max: 1}},
+	"limit_conn_log_level": {{Context: ctxHSL, Min: 1, Max: 1}},
+	"limit_rate":           {{Context: ctxHSLI, Min: 1, Max: 1}},
+	"limit_rate_after":     {{Context: ctxHSLI, Min: 1, Max: 1}},
+	"set_real_ip_from":     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"real_ip_header":       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"real_ip_recursive":    {{Context: ctxHSL, Min: 1, Max: 1}},
+
+	// gzip
+	"gzip":              {{Context: ctxHSLI, Min: 1, Max: 1}},
+	"gzip_vary":         {{Context: ctxHSL, Min: 1, Max: 1}},
+	"gzip_proxied":      {{Context: ctxHSL, Min: 1, Max: -1}},
+	"gzip_comp_level":   {{Context: ctxHSL, Min: 1, Max: 1}},
+	"gzip_types":        {{Context: ctxHSL, Min: 1, Max: -1}},
+	"gzip_min_length":   {{Context: ctxHSL, Min: 1, Max: 1}},
+	"gzip_buffers":      {{Context: ctxHSL, Min: 2, Max: 2}},
+	"gzip_http_version": {{Context: ctxHSL, Min: 1, Max: 1}},
+	"gzip_disable":      {{Context: ctxHSL, Min: 1, Max: -1}},
+	"gzip_static":       {{Context: ctxHSL, Min: 1, Max: 1}},
+
+	// ssl
+	"ssl":                       {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"http2":                     {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_certificate":           {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_certificate_key":       {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_password_file":         {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_protocols":             {{Context: ctxHTTPServer, Min: 1, Max: -1}},
+	"ssl_ciphers":               {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_prefer_server_ciphers": {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_session_cache":         {{Context: ctxHTTPServer, Min: 1, Max: 2}},
+	"ssl_session_timeout":       {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_session_tickets":       {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_dhparam":               {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_ecdh_curve":            {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_buffer_size":           {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_stapling":              {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_stapling_verify":       {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_trusted_certificate":   {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_client_certificate":    {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_verify_client":         {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_verify_depth":          {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+	"ssl_early_data":            {{Context: ctxHTTPServer, Min: 1, Max: 1}},
+
+	// 反向代理
+	"proxy_pass":                    {{Context: ctxLocIf | ctxLimitExcept | ctxStreamServer, Min: 1, Max: 1}},
+	"proxy_set_header":              {{Context: ctxHSL, Min: 2, Max: 2}},
+	"proxy_http_version":            {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_connect_timeout":         {{Context: ctxHSL | ctxStreams, Min: 1, Max: 1}},
+	"proxy_read_timeout":            {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_send_timeout":            {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_buffering":               {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_buffer_size":             {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_buffers":                 {{Context: ctxHSL, Min: 2, Max: 2}},
+	"proxy_busy_buffers_size":       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_request_buffering":       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_max_temp_file_size":      {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_temp_path":               {{Context: ctxHSL, Min: 1, Max: 4}},
+	"proxy_redirect":                {{Context: ctxHSL, Min: 1, Max: 2}},
+	"proxy_next_upstream":           {{Context: ctxHSL | ctxStreams, Min: 1, Max: -1}},
+	"proxy_next_upstream_tries":     {{Context: ctxHSL | ctxStreams, Min: 1, Max: 1}},
+	"proxy_next_upstream_timeout":   {{Context: ctxHSL | ctxStreams, Min: 1, Max: 1}},
+	"proxy_intercept_errors":        {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_hide_header":             {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_pass_header":             {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_ignore_headers":          {{Context: ctxHSL, Min: 1, Max: -1}},
+	"proxy_pass_request_body":       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_pass_request_headers":    {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_set_body":                {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_method":                  {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cookie_domain":           {{Context: ctxHSL, Min: 1, Max: 2}},
+	"proxy_cookie_path":             {{Context: ctxHSL, Min: 1, Max: 2}},
+	"proxy_ssl_server_name":         {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_ssl_name":                {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_ssl_verify":              {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_ssl_protocols":           {{Context: ctxHSL, Min: 1, Max: -1}},
+	"proxy_ssl_trusted_certificate": {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_ssl_certificate":         {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_ssl_certificate_key":     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cache_path":              {{Context: ctxHTTP, Min: 2, Max: -1}},
+	"proxy_cache":                   {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cache_key":               {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cache_valid":             {{Context: ctxHSL, Min: 1, Max: -1}},
+	"proxy_cache_methods":           {{Context: ctxHSL, Min: 1, Max: -1}},
+	"proxy_cache_min_uses":          {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cache_bypass":            {{Context: ctxHSL, Min: 1, Max: -1}},
+	"proxy_no_cache":                {{Context: ctxHSL, Min: 1, Max: -1}},
+	"proxy_cache_use_stale":         {{Context: ctxHSL, Min: 1, Max: -1}},
+	"proxy_cache_background_update": {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cache_lock":              {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cache_lock_timeout":      {{Context: ctxHSL, Min: 1, Max: 1}},
+	"proxy_cache_revalidate":        {{Context: ctxHSL, Min: 1, Max: 1}},
+
+	// fastcgi / uwsgi / grpc
+	"fastcgi_pass":             {{Context: ctxLocIf, Min: 1, Max: 1}},
+	"fastcgi_param":            {{Context: ctxHSL, Min: 2, Max: 3}},
+	"fastcgi_index":            {{Context: ctxHSL, Min: 1, Max: 1}},
+	"fastcgi_split_path_info":  {{Context: ctxLocation, Min: 1, Max: 1}},
+	"fastcgi_read_timeout":     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"fastcgi_connect_timeout":  {{Context: ctxHSL, Min: 1, Max: 1}},
+	"fastcgi_send_timeout":     {{Context: ctxHSL, Min: 1, Max: 1}},
+	"fastcgi_buffers":          {{Context: ctxHSL, Min: 2, Max: 2}},
+	"fastcgi_buffer_size":      {{Context: ctxHSL, Min: 1, Max: 1}},
+	"fastcgi_intercept_errors": {{Context: ctxHSL, Min: 1, Max: 1}},
+	"uwsgi_pass":               {{Context: ctxLocIf, Min: 1, Max: 1}},
+	"uwsgi_param":              {{Context: ctxHSL, Min: 2, Max: 3}},
+	"uwsgi_read_timeout":       {{Context: ctxHSL, Min: 1, Max: 1}},
+	"scgi_pass":                {{Context: ctxLocIf, Min: 1, Max: 1}},
+	"scgi_param":               {{Context: ctxHSL, Min: 2, Max: 3}},
+	"grpc_pass":                {{Context: ctxLocIf, Min: 1, Max: 1}},
+	"grpc_set_header":          {{Context: ctxHSL, Min: 2, Max: 2}},
+	"grpc_read_timeout":        {{Context: ctxHSL, Min: 1, Max: 1}},
+	"grpc_send_timeout":        {{Context: ctxHSL, Min: 1, Max: 1}},
+	"grpc_connect_timeout":     {{Context: ctxHSL, Min: 1, Max: 1}},
+
+	// 内容替换
+	"sub_filter":       {{Context: ctxHSL, Min: 2, Max: 2}},
+	"sub_filter_once":  {{Context: ctxHSL, Min: 1, Max: 1}},
+	"sub_filter_types": {{Context: ctxHSL, Min: 1, Max: -1}},
+
+	// upstream
+	"least_conn":     {{Context: ctxUpstream | ctxStreamUpstream, Min: 0, Max: 0}},
+	"ip_hash":        {{Context: ctxUpstream, Min: 0, Max: 0}},
+	"hash":           {{Context: ctxUpstream | ctxStreamUpstream, Min: 1, Max: 2}},
+	"random":         {{Context: ctxUpstream | ctxStreamUpstream, Min: 0, Max: 2}},
+	"keepalive":      {{Context: ctxUpstream, Min: 1, Max: 1}},
+	"keepalive_time": {{Context: ctxHSL | ctxUpstream, Min: 1, Max: 1}},
+	"zone":           {{Context: ctxUpstream | ctxStreamUpstream, Min: 1, Max: 2}},
+
+	// stream 四层代理
+	"proxy_timeout":   {{Context: ctxStreams, Min: 1, Max: 1}},
+	"proxy_protocol":  {{Context: ctxStreams, Min: 1, Max: 1}},
+	"proxy_responses": {{Context: ctxStreams, Min: 1, Max: 1}},
+}
+
+// nginxValidationError 配置校验错误。File 为空表示生成的配置文件，否则为出错的配置片段
+type nginxValidationError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e nginxValidationError) String() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s 第 %d 行: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("第 %d 行: %s", e.Line, e.Message)
+}
+
+// nginxValidator 对解析后的指令树进行语义校验
+type nginxValidator struct {
+	errors        []nginxValidationError
+	warnings      []nginxValidationError // 不阻止保存的提示，如第三方模块的未知指令
+	upstreams     map[string]bool
+	refs          []*nginxDirective
+	skipUnknown   bool // 加载了动态模块时无法确定全部指令，不报告未知指令
+	listenNames   map[string]int
+	defaultListen map[string]int
+	httpListens   map[string]int // http server 的监听地址及所在行
+	streamUps     map[string]bool
+	streamRefs    []*nginxDirective
+	streamListens []*nginxDirective
+}
+
+func (v *nginxValidator) addError(d *nginxDirective, format string, args ...interface{}) {
+	v.errors = append(v.errors, nginxValidationError{Line: d.Line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *nginxValidator) addWarning(d *nginxDirective, format string, args ...interface{}) {
+	v.warnings = append(v.warnings, nginxValidationError{Line: d.Line, Message: fmt.Sprintf(format, args...)})
+}
+
+// nginxContextName 上下文名称
+func nginxContextName(ctx int) string {
+	if name, ok := nginxContextNames[ctx]; ok {
+		return name
+	}
+	return "未知"
+}
+
+// checkBlock 校验块中的指令
+func (v *nginxValidator) checkBlock(block []*nginxDirective, ctx int) {
+	for _, d := range block {
+		v.checkDirective(d, ctx)
+	}
+}
+
+// checkDirective 校验指令名称、上下文、参数个数，并递归校验块内容
+func (v *nginxValidator) checkDirective(d *nginxDirective, ctx int) {
+	specs, known := nginxDirectiveSpecs[d.Name]
+	if !known {
+		// 第三方模块（lua、brotli、headers-more 等）或较新版本的指令无法穷举，只提示不报错
+		if !v.skipUnknown {
+			v.addWarning(d, "未知指令 %q", d.Name)
+		}
+		if d.Block != nil {
+			v.checkBlock(d.Block, ctx)
+		}
+		return
+	}
+
+	var spec *nginxDirectiveSpec
+	for i := range specs {
+		if specs[i].Context&ctx != 0 {
+			spec = &specs[i]
+			break
+		}
+	}
+	if spec == nil {
+		v.addError(d, "指令 %q 不能出现在 %s 上下文中", d.Name, nginxContextName(ctx))
+		return
+	}
+
+	if n := len(d.Args); n < spec.Min || (spec.Max >= 0 && n > spec.Max) {
+		v.addError(d, "指令 %q 的参数个数 %d 不正确（%s）", d.Name, n, argCountHint(spec))
+	}
+	if spec.Block && d.Block == nil {
+		v.addError(d, "指令 %q 需要块 {}", d.Name)
+		return
+	}
+	if !spec.Block && d.Block != nil {
+		v.addError(d, "指令 %q 不能带块 {}", d.Name)
+		return
+	}
+
+	switch d.Name {
+	case "upstream":
+		if ctx == ctxStream {
+			v.streamUps[d.arg(0)] = true
+		} else {
+			v.upstreams[d.arg(0)] = true
+		}
+	case "proxy_pass", "fastcgi_pass", "uwsgi_pass", "scgi_pass", "grpc_pass":
+		if ctx == ctxStreamServer {
+			v.streamRefs = append(v.streamRefs, d)
+		} else {
+			v.refs = append(v.refs, d)
+		}
+	case "listen":
+		if ctx == ctxStreamServer {
+			v.streamListens = append(v.streamListens, d)
+		}
+	case "server":
+		if ctx == ctxHTTP {
+			v.checkServerNames(d)
+		}
+	}
+
+	if spec.Block && !spec.Raw {
+		v.checkBlock(d.Block, spec.Child)
+	}
+}
+
+// argCountHint 参数个数说明
+func argCountHint(spec *nginxDirectiveSpec) string {
+	switch {
+	case spec.Max < 0:
+		return fmt.Sprintf("至少 %d 个", spec.Min)
+	case spec.Min == spec.Max:
+		return fmt.Sprintf("应为 %d 个", spec.Min)
+	default:
+		return fmt.Sprintf("应为 %d 到 %d 个", spec.Min, spec.Max)
+	}
+}
+
+// normalizeListen 规范化 listen 地址，便于判断不同写法的同一监听地址
+func normalizeListen(addr string) string {
+	if strings.HasPrefix(addr, "unix:") {
+		return addr
+	}
+	if isAllDigits(addr) {
+		return "*:" + addr
+	}
+	if strings.HasPrefix(addr, "0.0.0.0:") {
+		return "*:" + strings.TrimPrefix(addr, "0.0.0.0:")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return addr + ":80"
+	}
+	return addr
+}
+
+// isAllDigits 判断字符串是否全为数字
+func isAllDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkServerNames 检查不同 server 块之间重复的 listen 与 server_name 组合，以及重复的 default_server
+func (v *nginxValidator) checkServerNames(server *nginxDirective) {
+	var listens []string
+	for _, l := range findDirectives(server.Block, "listen") {
+		if len(l.Args) == 0 {
+			continue
+		}
+		addr := normalizeListen(l.arg(0))
+		listens = append(listens, addr)
+		for _, a := range l.Args[1:] {
+			if a != "default_server" && a != "default" {
+				continue
+			}
+			if line, ok := v.defaultListen[addr]; ok {
+				v.addError(l, "listen %s 的 default_server 重复（第 %d 行已定义）", addr, line)
+			} else {
+				v.defaultListen[addr] = l.Line
+			}
+		}
+	}
+	if len(listens) == 0 {
+		listens = []string{"*:80"}
+	}
+	for _, addr := range listens {
+		if _, ok := v.httpListens[addr]; !ok {
+			v.httpListens[addr] = server.Line
+		}
+	}
+
+	names := []string{""}
+	nameLine := server.Line
+	if found := findDirectives(server.Block, "server_name"); len(found) > 0 {
+		names = nil
+		for _, d := range found {
+			names = append(names, d.Args...)
+			nameLine = d.Line
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, addr := range listens {
+		for _, name := range names {
+			key := addr + " " + strings.ToLower(name)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if line, ok := v.listenNames[key]; ok {
+				v.errors = append(v.errors, nginxValidationError{
+					Line:    nameLine,
+					Message: fmt.Sprintf("listen %s 与 server_name %q 的组合重复（第 %d 行的 server 已定义）", addr, name, line),
+				})
+				continue
+			}
+			v.listenNames[key] = server.Line
+		}
+	}
+}
+
+// isDirectUpstreamHost 判断代理目标是否为直接地址（带端口、IP、域名、localhost 或 unix socket），而非 upstream 名称
+func isDirectUpstreamHost(host string) bool {
+	return strings.ContainsAny(host, ".:[") || host == "localhost" || net.ParseIP(host) != nil ||
+		strings.HasPrefix(host, "unix:")
+}
+
+// checkUpstreamRefs 检查代理指令引用的 upstream 是否已定义
+func (v *nginxValidator) checkUpstreamRefs() {
+	for _, d := range v.refs {
+		host := proxyPassHost(d.arg(0))
+		if host == "" || v.upstreams[host] || isDirectUpstreamHost(host) {
+			continue
+		}
+		v.addError(d, "%s 引用了未定义的 upstream: %s", d.Name, host)
+	}
+}
+
+// checkStreamRefs 检查 stream proxy_pass 引用的 stream upstream 是否已定义（stream 与 http 的 upstream 互不可见）
+func (v *nginxValidator) checkStreamRefs() {
+	for _, d := range v.streamRefs {
+		target := d.arg(0)
+		if strings.Contains(target, "$") || v.streamUps[target] || isDirectUpstreamHost(target) {
+			continue
+		}
+		v.addError(d, "proxy_pass 引用了未定义的 stream upstream: %s", target)
+	}
+}
+
+// listenConflict 判断两个规范化的监听地址是否占用同一端口
+func listenConflict(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	return hostA == hostB || hostA == "*" || hostB == "*"
+}
+
+// checkStreamListens 检查 TCP stream server 的监听端口是否与 http server 冲突
+func (v *nginxValidator) checkStreamListens() {
+	for _, l := range v.streamListens {
+		if len(l.Args) == 0 {
+			continue
+		}
+		udp := false
+		for _, a := range l.Args[1:] {
+			udp = udp || a == "udp"
+		}
+		if udp {
+			continue
+		}
+		addr := normalizeListen(l.arg(0))
+		for httpAddr, line := range v.httpListens {
+			if listenConflict(addr, httpAddr) {
+				v.addError(l, "stream listen %s 与第 %d 行 http server 的监听地址 %s 冲突", addr, line, httpAddr)
+				break
+			}
+		}
+	}
+}
+
+// validateNginxContent 校验完整的配置内容，返回错误与警告；vhost 为 true 时内容位于 http 上下文（include 文件）
+func validateNginxContent(content string, vhost bool) (errs, warnings []nginxValidationError) {
+	directives, err := parseNginxConfig(content, "")
+	if err != nil {
+		var perr *nginxParseError
+		if errors.As(err, &perr) {
+			return []nginxValidationError{{Line: perr.Line, Message: perr.Msg}}, nil
+		}
+		return []nginxValidationError{{Message: err.Error()}}, nil
+	}
+
+	v := &nginxValidator{
+		upstreams:     map[string]bool{},
+		listenNames:   map[string]int{},
+		defaultListen: map[string]int{},
+		httpListens:   map[string]int{},
+		streamUps:     map[string]bool{},
+		skipUnknown:   len(findDirectives(directives, "load_module")) > 0,
+	}
+	ctx := ctxMain
+	if vhost {
+		ctx = ctxHTTP
+	}
+	v.checkBlock(directives, ctx)
+	v.checkUpstreamRefs()
+	v.checkStreamRefs()
+	v.checkStreamListens()
+	return v.errors, v.warnings
+}
+
+// validateNginxFragments 单独解析各自定义配置片段，使括号、引号不匹配的错误定位到片段自身的行号
+func validateNginxFragments(cfg *models.NginxConfig) []nginxValidationError {
+	fragments := []struct {
+		name    string
+		content string
+	}{
+		{"main_custom_config", cfg.MainCustomConfig},
+		{"custom_config", cfg.CustomConfig},
+		{"server_custom_config", cfg.ServerCustomConfig},
+	}
+	for _, loc := range cfg.Locations {
+		fragments = append(fragments, struct {
+			name    string
+			content string
+		}{fmt.Sprintf("location %s 的 custom_config", loc.Path), loc.CustomConfig})
+	}
+
+	var errs []nginxValidationError
+	for _, f := range fragments {
+		if strings.TrimSpace(f.content) == "" {
+			continue
+		}
+		if _, err := parseNginxConfig(f.content, f.name); err != nil {
+			var perr *nginxParseError
+			if errors.As(err, &perr) {
+				errs = append(errs, nginxValidationError{File: f.name, Line: perr.Line, Message: perr.Msg})
+			} else {
+				errs = append(errs, nginxValidationError{File: f.name, Message: err.Error()})
+			}
+		}
+	}
+	return errs
+}
+
+// validateNginxConfig 生成配置并离线校验语法，返回生成的内容与带行号的错误、警告列表
+func validateNginxConfig(cfg *models.NginxConfig) (string, []nginxValidationError, []nginxValidationError, error) {
+	content, err := generateNginxConfig(cfg)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if errs := validateNginxFragments(cfg); len(errs) > 0 {
+		return content, errs, nil, nil
+	}
+	errs, warnings := validateNginxContent(content, isNginxVhost(cfg))
+	return content, errs, warnings, nil
+}
+
+// checkNginxConfig 保存前离线校验配置，失败时返回 400 及带行号的错误列表与生成的内容；警告不阻止保存
+func checkNginxConfig(c *gin.Context, cfg *models.NginxConfig) bool {
+	content, errs, _, err := validateNginxConfig(cfg)
+	if err != nil {
+		response.BadRequest(c, "生成配置失败: "+err.Error())
+		return false
+	}
+	if len(errs) > 0 {
+		response.ErrorWithData(c, http.StatusBadRequest, "配置校验失败: "+errs[0].String(), gin.H{
+			"errors":  errs,
+			"content": content,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestValidateNginxConfig(t *testing.T) {
+	base := func() *models.NginxConfig {
+		return &models.NginxConfig{
+			Name:              "site",
+			Mode:              nginxModeMain,
+			WorkerProcesses:   "auto",
+			WorkerConnections: 1024,
+			EnableHTTP:        true,
+			HTTPPort:          80,
+			EnableHTTPS:       true,
+			HTTPSPort:         443,
+			ServerName:        "example.com",
+			RootPath:          "/srv/www",
+			IndexFiles:        "index.html",
+			AccessLogPath:     "/var/log/nginx/access.log",
+			ErrorLogPath:      "/var/log/nginx/error.log",
+			LogFormat:         "json",
+			ClientMaxBodySize: "10m",
+			Gzip:              true,
+			Upstreams:         []models.NginxUpstream{{Name: "api", Servers: `[{"address":"10.0.0.1:8080"}]`, Keepalive: 8}},
+			Locations: []models.NginxLocation{
+				{Path: "/api/", HandlerType: "proxy", ProxyPass: "http://api"},
+				{Path: "/old", MatchType: "exact", HandlerType: "redirect", RedirectURL: "/new", RedirectCode: 301},
+			},
+		}
+	}
+
+	t.Run("生成的配置通过校验", func(t *testing.T) {
+		_, errs, warnings, err := validateNginxConfig(base())
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+		assert.Empty(t, warnings)
+
+		vhost := base()
+		vhost.Mode = nginxModeVhost
+		_, errs, _, err = validateNginxConfig(vhost)
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+	})
+
+	t.Run("自定义配置片段的错误带行号", func(t *testing.T) {
+		cfg := base()
+		cfg.ServerCustomConfig = "add_header X-A 1;\nlocation /x {\n    return 200;\n"
+		_, errs, _, err := validateNginxConfig(cfg)
+		assert.NoError(t, err)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, "server_custom_config", errs[0].File)
+			assert.Equal(t, 2, errs[0].Line)
+		}
+	})
+
+	t.Run("指令、上下文、参数与引用检查", func(t *testing.T) {
+		cfg := base()
+		cfg.CustomConfig = "    server {\n        listen 80;\n        server_name example.com;\n        proxy_pass http://missing;\n        foo_bar on;\n        listen;\n    }\n    worker_connections 10;"
+		content, errs, warnings, err := validateNginxConfig(cfg)
+		assert.NoError(t, err)
+
+		var messages []string
+		for _, e := range errs {
+			assert.Greater(t, e.Line, 0)
+			messages = append(messages, e.Message)
+		}
+		assert.Contains(t, messages, `指令 "proxy_pass" 不能出现在 server 上下文中`)
+		assert.Contains(t, messages, `指令 "listen" 的参数个数 0 不正确（至少 1 个）`)
+		assert.Contains(t, messages, `指令 "worker_connections" 不能出现在 http 上下文中`)
+		assert.Contains(t, content, "server_name example.com;")
+
+		assert.Contains(t, errs[0].Message, `listen *:80 与 server_name "example.com" 的组合重复`)
+
+		// 未知指令只作为警告，不阻止保存
+		assert.NotContains(t, messages, `未知指令 "foo_bar"`)
+		if assert.Len(t, warnings, 1) {
+			assert.Equal(t, `未知指令 "foo_bar"`, warnings[0].Message)
+			assert.Greater(t, warnings[0].Line, 0)
+		}
+	})
+
+	t.Run("未定义的 upstream 与括号不匹配", func(t *testing.T) {
+		errs, _ := validateNginxContent("server {\n    location / {\n        proxy_pass http://backend/;\n    }\n}\n", true)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, 3, errs[0].Line)
+			assert.Contains(t, errs[0].Message, "backend")
+		}
+
+		errs, _ = validateNginxContent("events {\n}\nhttp {\n    server {\n", false)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, 4, errs[0].Line)
+		}
+
+		// 加载动态模块后不报告未知指令
+		errs, warnings := validateNginxContent("load_module modules/ngx_http_lua_module.so;\nevents {}\nhttp { lua_shared_dict cache 10m; }\n", false)
+		assert.Empty(t, errs)
+		assert.Empty(t, warnings)
+
+		// 第三方模块指令不阻止保存
+		errs, warnings = validateNginxContent("server {\n    listen 443 quic;\n    http3 on;\n    more_set_headers \"Server: x\";\n    content_by_lua_block {\n        ngx.say(1)\n    }\n}\n", true)
+		assert.Empty(t, errs)
+		assert.Len(t, warnings, 3)
+	})
+}
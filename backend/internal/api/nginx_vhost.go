@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// 配置模式
+const (
+	nginxModeMain  = "main"  // 完整 nginx.conf
+	nginxModeVhost = "vhost" // 虚拟主机，include 目录下的独立文件
+)
+
+// defaultNginxIncludeDir 虚拟主机默认部署目录
+const defaultNginxIncludeDir = "/etc/nginx/conf.d"
+
+// vhostFileNamePattern 虚拟主机文件名中不允许的字符
+var vhostFileNamePattern = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// vhostFileNameValid 合法的虚拟主机文件名
+var vhostFileNameValid = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\.conf$`)
+
+// isNginxVhost 判断配置是否为虚拟主机模式
+func isNginxVhost(cfg *models.NginxConfig) bool {
+	return cfg.Mode == nginxModeVhost
+}
+
+// vhostFileName 根据配置名称生成虚拟主机文件名
+func vhostFileName(name string) string {
+	file := strings.Trim(vhostFileNamePattern.ReplaceAllString(name, "_"), "._")
+	if file == "" {
+		file = "vhost"
+	}
+	return file + ".conf"
+}
+
+// vhostTargetName 虚拟主机部署的文件名
+func vhostTargetName(cfg *models.NginxConfig) string {
+	if cfg.FileName != "" {
+		return cfg.FileName
+	}
+	return vhostFileName(cfg.Name)
+}
+
+// validateNginxMode 校验配置模式、include 目录与虚拟主机文件名
+func validateNginxMode(mode, includeDir, fileName string) error {
+	switch mode {
+	case "", nginxModeMain, nginxModeVhost:
+	default:
+		return fmt.Errorf("配置模式 %q 不支持", mode)
+	}
+	if includeDir != "" && (!path.IsAbs(includeDir) || strings.ContainsAny(includeDir, " \t;{}*\"'")) {
+		return fmt.Errorf("include 目录 %q 必须是不含空白和特殊字符的绝对路径", includeDir)
+	}
+	if fileName != "" && !vhostFileNameValid.MatchString(fileName) {
+		return fmt.Errorf("虚拟主机文件名 %q 不合法，只能包含字母、数字、下划线、点和横线，并以 .conf 结尾", fileName)
+	}
+	return nil
+}
+
+// nginxConfigTargetPath 配置的默认部署路径：vhost 部署到 include 目录下的 <名称>.conf
+func nginxConfigTargetPath(cfg *models.NginxConfig) string {
+	if isNginxVhost(cfg) {
+		return path.Join(defaultString(cfg.IncludeDir, defaultNginxIncludeDir), vhostTargetName(cfg))
+	}
+	return "/etc/nginx/nginx.conf"
+}
+
+// nginxConfigTargetFile 将目录形式的目标路径补全为文件路径
+func nginxConfigTargetFile(cfg *models.NginxConfig, target string) string {
+	if strings.HasSuffix(target, ".conf") {
+		return target
+	}
+	if isNginxVhost(cfg) {
+		return path.Join(target, vhostTargetName(cfg))
+	}
+	return path.Join(target, "nginx.conf")
+}
+
+// defaultNginxDeployTargetPath 部署记录未指定目标路径时按关联配置确定默认路径
+func defaultNginxDeployTargetPath(configID uint) string {
+	var cfg models.NginxConfig
+	if err := db.DB.Select("id", "name", "mode", "include_dir", "file_name").First(&cfg, configID).Error; err != nil {
+		return "/etc/nginx/nginx.conf"
+	}
+	return nginxConfigTargetPath(&cfg)
+}
+
+// nginxTestCommand 测试完整配置树的命令。include 文件不能单独测试，需通过主配置测试。
+func nginxTestCommand(nginxPath string, cfg *models.NginxConfig, targetFile, mainConfigPath string) string {
+	if !isNginxVhost(cfg) {
+		return nginxPath + " -t -c " + targetFile
+	}
+	if mainConfigPath != "" {
+		return nginxPath + " -t -c " + mainConfigPath
+	}
+	return nginxPath + " -t"
+}
+
+// restoreIncludeFileCommand 测试失败时恢复 include 文件：有备份则还原，否则删除新文件，
+// 避免错误的虚拟主机影响同一服务器上其他配置的重载
+func restoreIncludeFileCommand(targetFile, backupPath string) string {
+	if backupPath != "" {
+		return fmt.Sprintf("cp %s %s", backupPath, targetFile)
+	}
+	return "rm -f " + targetFile
+}
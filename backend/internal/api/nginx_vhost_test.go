@@ -0,0 +1,48 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestNginxVhost(t *testing.T) {
+	vhost := &models.NginxConfig{
+		Name:              "team a/shop",
+		Mode:              nginxModeVhost,
+		EnableHTTP:        true,
+		HTTPPort:          8080,
+		ServerName:        "shop.example.com",
+		AccessLogPath:     "/var/log/nginx/shop.log",
+		ClientMaxBodySize: "10m",
+		Locations:         []models.NginxLocation{{Path: "/", ProxyPass: "http://127.0.0.1:9000"}},
+	}
+
+	t.Run("虚拟主机只渲染 server 块", func(t *testing.T) {
+		content, err := generateNginxConfig(vhost)
+		assert.NoError(t, err)
+		assert.Contains(t, content, "server_name shop.example.com;\n        access_log /var/log/nginx/shop.log;")
+		assert.Contains(t, content, "location / {")
+		assert.NotContains(t, content, "events {")
+		assert.NotContains(t, content, "http {")
+	})
+
+	t.Run("主配置 include 虚拟主机目录", func(t *testing.T) {
+		content, err := generateNginxConfig(&models.NginxConfig{EnableHTTP: true, IncludeDir: "/etc/nginx/conf.d"})
+		assert.NoError(t, err)
+		assert.Contains(t, content, "include /etc/nginx/conf.d/*.conf;")
+		assert.Contains(t, content, "events {")
+	})
+
+	t.Run("部署路径与测试命令", func(t *testing.T) {
+		assert.Equal(t, "/etc/nginx/conf.d/team_a_shop.conf", nginxConfigTargetPath(vhost))
+		assert.Equal(t, "/srv/vhosts/team_a_shop.conf", nginxConfigTargetFile(vhost, "/srv/vhosts"))
+		assert.Equal(t, "nginx -t -c /etc/nginx/nginx.conf", nginxTestCommand("nginx", vhost, "/etc/nginx/conf.d/team_a_shop.conf", "/etc/nginx/nginx.conf"))
+		assert.Equal(t, "nginx -t", nginxTestCommand("nginx", vhost, "/etc/nginx/conf.d/team_a_shop.conf", ""))
+
+		main := &models.NginxConfig{Name: "main"}
+		assert.Equal(t, "/etc/nginx/nginx.conf", nginxConfigTargetPath(main))
+		assert.Equal(t, "nginx -t -c /opt/nginx.conf", nginxTestCommand("nginx", main, "/opt/nginx.conf", ""))
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -39,6 +40,7 @@ type UploadRequest struct {
 	Description string `form:"description"`                    // 描述
 	OSType      string `form:"os_type" binding:"required"`     // rocky, centos, openEuler
 	OSVersion   string `form:"os_version" binding:"required"`  // 9.4, 7.9
+	Arch        string `form:"arch"`                           // x86_64, aarch64，留空则从离线包识别
 }
 
 // Upload 上传离线包
@@ -58,6 +60,7 @@ func (p *PackageAPI) Upload(c *gin.Context) {
 	description := c.PostForm("description")
 	osType := c.PostForm("os_type")
 	osVersion := c.PostForm("os_version")
+	arch := c.PostForm("arch")
 
 	// 验证必填字段
 	if name == "" || version == "" || osType == "" || osVersion == "" {
@@ -72,6 +75,7 @@ func (p *PackageAPI) Upload(c *gin.Context) {
 		Description: description,
 		OSType:      osType,
 		OSVersion:   osVersion,
+		Arch:        normalizeArch(arch),
 	}
 
 	// 验证文件类型（必须是 zip）
@@ -117,12 +121,15 @@ func (p *PackageAPI) Upload(c *gin.Context) {
 		response.InternalServerError(c, "创建目标文件失败")
 		return
 	}
-	defer dst.Close()
 
 	// 计算 SHA256 并保存文件
 	hash := sha256.New()
 	writer := io.MultiWriter(dst, hash)
-	if _, err := io.Copy(writer, src); err != nil {
+	_, err = io.Copy(writer, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		logger.Errorf("保存文件失败: %v", err)
 		os.Remove(filePath) // 清理失败的文件
 		response.InternalServerError(c, "保存文件失败")
@@ -132,10 +139,21 @@ func (p *PackageAPI) Upload(c *gin.Context) {
 	// 获取文件哈希
 	fileHash := hex.EncodeToString(hash.Sum(nil))
 
-	// 检查是否已存在相同的包（相同名称、版本、OS）
+	// 未指定架构时从 metadata.json 或包内 RPM 文件名识别
+	if req.Arch == "" {
+		req.Arch = detectPackageArch(filePath)
+	}
+
+	// 检查是否已存在相同的包（相同名称、版本、OS、架构），记录架构之前上传的包架构为空，仅与同样未识别架构的包视为相同
 	var existingPkg models.MiddlewarePackage
-	result := db.DB.Where("name = ? AND version = ? AND os_type = ? AND os_version = ? AND status = 'active'",
-		req.Name, req.Version, req.OSType, req.OSVersion).First(&existingPkg)
+	query := db.DB.Where("name = ? AND version = ? AND os_type = ? AND os_version = ? AND status = 'active'",
+		req.Name, req.Version, req.OSType, req.OSVersion)
+	if req.Arch == "" {
+		query = query.Where("arch IS NULL OR arch = ''")
+	} else {
+		query = query.Where("arch = ?", req.Arch)
+	}
+	result := query.First(&existingPkg)
 
 	if result.Error == nil {
 		// 已存在，删除新上传的文件
@@ -158,6 +176,7 @@ func (p *PackageAPI) Upload(c *gin.Context) {
 		FileHash:    fileHash,
 		OSType:      req.OSType,
 		OSVersion:   req.OSVersion,
+		Arch:        req.Arch,
 		Status:      "active",
 	}
 
@@ -286,52 +305,61 @@ func (p *PackageAPI) GetMetadata(c *gin.Context) {
 		return
 	}
 
-	// 检查文件是否存在
-	if _, err := os.Stat(pkg.FilePath); os.IsNotExist(err) {
-		response.NotFound(c, "离线包文件不存在")
+	data, err := readPackageMetadata(&pkg)
+	if err != nil {
+		if os.IsNotExist(err) {
+			response.NotFound(c, "离线包文件不存在")
+			return
+		}
+		if errors.Is(err, errMetadataNotFound) {
+			response.NotFound(c, err.Error())
+			return
+		}
+		logger.Errorf("读取 metadata.json 失败: %v", err)
+		response.InternalServerError(c, "读取元数据失败")
 		return
 	}
 
-	// 打开 ZIP 文件
+	// 解析 JSON
+	var metadata models.PackageMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		logger.Errorf("解析 metadata.json 失败: %v", err)
+		response.InternalServerError(c, "解析元数据失败")
+		return
+	}
+
+	response.Success(c, metadata)
+}
+
+// errMetadataNotFound 离线包中不含 metadata.json
+var errMetadataNotFound = errors.New("离线包中未找到 metadata.json")
+
+// readPackageMetadata 读取离线包（ZIP）中的 metadata.json 原始内容
+func readPackageMetadata(pkg *models.MiddlewarePackage) ([]byte, error) {
+	if _, err := os.Stat(pkg.FilePath); err != nil {
+		return nil, err
+	}
+
 	zipReader, err := zip.OpenReader(pkg.FilePath)
 	if err != nil {
-		logger.Errorf("打开 ZIP 文件失败: %v", err)
-		response.InternalServerError(c, "打开离线包失败")
-		return
+		return nil, fmt.Errorf("打开离线包失败: %v", err)
 	}
 	defer zipReader.Close()
 
 	// 查找 metadata.json 文件
-	var metadataFile *zip.File
 	for _, file := range zipReader.File {
-		if strings.HasSuffix(file.Name, "metadata.json") {
-			metadataFile = file
-			break
+		if !strings.HasSuffix(file.Name, "metadata.json") {
+			continue
 		}
-	}
 
-	if metadataFile == nil {
-		response.NotFound(c, "离线包中未找到 metadata.json")
-		return
-	}
-
-	// 读取 metadata.json 内容
-	rc, err := metadataFile.Open()
-	if err != nil {
-		logger.Errorf("打开 metadata.json 失败: %v", err)
-		response.InternalServerError(c, "读取元数据失败")
-		return
-	}
-	defer rc.Close()
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("打开 metadata.json 失败: %v", err)
+		}
+		defer rc.Close()
 
-	// 解析 JSON
-	var metadata models.PackageMetadata
-	decoder := json.NewDecoder(rc)
-	if err := decoder.Decode(&metadata); err != nil {
-		logger.Errorf("解析 metadata.json 失败: %v", err)
-		response.InternalServerError(c, "解析元数据失败")
-		return
+		return io.ReadAll(rc)
 	}
 
-	response.Success(c, metadata)
+	return nil, errMetadataNotFound
 }
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// archCommand 获取 CPU 架构的命令
+const archCommand = "uname -m"
+
+// normalizeArch 统一架构名称：amd64/x64 → x86_64，arm64 → aarch64
+func normalizeArch(arch string) string {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	switch arch {
+	case "amd64", "x64", "x86-64":
+		return "x86_64"
+	case "arm64", "armv8":
+		return "aarch64"
+	case "noarch", "any", "all":
+		return ""
+	}
+	return arch
+}
+
+// archMatches 判断离线包架构与服务器架构是否匹配，任一方未知时视为匹配
+func archMatches(pkgArch, serverArch string) bool {
+	pkgArch, serverArch = normalizeArch(pkgArch), normalizeArch(serverArch)
+	return pkgArch == "" || serverArch == "" || pkgArch == serverArch
+}
+
+// archMismatchReason 架构不匹配的说明
+func archMismatchReason(pkgArch, serverArch string) string {
+	return fmt.Sprintf("离线包架构 %s 与服务器架构 %s 不一致", normalizeArch(pkgArch), normalizeArch(serverArch))
+}
+
+// detectPackageArch 从离线包中识别 CPU 架构：优先读取 metadata.json 的 arch 字段，
+// 其次根据包内 RPM 文件名（*.x86_64.rpm / *.aarch64.rpm）推断；同时包含多种架构时视为通用。
+func detectPackageArch(zipPath string) string {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return ""
+	}
+	defer zipReader.Close()
+
+	found := map[string]bool{}
+	for _, file := range zipReader.File {
+		name := path.Base(file.Name)
+
+		if name == "metadata.json" {
+			if arch := metadataArch(file); arch != "" {
+				return arch
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(name, ".rpm") {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(name, ".rpm"), ".")
+		if arch := normalizeArch(parts[len(parts)-1]); arch == "x86_64" || arch == "aarch64" {
+			found[arch] = true
+		}
+	}
+
+	if len(found) != 1 {
+		return ""
+	}
+	for arch := range found {
+		return arch
+	}
+	return ""
+}
+
+// metadataArch 读取 metadata.json 中声明的架构
+func metadataArch(file *zip.File) string {
+	rc, err := file.Open()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+
+	var metadata struct {
+		Arch         string `json:"arch"`
+		Architecture string `json:"architecture"`
+	}
+	if err := json.NewDecoder(rc).Decode(&metadata); err != nil {
+		return ""
+	}
+	return normalizeArch(defaultString(metadata.Arch, metadata.Architecture))
+}
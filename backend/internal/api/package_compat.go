@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// osInfoCommand 获取操作系统信息的命令（同时读取 redhat-release 以获得更精确的小版本号）
+const osInfoCommand = "{ cat /etc/os-release; cat /etc/redhat-release; } 2>/dev/null || uname -a"
+
+// 操作系统兼容性状态
+const (
+	osCompatible   = "compatible"   // 兼容
+	osIncompatible = "incompatible" // 不兼容
+	osUnknown      = "unknown"      // 服务器操作系统未知
+	osUnrestricted = "unrestricted" // 离线包未声明支持的操作系统
+)
+
+// OSCompatibility 服务器与离线包的操作系统兼容性
+type OSCompatibility struct {
+	Compatible bool   `json:"compatible"`
+	Status     string `json:"status"` // compatible, incompatible, unknown, unrestricted
+	ServerOS   string `json:"server_os"`
+	Supported  string `json:"supported"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// packageSupportedOS 获取离线包支持的操作系统：优先使用 metadata.json 的 supported_os，其次使用上传时填写的 OSType/OSVersion
+func packageSupportedOS(pkg *models.MiddlewarePackage) []models.SupportedOS {
+	if data, err := readPackageMetadata(pkg); err == nil {
+		var metadata struct {
+			SupportedOS []models.SupportedOS `json:"supported_os"`
+		}
+		if json.Unmarshal(data, &metadata) == nil && len(metadata.SupportedOS) > 0 {
+			return metadata.SupportedOS
+		}
+	}
+
+	if pkg.OSType == "" {
+		return nil
+	}
+	supported := models.SupportedOS{Type: pkg.OSType}
+	if pkg.OSVersion != "" {
+		supported.Versions = []string{pkg.OSVersion}
+	}
+	return []models.SupportedOS{supported}
+}
+
+// checkOSCompatibility 检查服务器操作系统是否在离线包支持列表中
+func checkOSCompatibility(supported []models.SupportedOS, osType, osVersion string) OSCompatibility {
+	result := OSCompatibility{
+		ServerOS:  strings.TrimSpace(osType + " " + osVersion),
+		Supported: formatSupportedOS(supported),
+	}
+
+	if len(supported) == 0 {
+		result.Compatible = true
+		result.Status = osUnrestricted
+		return result
+	}
+	if osType == "" || osType == "unknown" {
+		result.Compatible = true
+		result.Status = osUnknown
+		result.Reason = "未检测到服务器操作系统，请先测试连接"
+		return result
+	}
+
+	for _, s := range supported {
+		if !strings.EqualFold(s.Type, osType) {
+			continue
+		}
+		if len(s.Versions) == 0 {
+			result.Compatible = true
+			result.Status = osCompatible
+			return result
+		}
+		for _, v := range s.Versions {
+			if osVersionMatches(v, osVersion) {
+				result.Compatible = true
+				result.Status = osCompatible
+				return result
+			}
+		}
+	}
+
+	result.Status = osIncompatible
+	result.Reason = fmt.Sprintf("服务器操作系统 %s 不在离线包支持列表（%s）中", result.ServerOS, result.Supported)
+	return result
+}
+
+// checkTargetOS 部署时实时检测目标服务器操作系统与 CPU 架构并校验兼容性，同时更新服务器记录
+func (a *DeploymentAPI) checkTargetOS(client *ssh.Client, deployment *models.Deployment, step *int) error {
+	a.addLog(deployment.ID, *step, "检查操作系统与架构兼容性", "")
+
+	server := deployment.Server
+	if output, err := a.runCommand(client, osInfoCommand); err == nil || output != "" {
+		osType, osVersion := parseOSInfo(output)
+		if osType != "unknown" && (osType != server.OSType || osVersion != server.OSVersion) {
+			server.OSType, server.OSVersion = osType, osVersion
+			db.DB.Model(&models.Server{}).Where("id = ?", server.ID).Updates(map[string]interface{}{
+				"os_type":    osType,
+				"os_version": osVersion,
+			})
+		}
+	}
+
+	if output, err := a.runCommand(client, archCommand); err == nil {
+		if arch := normalizeArch(output); arch != "" && arch != server.Arch {
+			server.Arch = arch
+			db.DB.Model(&models.Server{}).Where("id = ?", server.ID).Update("arch", arch)
+		}
+	}
+
+	compat := checkOSCompatibility(packageSupportedOS(deployment.Package), server.OSType, server.OSVersion)
+	output := fmt.Sprintf("服务器操作系统: %s (%s)\n离线包支持: %s (%s)",
+		defaultString(compat.ServerOS, "未知"), defaultString(server.Arch, "未知架构"),
+		defaultString(compat.Supported, "未声明"), defaultString(deployment.Package.Arch, "通用"))
+
+	// 架构不一致时安装必然失败，不允许忽略
+	if !archMatches(deployment.Package.Arch, server.Arch) {
+		reason := archMismatchReason(deployment.Package.Arch, server.Arch)
+		a.updateLog(deployment.ID, *step, "failed", output, reason)
+		return fmt.Errorf("架构不兼容: %s", reason)
+	}
+
+	if !compat.Compatible {
+		if !deployment.IgnoreOSCheck {
+			a.updateLog(deployment.ID, *step, "failed", output, compat.Reason)
+			return fmt.Errorf("操作系统不兼容: %s", compat.Reason)
+		}
+		output = "警告: " + compat.Reason + "，已按设置忽略检查\n" + output
+	} else if compat.Reason != "" {
+		output = "警告: " + compat.Reason + "\n" + output
+	}
+
+	a.updateLog(deployment.ID, *step, "success", output, "")
+	(*step)++
+	return nil
+}
+
+// osVersionMatches 版本号按点分前缀匹配：9 匹配 9.4，7.9 匹配只检测到主版本的 7
+func osVersionMatches(supported, actual string) bool {
+	supported = strings.ToLower(strings.TrimSpace(supported))
+	actual = strings.ToLower(strings.TrimSpace(actual))
+	if supported == "" || actual == "" {
+		return supported == actual
+	}
+	return supported == actual ||
+		strings.HasPrefix(actual, supported+".") ||
+		strings.HasPrefix(supported, actual+".")
+}
+
+// formatSupportedOS 格式化支持的操作系统列表
+func formatSupportedOS(supported []models.SupportedOS) string {
+	var parts []string
+	for _, s := range supported {
+		if len(s.Versions) == 0 {
+			parts = append(parts, s.Type)
+			continue
+		}
+		for _, v := range s.Versions {
+			parts = append(parts, s.Type+"-"+v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// osVersionIDPattern os-release 中的 VERSION_ID
+var osVersionIDPattern = regexp.MustCompile(`(?m)^VERSION_ID="?([^"\n]+)"?`)
+
+// osReleasePattern redhat-release 中的版本号，如 CentOS Linux release 7.9.2009
+var osReleasePattern = regexp.MustCompile(`release\s+([0-9]+(?:\.[0-9]+)*)`)
+
+// parseOSVersion 提取操作系统版本号，redhat-release 提供更精确的小版本时取主.次版本
+func parseOSVersion(osInfo string) string {
+	version := ""
+	if m := osVersionIDPattern.FindStringSubmatch(osInfo); m != nil {
+		version = strings.TrimSpace(m[1])
+	}
+
+	m := osReleasePattern.FindStringSubmatch(osInfo)
+	if m == nil {
+		return version
+	}
+	parts := strings.Split(m[1], ".")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	release := strings.Join(parts, ".")
+	if version == "" || (release != version && strings.HasPrefix(release, version+".")) {
+		return release
+	}
+	return version
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestPackageMetadata_LegacyFormat(t *testing.T) {
+	data := []byte(`{
+		"name": "redis",
+		"supported_os": ["rocky-9.4", "centos-7.9", {"type": "openEuler", "versions": ["22.03"]}],
+		"requirements": {"min_memory": "1GB", "min_disk": "500MB", "ports": [6379]}
+	}`)
+
+	var metadata models.PackageMetadata
+	assert.NoError(t, json.Unmarshal(data, &metadata))
+
+	assert.Len(t, metadata.SupportedOS, 3)
+	assert.Equal(t, "rocky", metadata.SupportedOS[0].Type)
+	assert.Equal(t, []string{"9.4"}, metadata.SupportedOS[0].Versions)
+	assert.Equal(t, "openEuler", metadata.SupportedOS[2].Type)
+
+	assert.Equal(t, "1GB", metadata.Requirements.Memory)
+	assert.Equal(t, "500MB", metadata.Requirements.DiskSpace)
+	assert.Equal(t, []int{6379}, metadata.Requirements.Ports)
+}
+
+func TestParseOSVersion(t *testing.T) {
+	centos7 := "NAME=\"CentOS Linux\"\nVERSION_ID=\"7\"\nCentOS Linux release 7.9.2009 (Core)\n"
+	rocky9 := "NAME=\"Rocky Linux\"\nVERSION_ID=\"9.4\"\nRocky Linux release 9.4 (Blue Onyx)\n"
+	euler := "NAME=\"openEuler\"\nVERSION_ID=\"22.03\"\n"
+
+	assert.Equal(t, "7.9", parseOSVersion(centos7))
+	assert.Equal(t, "9.4", parseOSVersion(rocky9))
+	assert.Equal(t, "22.03", parseOSVersion(euler))
+}
+
+func TestCheckOSCompatibility(t *testing.T) {
+	supported := []models.SupportedOS{
+		{Type: "rocky", Versions: []string{"9.4"}},
+		{Type: "centos", Versions: []string{"7.9"}},
+	}
+
+	assert.Equal(t, osCompatible, checkOSCompatibility(supported, "rocky", "9.4").Status)
+	assert.Equal(t, osCompatible, checkOSCompatibility(supported, "centos", "7").Status)
+	assert.Equal(t, osIncompatible, checkOSCompatibility(supported, "rocky", "8.10").Status)
+	assert.Equal(t, osIncompatible, checkOSCompatibility(supported, "openEuler", "22.03").Status)
+	assert.Equal(t, osUnknown, checkOSCompatibility(supported, "", "").Status)
+	assert.Equal(t, osUnrestricted, checkOSCompatibility(nil, "rocky", "9.4").Status)
+}
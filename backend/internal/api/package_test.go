@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/config"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// uploadPackage 以 multipart 表单上传离线包
+func uploadPackage(t *testing.T, router *gin.Engine, arch string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	fields := map[string]string{"name": "redis", "version": "7.2", "os_type": "rocky", "os_version": "9"}
+	if arch != "" {
+		fields["arch"] = arch
+	}
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	part, err := form.CreateFormFile("file", "redis.zip")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write([]byte("not a real zip"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/packages", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPackageAPI_Upload_Arch(t *testing.T) {
+	testDB := setupAPITestDB(t, &models.MiddlewarePackage{})
+	packageAPI := NewPackageAPI(&config.Config{Data: config.DataConfig{UploadDir: t.TempDir()}})
+	router := gin.New()
+	router.POST("/packages", packageAPI.Upload)
+
+	// 记录架构之前上传的包
+	legacy := &models.MiddlewarePackage{Name: "redis", Version: "7.2", OSType: "rocky", OSVersion: "9", Status: "active"}
+	testDB.Create(legacy)
+
+	t.Run("旧包未记录架构时可上传指定架构的变体", func(t *testing.T) {
+		w := uploadPackage(t, router, "x86_64")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = uploadPackage(t, router, "arm64")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("相同架构重复上传", func(t *testing.T) {
+		w := uploadPackage(t, router, "amd64")
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("未识别架构时与旧包重复", func(t *testing.T) {
+		w := uploadPackage(t, router, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "该离线包已存在")
+	})
+
+	var count int64
+	testDB.Model(&models.MiddlewarePackage{}).Count(&count)
+	assert.Equal(t, int64(3), count)
+}
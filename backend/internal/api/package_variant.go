@@ -0,0 +1,185 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+)
+
+// PackageVariant 为某台服务器选中的离线包变体
+type PackageVariant struct {
+	ServerID    uint   `json:"server_id"`
+	ServerName  string `json:"server_name"`
+	ServerOS    string `json:"server_os"`
+	ServerArch  string `json:"server_arch,omitempty"`
+	PackageID   uint   `json:"package_id,omitempty"`
+	PackageFile string `json:"package_file,omitempty"`
+	Supported   string `json:"supported,omitempty"`
+	Reason      string `json:"reason,omitempty"` // 未匹配原因
+}
+
+// variantCandidate 离线包变体及其支持的操作系统
+type variantCandidate struct {
+	pkg       models.MiddlewarePackage
+	supported []models.SupportedOS
+}
+
+// loadPackageVariants 加载同名同版本的所有离线包变体
+func loadPackageVariants(name, version string) ([]variantCandidate, error) {
+	var packages []models.MiddlewarePackage
+	if err := db.DB.Where("name = ? AND version = ? AND status = ?", name, version, "active").
+		Order("id ASC").Find(&packages).Error; err != nil {
+		return nil, err
+	}
+
+	candidates := make([]variantCandidate, 0, len(packages))
+	for _, pkg := range packages {
+		candidates = append(candidates, variantCandidate{pkg: pkg, supported: packageSupportedOS(&pkg)})
+	}
+	return candidates, nil
+}
+
+// selectPackageVariant 按服务器操作系统和 CPU 架构选择最匹配的离线包变体
+// 精确版本匹配优先于前缀匹配，前缀匹配优先于仅类型匹配；未声明支持列表的变体仅在没有其他匹配时使用。
+func selectPackageVariant(candidates []variantCandidate, server *models.Server) PackageVariant {
+	variant := PackageVariant{
+		ServerID:   server.ID,
+		ServerName: server.Name,
+		ServerOS:   strings.TrimSpace(server.OSType + " " + server.OSVersion),
+		ServerArch: server.Arch,
+	}
+
+	if len(candidates) == 0 {
+		variant.Reason = "未找到该名称和版本的离线包"
+		return variant
+	}
+	if server.OSType == "" || server.OSType == "unknown" {
+		variant.Reason = "未检测到服务器操作系统，请先测试连接"
+		return variant
+	}
+
+	var best *variantCandidate
+	bestScore := -1
+	for i := range candidates {
+		if !archMatches(candidates[i].pkg.Arch, server.Arch) {
+			continue
+		}
+		score := variantScore(candidates[i].supported, server)
+		if score < 0 {
+			continue
+		}
+		// 同等匹配度下优先架构明确一致的变体
+		score *= 2
+		if server.Arch != "" && normalizeArch(candidates[i].pkg.Arch) == normalizeArch(server.Arch) {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = &candidates[i], score
+		}
+	}
+
+	if best == nil || bestScore < 0 {
+		var supported []string
+		for _, c := range candidates {
+			if s := formatSupportedOS(c.supported); s != "" {
+				supported = append(supported, s)
+			}
+		}
+		variant.Supported = strings.Join(supported, ", ")
+		variant.Reason = "没有适用于 " + strings.TrimSpace(variant.ServerOS+" "+server.Arch) + " 的离线包变体"
+		return variant
+	}
+
+	variant.PackageID = best.pkg.ID
+	variant.PackageFile = best.pkg.FileName
+	variant.Supported = formatSupportedOS(best.supported)
+	return variant
+}
+
+// variantScore 变体与服务器的匹配度，-1 表示不兼容
+func variantScore(supported []models.SupportedOS, server *models.Server) int {
+	if len(supported) == 0 {
+		return 0
+	}
+
+	score := -1
+	for _, s := range supported {
+		if !strings.EqualFold(s.Type, server.OSType) {
+			continue
+		}
+		if len(s.Versions) == 0 {
+			score = max(score, 1)
+			continue
+		}
+		for _, v := range s.Versions {
+			switch {
+			case strings.EqualFold(v, server.OSVersion):
+				score = max(score, 3)
+			case osVersionMatches(v, server.OSVersion):
+				score = max(score, 2)
+			}
+		}
+	}
+	return score
+}
+
+// resolvePackageVariants 为每台服务器选择离线包变体，返回选择结果和未匹配的服务器
+func resolvePackageVariants(name, version string, servers []models.Server) ([]PackageVariant, []PackageVariant, error) {
+	candidates, err := loadPackageVariants(name, version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var matched, unmatched []PackageVariant
+	for i := range servers {
+		variant := selectPackageVariant(candidates, &servers[i])
+		if variant.PackageID == 0 {
+			unmatched = append(unmatched, variant)
+		} else {
+			matched = append(matched, variant)
+		}
+	}
+	return matched, unmatched, nil
+}
+
+// ResolveVariants 预览批量部署时每台服务器将使用的离线包变体
+func (p *PackageAPI) ResolveVariants(c *gin.Context) {
+	name := c.Query("name")
+	version := c.Query("version")
+	if name == "" || version == "" {
+		response.BadRequest(c, "请指定离线包名称和版本")
+		return
+	}
+
+	var serverIDs []uint
+	for _, s := range strings.Split(c.Query("server_ids"), ",") {
+		if id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32); err == nil {
+			serverIDs = append(serverIDs, uint(id))
+		}
+	}
+	if len(serverIDs) == 0 {
+		response.BadRequest(c, "请指定服务器")
+		return
+	}
+
+	var servers []models.Server
+	if err := db.DB.Where("id IN ?", serverIDs).Find(&servers).Error; err != nil {
+		response.InternalServerError(c, "查询服务器失败")
+		return
+	}
+
+	matched, unmatched, err := resolvePackageVariants(name, version, servers)
+	if err != nil {
+		response.InternalServerError(c, "查询离线包失败")
+		return
+	}
+
+	response.Success(c, gin.H{
+		"matched":   matched,
+		"unmatched": unmatched,
+	})
+}
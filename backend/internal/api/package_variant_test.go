@@ -0,0 +1,147 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// testVariant 构造离线包变体，supported 为空时表示未声明支持列表
+func testVariant(id uint, arch string, supported ...models.SupportedOS) variantCandidate {
+	return variantCandidate{
+		pkg:       models.MiddlewarePackage{ID: id, FileName: "nginx.zip", Arch: arch},
+		supported: supported,
+	}
+}
+
+func TestVariantScore(t *testing.T) {
+	server := &models.Server{OSType: "rocky", OSVersion: "9.4"}
+
+	tests := []struct {
+		name      string
+		supported []models.SupportedOS
+		want      int
+	}{
+		{"未声明支持列表", nil, 0},
+		{"版本精确匹配", []models.SupportedOS{{Type: "rocky", Versions: []string{"9.4"}}}, 3},
+		{"版本前缀匹配", []models.SupportedOS{{Type: "rocky", Versions: []string{"9"}}}, 2},
+		{"仅类型匹配", []models.SupportedOS{{Type: "Rocky"}}, 1},
+		{"取多个条目中的最高匹配度", []models.SupportedOS{{Type: "rocky", Versions: []string{"8", "9"}}, {Type: "rocky", Versions: []string{"9.4"}}}, 3},
+		{"版本不匹配", []models.SupportedOS{{Type: "rocky", Versions: []string{"8.10"}}}, -1},
+		{"类型不匹配", []models.SupportedOS{{Type: "centos", Versions: []string{"9.4"}}}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, variantScore(tt.supported, server))
+		})
+	}
+}
+
+func TestSelectPackageVariant(t *testing.T) {
+	rocky := func(versions ...string) models.SupportedOS {
+		return models.SupportedOS{Type: "rocky", Versions: versions}
+	}
+	x86Rocky94 := &models.Server{ID: 1, Name: "web01", OSType: "rocky", OSVersion: "9.4", Arch: "x86_64"}
+
+	tests := []struct {
+		name       string
+		candidates []variantCandidate
+		server     *models.Server
+		wantID     uint
+		wantReason string
+	}{
+		{
+			name:       "精确版本优先于前缀匹配",
+			candidates: []variantCandidate{testVariant(1, "", rocky("9")), testVariant(2, "", rocky("9.4"))},
+			server:     x86Rocky94,
+			wantID:     2,
+		},
+		{
+			name:       "前缀匹配优先于仅类型匹配",
+			candidates: []variantCandidate{testVariant(1, "", rocky()), testVariant(2, "", rocky("9"))},
+			server:     x86Rocky94,
+			wantID:     2,
+		},
+		{
+			name:       "仅类型匹配优先于未声明支持列表的变体",
+			candidates: []variantCandidate{testVariant(1, ""), testVariant(2, "", rocky())},
+			server:     x86Rocky94,
+			wantID:     2,
+		},
+		{
+			name:       "没有其他匹配时使用未声明支持列表的变体",
+			candidates: []variantCandidate{testVariant(1, "", rocky("8")), testVariant(2, "")},
+			server:     x86Rocky94,
+			wantID:     2,
+		},
+		{
+			name:       "同等匹配度下优先架构明确一致的变体",
+			candidates: []variantCandidate{testVariant(1, "", rocky("9.4")), testVariant(2, "amd64", rocky("9.4"))},
+			server:     x86Rocky94,
+			wantID:     2,
+		},
+		{
+			name:       "架构一致不能弥补更低的系统匹配度",
+			candidates: []variantCandidate{testVariant(1, "", rocky("9.4")), testVariant(2, "x86_64", rocky("9"))},
+			server:     x86Rocky94,
+			wantID:     1,
+		},
+		{
+			name:       "服务器架构未知时同等匹配度取先上传的变体",
+			candidates: []variantCandidate{testVariant(1, "x86_64", rocky("9.4")), testVariant(2, "aarch64", rocky("9.4"))},
+			server:     &models.Server{ID: 1, Name: "web01", OSType: "rocky", OSVersion: "9.4"},
+			wantID:     1,
+		},
+		{
+			name:       "跳过架构不一致的变体",
+			candidates: []variantCandidate{testVariant(1, "aarch64", rocky("9.4")), testVariant(2, "x86_64", rocky())},
+			server:     x86Rocky94,
+			wantID:     2,
+		},
+		{
+			name:       "操作系统类型不区分大小写",
+			candidates: []variantCandidate{testVariant(1, "", models.SupportedOS{Type: "openEuler", Versions: []string{"22.03"}})},
+			server:     &models.Server{ID: 1, Name: "db01", OSType: "openeuler", OSVersion: "22.03"},
+			wantID:     1,
+		},
+		{
+			name:       "没有匹配的变体",
+			candidates: []variantCandidate{testVariant(1, "", rocky("9")), testVariant(2, "aarch64", models.SupportedOS{Type: "centos"})},
+			server:     &models.Server{ID: 1, Name: "db01", OSType: "centos", OSVersion: "7.9", Arch: "x86_64"},
+			wantReason: "没有适用于 centos 7.9 x86_64 的离线包变体",
+		},
+		{
+			name:       "未检测到操作系统",
+			candidates: []variantCandidate{testVariant(1, "")},
+			server:     &models.Server{ID: 1, Name: "db01", OSType: "unknown"},
+			wantReason: "未检测到服务器操作系统",
+		},
+		{
+			name:       "没有离线包",
+			server:     x86Rocky94,
+			wantReason: "未找到该名称和版本的离线包",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variant := selectPackageVariant(tt.candidates, tt.server)
+			assert.Equal(t, tt.wantID, variant.PackageID)
+			if tt.wantReason == "" {
+				assert.Empty(t, variant.Reason)
+				assert.Equal(t, "nginx.zip", variant.PackageFile)
+			} else {
+				assert.Contains(t, variant.Reason, tt.wantReason)
+			}
+			assert.Equal(t, tt.server.ID, variant.ServerID)
+		})
+	}
+
+	t.Run("未匹配时列出所有变体支持的系统", func(t *testing.T) {
+		candidates := []variantCandidate{testVariant(1, "", rocky("9")), testVariant(2, "", models.SupportedOS{Type: "openEuler", Versions: []string{"22.03"}})}
+		variant := selectPackageVariant(candidates, &models.Server{OSType: "centos", OSVersion: "7.9"})
+		assert.Zero(t, variant.PackageID)
+		assert.Equal(t, "rocky-9, openEuler-22.03", variant.Supported)
+	})
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// scriptVariableNamePattern 变量名规则，需可在模板中以 .Vars.name 引用
+var scriptVariableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ScriptTemplateData 脚本模板渲染上下文
+type ScriptTemplateData struct {
+	Vars       map[string]string // 脚本变量
+	Server     ScriptServerInfo  // 目标服务器
+	Package    ScriptPackageInfo // 离线包（非离线包部署时为空）
+	Deployment ScriptDeployInfo  // 部署任务
+	TargetPath string            // 目标路径
+}
+
+// ScriptServerInfo 模板中可用的服务器信息
+type ScriptServerInfo struct {
+	ID        uint
+	Name      string
+	Host      string
+	Port      int
+	OSType    string
+	OSVersion string
+	Arch      string
+}
+
+// ScriptPackageInfo 模板中可用的离线包信息
+type ScriptPackageInfo struct {
+	Name     string
+	Version  string
+	FileName string
+}
+
+// ScriptDeployInfo 模板中可用的部署任务信息
+type ScriptDeployInfo struct {
+	ID          uint
+	Name        string
+	Type        string
+	ServiceName string
+	Params      map[string]interface{} // 部署参数（deploy_params）
+}
+
+// parseScriptVariables 解析脚本变量定义
+func parseScriptVariables(raw string) ([]models.ScriptVariable, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var vars []models.ScriptVariable
+	if err := json.Unmarshal([]byte(raw), &vars); err != nil {
+		return nil, fmt.Errorf("变量定义格式错误: %v", err)
+	}
+
+	seen := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		if !scriptVariableNamePattern.MatchString(v.Name) {
+			return nil, fmt.Errorf("变量名 %q 不合法，只能包含字母、数字和下划线且不能以数字开头", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("变量 %s 重复定义", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return vars, nil
+}
+
+// parseHookVariables 解析钩子的变量取值
+func parseHookVariables(raw string) (map[string]string, error) {
+	values := map[string]string{}
+	if strings.TrimSpace(raw) == "" {
+		return values, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, fmt.Errorf("变量取值格式错误，应为字符串键值对: %v", err)
+	}
+	return values, nil
+}
+
+// resolveScriptVariables 按变量定义合并取值与默认值，并校验必填变量
+func resolveScriptVariables(defs []models.ScriptVariable, values map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(defs)+len(values))
+	for k, v := range values {
+		resolved[k] = v
+	}
+
+	var missing []string
+	for _, def := range defs {
+		if v, ok := resolved[def.Name]; ok && v != "" {
+			continue
+		}
+		if def.Default != "" {
+			resolved[def.Name] = def.Default
+			continue
+		}
+		if def.Required {
+			missing = append(missing, defaultString(def.Label, def.Name))
+			continue
+		}
+		resolved[def.Name] = ""
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("缺少必填变量: %s", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// scriptTemplateData 构建部署任务的模板渲染上下文
+func scriptTemplateData(deployment *models.Deployment, vars map[string]string) ScriptTemplateData {
+	data := ScriptTemplateData{
+		Vars:       vars,
+		TargetPath: deployment.TargetPath,
+		Deployment: ScriptDeployInfo{
+			ID:          deployment.ID,
+			Name:        deployment.Name,
+			Type:        string(deployment.Type),
+			ServiceName: deployment.ServiceName,
+			Params:      map[string]interface{}{},
+		},
+	}
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+	if deployment.DeployParams != "" {
+		json.Unmarshal([]byte(deployment.DeployParams), &data.Deployment.Params)
+	}
+	if s := deployment.Server; s != nil {
+		data.Server = ScriptServerInfo{
+			ID:        s.ID,
+			Name:      s.Name,
+			Host:      s.Host,
+			Port:      s.Port,
+			OSType:    s.OSType,
+			OSVersion: s.OSVersion,
+			Arch:      s.Arch,
+		}
+	}
+	if p := deployment.Package; p != nil {
+		data.Package = ScriptPackageInfo{
+			Name:     p.Name,
+			Version:  p.Version,
+			FileName: p.FileName,
+		}
+	}
+	return data
+}
+
+// scriptTemplateFuncs 脚本模板可用的函数：
+// shellQuote 将值转义为 shell 单引号字符串（如 {{shellQuote .Vars.name}}），避免取值中的特殊字符注入 shell 语法
+var scriptTemplateFuncs = template.FuncMap{
+	"shellQuote": func(v interface{}) string { return shellQuote(fmt.Sprint(v)) },
+}
+
+// scriptTemplated 判断脚本是否按模板渲染：显式启用，或声明/提供了变量。
+// 未启用时脚本原样执行，其中的 {{ }}（如 docker inspect --format）不做解析
+func scriptTemplated(templated bool, variables string) bool {
+	switch strings.TrimSpace(variables) {
+	case "", "{}", "[]", "null":
+		return templated
+	}
+	return true
+}
+
+// parseScriptTemplate 解析脚本模板，引用未定义的变量时渲染报错
+func parseScriptTemplate(content string) (*template.Template, error) {
+	tmpl, err := template.New("script").Option("missingkey=error").Funcs(scriptTemplateFuncs).Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("脚本模板语法错误: %v", err)
+	}
+	return tmpl, nil
+}
+
+// renderScript 渲染脚本内容
+func renderScript(content string, data ScriptTemplateData) (string, error) {
+	tmpl, err := parseScriptTemplate(content)
+	if err != nil {
+		return "", err
+	}
+	return executeScriptTemplate(tmpl, data)
+}
+
+// executeScriptTemplate 执行已解析的脚本模板
+func executeScriptTemplate(tmpl *template.Template, data ScriptTemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("渲染脚本失败: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// renderHook 使用部署上下文渲染钩子脚本，未启用模板时原样返回
+func renderHook(hook *models.DeploymentHook, deployment *models.Deployment) (string, error) {
+	if !scriptTemplated(hook.Templated, hook.Variables) {
+		return hook.Content, nil
+	}
+	vars, err := parseHookVariables(hook.Variables)
+	if err != nil {
+		return "", err
+	}
+	return renderScript(hook.Content, scriptTemplateData(deployment, vars))
+}
+
+// validateScriptTemplate 校验变量定义；按模板渲染的脚本再校验语法并试渲染一次，
+// 检查是否引用了未声明的变量或不存在的字段
+func validateScriptTemplate(content, variables string, templated bool) error {
+	defs, err := parseScriptVariables(variables)
+	if err != nil {
+		return err
+	}
+	if !scriptTemplated(templated, variables) {
+		return nil
+	}
+	tmpl, err := parseScriptTemplate(content)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]string, len(defs))
+	for _, def := range defs {
+		vars[def.Name] = defaultString(def.Default, def.Name)
+	}
+	data := scriptTemplateData(&models.Deployment{}, vars)
+	data.Deployment.Params = scriptParamPlaceholders(tmpl)
+	if _, err := executeScriptTemplate(tmpl, data); err != nil {
+		return err
+	}
+	return nil
+}
+
+// scriptParamPlaceholders 收集模板引用的部署参数（.Deployment.Params.xxx），
+// 部署参数在执行时才确定，试渲染时以空值占位
+func scriptParamPlaceholders(tmpl *template.Template) map[string]interface{} {
+	params := map[string]interface{}{}
+	var walk func(node parse.Node)
+	walkBranch := func(b *parse.BranchNode) {
+		walk(b.Pipe)
+		walk(b.List)
+		if b.ElseList != nil {
+			walk(b.ElseList)
+		}
+	}
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walkBranch(&n.BranchNode)
+		case *parse.RangeNode:
+			walkBranch(&n.BranchNode)
+		case *parse.WithNode:
+			walkBranch(&n.BranchNode)
+		case *parse.TemplateNode:
+			if n.Pipe != nil {
+				walk(n.Pipe)
+			}
+		case *parse.PipeNode:
+			for _, cmd := range n.Cmds {
+				for _, arg := range cmd.Args {
+					walk(arg)
+				}
+			}
+		case *parse.FieldNode:
+			if len(n.Ident) < 3 || n.Ident[0] != "Deployment" || n.Ident[1] != "Params" {
+				return
+			}
+			m := params
+			for _, key := range n.Ident[2 : len(n.Ident)-1] {
+				next, ok := m[key].(map[string]interface{})
+				if !ok {
+					next = map[string]interface{}{}
+					m[key] = next
+				}
+				m = next
+			}
+			if last := n.Ident[len(n.Ident)-1]; m[last] == nil {
+				m[last] = ""
+			}
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	return params
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+func TestRenderScript(t *testing.T) {
+	defs := []models.ScriptVariable{
+		{Name: "port", Required: true},
+		{Name: "user", Default: "app"},
+		{Name: "comment"},
+	}
+
+	t.Run("缺少必填变量", func(t *testing.T) {
+		_, err := resolveScriptVariables(defs, map[string]string{"user": "root"})
+		assert.ErrorContains(t, err, "port")
+	})
+
+	t.Run("默认值与内置上下文", func(t *testing.T) {
+		vars, err := resolveScriptVariables(defs, map[string]string{"port": "8080"})
+		assert.NoError(t, err)
+		assert.Equal(t, "app", vars["user"])
+
+		deployment := &models.Deployment{
+			TargetPath:   "/opt/redis",
+			DeployParams: `{"mode":"cluster"}`,
+			Server:       &models.Server{Host: "10.0.0.5", OSType: "rocky"},
+			Package:      &models.MiddlewarePackage{Name: "redis", Version: "7.2"},
+		}
+		out, err := renderScript(
+			"{{.Server.Host}}:{{.Vars.port}} {{.Vars.user}} {{.Package.Name}}-{{.Package.Version}} {{.TargetPath}} {{.Deployment.Params.mode}}",
+			scriptTemplateData(deployment, vars))
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.5:8080 app redis-7.2 /opt/redis cluster", out)
+	})
+
+	t.Run("引用未声明变量", func(t *testing.T) {
+		err := validateScriptTemplate(`echo {{.Vars.missing}}`, `[{"name":"port"}]`, false)
+		assert.Error(t, err)
+		assert.NoError(t, validateScriptTemplate(`echo ${HOME} {{.Vars.port}}`, `[{"name":"port"}]`, false))
+	})
+
+	t.Run("未启用模板时原样执行", func(t *testing.T) {
+		content := `docker inspect --format '{{.State.Running}}' redis`
+		assert.NoError(t, validateScriptTemplate(content, "", false))
+		assert.Error(t, validateScriptTemplate(content, "", true))
+
+		out, err := renderHook(&models.DeploymentHook{Content: content}, &models.Deployment{})
+		assert.NoError(t, err)
+		assert.Equal(t, content, out)
+	})
+
+	t.Run("部署参数与 shell 转义", func(t *testing.T) {
+		assert.NoError(t, validateScriptTemplate(`echo {{.Deployment.Params.mode}} {{if .Deployment.Params.tls.enabled}}tls{{end}}`, "", true))
+
+		hook := &models.DeploymentHook{Content: `echo {{shellQuote .Vars.name}}`, Variables: `{"name":"a'; rm -rf /"}`}
+		out, err := renderHook(hook, &models.Deployment{})
+		assert.NoError(t, err)
+		assert.Equal(t, `echo 'a'\''; rm -rf /'`, out)
+	})
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -12,7 +13,6 @@ import (
 	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
 	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
 	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
-	"golang.org/x/crypto/ssh"
 )
 
 // ServerAPI 服务器管理 API
@@ -22,6 +22,7 @@ type ServerAPI struct {
 
 // NewServerAPI 创建服务器 API 实例
 func NewServerAPI(cfg *config.Config) *ServerAPI {
+	sshPool.configure(cfg.SSH)
 	return &ServerAPI{cfg: cfg}
 }
 
@@ -37,8 +38,10 @@ type CreateServerRequest struct {
 	Passphrase  string `json:"passphrase"`
 	OSType      string `json:"os_type"`
 	OSVersion   string `json:"os_version"`
+	Arch        string `json:"arch"` // CPU 架构，留空则在测试连接时自动获取
 	Description string `json:"description"`
 	Tags        string `json:"tags"`
+	BastionID   *uint  `json:"bastion_id"` // 跳板机 ID
 }
 
 // UpdateServerRequest 更新服务器请求
@@ -53,8 +56,10 @@ type UpdateServerRequest struct {
 	Passphrase  string `json:"passphrase"`
 	OSType      string `json:"os_type"`
 	OSVersion   string `json:"os_version"`
+	Arch        string `json:"arch"`
 	Description string `json:"description"`
 	Tags        string `json:"tags"`
+	BastionID   *uint  `json:"bastion_id"` // 跳板机 ID，传 0 表示取消跳板机
 }
 
 // Create 创建服务器
@@ -83,6 +88,14 @@ func (s *ServerAPI) Create(c *gin.Context) {
 		return
 	}
 
+	// 验证跳板机
+	if req.BastionID != nil {
+		if err := validateBastion(0, *req.BastionID); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	}
+
 	// 检查服务器是否已存在
 	var existingServer models.Server
 	result := db.DB.Where("host = ? AND port = ?", req.Host, req.Port).First(&existingServer)
@@ -105,8 +118,10 @@ func (s *ServerAPI) Create(c *gin.Context) {
 		Passphrase:  req.Passphrase,
 		OSType:      req.OSType,
 		OSVersion:   req.OSVersion,
+		Arch:        normalizeArch(req.Arch),
 		Description: req.Description,
 		Tags:        req.Tags,
+		BastionID:   req.BastionID,
 		Status:      "unknown",
 	}
 
@@ -159,12 +174,35 @@ func (s *ServerAPI) List(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, gin.H{
+	result := gin.H{
 		"servers":   servers,
 		"total":     total,
 		"page":      page,
 		"page_size": pageSize,
-	})
+	}
+
+	// 指定离线包时返回每台服务器的操作系统兼容性（用于批量部署选择服务器）
+	if packageID := c.Query("package_id"); packageID != "" {
+		var pkg models.MiddlewarePackage
+		if err := db.DB.First(&pkg, packageID).Error; err != nil {
+			response.BadRequest(c, "离线包不存在")
+			return
+		}
+		supported := packageSupportedOS(&pkg)
+		compatibility := make(map[uint]OSCompatibility, len(servers))
+		for _, server := range servers {
+			compat := checkOSCompatibility(supported, server.OSType, server.OSVersion)
+			if !archMatches(pkg.Arch, server.Arch) {
+				compat.Compatible = false
+				compat.Status = osIncompatible
+				compat.Reason = archMismatchReason(pkg.Arch, server.Arch)
+			}
+			compatibility[server.ID] = compat
+		}
+		result["compatibility"] = compatibility
+	}
+
+	response.Success(c, result)
 }
 
 // Get 获取服务器详情
@@ -176,7 +214,7 @@ func (s *ServerAPI) Get(c *gin.Context) {
 	}
 
 	var server models.Server
-	if err := db.DB.First(&server, id).Error; err != nil {
+	if err := db.DB.Preload("Bastion").First(&server, id).Error; err != nil {
 		response.NotFound(c, "服务器不存在")
 		return
 	}
@@ -208,12 +246,22 @@ func (s *ServerAPI) Update(c *gin.Context) {
 	if req.Name != "" {
 		server.Name = req.Name
 	}
+	addrChanged := (req.Host != "" && req.Host != server.Host) || (req.Port != 0 && req.Port != server.Port)
 	if req.Host != "" {
 		server.Host = req.Host
 	}
 	if req.Port != 0 {
 		server.Port = req.Port
 	}
+	// 地址变更后已信任的主机密钥不再适用，下次连接重新信任
+	if addrChanged {
+		server.HostKey = ""
+		server.HostKeyFingerprint = ""
+		server.HostKeyVerifiedAt = nil
+		server.PendingHostKey = ""
+		server.PendingHostKeyFingerprint = ""
+		server.PendingHostKeySeenAt = nil
+	}
 	if req.Username != "" {
 		server.Username = req.Username
 	}
@@ -235,12 +283,26 @@ func (s *ServerAPI) Update(c *gin.Context) {
 	if req.OSVersion != "" {
 		server.OSVersion = req.OSVersion
 	}
+	if req.Arch != "" {
+		server.Arch = normalizeArch(req.Arch)
+	}
 	if req.Description != "" {
 		server.Description = req.Description
 	}
 	if req.Tags != "" {
 		server.Tags = req.Tags
 	}
+	if req.BastionID != nil {
+		if *req.BastionID == 0 {
+			server.BastionID = nil
+		} else {
+			if err := validateBastion(server.ID, *req.BastionID); err != nil {
+				response.BadRequest(c, err.Error())
+				return
+			}
+			server.BastionID = req.BastionID
+		}
+	}
 
 	if err := db.DB.Save(&server).Error; err != nil {
 		logger.Errorf("更新服务器失败: %v", err)
@@ -248,6 +310,9 @@ func (s *ServerAPI) Update(c *gin.Context) {
 		return
 	}
 
+	// 服务器信息可能已变更，关闭旧连接
+	sshPool.Invalidate(server.ID)
+
 	logger.Infof("服务器更新成功: %s (ID: %d)", server.Name, server.ID)
 	response.SuccessWithMessage(c, "更新成功", server)
 }
@@ -266,12 +331,21 @@ func (s *ServerAPI) Delete(c *gin.Context) {
 		return
 	}
 
+	// 被其他服务器用作跳板机时不允许删除
+	var bastionUsers int64
+	db.DB.Model(&models.Server{}).Where("bastion_id = ?", server.ID).Count(&bastionUsers)
+	if bastionUsers > 0 {
+		response.BadRequest(c, fmt.Sprintf("该服务器正被 %d 台服务器用作跳板机，无法删除", bastionUsers))
+		return
+	}
+
 	// 软删除
 	if err := db.DB.Delete(&server).Error; err != nil {
 		logger.Errorf("删除服务器失败: %v", err)
 		response.InternalServerError(c, "删除失败")
 		return
 	}
+	sshPool.Invalidate(server.ID)
 
 	logger.Infof("服务器已删除: %s (ID: %d)", server.Name, server.ID)
 	response.SuccessWithMessage(c, "删除成功", nil)
@@ -306,6 +380,9 @@ func (s *ServerAPI) TestConnection(c *gin.Context) {
 			server.OSType = result.OSType
 			server.OSVersion = result.OSVersion
 		}
+		if result.Arch != "" {
+			server.Arch = result.Arch
+		}
 	} else {
 		server.Status = "offline"
 	}
@@ -330,6 +407,7 @@ type TestConnectionRequest struct {
 	Password   string `json:"password"`
 	PrivateKey string `json:"private_key"`
 	Passphrase string `json:"passphrase"`
+	BastionID  *uint  `json:"bastion_id"` // 跳板机 ID
 }
 
 // TestConnectionDirect 直接测试连接（不需要保存的服务器）
@@ -355,6 +433,14 @@ func (s *ServerAPI) TestConnectionDirect(c *gin.Context) {
 		Password:   req.Password,
 		PrivateKey: req.PrivateKey,
 		Passphrase: req.Passphrase,
+		BastionID:  req.BastionID,
+	}
+
+	if req.BastionID != nil {
+		if err := validateBastion(0, *req.BastionID); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
 	}
 
 	result := testSSHConnection(server)
@@ -366,6 +452,9 @@ func (s *ServerAPI) TestConnectionDirect(c *gin.Context) {
 		"os_info":    result.OSInfo,
 		"os_type":    result.OSType,
 		"os_version": result.OSVersion,
+		"arch":       result.Arch,
+
+		"host_key_fingerprint": server.HostKeyFingerprint,
 	})
 }
 
@@ -377,6 +466,7 @@ type SSHTestResult struct {
 	OSInfo    string
 	OSType    string
 	OSVersion string
+	Arch      string
 }
 
 // testSSHConnection 测试 SSH 连接
@@ -384,50 +474,19 @@ func testSSHConnection(server *models.Server) *SSHTestResult {
 	result := &SSHTestResult{}
 	startTime := time.Now()
 
-	// 配置 SSH 客户端
-	var authMethods []ssh.AuthMethod
-
-	if server.AuthType == "key" && server.PrivateKey != "" {
-		var signer ssh.Signer
-		var err error
-
-		if server.Passphrase != "" {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(server.PrivateKey), []byte(server.Passphrase))
-		} else {
-			signer, err = ssh.ParsePrivateKey([]byte(server.PrivateKey))
-		}
-
-		if err != nil {
-			result.Message = fmt.Sprintf("解析私钥失败: %v", err)
-			return result
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	} else if server.Password != "" {
-		authMethods = append(authMethods, ssh.Password(server.Password))
-	} else {
-		result.Message = "未提供有效的认证信息"
-		return result
-	}
-
-	config := &ssh.ClientConfig{
-		User:            server.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // 生产环境应使用更安全的方式
-		Timeout:         10 * time.Second,
-	}
-
-	// 建立连接
-	addr := fmt.Sprintf("%s:%d", server.Host, server.Port)
-	client, err := ssh.Dial("tcp", addr, config)
+	// 新建连接以真实反映连通性（配置了跳板机时经跳板机转发）
+	lease, err := sshPool.AcquireFresh(server, 10*time.Second)
 	if err != nil {
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
 			result.Message = "连接超时"
 		} else {
 			result.Message = fmt.Sprintf("连接失败: %v", err)
 		}
 		return result
 	}
-	defer client.Close()
+	defer lease.Release()
+	client := lease.Client
 
 	result.LatencyMs = time.Since(startTime).Milliseconds()
 
@@ -441,13 +500,18 @@ func testSSHConnection(server *models.Server) *SSHTestResult {
 	defer session.Close()
 
 	// 执行命令获取 OS 信息
-	output, err := session.CombinedOutput("cat /etc/os-release 2>/dev/null || cat /etc/redhat-release 2>/dev/null || uname -a")
+	output, err := session.CombinedOutput(osInfoCommand)
 	if err == nil {
 		result.OSInfo = string(output)
 		// 解析 OS 信息
 		result.OSType, result.OSVersion = parseOSInfo(string(output))
 	}
 
+	// 获取 CPU 架构
+	if arch, err := runRemote(client, archCommand); err == nil {
+		result.Arch = normalizeArch(arch)
+	}
+
 	result.Success = true
 	result.Message = "连接成功"
 	return result
@@ -473,8 +537,7 @@ func parseOSInfo(osInfo string) (osType, osVersion string) {
 		osType = "unknown"
 	}
 
-	// 提取版本号（简单实现）
-	// 可以使用正则表达式进行更精确的提取
+	osVersion = parseOSVersion(osInfo)
 	return osType, osVersion
 }
 
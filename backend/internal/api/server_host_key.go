@@ -0,0 +1,453 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/response"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMismatchError 主机密钥与已信任的密钥不一致
+type HostKeyMismatchError struct {
+	ServerName string
+	Addr       string
+	Expected   string
+	Presented  string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("主机密钥不匹配！服务器 %s (%s) 出示的密钥指纹为 %s，已信任的指纹为 %s，可能存在中间人攻击或服务器已重装。请核实后在服务器详情中接受新密钥",
+		e.ServerName, e.Addr, e.Presented, e.Expected)
+}
+
+// hostKeyCheck 单次握手的主机密钥校验，首次测试连接成功后固定（TOFU），之后每次连接校验
+type hostKeyCheck struct {
+	server    *models.Server
+	presented ssh.PublicKey
+	pinned    bool // 握手时已存在信任的密钥
+	trust     bool // 尚未固定密钥时是否信任并固定首次出示的密钥（连接测试或升级前已存在的服务器）
+}
+
+// newHostKeyCheck 创建主机密钥校验，已保存的服务器以数据库中最新的密钥为准。
+// trust 为 false 时拒绝连接尚未固定密钥的服务器，升级前已存在的服务器除外
+func newHostKeyCheck(server *models.Server, trust bool) *hostKeyCheck {
+	if server.ID != 0 {
+		var current models.Server
+		if err := db.DB.First(&current, server.ID).Error; err == nil {
+			server.HostKey = current.HostKey
+			server.HostKeyFingerprint = current.HostKeyFingerprint
+			server.HostKeyVerifiedAt = current.HostKeyVerifiedAt
+			server.HostKeyTrustOnFirstUse = current.HostKeyTrustOnFirstUse
+			server.PendingHostKey = current.PendingHostKey
+			server.PendingHostKeyFingerprint = current.PendingHostKeyFingerprint
+			server.PendingHostKeySeenAt = current.PendingHostKeySeenAt
+		}
+	}
+	return &hostKeyCheck{server: server, pinned: server.HostKey != "", trust: trust || server.HostKeyTrustOnFirstUse}
+}
+
+// algorithms 已固定密钥时只协商该密钥类型，避免服务器出示其他类型的密钥
+func (h *hostKeyCheck) algorithms() []string {
+	if !h.pinned {
+		return nil
+	}
+	key, err := parseHostKey(h.server.HostKey)
+	if err != nil {
+		return nil
+	}
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{key.Type()}
+}
+
+// callback SSH 握手时的主机密钥回调
+func (h *hostKeyCheck) callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	h.presented = key
+	if !h.pinned {
+		if h.trust {
+			return nil
+		}
+		return fmt.Errorf("服务器 %s (%s) 尚未信任主机密钥（出示的指纹为 %s），请在服务器列表中测试连接以固定密钥，或导入 known_hosts 批量信任",
+			h.server.Name, net.JoinHostPort(h.server.Host, strconv.Itoa(h.server.Port)), ssh.FingerprintSHA256(key))
+	}
+
+	pinned, err := parseHostKey(h.server.HostKey)
+	if err != nil {
+		return fmt.Errorf("已保存的主机密钥无效: %v", err)
+	}
+	if bytes.Equal(pinned.Marshal(), key.Marshal()) {
+		return nil
+	}
+
+	mismatch := &HostKeyMismatchError{
+		ServerName: h.server.Name,
+		Addr:       net.JoinHostPort(h.server.Host, strconv.Itoa(h.server.Port)),
+		Expected:   h.server.HostKeyFingerprint,
+		Presented:  ssh.FingerprintSHA256(key),
+	}
+	logger.Errorf("%s", mismatch.Error())
+
+	// 记录待确认的新密钥，供管理员核实后接受
+	h.server.PendingHostKey = marshalHostKey(key)
+	h.server.PendingHostKeyFingerprint = mismatch.Presented
+	h.server.PendingHostKeySeenAt = now()
+	if h.server.ID != 0 {
+		db.DB.Model(&models.Server{}).Where("id = ?", h.server.ID).Updates(map[string]interface{}{
+			"pending_host_key":             h.server.PendingHostKey,
+			"pending_host_key_fingerprint": h.server.PendingHostKeyFingerprint,
+			"pending_host_key_seen_at":     h.server.PendingHostKeySeenAt,
+		})
+	}
+	return mismatch
+}
+
+// commit 连接成功后固定首次出示的主机密钥
+func (h *hostKeyCheck) commit() {
+	if h.pinned || !h.trust || h.presented == nil {
+		return
+	}
+
+	h.server.HostKey = marshalHostKey(h.presented)
+	h.server.HostKeyFingerprint = ssh.FingerprintSHA256(h.presented)
+	h.server.HostKeyVerifiedAt = now()
+	h.server.HostKeyTrustOnFirstUse = false
+
+	if h.server.ID == 0 {
+		return
+	}
+
+	// 仅在尚未固定时写入，避免并发连接覆盖已信任的密钥
+	result := db.DB.Model(&models.Server{}).
+		Where("id = ? AND (host_key = '' OR host_key IS NULL)", h.server.ID).
+		Updates(map[string]interface{}{
+			"host_key":                    h.server.HostKey,
+			"host_key_fingerprint":        h.server.HostKeyFingerprint,
+			"host_key_verified_at":        h.server.HostKeyVerifiedAt,
+			"host_key_trust_on_first_use": false,
+		})
+	if result.RowsAffected > 0 {
+		logger.Infof("首次连接，已信任服务器 %s 的主机密钥: %s", h.server.Name, h.server.HostKeyFingerprint)
+	}
+}
+
+// parseHostKey 解析 authorized_keys 格式的主机密钥
+func parseHostKey(s string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	return key, err
+}
+
+// marshalHostKey 将主机密钥序列化为 authorized_keys 格式
+func marshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// GetHostKey 获取服务器的主机密钥信息
+func (s *ServerAPI) GetHostKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 ID")
+		return
+	}
+
+	var server models.Server
+	if err := db.DB.First(&server, id).Error; err != nil {
+		response.NotFound(c, "服务器不存在")
+		return
+	}
+
+	keyType := ""
+	if key, err := parseHostKey(server.HostKey); err == nil {
+		keyType = key.Type()
+	}
+	pendingType := ""
+	if key, err := parseHostKey(server.PendingHostKey); err == nil {
+		pendingType = key.Type()
+	}
+
+	response.Success(c, gin.H{
+		"trusted":                      server.HostKey != "",
+		"host_key":                     server.HostKey,
+		"key_type":                     keyType,
+		"fingerprint":                  server.HostKeyFingerprint,
+		"verified_at":                  server.HostKeyVerifiedAt,
+		"pending_host_key":             server.PendingHostKey,
+		"pending_key_type":             pendingType,
+		"pending_host_key_fingerprint": server.PendingHostKeyFingerprint,
+		"pending_host_key_seen_at":     server.PendingHostKeySeenAt,
+	})
+}
+
+// AcceptHostKeyRequest 接受新主机密钥请求
+type AcceptHostKeyRequest struct {
+	Fingerprint string `json:"fingerprint" binding:"required"` // 需与待确认密钥指纹一致，防止误接受未核实的密钥
+}
+
+// AcceptHostKey 接受服务器变更后的主机密钥
+func (s *ServerAPI) AcceptHostKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的 ID")
+		return
+	}
+
+	var req AcceptHostKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误: "+err.Error())
+		return
+	}
+
+	var server models.Server
+	if err := db.DB.First(&server, id).Error; err != nil {
+		response.NotFound(c, "服务器不存在")
+		return
+	}
+
+	if server.PendingHostKey == "" {
+		response.BadRequest(c, "该服务器没有待确认的主机密钥")
+		return
+	}
+	if req.Fingerprint != server.PendingHostKeyFingerprint {
+		response.BadRequest(c, "指纹与待确认的主机密钥不一致")
+		return
+	}
+
+	oldFingerprint := server.HostKeyFingerprint
+	newFingerprint := server.PendingHostKeyFingerprint
+	if err := db.DB.Model(&server).Updates(map[string]interface{}{
+		"host_key":                     server.PendingHostKey,
+		"host_key_fingerprint":         server.PendingHostKeyFingerprint,
+		"host_key_verified_at":         time.Now(),
+		"pending_host_key":             "",
+		"pending_host_key_fingerprint": "",
+		"pending_host_key_seen_at":     nil,
+	}).Error; err != nil {
+		logger.Errorf("更新主机密钥失败: %v", err)
+		response.InternalServerError(c, "更新主机密钥失败")
+		return
+	}
+	sshPool.Invalidate(server.ID)
+
+	logger.Infof("服务器 %s 主机密钥已变更: %s -> %s", server.Name, oldFingerprint, newFingerprint)
+	response.SuccessWithMessage(c, "已接受新主机密钥", gin.H{
+		"fingerprint":     newFingerprint,
+		"old_fingerprint": oldFingerprint,
+	})
+}
+
+// ImportKnownHostsRequest 导入 known_hosts 请求
+type ImportKnownHostsRequest struct {
+	Content   string `json:"content" binding:"required"` // known_hosts 文件内容
+	Overwrite bool   `json:"overwrite"`                  // 是否覆盖已信任但不一致的密钥
+}
+
+// KnownHostsImportResult 单台服务器的导入结果
+type KnownHostsImportResult struct {
+	ServerID    uint   `json:"server_id"`
+	ServerName  string `json:"server_name"`
+	Status      string `json:"status"` // imported, unchanged, conflict, overwritten
+	Fingerprint string `json:"fingerprint"`
+	Message     string `json:"message,omitempty"`
+}
+
+// knownHostEntry known_hosts 中的一条记录
+type knownHostEntry struct {
+	hosts []string
+	key   ssh.PublicKey
+}
+
+// ImportKnownHosts 从 known_hosts 内容导入服务器主机密钥
+func (s *ServerAPI) ImportKnownHosts(c *gin.Context) {
+	var req ImportKnownHostsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数错误: "+err.Error())
+		return
+	}
+
+	entries, invalid := parseKnownHosts(req.Content)
+	if len(entries) == 0 {
+		response.BadRequest(c, "未解析到有效的 known_hosts 记录")
+		return
+	}
+
+	var servers []models.Server
+	if err := db.DB.Find(&servers).Error; err != nil {
+		response.InternalServerError(c, "查询服务器失败")
+		return
+	}
+
+	var results []KnownHostsImportResult
+	for i := range servers {
+		server := &servers[i]
+		key := matchKnownHost(entries, server.Host, server.Port)
+		if key == nil {
+			continue
+		}
+
+		result := KnownHostsImportResult{
+			ServerID:    server.ID,
+			ServerName:  server.Name,
+			Fingerprint: ssh.FingerprintSHA256(key),
+		}
+
+		switch {
+		case server.HostKey == "":
+			result.Status = "imported"
+		case server.HostKeyFingerprint == result.Fingerprint:
+			result.Status = "unchanged"
+			results = append(results, result)
+			continue
+		case !req.Overwrite:
+			result.Status = "conflict"
+			result.Message = "与已信任的密钥 " + server.HostKeyFingerprint + " 不一致"
+			results = append(results, result)
+			continue
+		default:
+			result.Status = "overwritten"
+		}
+
+		if err := db.DB.Model(server).Updates(map[string]interface{}{
+			"host_key":                     marshalHostKey(key),
+			"host_key_fingerprint":         result.Fingerprint,
+			"host_key_verified_at":         time.Now(),
+			"pending_host_key":             "",
+			"pending_host_key_fingerprint": "",
+			"pending_host_key_seen_at":     nil,
+		}).Error; err != nil {
+			result.Status = "failed"
+			result.Message = err.Error()
+		} else {
+			sshPool.Invalidate(server.ID)
+		}
+		results = append(results, result)
+	}
+
+	logger.Infof("导入 known_hosts: %d 条记录，匹配 %d 台服务器", len(entries), len(results))
+	response.Success(c, gin.H{
+		"entries": len(entries),
+		"invalid": invalid,
+		"matched": len(results),
+		"results": results,
+	})
+}
+
+// parseKnownHosts 逐行解析 known_hosts，跳过注释、无效行以及 @revoked/@cert-authority 记录
+func parseKnownHosts(content string) ([]knownHostEntry, int) {
+	var entries []knownHostEntry
+	invalid := 0
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil {
+			invalid++
+			continue
+		}
+		if marker != "" {
+			continue
+		}
+		entries = append(entries, knownHostEntry{hosts: hosts, key: key})
+	}
+
+	return entries, invalid
+}
+
+// matchKnownHost 查找与服务器地址匹配的主机密钥，存在多个时按 ed25519 > ecdsa > rsa 优先
+func matchKnownHost(entries []knownHostEntry, host string, port int) ssh.PublicKey {
+	addr := knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))
+
+	var best ssh.PublicKey
+	for _, entry := range entries {
+		if !knownHostPatternsMatch(entry.hosts, addr) {
+			continue
+		}
+		if best == nil || hostKeyPriority(entry.key) > hostKeyPriority(best) {
+			best = entry.key
+		}
+	}
+	return best
+}
+
+// knownHostPatternsMatch 判断主机模式列表是否匹配地址（支持哈希、通配符和否定模式）
+func knownHostPatternsMatch(patterns []string, addr string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		var ok bool
+		if strings.HasPrefix(pattern, "|1|") {
+			ok = hashedHostMatches(pattern, addr)
+		} else {
+			ok = hostPatternMatches(pattern, addr)
+		}
+
+		if ok && negate {
+			return false
+		}
+		if ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// hostPatternMatches 匹配明文主机模式，仅 * 和 ? 为通配符（[host]:port 中的方括号按字面匹配）
+func hostPatternMatches(pattern, addr string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == addr
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(pattern)
+	ok, _ := path.Match(escaped, addr)
+	return ok
+}
+
+// hashedHostMatches 校验哈希形式的主机名（|1|salt|hash，HMAC-SHA1）
+func hashedHostMatches(pattern, addr string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(addr))
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// hostKeyPriority 主机密钥类型优先级
+func hostKeyPriority(key ssh.PublicKey) int {
+	switch key.Type() {
+	case ssh.KeyAlgoED25519:
+		return 3
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return 2
+	default:
+		return 1
+	}
+}
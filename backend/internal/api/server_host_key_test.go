@@ -0,0 +1,127 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	return key
+}
+
+func hashKnownHost(salt []byte, host string) string {
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestMatchKnownHost(t *testing.T) {
+	plainKey := newTestHostKey(t)
+	hashedKey := newTestHostKey(t)
+	portKey := newTestHostKey(t)
+
+	content := "# comment\n" +
+		"192.168.1.10,web01 " + marshalHostKey(plainKey) + "\n" +
+		hashKnownHost([]byte("0123456789abcdefghij"), "192.168.1.20") + " " + marshalHostKey(hashedKey) + "\n" +
+		"[192.168.1.30]:2222 " + marshalHostKey(portKey) + "\n" +
+		"@revoked 192.168.1.40 " + marshalHostKey(plainKey) + "\n" +
+		"not a valid line\n"
+
+	entries, invalid := parseKnownHosts(content)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, 1, invalid)
+
+	t.Run("明文主机名", func(t *testing.T) {
+		key := matchKnownHost(entries, "192.168.1.10", 22)
+		assert.NotNil(t, key)
+		assert.Equal(t, ssh.FingerprintSHA256(plainKey), ssh.FingerprintSHA256(key))
+	})
+
+	t.Run("哈希主机名", func(t *testing.T) {
+		key := matchKnownHost(entries, "192.168.1.20", 22)
+		assert.NotNil(t, key)
+		assert.Equal(t, ssh.FingerprintSHA256(hashedKey), ssh.FingerprintSHA256(key))
+	})
+
+	t.Run("非默认端口", func(t *testing.T) {
+		assert.NotNil(t, matchKnownHost(entries, "192.168.1.30", 2222))
+		assert.Nil(t, matchKnownHost(entries, "192.168.1.30", 22))
+	})
+
+	t.Run("吊销记录不导入", func(t *testing.T) {
+		assert.Nil(t, matchKnownHost(entries, "192.168.1.40", 22))
+	})
+}
+
+func TestHostKeyCheck_TrustOnlyWhenTesting(t *testing.T) {
+	logger.Init()
+	key := newTestHostKey(t)
+
+	// 非连接测试不信任尚未固定的密钥
+	server := &models.Server{Name: "web01", Host: "192.168.1.10", Port: 22}
+	check := newHostKeyCheck(server, false)
+	assert.ErrorContains(t, check.callback("", nil, key), "测试连接以固定密钥")
+	check.commit()
+	assert.Empty(t, server.HostKey)
+
+	// 连接测试时固定首次出示的密钥，之后校验
+	check = newHostKeyCheck(server, true)
+	assert.NoError(t, check.callback("", nil, key))
+	check.commit()
+	assert.Equal(t, marshalHostKey(key), server.HostKey)
+
+	check = newHostKeyCheck(server, false)
+	assert.NoError(t, check.callback("", nil, key))
+	assert.Error(t, check.callback("", nil, newTestHostKey(t)))
+}
+
+func TestHostKeyCheck_TrustOnFirstUseAfterUpgrade(t *testing.T) {
+	setupAPITestDB(t)
+
+	// 模拟引入主机密钥校验前的服务器表
+	db.DB.Exec("CREATE TABLE servers (id integer PRIMARY KEY AUTOINCREMENT, name text NOT NULL, host text NOT NULL, port integer DEFAULT 22, username text NOT NULL, created_at datetime, updated_at datetime, deleted_at datetime)")
+	db.DB.Exec("INSERT INTO servers (name, host, port, username) VALUES ('legacy', '192.168.1.10', 22, 'root')")
+	assert.NoError(t, db.AutoMigrate())
+
+	var legacy models.Server
+	assert.NoError(t, db.DB.First(&legacy).Error)
+	assert.True(t, legacy.HostKeyTrustOnFirstUse)
+
+	// 升级后新增的服务器仍需测试连接固定密钥
+	assert.NoError(t, db.AutoMigrate())
+	added := &models.Server{Name: "added", Host: "192.168.1.20", Port: 22, Username: "root"}
+	assert.NoError(t, db.DB.Create(added).Error)
+	assert.False(t, added.HostKeyTrustOnFirstUse)
+	assert.ErrorContains(t, newHostKeyCheck(added, false).callback("", nil, newTestHostKey(t)), "known_hosts")
+
+	// 已存在的服务器在首次连接时固定密钥，之后正常校验
+	key := newTestHostKey(t)
+	check := newHostKeyCheck(&models.Server{ID: legacy.ID}, false)
+	assert.NoError(t, check.callback("", nil, key))
+	check.commit()
+
+	assert.NoError(t, db.DB.First(&legacy, legacy.ID).Error)
+	assert.Equal(t, marshalHostKey(key), legacy.HostKey)
+	assert.False(t, legacy.HostKeyTrustOnFirstUse)
+
+	check = newHostKeyCheck(&models.Server{ID: legacy.ID}, false)
+	assert.Error(t, check.callback("", nil, newTestHostKey(t)))
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxBastionHops 跳板机链的最大层数
+const maxBastionHops = 5
+
+// sshAuthMethods 根据服务器认证信息构建 SSH 认证方式
+func sshAuthMethods(server *models.Server) ([]ssh.AuthMethod, error) {
+	if server.AuthType == "key" && server.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+
+		if server.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(server.PrivateKey), []byte(server.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(server.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析私钥失败: %v", err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	if server.Password != "" {
+		return []ssh.AuthMethod{ssh.Password(server.Password)}, nil
+	}
+
+	return nil, errors.New("未提供有效的认证信息")
+}
+
+// sshClientConfig 构建 SSH 客户端配置，主机密钥由 hostKey 校验
+func sshClientConfig(server *models.Server, timeout time.Duration, hostKey *hostKeyCheck) (*ssh.ClientConfig, error) {
+	authMethods, err := sshAuthMethods(server)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:              server.Username,
+		Auth:              authMethods,
+		HostKeyCallback:   hostKey.callback,
+		HostKeyAlgorithms: hostKey.algorithms(),
+		Timeout:           timeout,
+	}, nil
+}
+
+// dialSSH 建立到服务器的 SSH 连接，配置了跳板机时经跳板机链逐级转发
+// 业务代码应通过 sshPool 获取连接，而不是直接调用该函数。
+func dialSSH(server *models.Server, timeout time.Duration) (*ssh.Client, error) {
+	chain, err := resolveBastionChain(server)
+	if err != nil {
+		return nil, err
+	}
+	return dialChain(chain, timeout, false)
+}
+
+// dialChain 按跳板机链顺序逐级建立连接，返回到链尾目标服务器的连接。
+// trust 为 true 时（仅连接测试）信任并固定尚未固定的主机密钥，否则拒绝连接这类服务器
+func dialChain(chain []*models.Server, timeout time.Duration, trust bool) (*ssh.Client, error) {
+	server := chain[len(chain)-1]
+
+	var client *ssh.Client
+	for _, hop := range chain {
+		hostKey := newHostKeyCheck(hop, trust)
+		config, err := sshClientConfig(hop, timeout, hostKey)
+		if err != nil {
+			if client != nil {
+				client.Close()
+			}
+			return nil, hopError(hop, server, err)
+		}
+
+		addr := net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port))
+		if client == nil {
+			client, err = ssh.Dial("tcp", addr, config)
+			if err != nil {
+				return nil, hopError(hop, server, err)
+			}
+			hostKey.commit()
+			continue
+		}
+
+		next, err := dialThrough(client, addr, config, timeout)
+		if err != nil {
+			client.Close()
+			return nil, hopError(hop, server, err)
+		}
+		hostKey.commit()
+
+		// 目标连接关闭时一并关闭其下层的跳板机连接
+		bastion := client
+		go func() {
+			next.Wait()
+			bastion.Close()
+		}()
+		client = next
+	}
+
+	return client, nil
+}
+
+// dialThrough 通过已建立的跳板机连接转发到下一跳并完成 SSH 握手
+func dialThrough(bastion *ssh.Client, addr string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	conn, err := bastion.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("经跳板机转发到 %s 失败: %v", addr, err)
+	}
+
+	type handshakeResult struct {
+		client *ssh.Client
+		err    error
+	}
+	resultChan := make(chan handshakeResult, 1)
+
+	go func() {
+		c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+		if err != nil {
+			resultChan <- handshakeResult{err: err}
+			return
+		}
+		resultChan <- handshakeResult{client: ssh.NewClient(c, chans, reqs)}
+	}()
+
+	// 转发通道不支持设置超时，握手超时时关闭通道使握手返回
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			conn.Close()
+		}
+		return r.client, r.err
+	case <-time.After(timeout):
+		conn.Close()
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}
+	}
+}
+
+// resolveBastionChain 解析跳板机链，返回从最外层跳板机到目标服务器的有序列表
+func resolveBastionChain(server *models.Server) ([]*models.Server, error) {
+	chain := []*models.Server{server}
+	visited := map[uint]bool{}
+	if server.ID != 0 {
+		visited[server.ID] = true
+	}
+
+	current := server
+	for current.BastionID != nil {
+		if len(chain) > maxBastionHops {
+			return nil, fmt.Errorf("跳板机链超过最大层数 %d", maxBastionHops)
+		}
+		if visited[*current.BastionID] {
+			return nil, fmt.Errorf("跳板机配置存在循环引用（服务器 #%d）", *current.BastionID)
+		}
+
+		var bastion models.Server
+		if err := db.DB.First(&bastion, *current.BastionID).Error; err != nil {
+			return nil, fmt.Errorf("跳板机 #%d 不存在", *current.BastionID)
+		}
+
+		visited[bastion.ID] = true
+		chain = append([]*models.Server{&bastion}, chain...)
+		current = &bastion
+	}
+
+	return chain, nil
+}
+
+// validateBastion 校验将 bastionID 设为 serverID 的跳板机是否合法（存在、非自身、无循环、未超层数）
+func validateBastion(serverID, bastionID uint) error {
+	if serverID != 0 && serverID == bastionID {
+		return errors.New("不能将服务器自身设为跳板机")
+	}
+
+	var bastion models.Server
+	if err := db.DB.First(&bastion, bastionID).Error; err != nil {
+		return errors.New("跳板机不存在")
+	}
+
+	chain, err := resolveBastionChain(&bastion)
+	if err != nil {
+		return err
+	}
+	for _, hop := range chain {
+		if serverID != 0 && hop.ID == serverID {
+			return errors.New("跳板机配置存在循环引用")
+		}
+	}
+	if len(chain) > maxBastionHops {
+		return fmt.Errorf("跳板机链超过最大层数 %d", maxBastionHops)
+	}
+
+	return nil
+}
+
+// hopError 为跳板机链中的某一跳错误附加上下文
+func hopError(hop, target *models.Server, err error) error {
+	if hop == target {
+		return err
+	}
+	return &bastionError{hop: hop, err: err}
+}
+
+// bastionError 跳板机连接错误，保留原始错误以便判断是否超时
+type bastionError struct {
+	hop *models.Server
+	err error
+}
+
+func (e *bastionError) Error() string {
+	return fmt.Sprintf("跳板机 %s (%s:%d): %v", e.hop.Name, e.hop.Host, e.hop.Port, e.err)
+}
+
+func (e *bastionError) Unwrap() error {
+	return e.err
+}
+
+// timeoutError 握手超时错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
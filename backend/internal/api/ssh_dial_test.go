@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+)
+
+// createServerChain 创建 n 台服务器，每台以前一台为跳板机，返回按创建顺序排列的列表
+func createServerChain(t *testing.T, n int) []*models.Server {
+	servers := make([]*models.Server, 0, n)
+	var bastionID *uint
+	for i := 0; i < n; i++ {
+		server := &models.Server{Name: fmt.Sprintf("server-%d", i), Host: fmt.Sprintf("10.0.0.%d", i+1), Port: 22, Username: "root", BastionID: bastionID}
+		if err := db.DB.Create(server).Error; err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		id := server.ID
+		bastionID = &id
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func TestResolveBastionChain(t *testing.T) {
+	t.Run("按从外到内的顺序返回跳板机链", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		servers := createServerChain(t, 3)
+
+		chain, err := resolveBastionChain(servers[2])
+		assert.NoError(t, err)
+		if assert.Len(t, chain, 3) {
+			assert.Equal(t, servers[0].ID, chain[0].ID)
+			assert.Equal(t, servers[1].ID, chain[1].ID)
+			assert.Same(t, servers[2], chain[2])
+		}
+	})
+
+	t.Run("最多允许 5 层跳板机", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		servers := createServerChain(t, maxBastionHops+2)
+
+		chain, err := resolveBastionChain(servers[maxBastionHops])
+		assert.NoError(t, err)
+		assert.Len(t, chain, maxBastionHops+1)
+
+		_, err = resolveBastionChain(servers[maxBastionHops+1])
+		assert.ErrorContains(t, err, "超过最大层数")
+	})
+
+	t.Run("检测循环引用", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		servers := createServerChain(t, 3)
+		// 绕过校验直接写入 0 -> 2 形成环
+		db.DB.Model(servers[0]).Update("bastion_id", servers[2].ID)
+
+		_, err := resolveBastionChain(servers[2])
+		assert.ErrorContains(t, err, "循环引用")
+	})
+
+	t.Run("跳板机不存在", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		missing := uint(99)
+		_, err := resolveBastionChain(&models.Server{ID: 1, BastionID: &missing})
+		assert.ErrorContains(t, err, "不存在")
+	})
+}
+
+func TestValidateBastion(t *testing.T) {
+	t.Run("不能将自身设为跳板机", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		servers := createServerChain(t, 1)
+		assert.ErrorContains(t, validateBastion(servers[0].ID, servers[0].ID), "自身")
+	})
+
+	t.Run("新建服务器可使用任意已有服务器作为跳板机", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		servers := createServerChain(t, 2)
+		assert.NoError(t, validateBastion(0, servers[1].ID))
+	})
+
+	t.Run("拒绝形成循环的跳板机", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		servers := createServerChain(t, 3)
+		// 将链头的跳板机设为链尾会形成 0 -> 2 -> 1 -> 0
+		assert.ErrorContains(t, validateBastion(servers[0].ID, servers[2].ID), "循环引用")
+		assert.NoError(t, validateBastion(servers[2].ID, servers[0].ID))
+	})
+
+	t.Run("跳板机链层数限制", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		servers := createServerChain(t, maxBastionHops+1)
+		assert.NoError(t, validateBastion(0, servers[maxBastionHops-1].ID))
+		assert.ErrorContains(t, validateBastion(0, servers[maxBastionHops].ID), "超过最大层数")
+	})
+
+	t.Run("跳板机不存在", func(t *testing.T) {
+		setupAPITestDB(t, &models.Server{})
+		assert.ErrorContains(t, validateBastion(0, 99), "不存在")
+	})
+}
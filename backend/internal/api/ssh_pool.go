@@ -0,0 +1,307 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/config"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// pooledConn 连接池中的一条 SSH 连接
+type pooledConn struct {
+	client      *ssh.Client
+	serverID    uint
+	chainIDs    []uint // 连接经过的服务器（含跳板机），用于失效判断
+	fingerprint string
+	leases      int
+	lastUsed    time.Time
+	retired     bool // 已失效，不再借出，归还后关闭
+}
+
+// sshConnPool SSH 连接池，按服务器复用连接，供部署、配置应用、连接测试等远程操作共用
+type sshConnPool struct {
+	mu          sync.Mutex
+	conns       map[uint][]*pooledConn
+	configOnce  sync.Once
+	startOnce   sync.Once
+	maxSessions int
+	idleTimeout time.Duration
+	keepalive   time.Duration
+	dialTimeout time.Duration
+	dial        func(chain []*models.Server, timeout time.Duration, trust bool) (*ssh.Client, error)
+}
+
+// 全局 SSH 连接池实例
+var sshPool = &sshConnPool{
+	conns:       make(map[uint][]*pooledConn),
+	maxSessions: 4,
+	idleTimeout: 5 * time.Minute,
+	keepalive:   30 * time.Second,
+	dialTimeout: 30 * time.Second,
+	dial:        dialChain,
+}
+
+// sshLease 从连接池借出的连接，使用完毕必须调用 Release 归还，不能直接关闭 Client
+type sshLease struct {
+	Client *ssh.Client
+	pool   *sshConnPool
+	conn   *pooledConn
+	once   sync.Once
+}
+
+// Release 归还连接
+func (l *sshLease) Release() {
+	l.once.Do(func() {
+		if l.conn == nil {
+			// 未入池的临时连接直接关闭
+			l.Client.Close()
+			return
+		}
+		l.pool.release(l.conn)
+	})
+}
+
+// configure 按配置初始化连接池（仅首次调用生效）
+func (p *sshConnPool) configure(cfg config.SSHConfig) {
+	p.configOnce.Do(func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if cfg.MaxSessionsPerConn > 0 {
+			p.maxSessions = cfg.MaxSessionsPerConn
+		}
+		if cfg.IdleTimeout > 0 {
+			p.idleTimeout = cfg.IdleTimeout
+		}
+		if cfg.KeepaliveInterval > 0 {
+			p.keepalive = cfg.KeepaliveInterval
+		}
+		if cfg.DialTimeout > 0 {
+			p.dialTimeout = cfg.DialTimeout
+		}
+		logger.Infof("SSH 连接池初始化: 单连接最大并发 %d，空闲回收 %s，保活间隔 %s", p.maxSessions, p.idleTimeout, p.keepalive)
+	})
+}
+
+// Acquire 获取到服务器的连接，优先复用池中凭据一致且未满的连接
+func (p *sshConnPool) Acquire(server *models.Server) (*sshLease, error) {
+	return p.acquire(server, false, p.dialTimeout)
+}
+
+// AcquireFresh 新建一条到服务器的连接（仅用于连接测试，需要真实反映连通性），新连接会放入池中供后续复用。
+// 服务器尚未固定主机密钥时，以本次连接出示的密钥作为信任的密钥
+func (p *sshConnPool) AcquireFresh(server *models.Server, timeout time.Duration) (*sshLease, error) {
+	return p.acquire(server, true, timeout)
+}
+
+// acquire 获取连接，fresh 为 true 时不复用池中连接并在首次连接时固定主机密钥
+func (p *sshConnPool) acquire(server *models.Server, fresh bool, timeout time.Duration) (*sshLease, error) {
+	p.startOnce.Do(func() {
+		go p.janitor()
+	})
+
+	chain, err := resolveBastionChain(server)
+	if err != nil {
+		return nil, err
+	}
+
+	// 未保存的服务器（如直接测试连接）不入池
+	if server.ID == 0 {
+		client, err := p.dial(chain, timeout, fresh)
+		if err != nil {
+			return nil, err
+		}
+		return &sshLease{Client: client, pool: p}, nil
+	}
+
+	fingerprint := chainFingerprint(chain)
+
+	if !fresh {
+		p.mu.Lock()
+		for _, pc := range p.conns[server.ID] {
+			if !pc.retired && pc.fingerprint == fingerprint && pc.leases < p.maxSessions {
+				pc.leases++
+				pc.lastUsed = time.Now()
+				p.mu.Unlock()
+				return &sshLease{Client: pc.client, pool: p, conn: pc}, nil
+			}
+		}
+		p.mu.Unlock()
+	}
+
+	client, err := p.dial(chain, timeout, fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	chainIDs := make([]uint, 0, len(chain))
+	for _, hop := range chain {
+		chainIDs = append(chainIDs, hop.ID)
+	}
+	pc := &pooledConn{
+		client:      client,
+		serverID:    server.ID,
+		chainIDs:    chainIDs,
+		fingerprint: fingerprint,
+		leases:      1,
+		lastUsed:    time.Now(),
+	}
+
+	p.mu.Lock()
+	p.conns[server.ID] = append(p.conns[server.ID], pc)
+	p.mu.Unlock()
+
+	// 连接断开后从池中移除
+	go func() {
+		client.Wait()
+		p.mu.Lock()
+		pc.retired = true
+		p.removeLocked(pc)
+		p.mu.Unlock()
+	}()
+
+	return &sshLease{Client: client, pool: p, conn: pc}, nil
+}
+
+// release 归还连接，已失效的连接在最后一个使用者归还后关闭
+func (p *sshConnPool) release(pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc.leases--
+	pc.lastUsed = time.Now()
+	if pc.retired && pc.leases <= 0 {
+		p.removeLocked(pc)
+		pc.client.Close()
+	}
+}
+
+// Invalidate 使与服务器相关的连接失效（服务器信息变更或删除时调用）
+// 以该服务器作为跳板机的连接同样失效；正在使用的连接会在归还后关闭。
+func (p *sshConnPool) Invalidate(serverID uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, conns := range p.conns {
+		// removeLocked 会原地修改切片，遍历副本
+		for _, pc := range append([]*pooledConn(nil), conns...) {
+			if !containsID(pc.chainIDs, serverID) {
+				continue
+			}
+			pc.retired = true
+			if pc.leases <= 0 {
+				p.removeLocked(pc)
+				pc.client.Close()
+			}
+		}
+	}
+}
+
+// janitor 定期发送保活请求并回收空闲连接
+func (p *sshConnPool) janitor() {
+	for {
+		p.mu.Lock()
+		interval := p.keepalive
+		p.mu.Unlock()
+		time.Sleep(interval)
+
+		for _, pc := range p.evictIdle() {
+			if err := sendKeepalive(pc.client, interval); err != nil {
+				logger.Warnf("SSH 连接保活失败，关闭连接: server_id=%d, %v", pc.serverID, err)
+				p.mu.Lock()
+				pc.retired = true
+				p.mu.Unlock()
+				pc.client.Close()
+			}
+		}
+	}
+}
+
+// evictIdle 关闭并移除空闲超时或已失效且无人使用的连接，返回仍保留的连接
+func (p *sshConnPool) evictIdle() []*pooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var alive []*pooledConn
+	for _, conns := range p.conns {
+		// removeLocked 会原地修改切片，遍历副本
+		for _, pc := range append([]*pooledConn(nil), conns...) {
+			if pc.leases <= 0 && (pc.retired || time.Since(pc.lastUsed) > p.idleTimeout) {
+				p.removeLocked(pc)
+				pc.client.Close()
+				logger.Infof("SSH 连接池回收空闲连接: server_id=%d", pc.serverID)
+				continue
+			}
+			alive = append(alive, pc)
+		}
+	}
+	return alive
+}
+
+// removeLocked 从池中移除连接（调用方需持有锁）
+func (p *sshConnPool) removeLocked(pc *pooledConn) {
+	conns := p.conns[pc.serverID]
+	for i, c := range conns {
+		if c == pc {
+			p.conns[pc.serverID] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(p.conns[pc.serverID]) == 0 {
+		delete(p.conns, pc.serverID)
+	}
+}
+
+// sendKeepalive 发送 keepalive@openssh.com 请求，超时视为连接失效
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	errChan := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		errChan <- err
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("保活请求超时")
+	}
+}
+
+// chainFingerprint 计算连接链路上所有服务器地址与凭据的指纹，凭据变更后不再复用旧连接
+func chainFingerprint(chain []*models.Server) string {
+	h := sha256.New()
+	for _, hop := range chain {
+		fmt.Fprintf(h, "%d|%s|%d|%s|%s|%s|%s|%s\n",
+			hop.ID, hop.Host, hop.Port, hop.Username, hop.AuthType, hop.Password, hop.PrivateKey, hop.Passphrase)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// containsID 判断 ID 列表中是否包含指定 ID
+func containsID(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// runRemote 在连接上开启一个会话执行命令并返回合并输出
+func runRemote(client *ssh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(cmd)
+	return string(output), err
+}
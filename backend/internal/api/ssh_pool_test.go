@@ -0,0 +1,244 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/db"
+	"github.com/yunzck8s/middleware-deploy-kit/backend/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// newLoopbackSSHClient 通过本地回环连接一个拒绝所有通道的 SSH 服务端，返回客户端
+func newLoopbackSSHClient(t *testing.T) *ssh.Client {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "")
+		}
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(clientConn, listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect ssh client: %v", err)
+	}
+	return ssh.NewClient(conn, chans, reqs)
+}
+
+// fakeDialer 记录拨号次数的假拨号函数
+type fakeDialer struct {
+	t     *testing.T
+	mu    sync.Mutex
+	dials int
+}
+
+func (f *fakeDialer) dial(chain []*models.Server, timeout time.Duration, trust bool) (*ssh.Client, error) {
+	client := newLoopbackSSHClient(f.t)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dials++
+	return client, nil
+}
+
+func (f *fakeDialer) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dials
+}
+
+// newTestSSHPool 创建使用假拨号函数的连接池，保活间隔足够长，避免后台回收干扰测试
+func newTestSSHPool(t *testing.T) (*sshConnPool, *fakeDialer) {
+	dialer := &fakeDialer{t: t}
+	pool := &sshConnPool{
+		conns:       make(map[uint][]*pooledConn),
+		maxSessions: 2,
+		idleTimeout: time.Minute,
+		keepalive:   time.Hour,
+		dialTimeout: time.Second,
+		dial:        dialer.dial,
+	}
+	return pool, dialer
+}
+
+// waitClosed 等待客户端连接关闭
+func waitClosed(t *testing.T, client *ssh.Client) {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ssh client was not closed")
+	}
+}
+
+func TestSSHPool_LeaseLimit(t *testing.T) {
+	setupAPITestDB(t, &models.Server{})
+	server := createServerChain(t, 1)[0]
+	pool, dialer := newTestSSHPool(t)
+
+	first, err := pool.Acquire(server)
+	assert.NoError(t, err)
+	second, err := pool.Acquire(server)
+	assert.NoError(t, err)
+	assert.Same(t, first.Client, second.Client)
+	assert.Equal(t, 1, dialer.count())
+
+	// 单连接借出数已达上限时新建连接
+	third, err := pool.Acquire(server)
+	assert.NoError(t, err)
+	assert.NotSame(t, first.Client, third.Client)
+	assert.Equal(t, 2, dialer.count())
+
+	// 归还后可再次复用
+	first.Release()
+	first.Release()
+	fourth, err := pool.Acquire(server)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dialer.count())
+	assert.Same(t, second.Client, fourth.Client)
+
+	// 连接测试总是新建连接
+	fresh, err := pool.AcquireFresh(server, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dialer.count())
+
+	for _, lease := range []*sshLease{second, third, fourth, fresh} {
+		lease.Release()
+	}
+	pool.mu.Lock()
+	for _, pc := range pool.conns[server.ID] {
+		assert.Zero(t, pc.leases)
+	}
+	pool.mu.Unlock()
+}
+
+func TestSSHPool_CredentialChange(t *testing.T) {
+	setupAPITestDB(t, &models.Server{})
+	servers := createServerChain(t, 2)
+	bastion, server := servers[0], servers[1]
+	pool, dialer := newTestSSHPool(t)
+
+	lease, err := pool.Acquire(server)
+	assert.NoError(t, err)
+	lease.Release()
+
+	// 目标服务器凭据变更后不复用旧连接
+	before := chainFingerprint([]*models.Server{bastion, server})
+	server.Password = "new-password"
+	assert.NotEqual(t, before, chainFingerprint([]*models.Server{bastion, server}))
+
+	lease, err = pool.Acquire(server)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dialer.count())
+	lease.Release()
+
+	// 跳板机凭据变更同样使指纹变化
+	db.DB.Model(bastion).Update("password", "bastion-password")
+	lease, err = pool.Acquire(server)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dialer.count())
+	lease.Release()
+}
+
+func TestSSHPool_Invalidate(t *testing.T) {
+	setupAPITestDB(t, &models.Server{})
+	servers := createServerChain(t, 2)
+	bastion, server := servers[0], servers[1]
+	pool, dialer := newTestSSHPool(t)
+
+	lease, err := pool.Acquire(server)
+	assert.NoError(t, err)
+
+	// 使用中的连接在归还后关闭
+	pool.Invalidate(bastion.ID)
+	next, err := pool.Acquire(server)
+	assert.NoError(t, err)
+	assert.NotSame(t, lease.Client, next.Client)
+	assert.Equal(t, 2, dialer.count())
+
+	lease.Release()
+	waitClosed(t, lease.Client)
+
+	// 空闲连接立即关闭
+	next.Release()
+	pool.Invalidate(server.ID)
+	waitClosed(t, next.Client)
+
+	pool.mu.Lock()
+	assert.Empty(t, pool.conns)
+	pool.mu.Unlock()
+}
+
+func TestSSHPool_EvictIdle(t *testing.T) {
+	setupAPITestDB(t, &models.Server{})
+	server := createServerChain(t, 1)[0]
+	pool, _ := newTestSSHPool(t)
+
+	var leases []*sshLease
+	for i := 0; i < 3; i++ {
+		lease, err := pool.Acquire(server)
+		assert.NoError(t, err)
+		leases = append(leases, lease)
+	}
+	leases[0].Release()
+	leases[2].Release()
+
+	// 让所有连接都超过空闲时间，仅回收无人使用的连接
+	pool.mu.Lock()
+	for _, pc := range pool.conns[server.ID] {
+		pc.lastUsed = time.Now().Add(-2 * pool.idleTimeout)
+	}
+	pool.mu.Unlock()
+
+	alive := pool.evictIdle()
+	if assert.Len(t, alive, 1) {
+		assert.Same(t, leases[0].Client, alive[0].client)
+	}
+	waitClosed(t, leases[2].Client)
+
+	leases[1].Release()
+	pool.mu.Lock()
+	alive[0].lastUsed = time.Now().Add(-2 * pool.idleTimeout)
+	pool.mu.Unlock()
+	assert.Empty(t, pool.evictIdle())
+	waitClosed(t, leases[0].Client)
+}
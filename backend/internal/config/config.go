@@ -10,6 +10,8 @@ type Config struct {
 	Database DatabaseConfig
 	JWT      JWTConfig
 	Data     DataConfig
+	Transfer TransferConfig
+	SSH      SSHConfig
 }
 
 // ServerConfig 服务器配置
@@ -38,6 +40,20 @@ type DataConfig struct {
 	UploadDir    string
 }
 
+// TransferConfig 文件传输配置
+type TransferConfig struct {
+	MaxConcurrentStreams int   // 同时进行的 SFTP 上传数上限（0 表示不限制）
+	BandwidthLimit       int64 // 全局上传带宽上限（KB/s，0 表示不限速）
+}
+
+// SSHConfig SSH 连接池配置
+type SSHConfig struct {
+	MaxSessionsPerConn int           // 单个连接同时借出的上限（每次借用可能开启 SFTP 和命令两个会话，需低于服务端 MaxSessions）
+	IdleTimeout        time.Duration // 空闲连接回收时间
+	KeepaliveInterval  time.Duration // 保活请求间隔
+	DialTimeout        time.Duration // 建立连接超时时间
+}
+
 // NewConfig 创建默认配置
 func NewConfig() *Config {
 	return &Config{
@@ -59,5 +75,15 @@ func NewConfig() *Config {
 			Logs:         "./data/logs",
 			UploadDir:    "./data/uploads",
 		},
+		Transfer: TransferConfig{
+			MaxConcurrentStreams: 4,
+			BandwidthLimit:       0,
+		},
+		SSH: SSHConfig{
+			MaxSessionsPerConn: 4,
+			IdleTimeout:        5 * time.Minute,
+			KeepaliveInterval:  30 * time.Second,
+			DialTimeout:        30 * time.Second,
+		},
 	}
 }
@@ -44,7 +44,10 @@ func Init(cfg *config.Config) error {
 
 // AutoMigrate 自动迁移数据库表
 func AutoMigrate() error {
-	return DB.AutoMigrate(
+	// 引入主机密钥校验前已存在的服务器没有固定的密钥，升级后需在下次连接时信任并固定，否则所有连接都会被拒绝
+	upgradeHostKeys := DB.Migrator().HasTable(&models.Server{}) && !DB.Migrator().HasColumn(&models.Server{}, "HostKey")
+
+	if err := DB.AutoMigrate(
 		&models.User{},
 		&models.MiddlewarePackage{},
 		&models.Certificate{},
@@ -54,13 +57,36 @@ func AutoMigrate() error {
 		&models.NginxConfig{},
 		&models.NginxLocation{},
 		&models.NginxUpstream{},
+		&models.NginxStreamServer{},
+		&models.NginxLimitZone{},
+		&models.NginxAuthUser{},
+		&models.NginxCacheZone{},
 		&models.NginxConfigApply{},
 		&models.NginxConfigApplyLog{},
+		&models.NginxConfigRevision{},
 		&models.Deployment{},
 		&models.DeploymentLog{},
 		&models.DeploymentScript{},
+		&models.DeploymentScriptRevision{},
 		&models.DeploymentHook{},
-	)
+		&models.Job{},
+		&models.JobHost{},
+		&models.JobLog{},
+	); err != nil {
+		return err
+	}
+
+	if upgradeHostKeys {
+		result := DB.Model(&models.Server{}).
+			Where("host_key = '' OR host_key IS NULL").
+			Update("host_key_trust_on_first_use", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		logger.Infof("已有 %d 台服务器将在下次连接时信任并固定主机密钥", result.RowsAffected)
+	}
+
+	return nil
 }
 
 // InitDefaultData 初始化默认数据
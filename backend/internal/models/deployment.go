@@ -47,6 +47,13 @@ type Deployment struct {
 	RestartService bool   `json:"restart_service" gorm:"default:false"`    // 是否重启服务
 	ServiceName    string `json:"service_name"`                            // 服务名称（用于重启）
 	DeployParams   string `json:"deploy_params" gorm:"type:text"`          // 部署参数（JSON格式，用于参数化部署）
+	BandwidthLimit int64  `json:"bandwidth_limit" gorm:"default:0"`        // 上传带宽上限（KB/s，0 表示不限速）
+	PreflightPolicy string `json:"preflight_policy" gorm:"default:'block'"` // 预检策略：block（未通过则中止）, warn（仅警告）, skip（跳过）
+	IgnoreOSCheck   bool   `json:"ignore_os_check" gorm:"default:false"`  // 忽略操作系统兼容性检查
+
+	// 中转分发配置（批量部署时先上传到中转服务器，再由其在内网复制到目标服务器）
+	RelayServerID *uint  `json:"relay_server_id,omitempty" gorm:"index"` // 中转服务器 ID
+	RelayMethod   string `json:"relay_method,omitempty"`                 // 中转复制方式：scp, rsync
 
 	// 执行信息
 	StartedAt   *time.Time `json:"started_at"`                            // 开始时间
@@ -60,6 +67,7 @@ type Deployment struct {
 
 	// 关联
 	Server       *Server          `json:"server,omitempty" gorm:"foreignKey:ServerID"`
+	RelayServer  *Server          `json:"relay_server,omitempty" gorm:"foreignKey:RelayServerID"`
 	NginxConfig  *NginxConfig     `json:"nginx_config,omitempty" gorm:"foreignKey:NginxConfigID"`
 	Package      *MiddlewarePackage `json:"package,omitempty" gorm:"foreignKey:PackageID"`
 	Certificate  *Certificate     `json:"certificate,omitempty" gorm:"foreignKey:CertificateID"`
@@ -71,16 +79,21 @@ type Deployment struct {
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// StepLog 执行步骤日志，部署日志与作业日志共用
+type StepLog struct {
+	Step     int    `json:"step"`                    // 步骤序号
+	Action   string `json:"action"`                  // 动作描述
+	Status   string `json:"status"`                  // 状态: running, success, failed, skipped, cancelled
+	Output   string `json:"output" gorm:"type:text"` // 输出内容
+	ErrorMsg string `json:"error_msg"`               // 错误信息
+	Duration int    `json:"duration"`                // 耗时（毫秒）
+}
+
 // DeploymentLog 部署日志
 type DeploymentLog struct {
 	ID           uint      `json:"id" gorm:"primaryKey"`
 	DeploymentID uint      `json:"deployment_id" gorm:"not null;index"`  // 部署任务 ID
-	Step         int       `json:"step"`                                  // 步骤序号
-	Action       string    `json:"action"`                                // 动作描述
-	Status       string    `json:"status"`                                // 状态: success, failed, skipped
-	Output       string    `json:"output" gorm:"type:text"`               // 输出内容
-	ErrorMsg     string    `json:"error_msg"`                             // 错误信息
-	Duration     int       `json:"duration"`                              // 耗时（毫秒）
+	StepLog
 	CreatedAt    time.Time `json:"created_at"`
 }
 
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // DeploymentScript 部署脚本模板
@@ -21,13 +23,49 @@ type DeploymentScript struct {
 	Timeout     int       `gorm:"default:300" json:"timeout"`                      // 超时时间（秒）
 	WorkDir     string    `gorm:"size:500" json:"work_dir"`                        // 工作目录
 	Variables   string    `gorm:"type:text" json:"variables"`                      // 变量定义（JSON格式）
+	Templated   bool      `gorm:"default:false" json:"templated"`                  // 按模板渲染（声明了变量时总是渲染）
 
 	// 状态
 	Status      string    `gorm:"size:20;not null;default:'active'" json:"status"` // 状态: active, disabled
 	IsTemplate  bool      `gorm:"default:true" json:"is_template"`                 // 是否为模板
+	CurrentRevision int   `gorm:"default:0" json:"current_revision"`               // 当前修订版本号
 
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"` // 软删除，保留修订版本供审计
+}
+
+// DeploymentScriptRevision 脚本修订版本（创建后不可修改）
+type DeploymentScriptRevision struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ScriptID   uint      `gorm:"not null;uniqueIndex:idx_script_revision" json:"script_id"` // 脚本ID
+	Revision   int       `gorm:"not null;uniqueIndex:idx_script_revision" json:"revision"`  // 修订版本号（从 1 开始递增）
+	ScriptType string    `gorm:"size:20;not null" json:"script_type"`                      // 脚本类型
+	Content    string    `gorm:"type:text;not null" json:"content"`                        // 脚本内容
+	Variables  string    `gorm:"type:text" json:"variables"`                               // 变量定义
+	Templated  bool      `gorm:"default:false" json:"templated"`                           // 按模板渲染
+	Timeout    int       `json:"timeout"`                                                  // 超时时间（秒）
+	WorkDir    string    `gorm:"size:500" json:"work_dir"`                                 // 工作目录
+	Author     string    `gorm:"size:100" json:"author"`                                   // 修改人
+	Message    string    `gorm:"size:500" json:"message"`                                  // 修改说明
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ScriptVariable 脚本变量定义（DeploymentScript.Variables 为其 JSON 数组）
+type ScriptVariable struct {
+	Name        string `json:"name"`                  // 变量名，模板中以 {{.Vars.name}} 引用
+	Label       string `json:"label,omitempty"`       // 显示名称
+	Description string `json:"description,omitempty"` // 说明
+	Default     string `json:"default,omitempty"`     // 默认值
+	Required    bool   `json:"required"`              // 是否必填
+}
+
+// HookCondition 钩子执行条件，各条件之间为“且”关系
+type HookCondition struct {
+	OSTypes         []string `json:"os_types,omitempty"`         // 服务器操作系统类型之一
+	DeploymentTypes []string `json:"deployment_types,omitempty"` // 部署类型之一
+	Param           string   `json:"param,omitempty"`            // 部署参数名
+	ParamValues     []string `json:"param_values,omitempty"`     // 部署参数取值之一（为空表示参数存在且非空）
 }
 
 // DeploymentHook 部署钩子配置
@@ -35,16 +73,22 @@ type DeploymentHook struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
 	DeploymentID uint      `gorm:"not null;index" json:"deployment_id"`             // 关联的部署任务ID
 	ScriptID     *uint     `gorm:"index" json:"script_id,omitempty"`                // 关联的脚本模板ID（可选）
+	ScriptRevision int     `gorm:"default:0" json:"script_revision"`                // 固定使用的脚本修订版本（0 表示未关联脚本）
 
 	// 钩子配置
 	HookType     string    `gorm:"size:20;not null" json:"hook_type"`               // 钩子类型: pre_deploy, post_deploy, on_success, on_failure
 	ScriptType   string    `gorm:"size:20;not null;default:'shell'" json:"script_type"` // 脚本类型
 	Content      string    `gorm:"type:text;not null" json:"content"`               // 脚本内容
+	SortOrder    int       `gorm:"default:0" json:"sort_order"`                     // 执行顺序（越小越先执行）
+	FailurePolicy string   `gorm:"size:20" json:"failure_policy"`                   // 失败策略: fail_deployment, continue_on_error（为空时 pre_deploy 中止部署，其余继续）
+	Condition    string    `gorm:"type:text" json:"condition"`                      // 执行条件（HookCondition 的 JSON，为空表示总是执行）
+	RetryCount   int       `gorm:"default:0" json:"retry_count"`                    // 失败重试次数
 
 	// 执行配置
 	Timeout      int       `gorm:"default:300" json:"timeout"`                      // 超时时间（秒）
 	WorkDir      string    `gorm:"size:500" json:"work_dir"`                        // 工作目录
-	Variables    string    `gorm:"type:text" json:"variables"`                      // 变量定义（JSON格式）
+	Variables    string    `gorm:"type:text" json:"variables"`                      // 变量取值（JSON 对象）
+	Templated    bool      `gorm:"default:false" json:"templated"`                  // 按模板渲染（提供了变量取值时总是渲染）
 
 	// 执行结果
 	Executed     bool      `gorm:"default:false" json:"executed"`                   // 是否已执行
@@ -53,6 +97,9 @@ type DeploymentHook struct {
 	Output       string    `gorm:"type:text" json:"output"`                         // 执行输出
 	ErrorMsg     string    `gorm:"type:text" json:"error_msg"`                      // 错误信息
 	Duration     int64     `gorm:"default:0" json:"duration"`                       // 执行耗时（毫秒）
+	Attempts     int       `gorm:"default:0" json:"attempts"`                       // 实际执行次数（含重试）
+	ExecutedRevision int   `gorm:"default:0" json:"executed_revision"`              // 实际执行的脚本修订版本
+	RenderedContent string `gorm:"type:text" json:"rendered_content"`               // 渲染后的脚本内容（用于审计）
 
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
@@ -66,6 +113,11 @@ func (DeploymentScript) TableName() string {
 	return "deployment_scripts"
 }
 
+// TableName 指定表名
+func (DeploymentScriptRevision) TableName() string {
+	return "deployment_script_revisions"
+}
+
 // TableName 指定表名
 func (DeploymentHook) TableName() string {
 	return "deployment_hooks"
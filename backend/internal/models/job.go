@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+)
+
+// JobStatus 作业状态
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"   // 待执行
+	JobStatusRunning   JobStatus = "running"   // 执行中
+	JobStatusSuccess   JobStatus = "success"   // 全部主机成功
+	JobStatusFailed    JobStatus = "failed"    // 全部主机失败
+	JobStatusPartial   JobStatus = "partial"   // 部分主机失败
+	JobStatusCancelled JobStatus = "cancelled" // 已取消
+)
+
+// Job 临时命令/脚本作业（在一批服务器上执行）
+type Job struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	Name           string    `json:"name" gorm:"not null"`                // 作业名称
+	ScriptID       *uint     `json:"script_id,omitempty" gorm:"index"`    // 使用的脚本模板（为空表示内联脚本）
+	ScriptRevision int       `json:"script_revision"`                     // 使用的脚本修订版本
+	ScriptType     string    `json:"script_type" gorm:"default:'shell'"`  // 脚本类型: shell, bash, python
+	Content        string    `json:"content" gorm:"type:text;not null"`   // 脚本内容（模板）
+	Variables      string    `json:"variables" gorm:"type:text"`          // 变量取值（JSON 对象）
+	Templated      bool      `json:"templated" gorm:"default:false"`      // 按模板渲染（提供了变量取值时总是渲染）
+	Concurrency    int       `json:"concurrency" gorm:"default:10"`       // 并发主机数
+	Timeout        int       `json:"timeout" gorm:"default:300"`          // 单台主机超时时间（秒）
+	Status         JobStatus `json:"status" gorm:"default:pending;index"` // 状态
+	CreatedBy      string    `json:"created_by"`                          // 创建人
+
+	// 执行统计
+	TotalHosts   int        `json:"total_hosts"`   // 主机总数
+	SuccessHosts int        `json:"success_hosts"` // 成功主机数
+	FailedHosts  int        `json:"failed_hosts"`  // 失败主机数
+	StartedAt    *time.Time `json:"started_at"`    // 开始时间
+	CompletedAt  *time.Time `json:"completed_at"`  // 完成时间
+	Duration     int        `json:"duration"`      // 耗时（秒）
+
+	// 关联
+	Hosts []JobHost `json:"hosts,omitempty" gorm:"foreignKey:JobID"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// JobHost 作业在单台主机上的执行结果
+type JobHost struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	JobID       uint       `json:"job_id" gorm:"not null;index"`     // 作业 ID
+	ServerID    uint       `json:"server_id" gorm:"not null;index"`  // 服务器 ID
+	ServerName  string     `json:"server_name"`                      // 服务器名称（冗余，服务器删除后仍可查看）
+	Host        string     `json:"host"`                             // 服务器地址
+	Status      string     `json:"status" gorm:"default:pending"`    // 状态: pending, running, success, failed, timeout, cancelled
+	ExitCode    *int       `json:"exit_code"`                        // 退出码（连接失败等情况为空）
+	Output      string     `json:"output" gorm:"type:text"`          // 输出内容（stdout 与 stderr 合并）
+	OutputHash  string     `json:"output_hash" gorm:"size:64;index"` // 输出内容摘要，用于聚合相同输出
+	ErrorMsg    string     `json:"error_msg"`                        // 错误信息
+	StartedAt   *time.Time `json:"started_at"`                       // 开始时间
+	CompletedAt *time.Time `json:"completed_at"`                     // 完成时间
+	Duration    int64      `json:"duration"`                         // 耗时（毫秒）
+}
+
+// JobLog 作业日志，ServerID 为 0 表示作业级日志
+type JobLog struct {
+	ID       uint `json:"id" gorm:"primaryKey"`
+	JobID    uint `json:"job_id" gorm:"not null;index"` // 作业 ID
+	ServerID uint `json:"server_id" gorm:"index"`       // 服务器 ID
+	StepLog
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName 指定表名
+func (Job) TableName() string {
+	return "jobs"
+}
+
+// TableName 指定表名
+func (JobHost) TableName() string {
+	return "job_hosts"
+}
+
+// TableName 指定表名
+func (JobLog) TableName() string {
+	return "job_logs"
+}
@@ -19,6 +19,7 @@ type MiddlewarePackage struct {
 	FileHash    string    `gorm:"size:64" json:"file_hash"`                               // SHA256 哈希
 	OSType      string    `gorm:"size:50" json:"os_type"`                                 // rocky, centos, openEuler
 	OSVersion   string    `gorm:"size:50" json:"os_version"`                              // 9.4, 7.9
+	Arch        string    `gorm:"size:20" json:"arch"`                                    // CPU 架构：x86_64, aarch64（空表示通用）
 	Status      string    `gorm:"size:20;default:'active'" json:"status"`                 // active, deleted
 	UploadedAt  time.Time `gorm:"autoCreateTime" json:"uploaded_at"`                      // 上传时间
 	Metadata    string    `gorm:"type:text" json:"metadata,omitempty"`                    // JSON 扩展元数据
@@ -3,6 +3,7 @@ package models
 import (
 	"time"
 
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -13,7 +14,13 @@ type NginxConfig struct {
 	Description string         `json:"description"`                          // 描述
 	ServerID    *uint          `json:"server_id" gorm:"index"`               // 关联的服务器 ID（可选）
 
+	// 配置模式
+	Mode       string `json:"mode" gorm:"default:'main'"` // main: 完整 nginx.conf；vhost: 虚拟主机，部署为 include 目录下的独立文件
+	IncludeDir string `json:"include_dir"`                // main 模式为 include 的虚拟主机目录（为空不 include）；vhost 模式为文件所在目录
+	FileName   string `json:"file_name"`                  // vhost 模式的文件名，为空时按配置名称生成
+
 	// 基础配置
+	WorkerUser        string `json:"worker_user" gorm:"default:'nobody'"`    // worker 进程运行用户，可带用户组，如 "nginx nginx"
 	WorkerProcesses   string `json:"worker_processes" gorm:"default:'auto'"` // worker 进程数
 	WorkerConnections int    `json:"worker_connections" gorm:"default:1024"` // 每个 worker 的连接数
 
@@ -46,9 +53,12 @@ type NginxConfig struct {
 	ClientMaxBodySize string `json:"client_max_body_size" gorm:"default:'100m'"` // 客户端最大请求体
 	Gzip              bool   `json:"gzip" gorm:"default:true"`                   // 是否启用 Gzip
 	CustomConfig      string `json:"custom_config" gorm:"type:text"`             // 自定义配置片段
+	MainCustomConfig   string `json:"main_custom_config" gorm:"type:text"`   // 全局（main 上下文）自定义配置片段
+	ServerCustomConfig string `json:"server_custom_config" gorm:"type:text"` // server 块内的自定义配置片段
 
 	// 状态
 	Status    string    `json:"status" gorm:"default:'draft'"` // draft, active, disabled
+	CurrentRevision int `json:"current_revision" gorm:"default:0"` // 当前修订版本号
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -57,6 +67,11 @@ type NginxConfig struct {
 	Server      *Server         `json:"server,omitempty" gorm:"foreignKey:ServerID"`
 	Certificate *Certificate    `json:"certificate,omitempty" gorm:"foreignKey:CertificateID"`
 	Locations   []NginxLocation `json:"locations,omitempty" gorm:"foreignKey:NginxConfigID"`
+	Upstreams   []NginxUpstream `json:"upstreams,omitempty" gorm:"foreignKey:NginxConfigID"`
+	StreamServers []NginxStreamServer `json:"stream_servers,omitempty" gorm:"foreignKey:NginxConfigID"`
+	LimitZones    []NginxLimitZone    `json:"limit_zones,omitempty" gorm:"foreignKey:NginxConfigID"`
+	AuthUsers     []NginxAuthUser     `json:"auth_users,omitempty" gorm:"foreignKey:NginxConfigID"`
+	CacheZones    []NginxCacheZone    `json:"cache_zones,omitempty" gorm:"foreignKey:NginxConfigID"`
 }
 
 // TableName 表名
@@ -69,10 +84,10 @@ type NginxLocation struct {
 	ID            uint   `json:"id" gorm:"primaryKey"`
 	NginxConfigID uint   `json:"nginx_config_id" gorm:"not null;index"`
 	Path          string `json:"path" gorm:"not null"`                // 路径，如 /、/api、/static
-	MatchType     string `json:"match_type" gorm:"default:'prefix'"` // 匹配类型：exact(=), prefix(无), regex(~)
+	MatchType     string `json:"match_type" gorm:"default:'prefix'"` // 匹配类型：exact(=), prefix(无), prefix_priority(^~), regex(~), regex_i(~*)
 
 	// 处理方式
-	HandlerType string `json:"handler_type" gorm:"default:'static'"` // static, proxy, redirect, return
+	HandlerType string `json:"handler_type" gorm:"default:'static'"` // static, proxy, redirect, return, custom（仅输出自定义配置片段）
 
 	// Static 配置
 	Root      string `json:"root"`       // 根目录
@@ -80,7 +95,21 @@ type NginxLocation struct {
 
 	// Proxy 配置
 	ProxyPass       string `json:"proxy_pass"`        // 代理地址
-	ProxySetHeaders string `json:"proxy_set_headers"` // JSON 格式的 header 设置
+	ProxySetHeaders string `json:"proxy_set_headers"` // JSON 对象格式的 header 设置，同名时覆盖默认 header
+
+	// 代理调优（为空使用 nginx 默认值）
+	ProxyConnectTimeout      string `json:"proxy_connect_timeout"`       // 连接后端超时，如 5s
+	ProxyReadTimeout         string `json:"proxy_read_timeout"`          // 读取响应超时，如 60s
+	ProxySendTimeout         string `json:"proxy_send_timeout"`          // 发送请求超时，如 60s
+	ProxyBuffering           string `json:"proxy_buffering"`             // 响应缓冲：on、off
+	WebSocket                bool   `json:"websocket"`                   // 转发 WebSocket 升级请求
+	ProxyNextUpstream        string `json:"proxy_next_upstream"`         // 切换到下一个后端的条件，如 error timeout http_502
+	ProxyNextUpstreamTries   int    `json:"proxy_next_upstream_tries"`   // 最大尝试次数（0 表示不限）
+	ProxyNextUpstreamTimeout string `json:"proxy_next_upstream_timeout"` // 切换后端的总超时
+
+	// 代理缓存
+	ProxyCache      string `json:"proxy_cache"`                        // 缓存 zone 名称（NginxCacheZone），为空不缓存
+	ProxyCacheValid string `json:"proxy_cache_valid" gorm:"type:text"` // 缓存有效期，多条用换行或分号分隔，如 200 302 10m; 404 1m
 
 	// Redirect 配置
 	RedirectURL  string `json:"redirect_url"`
@@ -90,6 +119,23 @@ type NginxLocation struct {
 	ReturnCode int    `json:"return_code"`
 	ReturnBody string `json:"return_body"`
 
+	// 访问控制
+	Allow string `json:"allow" gorm:"type:text"` // 允许访问的 IP/CIDR，多个用逗号或换行分隔
+	Deny  string `json:"deny" gorm:"type:text"`  // 拒绝访问的 IP/CIDR 或 all，在 allow 之后输出
+
+	// 限流
+	LimitReqZone    string `json:"limit_req_zone"`    // 请求速率限制 zone 名称（NginxLimitZone）
+	LimitReqBurst   int    `json:"limit_req_burst"`   // 允许的突发请求数
+	LimitReqNodelay bool   `json:"limit_req_nodelay"` // 突发请求不排队延迟
+	LimitConnZone   string `json:"limit_conn_zone"`   // 并发连接限制 zone 名称（NginxLimitZone）
+	LimitConn       int    `json:"limit_conn"`        // 每个 key 的最大并发连接数
+
+	// Basic 认证
+	AuthBasic string `json:"auth_basic"` // 认证提示信息，为空表示不启用；用户在配置级别维护（NginxAuthUser）
+
+	// 自定义配置片段（location 块内原样输出）
+	CustomConfig string `json:"custom_config" gorm:"type:text"`
+
 	// 排序
 	SortOrder int       `json:"sort_order" gorm:"default:0"`
 	CreatedAt time.Time `json:"created_at"`
@@ -106,13 +152,110 @@ type NginxUpstream struct {
 	ID            uint   `json:"id" gorm:"primaryKey"`
 	NginxConfigID uint   `json:"nginx_config_id" gorm:"not null;index"`
 	Name          string `json:"name" gorm:"not null"`                   // upstream 名称
+	Context       string `json:"context" gorm:"default:'http'"`          // 所在上下文：http 或 stream（TCP/UDP 四层代理）
 	LoadBalance   string `json:"load_balance" gorm:"default:'round_robin'"` // 负载均衡：round_robin, least_conn, ip_hash
-	Servers       string `json:"servers" gorm:"type:text"`               // JSON 格式的服务器列表
+	Servers       string `json:"servers" gorm:"type:text"`               // JSON 格式的服务器列表（UpstreamServer 数组）
+	Keepalive     int    `json:"keepalive" gorm:"default:0"`             // 到后端的空闲长连接数（0 表示不启用）
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
+// UpstreamServer Upstream 中的后端服务器
+type UpstreamServer struct {
+	Address     string `json:"address"`                // 地址，如 10.0.0.1:8080
+	Weight      int    `json:"weight,omitempty"`       // 权重（默认 1）
+	MaxFails    int    `json:"max_fails,omitempty"`    // 失败次数阈值
+	FailTimeout string `json:"fail_timeout,omitempty"` // 失败判定时间窗口，如 10s
+	Backup      bool   `json:"backup,omitempty"`       // 备用服务器
+	Down        bool   `json:"down,omitempty"`         // 标记为下线
+}
+
 // TableName 表名
 func (NginxUpstream) TableName() string {
 	return "nginx_upstreams"
 }
+
+// NginxStreamServer Nginx stream（TCP/UDP 四层代理）server 配置
+type NginxStreamServer struct {
+	ID                  uint      `json:"id" gorm:"primaryKey"`
+	NginxConfigID       uint      `json:"nginx_config_id" gorm:"not null;index"`
+	Name                string    `json:"name"`                             // 名称，如 redis、mysql（渲染为注释）
+	ListenPort          int       `json:"listen_port" gorm:"not null"`      // 监听端口
+	Protocol            string    `json:"protocol" gorm:"default:'tcp'"`    // 协议：tcp 或 udp
+	ProxyPass           string    `json:"proxy_pass" gorm:"not null"`       // 代理目标：stream upstream 名称或 host:port
+	ProxyConnectTimeout string    `json:"proxy_connect_timeout"`            // 连接后端超时，如 5s
+	ProxyTimeout        string    `json:"proxy_timeout"`                    // 连接空闲超时，如 10m
+	ProxyProtocol       bool      `json:"proxy_protocol" gorm:"default:false"` // 向后端发送 PROXY 协议头以传递客户端地址
+	SortOrder           int       `json:"sort_order" gorm:"default:0"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// TableName 表名
+func (NginxStreamServer) TableName() string {
+	return "nginx_stream_servers"
+}
+
+// NginxLimitZone Nginx 限流共享内存 zone（limit_req_zone / limit_conn_zone），在 http 块中定义，由 location 引用
+type NginxLimitZone struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	NginxConfigID uint      `json:"nginx_config_id" gorm:"not null;index"`
+	Name          string    `json:"name" gorm:"not null"`                     // zone 名称
+	Type          string    `json:"type" gorm:"default:'req'"`                // req：请求速率；conn：并发连接
+	Key           string    `json:"key" gorm:"default:'$binary_remote_addr'"` // 限流维度，如 $binary_remote_addr、$server_name
+	Size          string    `json:"size" gorm:"default:'10m'"`                // 共享内存大小
+	Rate          string    `json:"rate"`                                     // 请求速率，如 10r/s（仅 req）
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName 表名
+func (NginxLimitZone) TableName() string {
+	return "nginx_limit_zones"
+}
+
+// NginxAuthUser Nginx basic 认证用户，部署时生成 htpasswd 文件
+type NginxAuthUser struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	NginxConfigID uint      `json:"nginx_config_id" gorm:"not null;index"`
+	Username      string    `json:"username" gorm:"not null"`
+	PasswordHash  string    `json:"-" gorm:"not null"` // bcrypt 哈希
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SetPassword 设置密码（自动哈希）
+func (u *NginxAuthUser) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// TableName 表名
+func (NginxAuthUser) TableName() string {
+	return "nginx_auth_users"
+}
+
+// NginxCacheZone Nginx 代理缓存 zone（proxy_cache_path），在 http 块中定义，由代理 location 引用
+type NginxCacheZone struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	NginxConfigID uint      `json:"nginx_config_id" gorm:"not null;index"`
+	Name          string    `json:"name" gorm:"not null"`          // keys_zone 名称
+	Path          string    `json:"path" gorm:"not null"`          // 缓存目录
+	Levels        string    `json:"levels" gorm:"default:'1:2'"`   // 目录层级
+	Size          string    `json:"size" gorm:"default:'10m'"`     // keys_zone 共享内存大小
+	MaxSize       string    `json:"max_size"`                      // 缓存最大磁盘占用，如 1g，为空不限制
+	Inactive      string    `json:"inactive" gorm:"default:'60m'"` // 未被访问的缓存保留时间
+	CacheKey      string    `json:"cache_key"`                     // 缓存 key，为空使用 nginx 默认值
+	Bypass        string    `json:"bypass"`                        // 跳过缓存的条件变量，如 $cookie_nocache $http_authorization
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName 表名
+func (NginxCacheZone) TableName() string {
+	return "nginx_cache_zones"
+}
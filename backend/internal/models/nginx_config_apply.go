@@ -18,6 +18,8 @@ type NginxConfigApply struct {
 	BackupPath     string `json:"backup_path"`                                         // 备份路径
 	RestartService bool   `json:"restart_service" gorm:"default:true"`                // 是否重启服务
 	ServiceName    string `json:"service_name" gorm:"default:'nginx'"`                // 服务名称
+	MainConfigPath string `json:"main_config_path"`                                    // 主配置路径（虚拟主机模式下用于测试完整配置）
+	Revision       int    `json:"revision"`                                            // 应用的配置修订版本号
 
 	// 执行状态
 	Status      string `json:"status" gorm:"default:'pending';index"` // pending, running, success, failed, cancelled
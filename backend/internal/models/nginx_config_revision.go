@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// NginxConfigRevision Nginx 配置修订版本（创建后不可修改）
+type NginxConfigRevision struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	NginxConfigID uint   `json:"nginx_config_id" gorm:"not null;uniqueIndex:idx_nginx_config_revision"`
+	Revision      int    `json:"revision" gorm:"not null;uniqueIndex:idx_nginx_config_revision"` // 修订版本号（从 1 开始递增）
+	Source        string `json:"source" gorm:"size:20"`                                          // 来源：save, import, apply, deploy
+	Snapshot      string `json:"snapshot,omitempty" gorm:"type:text"`                            // 配置快照（含 locations、upstreams 的 JSON）
+	Content       string `json:"content,omitempty" gorm:"type:text"`                             // 渲染后的配置文件内容
+	Htpasswd      string `json:"-" gorm:"type:text"`                                             // basic 认证用户文件内容（含密码哈希，不对外返回）
+	Author        string `json:"author" gorm:"size:100"`                                         // 操作人
+	Message       string `json:"message" gorm:"size:500"`                                        // 修改说明
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName 表名
+func (NginxConfigRevision) TableName() string {
+	return "nginx_config_revisions"
+}
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // PackageMetadata 离线包元数据
 type PackageMetadata struct {
 	Name          string          `json:"name"`                   // 包名称
@@ -7,6 +12,7 @@ type PackageMetadata struct {
 	DisplayName   string          `json:"display_name"`           // 显示名称
 	Description   string          `json:"description"`            // 描述
 	SupportedOS   []SupportedOS   `json:"supported_os"`           // 支持的操作系统
+	Arch          string          `json:"arch,omitempty"`         // CPU 架构：x86_64, aarch64
 	InstallScript string          `json:"install_script"`         // 安装脚本名称
 	Parameters    []Parameter     `json:"parameters"`             // 可配置参数列表
 	Features      []string        `json:"features,omitempty"`     // 功能特性
@@ -19,6 +25,28 @@ type SupportedOS struct {
 	Versions []string `json:"versions"` // 支持的版本列表
 }
 
+// UnmarshalJSON 兼容 "rocky-9.4" 形式的字符串写法
+func (s *SupportedOS) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		osType, version, _ := strings.Cut(str, "-")
+		s.Type = osType
+		s.Versions = nil
+		if version != "" {
+			s.Versions = []string{version}
+		}
+		return nil
+	}
+
+	type supportedOS SupportedOS
+	var obj supportedOS
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*s = SupportedOS(obj)
+	return nil
+}
+
 // Parameter 可配置参数
 type Parameter struct {
 	Name        string      `json:"name"`                  // 参数名（环境变量名）
@@ -54,3 +82,25 @@ type Requirements struct {
 	Ports        []int    `json:"ports,omitempty"`        // 需要的端口列表
 	Dependencies []string `json:"dependencies,omitempty"` // 依赖的系统包
 }
+
+// UnmarshalJSON 兼容 min_disk / min_memory 写法
+func (r *Requirements) UnmarshalJSON(data []byte) error {
+	type requirements Requirements
+	var raw struct {
+		requirements
+		MinDisk   string `json:"min_disk"`
+		MinMemory string `json:"min_memory"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*r = Requirements(raw.requirements)
+	if r.DiskSpace == "" {
+		r.DiskSpace = raw.MinDisk
+	}
+	if r.Memory == "" {
+		r.Memory = raw.MinMemory
+	}
+	return nil
+}
@@ -19,11 +19,21 @@ type Server struct {
 	Passphrase  string         `json:"-" gorm:""`                                 // 私钥密码（加密存储）
 	OSType      string         `json:"os_type" gorm:""`                           // 操作系统类型：rocky, centos, openEuler, kylin
 	OSVersion   string         `json:"os_version" gorm:""`                        // 操作系统版本
+	Arch        string         `json:"arch" gorm:""`                              // CPU 架构：x86_64, aarch64
 	Description string         `json:"description" gorm:""`                       // 描述
 	Tags        string         `json:"tags" gorm:""`                              // 标签（JSON 数组）
 	Status      string         `json:"status" gorm:"default:'unknown'"`           // 状态：online, offline, unknown
 	LastCheckAt *time.Time     `json:"last_check_at" gorm:""`                     // 最后检查时间
 	LastCheckMsg string        `json:"last_check_msg" gorm:""`                    // 最后检查结果消息
+	BastionID   *uint          `json:"bastion_id,omitempty" gorm:"index"`         // 跳板机 ID（同为服务器记录，可多级串联）
+	Bastion     *Server        `json:"bastion,omitempty" gorm:"foreignKey:BastionID"` // 跳板机
+	HostKey            string     `json:"-" gorm:"type:text"`                // 已信任的主机公钥（authorized_keys 格式）
+	HostKeyFingerprint string     `json:"host_key_fingerprint" gorm:""`      // 已信任的主机密钥指纹（SHA256）
+	HostKeyVerifiedAt  *time.Time `json:"host_key_verified_at" gorm:""`      // 主机密钥信任时间
+	HostKeyTrustOnFirstUse bool   `json:"host_key_trust_on_first_use" gorm:"default:false"` // 升级前已存在的服务器，下次连接时信任并固定出示的密钥
+	PendingHostKey            string     `json:"-" gorm:"type:text"`                   // 不一致时服务器出示的新公钥，待确认
+	PendingHostKeyFingerprint string     `json:"pending_host_key_fingerprint" gorm:""` // 待确认的主机密钥指纹
+	PendingHostKeySeenAt      *time.Time `json:"pending_host_key_seen_at" gorm:""`     // 发现不一致的时间
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
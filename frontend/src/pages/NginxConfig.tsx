@@ -98,6 +98,7 @@ const NginxConfigPage: React.FC = () => {
     setEditingConfig(null);
     form.resetFields();
     form.setFieldsValue({
+      worker_user: 'nobody',
       worker_processes: 'auto',
       worker_connections: 1024,
       enable_http: true,
@@ -410,17 +411,22 @@ const NginxConfigPage: React.FC = () => {
                       </Col>
                     </Row>
                     <Row gutter={16}>
-                      <Col span={8}>
+                      <Col span={6}>
+                        <Form.Item label="Worker 运行用户" name="worker_user" tooltip="用户名，可跟用户组，如 nginx nginx">
+                          <Input placeholder="nobody" />
+                        </Form.Item>
+                      </Col>
+                      <Col span={6}>
                         <Form.Item label="Worker 进程数" name="worker_processes">
                           <Input placeholder="auto" />
                         </Form.Item>
                       </Col>
-                      <Col span={8}>
+                      <Col span={6}>
                         <Form.Item label="Worker 连接数" name="worker_connections">
                           <InputNumber min={1} max={65535} style={{ width: '100%' }} />
                         </Form.Item>
                       </Col>
-                      <Col span={8}>
+                      <Col span={6}>
                         <Form.Item label="客户端最大请求体" name="client_max_body_size">
                           <Input placeholder="100m" />
                         </Form.Item>
@@ -110,6 +110,7 @@ export interface NginxConfig {
   name: string;
   description: string;
   server_id?: number;
+  worker_user: string;
   worker_processes: string;
   worker_connections: number;
   enable_http: boolean;